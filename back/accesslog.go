@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// accessLog turns on one log line per request, see withAccessLog
+var accessLog bool
+
+// accessLogWriter records the status and size of the response going
+// through it
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (a *accessLogWriter) WriteHeader(status int) {
+	// 1xx responses like 103 Early Hints come before the real one
+	if a.status == 0 && status >= 200 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *accessLogWriter) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += int64(n)
+	return n, err
+}
+
+// Flush passes through for streaming handlers
+func (a *accessLogWriter) Flush() {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	http.NewResponseController(a.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (a *accessLogWriter) Unwrap() http.ResponseWriter { return a.ResponseWriter }
+
+// withAccessLog logs every request once it's answered: method, path,
+// status, bytes sent and how long it took, on top of what the handlers log
+// about their own work. It goes outside compression, so bytes is what
+// went over the wire.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !accessLog {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		aw := &accessLogWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+		if aw.status == 0 {
+			aw.status = http.StatusOK // Nothing written, net/http sends an empty 200
+		}
+		logRequest(r, aw.status, "access", "Handled request", "method", r.Method, "path", r.URL.Path,
+			"bytes", aw.bytes, "duration", time.Since(start).String())
+	})
+}
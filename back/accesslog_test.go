@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// accessRecords captures the logs of the test and returns a func giving
+// the access log records so far
+func accessRecords(t *testing.T) func() []map[string]any {
+	t.Helper()
+	var logs bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return func() []map[string]any {
+		var records []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var record map[string]any
+			if line == "" {
+				continue
+			}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatal(err)
+			}
+			if record["event"] == "access" {
+				records = append(records, record)
+			}
+		}
+		logs.Reset()
+		return records
+	}
+}
+
+func TestAccessLogStatus(t *testing.T) {
+	setForTest(t, &accessLog, true)
+	records := accessRecords(t)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		bytes   float64 // JSON numbers decode as float64
+	}{
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) }, http.StatusOK, 5},
+		{"created", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"ok":true}`))
+		}, http.StatusCreated, 11},
+		{"no content", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }, http.StatusNoContent, 0},
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0},
+		{"error", func(w http.ResponseWriter, r *http.Request) {
+			writeJSONError(w, http.StatusServiceUnavailable, "Down", errUnavailable)
+		}, http.StatusServiceUnavailable, -1},
+		{"early hints first", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", "</app.css>; rel=preload")
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusAccepted)
+		}, http.StatusAccepted, 0},
+		{"superfluous WriteHeader", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusNotFound, 0},
+		{"flushed", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("a"))
+			http.NewResponseController(w).Flush()
+			w.Write([]byte("b"))
+		}, http.StatusOK, 2},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/some/path?q=1", nil)
+		withAccessLog(tt.handler).ServeHTTP(rec, r)
+
+		got := records()
+		if len(got) != 1 {
+			t.Errorf("%s: %d access records, want 1", tt.name, len(got))
+			continue
+		}
+		record := got[0]
+		if record["status"] != float64(tt.status) {
+			t.Errorf("%s: logged status %v, want %d", tt.name, record["status"], tt.status)
+		}
+		// httptest.ResponseRecorder keeps a 1xx as the status, a real client doesn't see it as one
+		if rec.Code != tt.status && rec.Code >= 200 {
+			t.Errorf("%s: handler wrote %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		wantBytes := tt.bytes
+		if wantBytes < 0 {
+			wantBytes = float64(rec.Body.Len())
+		}
+		if record["bytes"] != wantBytes {
+			t.Errorf("%s: bytes = %v, want %v", tt.name, record["bytes"], wantBytes)
+		}
+		if record["method"] != "POST" || record["path"] != "/some/path" || record["remote_ip"] != "192.0.2.1" || record["duration"] == "" {
+			t.Errorf("%s: record = %v", tt.name, record)
+		}
+	}
+}
+
+func TestAccessLogOff(t *testing.T) {
+	setForTest(t, &accessLog, false)
+	records := accessRecords(t)
+	withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := records(); len(got) != 0 {
+		t.Errorf("access records with ACCESS_LOG off: %v", got)
+	}
+}
+
+func TestAccessLogThroughRouter(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &accessLog, true)
+	router := testRouter(t)
+	records := accessRecords(t)
+	saveTestLink(t, "logged", Link{URL: "https://golang.org/doc"})
+
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/logged", nil),
+		httptest.NewRequest(http.MethodGet, "/nosuchcode", nil),
+		httptest.NewRequest(http.MethodOptions, "/shorten", nil),
+		httptest.NewRequest(http.MethodGet, "/openapi.json", nil),
+	}
+	requests[2].Header.Set("Origin", "https://app.example")
+	requests[2].Header.Set("Access-Control-Request-Method", "POST")
+	requests[3].Header.Set("Accept-Encoding", "gzip")
+	for _, r := range requests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		got := records()
+		if len(got) != 1 {
+			t.Errorf("%s %s: %d access records, want 1", r.Method, r.URL.Path, len(got))
+			continue
+		}
+		// Outside CORS and gzip, so what the client got
+		if got[0]["status"] != float64(rec.Code) || got[0]["bytes"] != float64(rec.Body.Len()) {
+			t.Errorf("%s %s: logged status %v and %v bytes, sent %d and %d", r.Method, r.URL.Path, got[0]["status"], got[0]["bytes"], rec.Code, rec.Body.Len())
+		}
+	}
+}
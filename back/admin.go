@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Response structure for POST /admin/flush
+type FlushResponse struct {
+	Removed int `json:"removed"` // Links deleted
+}
+
+// handleFlush deletes every link, for resetting test and staging
+// instances. There's no undo, so it's admin only like handleListLinks.
+func handleFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+
+	removed, err := store.Flush(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error flushing links", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "flush", "Error flushing links", "removed", removed, "error", err)
+		return
+	}
+	// Visit breakdowns would otherwise show up on codes handed out again
+	analytics.Reset()
+	logRequest(r, http.StatusOK, "flush", "Flushed all links", "removed", removed)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(FlushResponse{Removed: removed}); err != nil {
+		logRequest(r, http.StatusInternalServerError, "flush", "Error encoding response", "error", err)
+	}
+}
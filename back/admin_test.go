@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// flushThroughRouter sends POST /admin/flush through the full router, with
+// key as the bearer token if set
+func flushThroughRouter(t *testing.T, key string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/admin/flush", nil)
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	testRouter(t).ServeHTTP(rec, r)
+	return rec
+}
+
+func TestFlush(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &apiKeys, []string{"secret"})
+	for _, url := range []string{"https://golang.org/a", "https://golang.org/b", "https://golang.org/a"} {
+		shortenOK(t, `{"url": "`+url+`"}`)
+	}
+
+	rec := flushThroughRouter(t, "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := decodeJSON[FlushResponse](t, rec).Removed; got != 3 {
+		t.Errorf("removed = %d, want 3", got)
+	}
+	if got := storeSize(t); got != 0 {
+		t.Errorf("%d links left", got)
+	}
+	// The URL index is emptied too
+	if codes, err := store.CodesForURL(t.Context(), "https://golang.org/a"); err != nil || len(codes) != 0 {
+		t.Errorf("CodesForURL after flush = %q, %v", codes, err)
+	}
+	if got := decodeJSON[FlushResponse](t, flushThroughRouter(t, "secret")).Removed; got != 0 {
+		t.Errorf("second flush removed %d", got)
+	}
+}
+
+func TestFlushNeedsAPIKey(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "keepme", Link{URL: "https://golang.org/doc"})
+
+	for _, tt := range []struct {
+		name string
+		keys []string
+		key  string
+		want int
+	}{
+		{"no key", []string{"secret"}, "", http.StatusUnauthorized},
+		{"wrong key", []string{"secret"}, "guess", http.StatusForbidden},
+		{"no keys configured", nil, "anything", http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &apiKeys, tt.keys)
+			if rec := flushThroughRouter(t, tt.key); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+	if got := storeSize(t); got != 1 {
+		t.Errorf("%d links left, want the link kept", got)
+	}
+
+	rec := httptest.NewRecorder()
+	handleFlush(rec, httptest.NewRequest(http.MethodGet, "/admin/flush", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want 405", rec.Code)
+	}
+}
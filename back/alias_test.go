@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUnicodeAliasesOff(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &unicodeAliases, false)
+	for _, alias := range []string{"café", "🚀-launch", "日本語"} {
+		rec := postShorten(t, `{"url": "https://golang.org/doc", "custom_alias": "`+alias+`"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("alias %q: status = %d, want 400", alias, rec.Code)
+			continue
+		}
+		if body := decodeJSON[ErrorResponse](t, rec); body.Code != errInvalidAlias {
+			t.Errorf("alias %q: error = %+v", alias, body)
+		}
+	}
+}
+
+func TestUnicodeAliasesRoundTrip(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &unicodeAliases, true)
+	tests := []struct {
+		name, alias string // alias as sent in JSON
+		code        string // As stored, NFC
+		paths       []string
+	}{
+		{"accented", "café", "café", []string{"/café", "/caf%C3%A9", "/cafe%CC%81"}},
+		{"decomposed", `me\u0301nage`, "ménage", []string{"/m%C3%A9nage", "/me%CC%81nage"}},
+		{"emoji", "🚀-launch", "🚀-launch", []string{"/%F0%9F%9A%80-launch"}},
+		{"skin tone", "👍🏽ok", "👍🏽ok", []string{"/" + url.PathEscape("👍🏽ok")}},
+		{"joined", "👨‍👩‍👧", "👨‍👩‍👧", []string{"/" + url.PathEscape("👨‍👩‍👧")}},
+		{"other script", "日本語", "日本語", []string{"/%E6%97%A5%E6%9C%AC%E8%AA%9E"}},
+	}
+	for _, tt := range tests {
+		dest := "https://golang.org/" + strings.ReplaceAll(tt.name, " ", "-")
+		resp := shortenOK(t, `{"url": "`+dest+`", "custom_alias": "`+tt.alias+`"}`)
+		if resp.Code != tt.code {
+			t.Errorf("%s: code = %q, want %q", tt.name, resp.Code, tt.code)
+		}
+		if !strings.HasSuffix(resp.ShortURL, "/"+url.PathEscape(tt.code)) && !strings.HasSuffix(resp.ShortURL, "/"+tt.code) {
+			t.Errorf("%s: short_url = %q", tt.name, resp.ShortURL)
+		}
+		for _, path := range tt.paths {
+			rec := getRedirect(path)
+			if rec.Code != http.StatusFound || rec.Header().Get("Location") != dest {
+				t.Errorf("%s: GET %s = %d, Location %q; want a redirect to %s", tt.name, path, rec.Code, rec.Header().Get("Location"), dest)
+			}
+		}
+	}
+
+	// Either form of the same alias is taken once
+	if rec := postShorten(t, `{"url": "https://golang.org/doc", "custom_alias": "cafe\u0301"}`); rec.Code != http.StatusConflict {
+		t.Errorf("decomposed duplicate: status = %d, want 409", rec.Code)
+	}
+}
+
+func TestUnicodeAliasLength(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &unicodeAliases, true)
+	tests := []struct {
+		alias string
+		ok    bool
+	}{
+		{strings.Repeat("🚀", maxAliasLength), true}, // 128 bytes, 32 characters
+		{strings.Repeat("é", maxAliasLength), true},
+		{strings.Repeat("🚀", maxAliasLength+1), false},
+		{"éé", false}, // 4 bytes, but 2 characters
+		{"ééé", true},
+		{"a b", false},
+		{"a/b", false},
+		{"<b>", false},
+	}
+	for _, tt := range tests {
+		rec := postShorten(t, `{"url": "https://golang.org/doc", "custom_alias": "`+tt.alias+`"}`)
+		if ok := rec.Code == http.StatusOK; ok != tt.ok {
+			t.Errorf("alias %q: status = %d, want it accepted: %v", tt.alias, rec.Code, tt.ok)
+		}
+	}
+}
+
+func TestUnicodeAliasCaseInsensitive(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &unicodeAliases, true)
+	setForTest(t, &caseInsensitive, true)
+	if code := shortenOK(t, `{"url": "https://golang.org/doc", "custom_alias": "CAFÉ"}`).Code; code != "café" {
+		t.Errorf("code = %q, want café", code)
+	}
+	if rec := getRedirect("/Caf%C3%89"); rec.Code != http.StatusFound {
+		t.Errorf("GET /Café: status = %d, want 302", rec.Code)
+	}
+}
+
+func TestShortenReuseAlias(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "provisioned", Link{URL: "https://golang.org/doc", Clicks: 12})
+
+	// The same URL, as given or in an equivalent form: the existing link
+	for _, dest := range []string{"https://golang.org/doc", "HTTPS://golang.org:443/doc"} {
+		rec := postShorten(t, `{"url": "`+dest+`", "custom_alias": "provisioned", "reuse_alias": true}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200: %s", dest, rec.Code, rec.Body)
+		}
+		if resp := decodeJSON[ShortenResponse](t, rec); resp.Code != "provisioned" || resp.OriginalURL != "https://golang.org/doc" {
+			t.Errorf("%s: response = %+v", dest, resp)
+		}
+	}
+	if link, _ := store.Lookup(t.Context(), "provisioned"); link.Clicks != 12 {
+		t.Errorf("clicks = %d, want the existing link untouched", link.Clicks)
+	}
+	if got := storeSize(t); got != 1 {
+		t.Errorf("%d links, want only the existing one", got)
+	}
+
+	// A different URL is a conflict, as is the same one without reuse_alias
+	for _, body := range []string{
+		`{"url": "https://golang.org/pkg", "custom_alias": "provisioned", "reuse_alias": true}`,
+		`{"url": "https://golang.org/doc", "custom_alias": "provisioned"}`,
+	} {
+		rec := postShorten(t, body)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("%s: status = %d, want 409", body, rec.Code)
+			continue
+		}
+		if resp := decodeJSON[ErrorResponse](t, rec); resp.Code != errConflict {
+			t.Errorf("%s: error = %+v", body, resp)
+		}
+	}
+	if link, _ := store.Lookup(t.Context(), "provisioned"); link.URL != "https://golang.org/doc" {
+		t.Errorf("URL = %q after a conflict", link.URL)
+	}
+
+	// A free alias is simply created
+	if code := shortenOK(t, `{"url": "https://golang.org/pkg", "custom_alias": "fresh", "reuse_alias": true}`).Code; code != "fresh" {
+		t.Errorf("free alias: code %q", code)
+	}
+	if rec := postShorten(t, `{"url": "https://golang.org/pkg", "reuse_alias": true}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("reuse_alias without custom_alias: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestShortenBatchReuseAlias(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "provisioned", Link{URL: "https://golang.org/doc"})
+	rec := postBatch(`[
+		{"url": "https://golang.org/doc", "custom_alias": "provisioned", "reuse_alias": true},
+		{"url": "https://golang.org/pkg", "custom_alias": "provisioned", "reuse_alias": true}
+	]`)
+	results := decodeJSON[[]BatchResult](t, rec)
+	if len(results) != 2 || results[0].Code != "provisioned" || results[0].Error != "" {
+		t.Fatalf("results = %+v, want the first to reuse the alias", results)
+	}
+	if results[1].ErrorCode != errConflict {
+		t.Errorf("different URL: %+v, want a conflict", results[1])
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Distinct referrers remembered per code. Once full, new ones are
+// counted under otherReferrer so a link can't grow without bound.
+const maxReferrersPerCode = 50
+
+// Buckets for visits without a usable Referer, and for overflow
+const (
+	directReferrer = "(direct)"
+	otherReferrer  = "(other)"
+)
+
+// visitStats are the visit aggregates of one code
+type visitStats struct {
+	referrers map[string]int64 // By referring host
+	browsers  map[string]int64 // By browser family, see browserFamily
+}
+
+// visitAnalytics aggregates redirects per code in memory. Like the rate
+// limiter it's per instance and starts empty after a restart.
+type visitAnalytics struct {
+	mu    sync.Mutex
+	codes map[string]*visitStats
+	// Only one visit in sampleRate is recorded, counting for sampleRate
+	// visits, see ANALYTICS_SAMPLE_RATE. Click counts are kept by the store
+	// and stay exact either way.
+	sampleRate int
+}
+
+// analytics collects the data behind GET /stats/{code}/detail
+var analytics = &visitAnalytics{codes: make(map[string]*visitStats), sampleRate: 1}
+
+// referrerHost reduces a Referer header to its host, the part worth grouping by
+func referrerHost(referer string) string {
+	if referer == "" {
+		return directReferrer
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Hostname() == "" {
+		return otherReferrer
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Browser families, checked in order: Edge and Opera also claim to be
+// Chrome, and Chrome claims to be Safari
+var browserFamilies = []struct{ token, family string }{
+	{"edg/", "Edge"},
+	{"opr/", "Opera"},
+	{"firefox/", "Firefox"},
+	{"chrome/", "Chrome"},
+	{"crios/", "Chrome"},
+	{"safari/", "Safari"},
+	{"curl/", "curl"},
+}
+
+// browserFamily maps a User-Agent to a coarse browser family
+func browserFamily(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return "Unknown"
+	case isBot(ua):
+		return "Bot"
+	}
+	for _, b := range browserFamilies {
+		if strings.Contains(ua, b.token) {
+			return b.family
+		}
+	}
+	return "Other"
+}
+
+// Record counts one visit of code, or with sampling, every sampleRate-th
+// visit on average as sampleRate visits
+func (a *visitAnalytics) Record(code string, r *http.Request) {
+	// Sampled out before any parsing or locking, that's the work saved
+	if a.sampleRate > 1 && rand.IntN(a.sampleRate) != 0 {
+		return
+	}
+	referrer := referrerHost(r.Header.Get("Referer"))
+	browser := browserFamily(r.Header.Get("User-Agent"))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats, exists := a.codes[code]
+	if !exists {
+		stats = &visitStats{referrers: make(map[string]int64), browsers: make(map[string]int64)}
+		a.codes[code] = stats
+	}
+	if _, known := stats.referrers[referrer]; !known && len(stats.referrers) >= maxReferrersPerCode {
+		referrer = otherReferrer
+	}
+	stats.referrers[referrer] += int64(a.sampleRate)
+	stats.browsers[browser] += int64(a.sampleRate)
+}
+
+// NamedCount is one row of a breakdown, like a referrer and its visits
+type NamedCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// sortedCounts turns a breakdown into rows, most visits first
+func sortedCounts(counts map[string]int64) []NamedCount {
+	rows := make([]NamedCount, 0, len(counts))
+	for name, count := range counts {
+		rows = append(rows, NamedCount{Name: name, Count: count})
+	}
+	slices.SortFunc(rows, func(a, b NamedCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Name, b.Name))
+	})
+	return rows
+}
+
+// Breakdown returns the referrers and browsers seen for code
+func (a *visitAnalytics) Breakdown(code string) (referrers, browsers []NamedCount) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats, exists := a.codes[code]
+	if !exists {
+		return []NamedCount{}, []NamedCount{}
+	}
+	return sortedCounts(stats.referrers), sortedCounts(stats.browsers)
+}
+
+// Reset forgets the visits of every code
+func (a *visitAnalytics) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.codes = make(map[string]*visitStats)
+}
+
+// Response structure for GET /stats/{code}/detail
+type StatsDetailResponse struct {
+	StatsResponse
+	Referrers []NamedCount `json:"referrers"` // Top referring hosts
+	Browsers  []NamedCount `json:"browsers"`  // Browser families
+	// Set when only one visit in this many is sampled: the breakdowns are
+	// then estimates, unlike clicks
+	SampleRate int `json:"sample_rate,omitempty"`
+}
+
+// handleStatsDetail returns the click count plus referrer and browser
+// breakdowns for a short code
+func handleStatsDetail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	shortCode := canonicalCode(r.PathValue("code"))
+	// Before the lookup, so codes can't be probed for without a token
+	if !authorizeStats(w, r, shortCode) {
+		return
+	}
+	link, err := lookupLink(r.Context(), shortCode)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		logRequest(r, http.StatusNotFound, "stats", "Short code not found", "code", shortCode)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "stats", "Error looking up short code", "code", shortCode, "error", err)
+		return
+	}
+
+	resp := StatsDetailResponse{
+		StatsResponse: newStatsResponse(shortCode, link),
+	}
+	resp.Referrers, resp.Browsers = analytics.Breakdown(shortCode)
+	if analytics.sampleRate > 1 {
+		resp.SampleRate = analytics.sampleRate
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "stats", "Error encoding response", "error", err)
+	}
+}
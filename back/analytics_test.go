@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// useAnalytics makes redirects record into fresh visitAnalytics until the test ends
+func useAnalytics(t *testing.T, sampleRate int) *visitAnalytics {
+	a := &visitAnalytics{codes: make(map[string]*visitStats), sampleRate: sampleRate}
+	setForTest(t, &analytics, a)
+	return a
+}
+
+// visit redirects to code with the given Referer and User-Agent
+func visit(code, referer, userAgent string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+	if referer != "" {
+		r.Header.Set("Referer", referer)
+	}
+	r.Header.Set("User-Agent", userAgent)
+	rec := httptest.NewRecorder()
+	handleRedirect(rec, r)
+	return rec
+}
+
+const (
+	firefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+	chromeUA  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+	edgeUA    = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0"
+	safariUA  = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15"
+)
+
+func TestBrowserFamily(t *testing.T) {
+	for ua, want := range map[string]string{
+		firefoxUA:           "Firefox",
+		chromeUA:            "Chrome",
+		edgeUA:              "Edge",
+		safariUA:            "Safari",
+		"curl/8.5.0":        "curl",
+		"":                  "Unknown",
+		"Googlebot/2.1":     "Bot",
+		"SomethingElse/1.0": "Other",
+	} {
+		if got := browserFamily(ua); got != want {
+			t.Errorf("browserFamily(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
+
+func TestStatsDetail(t *testing.T) {
+	useMemoryStore(t)
+	useAnalytics(t, 1)
+	saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc"})
+
+	visit("abc123", "https://news.ycombinator.com/item?id=1", firefoxUA)
+	visit("abc123", "https://NEWS.ycombinator.com/", chromeUA)
+	visit("abc123", "https://twitter.com/someone", chromeUA)
+	visit("abc123", "", edgeUA)
+	visit("missing", "https://twitter.com/", chromeUA) // Not a visit of anything
+
+	rec := serve("/stats/{code}/detail", handleStatsDetail, httptest.NewRequest(http.MethodGet, "/stats/abc123/detail", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	detail := decodeJSON[StatsDetailResponse](t, rec)
+	if detail.Clicks != 4 {
+		t.Errorf("clicks = %d, want 4", detail.Clicks)
+	}
+	wantReferrers := []NamedCount{{"news.ycombinator.com", 2}, {directReferrer, 1}, {"twitter.com", 1}}
+	if !slices.Equal(detail.Referrers, wantReferrers) {
+		t.Errorf("referrers = %v, want %v", detail.Referrers, wantReferrers)
+	}
+	wantBrowsers := []NamedCount{{"Chrome", 2}, {"Edge", 1}, {"Firefox", 1}}
+	if !slices.Equal(detail.Browsers, wantBrowsers) {
+		t.Errorf("browsers = %v, want %v", detail.Browsers, wantBrowsers)
+	}
+}
+
+func TestReferrersAreCapped(t *testing.T) {
+	a := useAnalytics(t, 1)
+	for i := range maxReferrersPerCode + 10 {
+		r := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		r.Header.Set("Referer", fmt.Sprintf("https://site%d.example/", i))
+		a.Record("abc123", r)
+	}
+
+	referrers, _ := a.Breakdown("abc123")
+	if len(referrers) != maxReferrersPerCode+1 {
+		t.Errorf("%d referrers tracked, want %d and the overflow", len(referrers), maxReferrersPerCode)
+	}
+	if referrers[0] != (NamedCount{otherReferrer, 10}) {
+		t.Errorf("top referrer = %v, want the 10 over the cap under %s", referrers[0], otherReferrer)
+	}
+}
+
+func TestAnalyticsSampling(t *testing.T) {
+	useMemoryStore(t)
+	useAnalytics(t, 10)
+	saveTestLink(t, "sampled", Link{URL: "https://golang.org/doc"})
+
+	const visits = 4000
+	for i := range visits {
+		ua := firefoxUA
+		if i%4 == 0 {
+			ua = chromeUA
+		}
+		visit("sampled", "", ua)
+	}
+
+	rec := serve("/stats/{code}/detail", handleStatsDetail, httptest.NewRequest(http.MethodGet, "/stats/sampled/detail", nil))
+	detail := decodeJSON[StatsDetailResponse](t, rec)
+	// The total is exact, only the breakdowns are sampled
+	if detail.Clicks != visits {
+		t.Errorf("clicks = %d, want exactly %d", detail.Clicks, visits)
+	}
+	if detail.SampleRate != 10 {
+		t.Errorf("sample_rate = %d, want 10", detail.SampleRate)
+	}
+	// About 400 samples counting for 10 each. The bounds are over 5
+	// standard deviations out, so this doesn't flake.
+	estimates := map[string]int64{}
+	var total int64
+	for _, b := range detail.Browsers {
+		estimates[b.Name] = b.Count
+		total += b.Count
+		if b.Count%10 != 0 {
+			t.Errorf("%s = %d, want a multiple of the rate", b.Name, b.Count)
+		}
+	}
+	for name, want := range map[string]int64{"Firefox": 3000, "Chrome": 1000} {
+		if got := estimates[name]; got < want/2 || got > want*3/2 {
+			t.Errorf("%s estimate = %d, want about %d", name, got, want)
+		}
+	}
+	if total < visits*7/10 || total > visits*13/10 {
+		t.Errorf("browsers sum to %d, want about %d", total, visits)
+	}
+	if len(detail.Referrers) != 1 || detail.Referrers[0].Name != directReferrer || detail.Referrers[0].Count != total {
+		t.Errorf("referrers = %v, want the same estimate under %s", detail.Referrers, directReferrer)
+	}
+}
+
+func TestAnalyticsNoSampling(t *testing.T) {
+	useMemoryStore(t)
+	useAnalytics(t, 1)
+	saveTestLink(t, "exact", Link{URL: "https://golang.org/doc"})
+	for range 37 {
+		visit("exact", "", firefoxUA)
+	}
+	rec := serve("/stats/{code}/detail", handleStatsDetail, httptest.NewRequest(http.MethodGet, "/stats/exact/detail", nil))
+	detail := decodeJSON[StatsDetailResponse](t, rec)
+	if detail.Clicks != 37 || detail.SampleRate != 0 || !slices.Equal(detail.Browsers, []NamedCount{{"Firefox", 37}}) {
+		t.Errorf("detail = %+v, want 37 exact Firefox visits", detail)
+	}
+
+	for _, rate := range []int{0, -1} {
+		cfg := defaultConfig()
+		cfg.AnalyticsSampleRate = rate
+		if err := cfg.validate(); err == nil {
+			t.Errorf("analytics_sample_rate %d accepted", rate)
+		}
+	}
+}
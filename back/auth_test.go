@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	if got := parseAPIKeys(" one, ,two,,"); !slices.Equal(got, []string{"one", "two"}) {
+		t.Errorf("parseAPIKeys = %q, want [one two]", got)
+	}
+	if got := parseAPIKeys(""); len(got) != 0 {
+		t.Errorf(`parseAPIKeys("") = %q, want none`, got)
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	setForTest(t, &apiKeys, []string{"secret-1", "secret-2"})
+	var creator string
+	handler := requireAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creator = requestCreator(r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+		code          string
+	}{
+		{"no header", "", http.StatusUnauthorized, errUnauthorized},
+		{"not bearer", "Basic c2VjcmV0LTE=", http.StatusUnauthorized, errUnauthorized},
+		{"empty key", "Bearer ", http.StatusUnauthorized, errUnauthorized},
+		{"wrong key", "Bearer secret-3", http.StatusForbidden, errForbidden},
+		{"prefix of a key", "Bearer secret", http.StatusForbidden, errForbidden},
+		{"first key", "Bearer secret-1", http.StatusNoContent, ""},
+		{"second key", "Bearer secret-2", http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/links", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d", rec.Code, tt.want)
+			}
+			if tt.code != "" {
+				if body := decodeJSON[ErrorResponse](t, rec); body.Code != tt.code {
+					t.Errorf("error code %q, want %q", body.Code, tt.code)
+				}
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+	if creator != apiKeyID("secret-2") || !strings.HasPrefix(creator, "key:") {
+		t.Errorf("creator = %q, want %q", creator, apiKeyID("secret-2"))
+	}
+}
+
+func TestAPIKeysOnlyGuardWrites(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &apiKeys, []string{"secret"})
+	saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc"})
+	router := testRouter(t)
+	request := func(method, target, key string) int {
+		r := jsonRequest(method, target, `{"url": "https://golang.org/pkg"}`)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	for _, tt := range []struct {
+		method, target, key string
+		want                int
+	}{
+		{http.MethodPost, "/shorten", "", http.StatusUnauthorized},
+		{http.MethodPost, "/shorten", "guess", http.StatusForbidden},
+		{http.MethodPost, "/shorten", "secret", http.StatusOK},
+		{http.MethodGet, "/links", "", http.StatusUnauthorized},
+		{http.MethodGet, "/links", "secret", http.StatusOK},
+		// Redirects and health checks stay public
+		{http.MethodGet, "/abc123", "", http.StatusFound},
+		{http.MethodGet, "/healthz", "", http.StatusOK},
+	} {
+		if got := request(tt.method, tt.target, tt.key); got != tt.want {
+			t.Errorf("%s %s with key %q: status %d, want %d", tt.method, tt.target, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestAdminEndpointsWithoutAPIKeys(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &apiKeys, nil)
+
+	// Nothing to check a key against, so no one gets in
+	r := httptest.NewRequest(http.MethodGet, "/links", nil)
+	r.Header.Set("Authorization", "Bearer anything")
+	rec := httptest.NewRecorder()
+	testRouter(t).ServeHTTP(rec, r)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403", rec.Code)
+	}
+}
+
+func TestCreatorMetadata(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &apiKeys, []string{"secret"})
+	router := testRouter(t)
+	send := func(r *http.Request, key string) *httptest.ResponseRecorder {
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+
+	r := jsonRequest(http.MethodPost, "/shorten", `{"url": "https://golang.org/doc"}`)
+	r.RemoteAddr = "203.0.113.7:4321"
+	rec := send(r, "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("shorten: status %d: %s", rec.Code, rec.Body)
+	}
+	code := decodeJSON[ShortenResponse](t, rec).Code
+	if strings.Contains(rec.Body.String(), "203.0.113.7") || strings.Contains(rec.Body.String(), "created_by") {
+		t.Errorf("shorten response gives away creator metadata: %s", rec.Body)
+	}
+
+	// Recorded with the link: the key's fingerprint, never the key itself
+	link, err := store.Lookup(t.Context(), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.CreatedBy != apiKeyID("secret") || !strings.HasPrefix(link.CreatedBy, "key:") || link.CreatorIP != "203.0.113.7" {
+		t.Errorf("stored creator = %q from %q", link.CreatedBy, link.CreatorIP)
+	}
+
+	// Admins see it in the list
+	list := decodeJSON[LinkListResponse](t, send(httptest.NewRequest(http.MethodGet, "/links", nil), "secret"))
+	if len(list.Links) != 1 || list.Links[0].CreatedBy != link.CreatedBy || list.Links[0].CreatorIP != "203.0.113.7" {
+		t.Errorf("admin list = %+v", list.Links)
+	}
+	// Anyone else doesn't, on the list or the public stats
+	if rec := send(httptest.NewRequest(http.MethodGet, "/links", nil), ""); rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "203.0.113.7") {
+		t.Errorf("list without a key: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = send(httptest.NewRequest(http.MethodGet, "/stats/"+code, nil), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stats: status %d", rec.Code)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "203.0.113.7") || strings.Contains(body, "key:") || strings.Contains(body, "created_by") {
+		t.Errorf("public stats give away creator metadata: %s", body)
+	}
+}
+
+func TestAnonymousCreator(t *testing.T) {
+	useMemoryStore(t)
+	code := shortenOK(t, `{"url": "https://golang.org/doc"}`).Code
+	link, err := store.Lookup(t.Context(), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.CreatedBy != "anonymous" || link.CreatorIP == "" {
+		t.Errorf("stored creator = %q from %q, want anonymous with an IP", link.CreatedBy, link.CreatorIP)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Maximum number of URLs accepted in one batch request
+const maxBatchSize = 100
+
+// BatchItem is one entry of a batch request: either a plain URL string
+// or a full ShortenRequest object (to pass a custom alias, TTL, ...)
+type BatchItem struct {
+	ShortenRequest
+}
+
+// UnmarshalJSON accepts both forms of BatchItem
+func (b *BatchItem) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		b.URL = url
+		return nil
+	}
+	// json.Unmarshal would accept unknown fields, unlike decodeJSONBody
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&b.ShortenRequest)
+}
+
+// Result structure for one URL of a batch. Error and ErrorCode are set
+// instead of ShortURL and Code when that URL couldn't be shortened.
+type BatchResult struct {
+	OriginalURL string `json:"original_url"`
+	ShortURL    string `json:"short_url,omitempty"`
+	Code        string `json:"code,omitempty"`
+	Error       string `json:"error,omitempty"`
+	ErrorCode   string `json:"error_code,omitempty"` // One of the err* error codes, set along with Error
+}
+
+// fail marks the result as failed with the given message and error code
+func (b *BatchResult) fail(message, code string) {
+	b.Error = message
+	b.ErrorCode = code
+}
+
+// pendingSave is a batch item waiting to be written to the store
+type pendingSave struct {
+	index   int // Position in the request (and results)
+	entry   Entry
+	attempt int // For generated codes, see CodeGenerator
+}
+
+// handleShortenBatch shortens many URLs in one request. Items are
+// validated one by one, so a bad URL only fails its own result.
+func handleShortenBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+
+	var items []BatchItem
+	// Enough for a full batch of maximum length URLs
+	if !decodeJSONBody(w, r, "shorten_batch", maxBatchSize*maxShortenBodySize(), &items) {
+		return
+	}
+	defer r.Body.Close()
+
+	if len(items) > maxBatchSize {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Too many URLs in batch (maximum is %d)", maxBatchSize), errTooLarge)
+		logRequest(r, http.StatusRequestEntityTooLarge, "shorten_batch", "Batch too large", "size", len(items))
+		return
+	}
+
+	results := make([]BatchResult, len(items))
+	var pending []pendingSave
+	// With DEDUPE, a URL repeated in the batch gets the result of its
+	// first occurrence, by index in results
+	firstOf := make(map[string]int)
+	repeats := make(map[int]int)
+	for i, item := range items {
+		results[i].OriginalURL = item.URL
+		item.CustomAlias = canonicalCode(item.CustomAlias)
+		item.Tenant = requestTenant(r, item.Tenant)
+		items[i].Tenant = item.Tenant // Read again when retrying
+
+		link, serr := buildLink(r, item.ShortenRequest)
+		if serr != nil {
+			results[i].fail(serr.message, serr.code)
+			continue
+		}
+		results[i].OriginalURL = link.URL // Normalized
+
+		if canDedupe(item.ShortenRequest) {
+			key := tenantKey(item.Tenant, link.URL)
+			if first, ok := firstOf[key]; ok {
+				repeats[i] = first
+				continue
+			}
+			firstOf[key] = i
+			code, err := findReusableCode(r.Context(), item.Tenant, link.URL)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error looking up existing short URL", "event", "shorten_batch", "url", link.URL, "error", err)
+				results[i].fail("Error looking up existing short URL", errUnavailable)
+				continue
+			}
+			if code != "" {
+				results[i].Code = code
+				continue
+			}
+		}
+
+		code := item.CustomAlias
+		if code == "" {
+			var err error
+			if code, err = codeGenerator.Code(r.Context(), 0, link.URL, requestAlphabet(item.ShortenRequest), requestCodeLength(item.ShortenRequest)); err != nil {
+				slog.ErrorContext(r.Context(), "Error generating short code", "event", "shorten_batch", "error", err)
+				results[i].fail("Error saving short URL", errUnavailable)
+				continue
+			}
+		}
+		pending = append(pending, pendingSave{index: i, entry: Entry{Code: tenantKey(item.Tenant, code), Link: link}})
+	}
+
+	// Save everything in one go. Random codes that collided get a new code
+	// and are retried in another round, which is almost never needed.
+	for len(pending) > 0 {
+		entries := make([]Entry, len(pending))
+		for j, p := range pending {
+			entries[j] = p.entry
+		}
+
+		var retry []pendingSave
+		for j, err := range saveEntries(r.Context(), entries) {
+			p := pending[j]
+			switch {
+			case err == nil:
+				results[p.index].Code = p.entry.Code
+			case errors.Is(err, ErrStoreFull):
+				results[p.index].fail("Storage is full, no more links can be created", errStorageFull)
+			case errors.Is(err, ErrCodeExists) && items[p.index].CustomAlias != "":
+				if code, serr := existingAlias(r.Context(), items[p.index].ShortenRequest, p.entry.Code, p.entry.Link); serr != nil {
+					results[p.index].fail(serr.message, serr.code)
+				} else {
+					results[p.index].Code = code
+				}
+			case errors.Is(err, ErrCodeExists):
+				if same, err := hashedLinkExists(r.Context(), p.entry.Code, items[p.index].ShortenRequest, p.entry.Link); err != nil {
+					slog.ErrorContext(r.Context(), "Error looking up existing short URL", "event", "shorten_batch", "code", p.entry.Code, "error", err)
+					results[p.index].fail("Error looking up existing short URL", errUnavailable)
+					continue
+				} else if same {
+					results[p.index].Code = p.entry.Code
+					continue
+				}
+				p.attempt++
+				code, err := codeGenerator.Code(r.Context(), p.attempt, p.entry.Link.URL, requestAlphabet(items[p.index].ShortenRequest), requestCodeLength(items[p.index].ShortenRequest))
+				if errors.Is(err, errNoFreeCode) {
+					slog.ErrorContext(r.Context(), "Could not find a free short code", "event", "shorten_batch", "attempts", p.attempt)
+					results[p.index].fail("Could not generate a unique short code, please try again", errInternal)
+					continue
+				}
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Error generating short code", "event", "shorten_batch", "error", err)
+					results[p.index].fail("Error saving short URL", errUnavailable)
+					continue
+				}
+				p.entry.Code = tenantKey(items[p.index].Tenant, code)
+				retry = append(retry, p)
+			default:
+				slog.ErrorContext(r.Context(), "Error saving short URL", "event", "shorten_batch", "code", p.entry.Code, "error", err)
+				results[p.index].fail("Error saving short URL", errUnavailable)
+			}
+		}
+		pending = retry
+	}
+	for i, first := range repeats {
+		results[i] = results[first]
+	}
+
+	shortened := 0
+	for i := range results {
+		if results[i].Code != "" {
+			results[i].ShortURL = shortLink(r, results[i].Code)
+			notifyWebhook(newShortenEvent(r, results[i].Code, results[i].OriginalURL))
+			favicons.prefetch(results[i].OriginalURL)
+			shortened++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "shorten_batch", "Error encoding response", "error", err)
+		return
+	}
+	logRequest(r, http.StatusOK, "shorten_batch", "Shortened batch", "shortened", shortened, "size", len(items))
+}
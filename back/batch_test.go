@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postBatch sends a JSON body to handleShortenBatch
+func postBatch(body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleShortenBatch(rec, jsonRequest(http.MethodPost, "/shorten/batch", body))
+	return rec
+}
+
+func TestShortenBatchPartialFailure(t *testing.T) {
+	s := useMemoryStore(t)
+	saveTestLink(t, "taken", Link{URL: "https://golang.org/other"})
+
+	rec := postBatch(`[
+		"https://golang.org/doc",
+		"not a url",
+		{"url": "https://golang.org/pkg", "custom_alias": "go-pkg"},
+		{"url": "https://golang.org/blog", "custom_alias": "taken"},
+		{"url": "https://golang.org/ref", "custom_alias": "bad alias!"}
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	results := decodeJSON[[]BatchResult](t, rec)
+	if len(results) != 5 {
+		t.Fatalf("got %d results, want 5: %+v", len(results), results)
+	}
+
+	for _, i := range []int{0, 2} {
+		if results[i].Error != "" || results[i].Code == "" || !strings.HasSuffix(results[i].ShortURL, "/"+results[i].Code) {
+			t.Errorf("result %d should have succeeded: %+v", i, results[i])
+		}
+	}
+	if results[2].Code != "go-pkg" {
+		t.Errorf("custom alias: code %q, want go-pkg", results[2].Code)
+	}
+	for i, want := range map[int]string{1: errInvalidURL, 3: errConflict, 4: errInvalidAlias} {
+		if results[i].ErrorCode != want || results[i].Error == "" || results[i].Code != "" || results[i].ShortURL != "" {
+			t.Errorf("result %d: %+v, want error code %s", i, results[i], want)
+		}
+	}
+
+	// Only the good ones were saved, next to the link that was there
+	if n := storeSize(t); n != 3 {
+		t.Errorf("store has %d links, want 3", n)
+	}
+	link, err := s.Lookup(t.Context(), results[0].Code)
+	if err != nil || link.URL != "https://golang.org/doc" {
+		t.Errorf("Lookup(%q) = %+v, %v", results[0].Code, link, err)
+	}
+}
+
+func TestShortenBatchTooLarge(t *testing.T) {
+	useMemoryStore(t)
+	urls := make([]string, maxBatchSize+1)
+	for i := range urls {
+		urls[i] = fmt.Sprintf(`"https://golang.org/doc/%d"`, i)
+	}
+
+	rec := postBatch("[" + strings.Join(urls, ",") + "]")
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", rec.Code)
+	}
+	if body := decodeJSON[ErrorResponse](t, rec); body.Code != errTooLarge {
+		t.Errorf("error code = %q, want %q", body.Code, errTooLarge)
+	}
+}
+
+func TestShortenBatchDedupe(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &dedupe, true)
+
+	results := decodeJSON[[]BatchResult](t, postBatch(`[
+		"https://golang.org/doc",
+		"https://golang.org/pkg",
+		"https://GOLANG.org/doc",
+		{"url": "https://golang.org/doc", "custom_alias": "go-doc"}
+	]`))
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want 4: %+v", len(results), results)
+	}
+	if results[0].Code == "" || results[2] != results[0] {
+		t.Errorf("repeated URL: %+v, want the first result again: %+v", results[2], results[0])
+	}
+	// A custom alias is asked for on purpose, so it's its own link
+	if results[3].Code != "go-doc" {
+		t.Errorf("custom alias: code %q, want go-doc", results[3].Code)
+	}
+	if n := storeSize(t); n != 3 {
+		t.Errorf("store has %d links, want 3", n)
+	}
+
+	// Without DEDUPE every URL gets its own code
+	setForTest(t, &dedupe, false)
+	results = decodeJSON[[]BatchResult](t, postBatch(`["https://golang.org/blog", "https://golang.org/blog"]`))
+	if results[0].Code == results[1].Code {
+		t.Errorf("both got %q with DEDUPE off", results[0].Code)
+	}
+}
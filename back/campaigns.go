@@ -0,0 +1,92 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// normalizeCampaign lowercases a campaign name and checks it. Names follow
+// the same rules as tags.
+func normalizeCampaign(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !tagPattern.MatchString(name) {
+		return "", fmt.Errorf("%q is not a valid campaign name (use up to 32 letters, digits, dashes or underscores)", name)
+	}
+	return name, nil
+}
+
+// CampaignLink is one link's share of a campaign
+type CampaignLink struct {
+	Code   string `json:"code"`
+	URL    string `json:"url"`
+	Clicks int64  `json:"clicks"`
+}
+
+// Response structure for GET /campaigns/{name}
+type CampaignResponse struct {
+	Campaign string         `json:"campaign"`
+	Links    int            `json:"links"`
+	Clicks   int64          `json:"clicks"`   // Across all of its links
+	PerLink  []CampaignLink `json:"per_link"` // Most clicked first
+}
+
+// handleCampaign adds up the clicks of every link shortened with the
+// campaign. Unlike tags, which only filter GET /links, a link is in at most
+// one campaign, so the totals don't count anything twice. Admin only, like
+// handleListLinks.
+func handleCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	name, err := normalizeCampaign(r.PathValue("name"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid campaign name", errInvalidRequest)
+		return
+	}
+
+	resp := CampaignResponse{Campaign: name, PerLink: []CampaignLink{}}
+	// Page by cursor, so links created meanwhile don't shift the pages.
+	// One extra link tells whether there's another page, like in handleListLinks.
+	opts := ListOptions{Campaign: name, Limit: maxListLimit + 1}
+	for {
+		entries, _, err := store.List(r.Context(), opts)
+		if err != nil {
+			writeJSONError(w, http.StatusServiceUnavailable, "Error listing campaign links", errUnavailable)
+			logRequest(r, http.StatusServiceUnavailable, "campaign", "Error listing campaign links", "campaign", name, "error", err)
+			return
+		}
+		more := len(entries) > maxListLimit
+		entries = entries[:min(len(entries), maxListLimit)]
+		for _, entry := range entries {
+			resp.PerLink = append(resp.PerLink, CampaignLink{Code: entry.Code, URL: entry.Link.URL, Clicks: entry.Link.Clicks})
+			resp.Clicks += entry.Link.Clicks
+		}
+		if !more {
+			break
+		}
+		opts.After = entries[len(entries)-1].Code
+	}
+	if len(resp.PerLink) == 0 {
+		writeJSONError(w, http.StatusNotFound, "Campaign not found", errNotFound)
+		logRequest(r, http.StatusNotFound, "campaign", "Campaign not found", "campaign", name)
+		return
+	}
+	resp.Links = len(resp.PerLink)
+	slices.SortFunc(resp.PerLink, func(a, b CampaignLink) int {
+		return cmp.Or(cmp.Compare(b.Clicks, a.Clicks), cmp.Compare(a.Code, b.Code))
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "campaign", "Error encoding response", "error", err)
+		return
+	}
+	logRequest(r, http.StatusOK, "campaign", "Served campaign stats", "campaign", name, "links", resp.Links)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// getCampaign asks handleCampaign for the totals of name
+func getCampaign(name string) *httptest.ResponseRecorder {
+	return serve("/campaigns/{name}", handleCampaign, httptest.NewRequest(http.MethodGet, "/campaigns/"+name, nil))
+}
+
+func TestCampaignTotals(t *testing.T) {
+	for name, open := range map[string]func(t *testing.T){
+		"memory": func(t *testing.T) { useMemoryStore(t) },
+		"sqlite": func(t *testing.T) {
+			setForTest(t, &store, Store(openTestSQLite(t, filepath.Join(t.TempDir(), "links.db"))))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			open(t)
+			// Names are case-insensitive, so these are all one campaign
+			a := shortenOK(t, `{"url": "https://golang.org/a", "campaign": "Spring-Sale"}`).Code
+			b := shortenOK(t, `{"url": "https://golang.org/b", "campaign": "spring-sale"}`).Code
+			c := shortenOK(t, `{"url": "https://golang.org/c", "campaign": " SPRING-SALE "}`).Code
+			other := shortenOK(t, `{"url": "https://golang.org/d", "campaign": "winter"}`).Code
+			none := shortenOK(t, `{"url": "https://golang.org/e"}`).Code
+			for code, clicks := range map[string]int{a: 3, b: 1, other: 5, none: 2} {
+				for range clicks {
+					if rec := getRedirect("/" + code); rec.Code != http.StatusFound {
+						t.Fatalf("/%s: status %d", code, rec.Code)
+					}
+				}
+			}
+
+			rec := getCampaign("Spring-Sale")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			resp := decodeJSON[CampaignResponse](t, rec)
+			if resp.Campaign != "spring-sale" || resp.Links != 3 || resp.Clicks != 4 {
+				t.Errorf("totals = %+v, want 3 links and 4 clicks in spring-sale", resp)
+			}
+			want := []CampaignLink{
+				{Code: a, URL: "https://golang.org/a", Clicks: 3},
+				{Code: b, URL: "https://golang.org/b", Clicks: 1},
+				{Code: c, URL: "https://golang.org/c", Clicks: 0},
+			}
+			if fmt.Sprint(resp.PerLink) != fmt.Sprint(want) {
+				t.Errorf("per link = %+v, want the most clicked first: %+v", resp.PerLink, want)
+			}
+
+			if resp := decodeJSON[CampaignResponse](t, getCampaign("winter")); resp.Links != 1 || resp.Clicks != 5 || resp.PerLink[0].Code != other {
+				t.Errorf("winter = %+v", resp)
+			}
+		})
+	}
+}
+
+func TestCampaignTotalsPaged(t *testing.T) {
+	useMemoryStore(t)
+	entries := make([]Entry, maxListLimit+5)
+	for i := range entries {
+		entries[i] = Entry{Code: fmt.Sprintf("big%04d", i), Link: Link{URL: "https://golang.org/doc", Clicks: 2, Campaign: "big"}}
+	}
+	for _, err := range store.SaveMany(t.Context(), entries) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp := decodeJSON[CampaignResponse](t, getCampaign("big"))
+	if resp.Links != maxListLimit+5 || resp.Clicks != 2*(maxListLimit+5) || len(resp.PerLink) != resp.Links {
+		t.Errorf("%d links, %d clicks, %d per link; want all %d links over several pages", resp.Links, resp.Clicks, len(resp.PerLink), maxListLimit+5)
+	}
+}
+
+func TestCampaignErrors(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "tagged", Link{URL: "https://golang.org/doc", Tags: []string{"spring"}})
+	tests := []struct {
+		name   string
+		r      *http.Request
+		status int
+	}{
+		// A tag isn't a campaign
+		{"unknown", httptest.NewRequest(http.MethodGet, "/campaigns/spring", nil), http.StatusNotFound},
+		{"invalid name", httptest.NewRequest(http.MethodGet, "/campaigns/no%20spaces", nil), http.StatusBadRequest},
+		{"POST", httptest.NewRequest(http.MethodPost, "/campaigns/spring", nil), http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if rec := serve("/campaigns/{name}", handleCampaign, tt.r); rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+	}
+	if rec := postShorten(t, `{"url": "https://golang.org/doc", "campaign": "not valid!"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid campaign on shorten: status %d, want 400", rec.Code)
+	}
+}
+
+func TestCampaignAdminOnly(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &apiKeys, []string{"secret"})
+	saveTestLink(t, "spring", Link{URL: "https://golang.org/doc", Campaign: "spring"})
+	router := testRouter(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/campaigns/spring", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: status %d, want 401", rec.Code)
+	}
+	r.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Errorf("with the key: status %d, want 200", rec.Code)
+	}
+}
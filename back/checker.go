@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// URLChecker decides whether a URL may be shortened. Implementations can
+// be a local blocklist or a remote service like Google Safe Browsing, so
+// Check gets the request context for timeouts and cancellation.
+type URLChecker interface {
+	// Check returns blocked=true and a short reason (shown to the client)
+	// for URLs that must not be shortened. err means the check itself
+	// failed and nothing is known about the URL.
+	Check(ctx context.Context, u *url.URL) (blocked bool, reason string, err error)
+}
+
+// urlChecker vets URLs before they're shortened, nil if nothing is configured
+var urlChecker URLChecker
+
+// multiChecker runs several checkers in order, the first to block wins
+type multiChecker []URLChecker
+
+func (m multiChecker) Check(ctx context.Context, u *url.URL) (bool, string, error) {
+	for _, c := range m {
+		if blocked, reason, err := c.Check(ctx, u); blocked || err != nil {
+			return blocked, reason, err
+		}
+	}
+	return false, "", nil
+}
+
+// blocklistChecker blocks URLs whose host, or any domain above it, is on
+// the list: blocking "example.com" also blocks "www.example.com".
+type blocklistChecker struct {
+	hosts map[string]bool
+}
+
+// newBlocklistChecker builds a checker from host names (case and a
+// trailing dot don't matter, blank entries are skipped)
+func newBlocklistChecker(hosts []string) *blocklistChecker {
+	c := &blocklistChecker{hosts: make(map[string]bool)}
+	for _, host := range hosts {
+		if host = normalizeHost(host); host != "" {
+			c.hosts[host] = true
+		}
+	}
+	return c
+}
+
+// normalizeHost puts a host name in the form the blocklist is keyed by
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// Check looks up the host and each of its parent domains
+func (c *blocklistChecker) Check(_ context.Context, u *url.URL) (bool, string, error) {
+	host := normalizeHost(u.Hostname())
+	for host != "" {
+		if c.hosts[host] {
+			return true, "domain is blocklisted", nil
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			break
+		}
+		host = parent
+	}
+	return false, "", nil
+}
+
+// How long resolving a destination host may take
+const resolveTimeout = 3 * time.Second
+
+// privateHostChecker blocks destinations on private, loopback and
+// link-local addresses (like 169.254.169.254, the cloud metadata server),
+// so links can't be used to reach internal services. Host names are
+// resolved and blocked if any of their addresses is internal.
+type privateHostChecker struct {
+	resolver *net.Resolver
+}
+
+// Carrier-grade NAT range, internal like the private ranges but not covered by netip.Addr.IsPrivate
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// internalAddr reports whether ip isn't publicly routable
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap() // ::ffff:10.0.0.1 is 10.0.0.1
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+func (c privateHostChecker) Check(ctx context.Context, u *url.URL) (bool, string, error) {
+	host := normalizeHost(u.Hostname())
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if internalAddr(ip) {
+			return true, "destination is a private address", nil
+		}
+		return false, "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	ips, err := c.resolver.LookupNetIP(ctx, "ip", host)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return true, "destination host doesn't resolve", nil
+	}
+	if err != nil {
+		return false, "", fmt.Errorf("resolving %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if internalAddr(ip) {
+			return true, "destination resolves to a private address", nil
+		}
+	}
+	return false, "", nil
+}
+
+// loadBlocklist combines the configured hosts with the ones in the file at
+// path (one per line, # starts a comment). It returns nil if neither is set.
+func loadBlocklist(hosts []string, path string) (*blocklistChecker, error) {
+	hosts = slices.Clone(hosts)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening BLOCKLIST_FILE: %w", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			hosts = append(hosts, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading BLOCKLIST_FILE: %w", err)
+		}
+	}
+
+	if len(hosts) == 0 && path == "" {
+		return nil, nil
+	}
+	return newBlocklistChecker(hosts), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlocklistChecker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	sample := "# Known bad\nmalware.test\n\n  Phishing.Example.  # trailing comment\n"
+	if err := os.WriteFile(path, []byte(sample), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	checker, err := loadBlocklist([]string{"spam.test"}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for raw, want := range map[string]bool{
+		"https://malware.test/x":           true,
+		"https://MALWARE.test./x":          true,
+		"https://cdn.malware.test/x":       true, // Subdomains of a listed domain
+		"https://phishing.example/login":   true,
+		"https://spam.test":                true, // From the setting, next to the file
+		"https://notmalware.test/x":        false,
+		"https://malware.test.example/x":   false,
+		"https://golang.org/doc":           false,
+		"https://example/phishing.example": false,
+	} {
+		u, _ := url.Parse(raw)
+		blocked, reason, err := checker.Check(t.Context(), u)
+		if err != nil || blocked != want {
+			t.Errorf("Check(%s) = %v, %v, want %v", raw, blocked, err, want)
+		}
+		if blocked && reason == "" {
+			t.Errorf("Check(%s) blocked without a reason", raw)
+		}
+	}
+
+	if checker, err := loadBlocklist(nil, ""); checker != nil || err != nil {
+		t.Errorf("nothing configured: %v, %v, want no checker", checker, err)
+	}
+	if _, err := loadBlocklist(nil, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("a missing BLOCKLIST_FILE was accepted")
+	}
+}
+
+func TestShortenBlockedURL(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &urlChecker, URLChecker(newBlocklistChecker([]string{"malware.test"})))
+
+	rec := postShorten(t, `{"url": "https://www.malware.test/payload"}`)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403", rec.Code)
+	}
+	if body := decodeJSON[ErrorResponse](t, rec); body.Code != errBlockedURL {
+		t.Errorf("error %+v", body)
+	}
+	if n := storeSize(t); n != 0 {
+		t.Errorf("store has %d links, want none", n)
+	}
+	shortenOK(t, `{"url": "https://golang.org/doc"}`)
+}
+
+// failingChecker can't tell whether a URL is safe
+type failingChecker struct{}
+
+func (failingChecker) Check(context.Context, *url.URL) (bool, string, error) {
+	return false, "", errors.New("lookup service down")
+}
+
+func TestShortenCheckerFailure(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &urlChecker, URLChecker(multiChecker{newBlocklistChecker(nil), failingChecker{}}))
+
+	if rec := postShorten(t, `{"url": "https://golang.org/doc"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", rec.Code)
+	}
+}
+
+func TestPrivateHostChecker(t *testing.T) {
+	checker := privateHostChecker{resolver: &net.Resolver{PreferGo: true}}
+	for raw, want := range map[string]bool{
+		"http://10.0.0.1/admin":          true,
+		"http://10.255.255.254":          true,
+		"http://127.0.0.1:8080/":         true,
+		"http://169.254.169.254/latest/": true, // Cloud metadata
+		"http://192.168.1.1":             true,
+		"http://172.16.0.1":              true,
+		"http://100.64.0.1":              true,
+		"http://[::1]/":                  true,
+		"http://[::ffff:10.0.0.1]/":      true,
+		"http://[fe80::1]/":              true,
+		"http://0.0.0.0/":                true,
+		"http://localhost:8080/":         true, // Resolved, from /etc/hosts
+		"https://8.8.8.8/":               false,
+		"https://93.184.215.14/":         false,
+		"http://172.32.0.1":              false, // Just outside 172.16.0.0/12
+		"https://[2606:4700::1111]/":     false,
+	} {
+		u, _ := url.Parse(raw)
+		blocked, reason, err := checker.Check(t.Context(), u)
+		if err != nil || blocked != want {
+			t.Errorf("Check(%s) = %v, %q, %v, want %v", raw, blocked, reason, err, want)
+		}
+	}
+}
+
+func TestShortenPrivateHost(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &urlChecker, URLChecker(privateHostChecker{resolver: &net.Resolver{PreferGo: true}}))
+
+	if rec := postShorten(t, `{"url": "http://169.254.169.254/latest/meta-data"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("metadata address: status %d, want 403", rec.Code)
+	}
+	shortenOK(t, `{"url": "https://8.8.8.8/"}`)
+
+	// Off, which is the default, internal links can be shortened
+	setForTest(t, &urlChecker, nil)
+	shortenOK(t, `{"url": "http://10.0.0.1/wiki"}`)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Default for CLICK_FLUSH_THRESHOLD
+const defaultClickFlushThreshold = 1000
+
+// clickBuffer collects click increments in memory and writes them to the
+// wrapped store with AddClicks every interval, or as soon as threshold
+// clicks are pending, instead of one write per redirect.
+//
+// Since IncrementClicks doesn't reach the store, it returns only the
+// clicks pending for the code and never ErrNotFound. Lookup adds the
+// pending clicks, so stats stay current; List, Summary and exports lag
+// behind by up to interval. Clicks still pending when the process dies
+// without Close are lost.
+type clickBuffer struct {
+	Store
+	threshold int
+
+	mu      sync.Mutex
+	pending map[string]int64
+	total   int // Sum of pending, compared to threshold
+
+	full chan struct{} // Wakes flushLoop early, see IncrementClicks
+	stop chan struct{}
+	done chan struct{}
+}
+
+// withClickBuffer wraps s so clicks are written every interval
+// (0 leaves s writing each click right away)
+func withClickBuffer(s Store, interval time.Duration, threshold int) Store {
+	if interval <= 0 {
+		return s
+	}
+	b := &clickBuffer{
+		Store:     s,
+		threshold: threshold,
+		pending:   make(map[string]int64),
+		full:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go b.flushLoop(interval)
+	return b
+}
+
+func (b *clickBuffer) IncrementClicks(_ context.Context, code string) (int64, error) {
+	b.mu.Lock()
+	b.pending[code]++
+	clicks := b.pending[code]
+	b.total++
+	full := b.threshold > 0 && b.total >= b.threshold
+	b.mu.Unlock()
+
+	if full {
+		// Don't block the redirect, a flush is already on its way if this is taken
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return clicks, nil
+}
+
+func (b *clickBuffer) Lookup(ctx context.Context, code string) (Link, error) {
+	link, err := b.Store.Lookup(ctx, code)
+	if err != nil {
+		return link, err
+	}
+	b.mu.Lock()
+	link.Clicks += b.pending[code]
+	b.mu.Unlock()
+	return link, nil
+}
+
+// flush writes the pending clicks to the store. If that fails they're put
+// back to be tried again with the next flush: AddClicks writes all of
+// them or none, so none are counted twice.
+func (b *clickBuffer) flush(ctx context.Context) {
+	b.mu.Lock()
+	clicks, total := b.pending, b.total
+	b.pending, b.total = make(map[string]int64), 0
+	b.mu.Unlock()
+	if total == 0 {
+		return
+	}
+
+	if err := b.Store.AddClicks(ctx, clicks); err != nil {
+		slog.Error("Error writing buffered clicks, will retry", "event", "click_flush", "links", len(clicks), "clicks", total, "error", err)
+		b.mu.Lock()
+		for code, n := range clicks {
+			b.pending[code] += n
+		}
+		b.total += total
+		b.mu.Unlock()
+		return
+	}
+	slog.Debug("Wrote buffered clicks", "event", "click_flush", "links", len(clicks), "clicks", total)
+}
+
+// flushLoop flushes every interval, or early when the buffer fills up,
+// until Close
+func (b *clickBuffer) flushLoop(interval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		b.flush(context.Background())
+	}
+}
+
+// Close writes the clicks still pending before closing the store, so a
+// graceful shutdown doesn't lose any
+func (b *clickBuffer) Close() error {
+	close(b.stop)
+	<-b.done
+	b.flush(context.Background())
+	return b.Store.Close()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storedClicks reads the clicks of code from s, under any buffer
+func storedClicks(t *testing.T, s Store, code string) int64 {
+	t.Helper()
+	link, err := s.Lookup(t.Context(), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link.Clicks
+}
+
+// waitForClicks waits until s has want clicks for code
+func waitForClicks(t *testing.T, s Store, code string, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for storedClicks(t, s, code) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: %d clicks stored, want %d", code, storedClicks(t, s, code), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClickBufferFlushesOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.db")
+	backing := openTestSQLite(t, path)
+	if err := backing.Save(t.Context(), "busy", Link{URL: "https://golang.org/doc", Clicks: 2}); err != nil {
+		t.Fatal(err)
+	}
+	buffered := withClickBuffer(backing, time.Hour, 0)
+	setForTest(t, &store, buffered)
+
+	for range 5 {
+		if rec := getRedirect("/busy"); rec.Code != http.StatusFound {
+			t.Fatalf("status %d", rec.Code)
+		}
+	}
+	// Pending: not written yet, but counted in stats
+	if got := storedClicks(t, backing, "busy"); got != 2 {
+		t.Errorf("store has %d clicks before a flush, want 2", got)
+	}
+	if got := storedClicks(t, buffered, "busy"); got != 7 {
+		t.Errorf("Lookup through the buffer = %d, want 7", got)
+	}
+	stats := decodeJSON[StatsResponse](t, getStats("/stats/busy", nil))
+	if stats.Clicks != 7 {
+		t.Errorf("stats clicks = %d, want 7", stats.Clicks)
+	}
+
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := openTestSQLite(t, path)
+	if got := storedClicks(t, reopened, "busy"); got != 7 {
+		t.Errorf("after Close and reopening: %d clicks, want 7", got)
+	}
+}
+
+func TestClickBufferFlushesPeriodically(t *testing.T) {
+	backing := useMemoryStore(t)
+	saveTestLink(t, "a", Link{URL: "https://golang.org/a"})
+	saveTestLink(t, "b", Link{URL: "https://golang.org/b"})
+	buffered := withClickBuffer(backing, 10*time.Millisecond, 0)
+	t.Cleanup(func() { buffered.Close() })
+
+	for range 3 {
+		buffered.IncrementClicks(t.Context(), "a")
+	}
+	buffered.IncrementClicks(t.Context(), "b")
+	// Clicks for links deleted meanwhile are dropped, not an error
+	buffered.IncrementClicks(t.Context(), "deleted")
+	waitForClicks(t, backing, "a", 3)
+	waitForClicks(t, backing, "b", 1)
+}
+
+func TestClickBufferFlushesAtThreshold(t *testing.T) {
+	backing := useMemoryStore(t)
+	saveTestLink(t, "hot", Link{URL: "https://golang.org/doc"})
+	buffered := withClickBuffer(backing, time.Hour, 10)
+	t.Cleanup(func() { buffered.Close() })
+
+	for i := range 9 {
+		if n, err := buffered.IncrementClicks(t.Context(), "hot"); err != nil || n != int64(i+1) {
+			t.Fatalf("IncrementClicks = %d, %v; want the pending %d", n, err, i+1)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := storedClicks(t, backing, "hot"); got != 0 {
+		t.Errorf("%d clicks written under the threshold", got)
+	}
+	buffered.IncrementClicks(t.Context(), "hot")
+	waitForClicks(t, backing, "hot", 10)
+}
+
+func TestClickBufferOff(t *testing.T) {
+	backing := useMemoryStore(t)
+	if got := withClickBuffer(backing, 0, 10); got != Store(backing) {
+		t.Errorf("CLICK_FLUSH_INTERVAL=0 wrapped the store in %T", got)
+	}
+}
+
+// flakyClicksStore fails AddClicks until fail is false
+type flakyClicksStore struct {
+	Store
+	mu   sync.Mutex
+	fail bool
+}
+
+func (f *flakyClicksStore) AddClicks(ctx context.Context, clicks map[string]int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		return errors.New("database is locked")
+	}
+	return f.Store.AddClicks(ctx, clicks)
+}
+
+func TestClickBufferRetriesFailedFlush(t *testing.T) {
+	backing := useMemoryStore(t)
+	saveTestLink(t, "retry", Link{URL: "https://golang.org/doc"})
+	flaky := &flakyClicksStore{Store: backing, fail: true}
+	b := withClickBuffer(flaky, time.Hour, 0).(*clickBuffer)
+
+	for range 4 {
+		b.IncrementClicks(t.Context(), "retry")
+	}
+	b.flush(t.Context())
+	if got := storedClicks(t, backing, "retry"); got != 0 {
+		t.Fatalf("%d clicks written by a failed flush", got)
+	}
+	// Kept for the next one, still counted in stats
+	if got := storedClicks(t, b, "retry"); got != 4 {
+		t.Errorf("Lookup after a failed flush = %d, want 4", got)
+	}
+
+	b.IncrementClicks(t.Context(), "retry")
+	flaky.mu.Lock()
+	flaky.fail = false
+	flaky.mu.Unlock()
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedClicks(t, backing, "retry"); got != 5 {
+		t.Errorf("after the retry: %d clicks, want 5", got)
+	}
+}
+
+// A flush that fails on one link mustn't have written the others: they're
+// all put back, and would be counted twice on the retry
+func TestClickBufferPartialFailureNotCountedTwice(t *testing.T) {
+	backing := openTestSQLite(t, filepath.Join(t.TempDir(), "links.db"))
+	for _, code := range []string{"fine", "stuck"} {
+		if err := backing.Save(t.Context(), code, Link{URL: "https://golang.org/" + code}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Fails the UPDATE of one row, after others may have gone through
+	if _, err := backing.db.Exec(`CREATE TRIGGER stuck_clicks BEFORE UPDATE OF clicks ON links
+		WHEN NEW.code = 'stuck' BEGIN SELECT RAISE(ABORT, 'disk I/O error'); END`); err != nil {
+		t.Fatal(err)
+	}
+	b := withClickBuffer(backing, time.Hour, 0).(*clickBuffer)
+	t.Cleanup(func() { b.Close() })
+	for range 3 {
+		b.IncrementClicks(t.Context(), "fine")
+	}
+	b.IncrementClicks(t.Context(), "stuck")
+
+	b.flush(t.Context())
+	if got := storedClicks(t, backing, "fine"); got != 0 {
+		t.Errorf("%d clicks written by a failed flush, want none", got)
+	}
+
+	if _, err := backing.db.Exec(`DROP TRIGGER stuck_clicks`); err != nil {
+		t.Fatal(err)
+	}
+	b.flush(t.Context())
+	if got := storedClicks(t, backing, "fine"); got != 3 {
+		t.Errorf("after the retry: %d clicks, want 3", got)
+	}
+	if got := storedClicks(t, backing, "stuck"); got != 1 {
+		t.Errorf("after the retry: %d clicks for the failed link, want 1", got)
+	}
+}
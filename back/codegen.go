@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// How many random codes to try at each length before moving on
+const maxCodeAttempts = 10
+
+// Codes that would shadow our own routes (or ones we may add), never
+// handed out as random codes or accepted as custom aliases
+var defaultReservedCodes = []string{
+	"admin", "api", "assets", "campaigns", "healthz", "import", "links", "login", "logout",
+	"favicon", "metrics", "openapi", "ping", "preview", "qr", "resolve", "shorten", "static", "stats", "unlock",
+}
+
+// reservedCodes holds the default reserved codes plus RESERVED_CODES,
+// lowercased: "Admin" is as reserved as "admin"
+var reservedCodes = newReservedCodes(nil)
+
+// newReservedCodes builds the reserved set from the defaults and extra
+func newReservedCodes(extra []string) map[string]bool {
+	reserved := make(map[string]bool)
+	for _, code := range append(defaultReservedCodes, extra...) {
+		if code = strings.ToLower(strings.TrimSpace(code)); code != "" {
+			reserved[code] = true
+		}
+	}
+	return reserved
+}
+
+// isReservedCode reports whether code may not be used for a link
+func isReservedCode(code string) bool {
+	return reservedCodes[strings.ToLower(code)]
+}
+
+// Alphabet presets for random codes, by the name used in CODE_ALPHABET
+// and the alphabet field of /shorten
+var codeAlphabets = map[string]string{
+	"base62": "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	// Without the characters that are easy to mix up when a code is read
+	// out or typed: 0/O/o and 1/l/I. 56 characters, 32 case insensitive.
+	"readable": "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789",
+}
+
+// isGeneratedCode reports whether a CodeGenerator could have handed out
+// code: one or more characters of an alphabet preset, as long as the
+// counter or hash needs. Custom aliases have their own rule, see validCustomAlias.
+func isGeneratedCode(code string) bool {
+	if code == "" {
+		return false
+	}
+	for _, r := range code {
+		inPreset := false
+		for _, preset := range codeAlphabets {
+			if strings.ContainsRune(preset, r) {
+				inPreset = true
+				break
+			}
+		}
+		if !inPreset {
+			return false
+		}
+	}
+	return true
+}
+
+// codeAlphabet returns the runes of an alphabet preset. With
+// CASE_INSENSITIVE only lowercase letters and digits are kept, e.g. 36^n
+// base62 codes instead of 62^n (about 2.2 billion for 6).
+func codeAlphabet(name string) ([]rune, bool) {
+	preset, ok := codeAlphabets[name]
+	if !ok {
+		return nil, false
+	}
+	if caseInsensitive {
+		preset = strings.Map(func(r rune) rune {
+			if r >= 'A' && r <= 'Z' {
+				return -1
+			}
+			return r
+		}, preset)
+	}
+	return []rune(preset), true
+}
+
+// generateShortCode creates a random string of a fixed length from alphabet.
+// It uses crypto/rand so codes can't be predicted or enumerated.
+func generateShortCode(length int, alphabet []rune) string {
+	// Bytes >= maxByte are rejected so every rune has the same probability
+	// (a plain modulo would favour the first 256 % len(alphabet) runes)
+	maxByte := 256 - 256%len(alphabet)
+	b := make([]rune, 0, length)
+	buf := make([]byte, length*2)
+	for len(b) < length {
+		if _, err := rand.Read(buf); err != nil {
+			// crypto/rand only fails if the OS entropy source is broken
+			panic(fmt.Sprintf("crypto/rand failed: %v", err))
+		}
+		for _, c := range buf {
+			if int(c) >= maxByte {
+				continue
+			}
+			b = append(b, alphabet[int(c)%len(alphabet)])
+			if len(b) == length {
+				break
+			}
+		}
+	}
+	return string(b)
+}
+
+// encodeNumber writes n in base len(alphabet), using alphabet for the digits
+func encodeNumber(n uint64, alphabet []rune) string {
+	if n == 0 {
+		return string(alphabet[0])
+	}
+	base := uint64(len(alphabet))
+	var b []rune
+	for n > 0 {
+		b = append(b, alphabet[n%base])
+		n /= base
+	}
+	// Digits were produced least significant first
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// codeCandidate returns the code to try on the given (0-based) attempt, so
+// collision retries can't spin forever once the keyspace fills up: random
+// codes of length first, then one character longer, and finally the
+// store's counter (see Store.NextSequence), which never repeats a value,
+// even across restarts and instances. All of them use alphabet. It returns
+// errNoFreeCode once every strategy is exhausted, other errors come from
+// the store. Reserved codes are skipped without counting as an attempt.
+func codeCandidate(ctx context.Context, attempt int, alphabet []rune, length int) (string, error) {
+	for {
+		var code string
+		switch {
+		case attempt < maxCodeAttempts:
+			code = generateShortCode(length, alphabet)
+		case attempt < 2*maxCodeAttempts:
+			code = generateShortCode(length+1, alphabet)
+		case attempt < 3*maxCodeAttempts:
+			n, err := store.NextSequence(ctx)
+			if err != nil {
+				return "", err
+			}
+			code = encodeNumber(n, alphabet)
+		default:
+			return "", errNoFreeCode
+		}
+		if !isReservedCode(code) {
+			return code, nil
+		}
+	}
+}
+
+// errNoFreeCode is returned by a CodeGenerator that has run out of codes to try
+var errNoFreeCode = errors.New("no free short code")
+
+// CodeGenerator picks the codes new links are saved under, see CODE_STRATEGY
+type CodeGenerator interface {
+	// Code returns the code to try on the given (0-based) attempt at
+	// saving a link to longURL (normalized), made of alphabet and length
+	// long if the strategy has a length. Later attempts follow collisions
+	// with codes already taken. It returns errNoFreeCode once there's
+	// nothing left to try, other errors come from the store.
+	Code(ctx context.Context, attempt int, longURL string, alphabet []rune, length int) (string, error)
+}
+
+// Code generators by the name used in CODE_STRATEGY
+var codeGenerators = map[string]CodeGenerator{
+	"random":     RandomGenerator{},
+	"sequential": SequentialBase62Generator{},
+	"hash":       HashGenerator{},
+}
+
+// codeGenerator is the CODE_STRATEGY in use
+var codeGenerator CodeGenerator = RandomGenerator{}
+
+// RandomGenerator hands out random codes that can't be guessed, see codeCandidate
+type RandomGenerator struct{}
+
+// Code returns codeCandidate's code for attempt
+func (RandomGenerator) Code(ctx context.Context, attempt int, _ string, alphabet []rune, length int) (string, error) {
+	return codeCandidate(ctx, attempt, alphabet, length)
+}
+
+// SequentialBase62Generator encodes a counter kept in the store (see
+// Store.NextSequence), for the shortest possible codes: "b", "c", ...,
+// "9", "ba" and so on with base62. Every attempt takes the next number, so
+// the numbers behind the codes only ever go up. Sequential codes are easy
+// to enumerate, only use them if links aren't meant to be private.
+type SequentialBase62Generator struct{}
+
+// Code returns the code for the next counter value that isn't reserved.
+// The counter sets the length, length is ignored.
+func (SequentialBase62Generator) Code(ctx context.Context, attempt int, _ string, alphabet []rune, _ int) (string, error) {
+	if attempt >= 3*maxCodeAttempts {
+		return "", errNoFreeCode // Every one collided, something is wrong
+	}
+	for {
+		n, err := store.NextSequence(ctx)
+		if err != nil {
+			return "", err
+		}
+		if code := encodeNumber(n, alphabet); !isReservedCode(code) {
+			return code, nil
+		}
+	}
+}
+
+// HashGenerator derives the code from the SHA-256 of the URL, so the same
+// URL always gets the same code, without looking anything up. The hash is
+// written in base len(alphabet) and the code is its first length digits,
+// one more for every collision: a code taken by another URL is followed
+// by the same longer code every time. Anyone can compute the code of a
+// URL, so hashed codes don't keep links private either.
+type HashGenerator struct{}
+
+// Code returns the first length+attempt digits of the hash of longURL,
+// skipping prefixes that are reserved codes
+func (HashGenerator) Code(_ context.Context, attempt int, longURL string, alphabet []rune, length int) (string, error) {
+	digits := hashDigits(longURL, alphabet)
+	for n := length + attempt; n <= len(digits); n++ {
+		if code := string(digits[:n]); !isReservedCode(code) {
+			return code, nil
+		}
+	}
+	return "", errNoFreeCode // Longer than the hash itself
+}
+
+// hashDigits writes the SHA-256 of s in base len(alphabet), least
+// significant digit first: those are evenly spread, unlike the leading
+// digit of a 256-bit number, which is nearly always the same.
+func hashDigits(s string, alphabet []rune) []rune {
+	sum := sha256.Sum256([]byte(s))
+	n := new(big.Int).SetBytes(sum[:])
+	base := big.NewInt(int64(len(alphabet)))
+	digit := new(big.Int)
+	var digits []rune
+	for n.Sign() > 0 {
+		n.DivMod(n, base, digit)
+		digits = append(digits, alphabet[digit.Int64()])
+	}
+	return digits
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGenerateShortCode(t *testing.T) {
+	alphabets := map[string][]rune{
+		"default": letterRunes,
+		"digits":  []rune("0123456789"),
+	}
+	for name, alphabet := range alphabets {
+		allowed := make(map[rune]bool, len(alphabet))
+		for _, r := range alphabet {
+			allowed[r] = true
+		}
+		for _, length := range []int{minCodeLength, defaultCodeLength, maxCodeLength} {
+			seen := make(map[rune]bool)
+			for i := 0; i < 10000; i++ {
+				code := generateShortCode(length, alphabet)
+				if got := len([]rune(code)); got != length {
+					t.Fatalf("%s: generateShortCode(%d) = %q, want %d characters", name, length, code, length)
+				}
+				for _, r := range code {
+					if !allowed[r] {
+						t.Fatalf("%s: generateShortCode(%d) = %q, %q isn't in the alphabet", name, length, code, r)
+					}
+					seen[r] = true
+				}
+			}
+			// At least 10000 runes drawn, every one of the alphabet should come up
+			if len(seen) != len(alphabet) {
+				t.Errorf("%s: generateShortCode(%d): only %d of the %d runes were generated", name, length, len(seen), len(alphabet))
+			}
+		}
+	}
+}
+
+func TestCodeCandidateFallbacks(t *testing.T) {
+	useMemoryStore(t)
+	alphabet := []rune("ab")
+	var last uint64
+	for attempt := range 3 * maxCodeAttempts {
+		code, err := codeCandidate(t.Context(), attempt, alphabet, 4)
+		if err != nil {
+			t.Fatalf("attempt %d: %v", attempt, err)
+		}
+		switch {
+		case attempt < maxCodeAttempts && len(code) != 4:
+			t.Errorf("attempt %d: %q, want 4 characters", attempt, code)
+		case attempt >= maxCodeAttempts && attempt < 2*maxCodeAttempts && len(code) != 5:
+			t.Errorf("attempt %d: %q, want 5 characters", attempt, code)
+		case attempt >= 2*maxCodeAttempts:
+			// The store's counter, so every code is a higher number
+			last++
+			if want := encodeNumber(last, alphabet); code != want {
+				t.Errorf("attempt %d: %q, want counter code %q", attempt, code, want)
+			}
+		}
+	}
+	if code, err := codeCandidate(t.Context(), 3*maxCodeAttempts, alphabet, 4); !errors.Is(err, errNoFreeCode) {
+		t.Errorf("attempt %d gave %q, %v; want none left", 3*maxCodeAttempts, code, err)
+	}
+}
+
+// The counter is the store's, so it carries on where the last run stopped
+func TestCodeCandidateCounterPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.db")
+	alphabet := []rune(codeAlphabets["base62"])
+	setForTest(t, &store, Store(openTestSQLite(t, path)))
+	first, err := codeCandidate(t.Context(), 2*maxCodeAttempts, alphabet, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openTestSQLite(t, path)
+	second, err := codeCandidate(t.Context(), 2*maxCodeAttempts, alphabet, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != encodeNumber(1, alphabet) || second != encodeNumber(2, alphabet) {
+		t.Errorf("counter codes %q then %q after reopening, want %q and %q", first, second, encodeNumber(1, alphabet), encodeNumber(2, alphabet))
+	}
+}
+
+func TestShortenCollisionFallback(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &letterRunes, []rune("ab"))
+	setForTest(t, &shortCodeLength, 2)
+
+	// Every 2 character code is taken, so random retries can only collide
+	for _, code := range []string{"aa", "ab", "ba", "bb"} {
+		saveTestLink(t, code, Link{URL: "https://golang.org/" + code})
+	}
+	resp := shortenOK(t, `{"url": "https://golang.org/doc"}`)
+	if len(resp.Code) != 3 {
+		t.Errorf("code %q, want a 3 character one", resp.Code)
+	}
+
+	// And with those taken too (one is by now), the counter
+	for _, code := range []string{"aaa", "aab", "aba", "abb", "baa", "bab", "bba", "bbb"} {
+		if code == resp.Code {
+			continue
+		}
+		saveTestLink(t, code, Link{URL: "https://golang.org/" + code})
+	}
+	resp = shortenOK(t, `{"url": "https://golang.org/pkg"}`)
+	if resp.Code != "b" {
+		t.Errorf("code %q, want the first counter code", resp.Code)
+	}
+	if link, err := store.Lookup(t.Context(), resp.Code); err != nil || link.URL != "https://golang.org/pkg" {
+		t.Errorf("Lookup(%q) = %+v, %v", resp.Code, link, err)
+	}
+}
+
+// exhaustedGenerator never has a code to give
+type exhaustedGenerator struct{}
+
+func (exhaustedGenerator) Code(context.Context, int, string, []rune, int) (string, error) {
+	return "", errNoFreeCode
+}
+
+func TestShortenNoFreeCode(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &codeGenerator, CodeGenerator(exhaustedGenerator{}))
+
+	rec := postShorten(t, `{"url": "https://golang.org/doc"}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+	if body := decodeJSON[ErrorResponse](t, rec); body.Code != errInternal || body.Error == "" {
+		t.Errorf("error = %+v", body)
+	}
+}
+
+func TestReservedCodesNeverGenerated(t *testing.T) {
+	setForTest(t, &reservedCodes, newReservedCodes([]string{"ab", " BA "}))
+	alphabet := []rune("ab")
+	for attempt := range 1000 {
+		code, err := codeCandidate(t.Context(), attempt%maxCodeAttempts, alphabet, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code == "ab" || code == "ba" {
+			t.Fatalf("generated reserved code %q", code)
+		}
+	}
+
+	// With base 2, the counter gives "b", "ba" (reserved, skipped), "bb"
+	useMemoryStore(t)
+	var codes []string
+	for attempt := range 2 {
+		code, err := SequentialBase62Generator{}.Code(t.Context(), attempt, "", alphabet, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, code)
+	}
+	if codes[0] != "b" || codes[1] != "bb" {
+		t.Errorf("sequential codes = %q, want [b bb]", codes)
+	}
+
+	// A reserved hash prefix is extended to the next one
+	const longURL = "https://golang.org/doc"
+	digits := hashDigits(longURL, letterRunes)
+	setForTest(t, &reservedCodes, newReservedCodes([]string{string(digits[:6])}))
+	if code, err := (HashGenerator{}).Code(t.Context(), 0, longURL, letterRunes, 6); err != nil || code != string(digits[:7]) {
+		t.Errorf("hash code = %q (%v), want %q", code, err, string(digits[:7]))
+	}
+}
+
+func TestShortenReservedAlias(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &reservedCodes, newReservedCodes([]string{"promo"}))
+	for _, alias := range []string{"admin", "Metrics", "HEALTHZ", "promo", "Promo"} {
+		rec := postShorten(t, `{"url": "https://golang.org/doc", "custom_alias": "`+alias+`"}`)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("alias %q: status = %d, want 409", alias, rec.Code)
+			continue
+		}
+		if got := decodeJSON[ErrorResponse](t, rec).Code; got != errConflict {
+			t.Errorf("alias %q: code = %q, want %q", alias, got, errConflict)
+		}
+	}
+	if got := storeSize(t); got != 0 {
+		t.Errorf("%d links saved under reserved aliases", got)
+	}
+	if rec := postShorten(t, `{"url": "https://golang.org/doc", "custom_alias": "admins"}`); rec.Code != http.StatusCreated && rec.Code != http.StatusOK {
+		t.Errorf("alias admins: status = %d, want it accepted: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAlphabetPresets(t *testing.T) {
+	readable, ok := codeAlphabet("readable")
+	if !ok {
+		t.Fatal("no readable preset")
+	}
+	for _, r := range readable {
+		if strings.ContainsRune("0O1lIo", r) {
+			t.Errorf("readable alphabet has ambiguous %q", r)
+		}
+	}
+	if base62, _ := codeAlphabet("base62"); len(base62) != 62 {
+		t.Errorf("base62 has %d runes", len(base62))
+	}
+	if _, ok := codeAlphabet("emoji"); ok {
+		t.Error("unknown preset accepted")
+	}
+
+	setForTest(t, &caseInsensitive, true)
+	lower, _ := codeAlphabet("readable")
+	for _, r := range lower {
+		if r >= 'A' && r <= 'Z' {
+			t.Fatalf("case insensitive alphabet has %q", r)
+		}
+	}
+}
+
+func TestShortenAlphabet(t *testing.T) {
+	useMemoryStore(t)
+	for _, tt := range []struct {
+		preset  string
+		exclude string // Never in its codes
+	}{
+		{"readable", "0O1lIo"},
+		{"base62", ""},
+	} {
+		seen := make(map[rune]bool)
+		for range 200 {
+			code := shortenOK(t, `{"url": "https://golang.org/doc", "alphabet": "`+tt.preset+`", "length": 8}`).Code
+			for _, r := range code {
+				if strings.ContainsRune(tt.exclude, r) {
+					t.Fatalf("%s: code %q has %q", tt.preset, code, r)
+				}
+				if !strings.ContainsRune(codeAlphabets[tt.preset], r) {
+					t.Fatalf("%s: code %q has %q, not in the preset", tt.preset, code, r)
+				}
+				seen[r] = true
+			}
+		}
+		if tt.preset == "base62" && !seen['0'] && !seen['O'] && !seen['1'] && !seen['l'] {
+			t.Error("base62: 1600 characters and none of 0, O, 1, l")
+		}
+	}
+	if rec := postShorten(t, `{"url": "https://golang.org/doc", "alphabet": "emoji"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown alphabet: status = %d, want 400", rec.Code)
+	}
+}
+
+// codeNumber reads a code written by encodeNumber back as its number
+func codeNumber(t *testing.T, code string, alphabet []rune) uint64 {
+	t.Helper()
+	var n uint64
+	for _, r := range code {
+		digit := slices.Index(alphabet, r)
+		if digit < 0 {
+			t.Fatalf("%q isn't in the alphabet", r)
+		}
+		n = n*uint64(len(alphabet)) + uint64(digit)
+	}
+	return n
+}
+
+func TestEncodeNumber(t *testing.T) {
+	for n, want := range map[uint64]string{0: "a", 1: "b", 61: "9", 62: "ba", 62*62 + 1: "bab"} {
+		if got := encodeNumber(n, letterRunes); got != want {
+			t.Errorf("encodeNumber(%d) = %q, want %q", n, got, want)
+		}
+		if got := codeNumber(t, want, letterRunes); got != n {
+			t.Errorf("codeNumber(%q) = %d, want %d", want, got, n)
+		}
+	}
+}
+
+func TestRandomGenerator(t *testing.T) {
+	seen := make(map[string]bool)
+	for attempt := range maxCodeAttempts {
+		code, err := RandomGenerator{}.Code(t.Context(), attempt, "https://golang.org", letterRunes, 7)
+		if err != nil || len(code) != 7 {
+			t.Fatalf("attempt %d: %q, %v", attempt, code, err)
+		}
+		seen[code] = true
+	}
+	if len(seen) < maxCodeAttempts-1 {
+		t.Errorf("only %d distinct codes in %d attempts", len(seen), maxCodeAttempts)
+	}
+}
+
+func TestShortenSequentialCodes(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &codeGenerator, codeGenerators["sequential"])
+
+	var last uint64
+	for i := range 200 {
+		code := shortenOK(t, fmt.Sprintf(`{"url": "https://golang.org/doc/%d"}`, i)).Code
+		n := codeNumber(t, code, letterRunes)
+		if n <= last {
+			t.Fatalf("code %q (%d) after %d, want strictly increasing", code, n, last)
+		}
+		last = n
+	}
+	// The first codes are one character, the 62nd on are two
+	if last < 200 || last > 220 {
+		t.Errorf("200 links reached %d, want about 200 with only reserved codes skipped", last)
+	}
+}
+
+func TestSequentialCodesSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.db")
+	s := openTestSQLite(t, path)
+	setForTest(t, &store, Store(s))
+	first, err := SequentialBase62Generator{}.Code(t.Context(), 0, "", letterRunes, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	setForTest(t, &store, Store(openTestSQLite(t, path)))
+	second, err := SequentialBase62Generator{}.Code(t.Context(), 0, "", letterRunes, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codeNumber(t, second, letterRunes) <= codeNumber(t, first, letterRunes) {
+		t.Errorf("after a restart the counter gave %q, the first run gave %q", second, first)
+	}
+}
+
+func TestHashGeneratorDeterministic(t *testing.T) {
+	const longURL = "https://golang.org/doc"
+	first, err := HashGenerator{}.Code(t.Context(), 0, longURL, letterRunes, 6)
+	if err != nil || len(first) != 6 {
+		t.Fatalf("Code = %q, %v", first, err)
+	}
+	for range 10 {
+		if code, _ := (HashGenerator{}).Code(t.Context(), 0, longURL, letterRunes, 6); code != first {
+			t.Fatalf("same URL gave %q, then %q", first, code)
+		}
+	}
+	if other, _ := (HashGenerator{}).Code(t.Context(), 0, "https://golang.org/pkg", letterRunes, 6); other == first {
+		t.Errorf("two URLs both gave %q", first)
+	}
+
+	// Each collision extends the same prefix by one
+	for attempt := range 5 {
+		code, err := HashGenerator{}.Code(t.Context(), attempt, longURL, letterRunes, 6)
+		if err != nil || len(code) != 6+attempt || !strings.HasPrefix(code, first) {
+			t.Errorf("attempt %d: %q, %v; want %d characters starting with %q", attempt, code, err, 6+attempt, first)
+		}
+	}
+	// Until the hash runs out
+	digits := hashDigits(longURL, letterRunes)
+	if _, err := (HashGenerator{}).Code(t.Context(), len(digits)-5, longURL, letterRunes, 6); !errors.Is(err, errNoFreeCode) {
+		t.Errorf("past the hash: %v, want errNoFreeCode", err)
+	}
+}
+
+func TestShortenHashedCodes(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &codeGenerator, codeGenerators["hash"])
+	setForTest(t, &dedupe, false)
+
+	first := shortenOK(t, `{"url": "https://golang.org/doc"}`).Code
+	want, _ := HashGenerator{}.Code(t.Context(), 0, "https://golang.org/doc", letterRunes, shortCodeLength)
+	if first != want {
+		t.Errorf("code %q, want the hash prefix %q", first, want)
+	}
+	// The same URL, in any equivalent form, maps to the same code and link
+	for _, body := range []string{`{"url": "https://golang.org/doc"}`, `{"url": "HTTPS://GOLANG.ORG:443/doc"}`} {
+		if code := shortenOK(t, body).Code; code != first {
+			t.Errorf("%s: code %q, want %q", body, code, first)
+		}
+	}
+	if got := storeSize(t); got != 1 {
+		t.Errorf("%d links for one URL", got)
+	}
+
+	// Another URL taking the prefix pushes this one to the next length,
+	// every time
+	useMemoryStore(t)
+	saveTestLink(t, want, Link{URL: "https://golang.org/squatter"})
+	longer, _ := HashGenerator{}.Code(t.Context(), 1, "https://golang.org/doc", letterRunes, shortCodeLength)
+	for range 2 {
+		if code := shortenOK(t, `{"url": "https://golang.org/doc"}`).Code; code != longer {
+			t.Errorf("after a collision: code %q, want %q", code, longer)
+		}
+	}
+	if got := storeSize(t); got != 2 {
+		t.Errorf("%d links, want the squatter and one for the URL", got)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// Default for CODE_LOAD_WARNING: past it, one new random code in a hundred
+// hits a taken one and is retried
+const defaultCodeLoadWarning = 0.01
+
+// How often checkCodeLoad runs after the check at startup
+const codeLoadCheckInterval = time.Hour
+
+// codeLoadWarning is the load factor checkCodeLoad warns above, 0 for never
+var codeLoadWarning = defaultCodeLoadWarning
+
+// loadFactor is the share of the random codes of length made of an
+// alphabet of alphabetSize characters that links taken ones. It's also
+// the chance that a new random code collides with an existing one.
+func loadFactor(links, alphabetSize, length int) float64 {
+	return float64(links) / math.Pow(float64(alphabetSize), float64(length))
+}
+
+// recommendedCodeLength is the shortest code length keeping links under
+// the warning load factor, at most maxCodeLength
+func recommendedCodeLength(links, alphabetSize int) int {
+	length := minCodeLength
+	for length < maxCodeLength && loadFactor(links, alphabetSize, length) > codeLoadWarning {
+		length++
+	}
+	return length
+}
+
+// checkCodeLoad updates the load factor metric from the number of stored
+// links and warns if it's past CODE_LOAD_WARNING. Only CODE_LENGTH and
+// CODE_ALPHABET are considered, not the length and alphabet requests can
+// pick. Sequential codes never collide, so they aren't checked.
+func checkCodeLoad(ctx context.Context) {
+	if _, random := codeGenerator.(RandomGenerator); !random {
+		return
+	}
+	now := time.Now()
+	summary, err := store.Summary(ctx, now, now)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Error counting links for the code load check", "event", "code_load", "error", err)
+		}
+		return
+	}
+
+	load := loadFactor(summary.Stored, len(letterRunes), shortCodeLength)
+	codeLoadFactor.Set(load)
+	if codeLoadWarning == 0 || load <= codeLoadWarning {
+		slog.Debug("Checked code load", "event", "code_load", "links", summary.Stored, "load_factor", load)
+		return
+	}
+	slog.Warn("Random codes are filling up, new links will collide and be retried more often: raise CODE_LENGTH",
+		"event", "code_load", "links", summary.Stored, "code_length", shortCodeLength, "load_factor", load,
+		"threshold", codeLoadWarning, "recommended_code_length", recommendedCodeLength(summary.Stored, len(letterRunes)))
+}
+
+// checkCodeLoadPeriodically runs checkCodeLoad now and then every
+// codeLoadCheckInterval until ctx is done
+func checkCodeLoadPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(codeLoadCheckInterval)
+	defer ticker.Stop()
+	for {
+		checkCodeLoad(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadFactor(t *testing.T) {
+	tests := []struct {
+		links, alphabet, length int
+		want                    float64
+	}{
+		{0, 62, 6, 0},
+		{1, 10, 1, 0.1},
+		{10, 10, 1, 1},
+		{20, 10, 1, 2}, // Can't happen, but the math holds
+		{100, 10, 4, 0.01},
+		{14776336, 62, 4, 1}, // 62^4
+		{568002, 62, 6, 568002 / 56800235584.0},
+		{1_000_000, 36, 6, 1_000_000 / 2176782336.0},
+	}
+	for _, tt := range tests {
+		got := loadFactor(tt.links, tt.alphabet, tt.length)
+		if math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("loadFactor(%d, %d, %d) = %g, want %g", tt.links, tt.alphabet, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestRecommendedCodeLength(t *testing.T) {
+	setForTest(t, &codeLoadWarning, 0.01)
+	tests := []struct {
+		links, alphabet int
+		want            int
+	}{
+		{0, 62, minCodeLength},
+		{100_000, 62, minCodeLength}, // 0.7% of 62^4
+		{200_000, 62, 5},
+		{10_000_000, 62, 6},
+		{10_000_000, 10, 9},
+		{math.MaxInt32, 2, maxCodeLength}, // Capped
+	}
+	for _, tt := range tests {
+		got := recommendedCodeLength(tt.links, tt.alphabet)
+		if got != tt.want {
+			t.Errorf("recommendedCodeLength(%d, %d) = %d, want %d", tt.links, tt.alphabet, got, tt.want)
+		}
+		if got < maxCodeLength && loadFactor(tt.links, tt.alphabet, got) > codeLoadWarning {
+			t.Errorf("recommendedCodeLength(%d, %d) = %d is still over the threshold", tt.links, tt.alphabet, got)
+		}
+	}
+}
+
+func TestCheckCodeLoad(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &letterRunes, []rune("0123456789"))
+	setForTest(t, &shortCodeLength, 2)
+	setForTest(t, &codeLoadWarning, 0.05)
+	setForTest(t, &codeGenerator, CodeGenerator(RandomGenerator{}))
+	var logs bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	for _, tt := range []struct {
+		links int
+		warn  bool
+	}{
+		{0, false},
+		{5, false}, // Exactly at the threshold
+		{6, true},
+		{50, true},
+	} {
+		for i := storeSize(t); i < tt.links; i++ {
+			saveTestLink(t, fmt.Sprintf("%02d", i), Link{URL: "https://golang.org/doc"})
+		}
+		logs.Reset()
+		checkCodeLoad(t.Context())
+		if got, want := testutil.ToFloat64(codeLoadFactor), float64(tt.links)/100; math.Abs(got-want) > 1e-12 {
+			t.Errorf("%d links: metric = %g, want %g", tt.links, got, want)
+		}
+		if warned := strings.Contains(logs.String(), `"level":"WARN"`); warned != tt.warn {
+			t.Errorf("%d links: warned %v, want %v:\n%s", tt.links, warned, tt.warn, logs.String())
+		}
+		if tt.warn && !strings.Contains(logs.String(), `"recommended_code_length":`) {
+			t.Errorf("%d links: no recommended length in %s", tt.links, logs.String())
+		}
+	}
+
+	// 0 turns the warning off, the metric is still kept
+	setForTest(t, &codeLoadWarning, 0)
+	logs.Reset()
+	checkCodeLoad(t.Context())
+	if strings.Contains(logs.String(), `"level":"WARN"`) {
+		t.Errorf("warned with CODE_LOAD_WARNING=0: %s", logs.String())
+	}
+	if got := testutil.ToFloat64(codeLoadFactor); got != 0.5 {
+		t.Errorf("metric = %g with the warning off, want 0.5", got)
+	}
+}
+
+func TestCheckCodeLoadSequential(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &codeGenerator, codeGenerators["sequential"])
+	codeLoadFactor.Set(0.25)
+	saveTestLink(t, "b", Link{URL: "https://golang.org/doc"})
+	checkCodeLoad(t.Context())
+	if got := testutil.ToFloat64(codeLoadFactor); got != 0.25 {
+		t.Errorf("metric = %g, want it untouched for sequential codes", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                   false,
+		"gzip":               true,
+		"deflate, GZIP":      true,
+		"gzip;q=0.5":         true,
+		"gzip;q=0":           false,
+		"*":                  true,
+		"br, deflate":        false,
+		"identity, gzip;q=0": false,
+	} {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestGzipThroughRouter(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &apiKeys, []string{"secret"})
+	for i := range 50 {
+		saveTestLink(t, fmt.Sprintf("code%02d", i), Link{URL: fmt.Sprintf("https://golang.org/doc/%d", i)})
+	}
+	router := testRouter(t)
+	request := func(method, target string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header = header
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "/links", http.Header{
+		"Accept-Encoding": {"gzip"},
+		"Authorization":   {"Bearer secret"},
+		"Origin":          {"http://localhost:3000"},
+	})
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status %d, Content-Encoding %q, want a gzipped 200", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	if !strings.Contains(strings.Join(rec.Header().Values("Vary"), ","), "Accept-Encoding") {
+		t.Errorf("Vary = %q, want Accept-Encoding in it", rec.Header().Values("Vary"))
+	}
+	// CORS headers survive compression
+	if rec.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Errorf("Access-Control-Allow-Origin = %q", rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list LinkListResponse
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatalf("decompressed body isn't JSON: %v", err)
+	}
+	if list.Total != 50 || len(list.Links) != 50 {
+		t.Errorf("decompressed list has %d of %d links, want 50", len(list.Links), list.Total)
+	}
+
+	// Tiny bodies and redirects aren't compressed
+	if rec := request(http.MethodGet, "/ping", http.Header{"Accept-Encoding": {"gzip"}}); rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("/ping compressed")
+	}
+	if rec := request(http.MethodGet, "/code01", http.Header{"Accept-Encoding": {"gzip"}}); rec.Code != http.StatusFound || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("redirect: status %d, Content-Encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	// Nor is anything for clients that didn't ask
+	if rec := request(http.MethodGet, "/links", http.Header{"Authorization": {"Bearer secret"}}); rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("compressed without Accept-Encoding")
+	}
+}
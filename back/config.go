@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the service. It's loaded once at startup:
+// defaults first, then the file named by CONFIG_FILE (JSON or YAML), then
+// environment variables, so the environment always wins. Each field can be
+// set by its json key in the file or by the variable in its env tag.
+type Config struct {
+	// Server
+	Port             string   `json:"port" env:"PORT"`
+	BaseURL          string   `json:"base_url" env:"BASE_URL"` // Short links are built from it, e.g. "https://sho.rt"
+	NotFoundTemplate string   `json:"not_found_template" env:"NOT_FOUND_TEMPLATE"`
+	LandingPage      string   `json:"landing_page" env:"LANDING_PAGE"`         // HTML file or URL to redirect to, for GET /
+	AllowedOrigins   []string `json:"allowed_origins" env:"ALLOWED_ORIGINS"`   // For CORS, see originMatcher
+	CORSCredentials  bool     `json:"cors_credentials" env:"CORS_CREDENTIALS"` // Let browsers send cookies and auth with cross-origin requests
+	RobotsTXT        string   `json:"robots_txt" env:"ROBOTS_TXT"`             // File served as /robots.txt instead of defaultRobotsTxt
+	TrustedProxies   int      `json:"trusted_proxies" env:"TRUSTED_PROXIES"`   // Proxies adding to X-Forwarded-For, see clientIP. 0 when clients connect directly.
+	// HTTPS, see listenAndServe. Plain HTTP when none are set.
+	TLSCert       string `json:"tls_cert" env:"TLS_CERT"` // PEM certificate (chain) file
+	TLSKey        string `json:"tls_key" env:"TLS_KEY"`
+	Domain        string `json:"domain" env:"DOMAIN"`                 // Get a Let's Encrypt certificate for it (or comma separated names) instead
+	AutocertCache string `json:"autocert_cache" env:"AUTOCERT_CACHE"` // Directory the certificate is kept in across restarts
+	// Connection timeouts, see http.Server. 0 for none.
+	ReadHeaderTimeout Duration `json:"read_header_timeout" env:"READ_HEADER_TIMEOUT"`
+	ReadTimeout       Duration `json:"read_timeout" env:"READ_TIMEOUT"`
+	WriteTimeout      Duration `json:"write_timeout" env:"WRITE_TIMEOUT"` // Must cover the slowest response, like a large export
+	IdleTimeout       Duration `json:"idle_timeout" env:"IDLE_TIMEOUT"`
+
+	// Short codes and redirects
+	CodeLength      int      `json:"code_length" env:"CODE_LENGTH"`
+	CodeAlphabet    string   `json:"code_alphabet" env:"CODE_ALPHABET"`         // "base62" or "readable", see codeAlphabets
+	CodeStrategy    string   `json:"code_strategy" env:"CODE_STRATEGY"`         // "random", "sequential" or "hash", see CodeGenerator
+	CodeLoadWarning float64  `json:"code_load_warning" env:"CODE_LOAD_WARNING"` // Warn when this share of random codes is taken, see checkCodeLoad
+	RedirectStatus  int      `json:"redirect_status" env:"REDIRECT_STATUS"`     // 301 or 302
+	RedirectDelay   Duration `json:"redirect_delay" env:"REDIRECT_DELAY"`       // Countdown page before redirecting (whole seconds), 0 for none
+	CaseInsensitive bool     `json:"case_insensitive" env:"CASE_INSENSITIVE"`
+	UnicodeAliases  bool     `json:"unicode_aliases" env:"UNICODE_ALIASES"` // Custom aliases beyond ASCII, see validCustomAlias
+	ForwardPath     bool     `json:"forward_path" env:"FORWARD_PATH"`       // See forwardPath
+	ForwardQuery    bool     `json:"forward_query" env:"FORWARD_QUERY"`     // See forwardQuery
+	UpgradeHTTPS    bool     `json:"upgrade_https" env:"UPGRADE_HTTPS"`     // See upgradeHTTPS
+	ReservedCodes   []string `json:"reserved_codes" env:"RESERVED_CODES"`   // Added to defaultReservedCodes
+	Tenants         []string `json:"tenants" env:"TENANTS"`                 // Code spaces under /{tenant}/, see tenants
+	// Referrers and browsers are recorded for one click in this many, see visitAnalytics
+	AnalyticsSampleRate int `json:"analytics_sample_rate" env:"ANALYTICS_SAMPLE_RATE"`
+	// Don't count visits from crawlers and link unfurlers, see isBot
+	SkipBotClicks bool `json:"skip_bot_clicks" env:"SKIP_BOT_CLICKS"`
+
+	// Submitted URLs
+	MaxURLLength        int      `json:"max_url_length" env:"MAX_URL_LENGTH"`
+	Dedupe              bool     `json:"dedupe" env:"DEDUPE"`
+	NormalizeURLs       bool     `json:"normalize_urls" env:"NORMALIZE_URLS"`
+	StripTrackingParams bool     `json:"strip_tracking_params" env:"STRIP_TRACKING_PARAMS"`
+	Blocklist           []string `json:"blocklist" env:"BLOCKLIST"` // Comma separated in the environment
+	BlocklistFile       string   `json:"blocklist_file" env:"BLOCKLIST_FILE"`
+	BlockPrivateHosts   bool     `json:"block_private_hosts" env:"BLOCK_PRIVATE_HOSTS"`
+	ExpandShortURLs     bool     `json:"expand_short_urls" env:"EXPAND_SHORT_URLS"`     // Store the destination of bit.ly and co links, see shortenerHosts
+	GeoIPDB             string   `json:"geoip_db" env:"GEOIP_DB"`                       // MaxMind country database (.mmdb) for geo rules
+	FetchFavicons       bool     `json:"fetch_favicons" env:"FETCH_FAVICONS"`           // Fetch destination icons for /favicon/{code} and previews, off by default
+	FetchPreviews       bool     `json:"fetch_previews" env:"FETCH_PREVIEWS"`           // Fetch destination Open Graph tags for /preview/{code}, off by default
+	LinkCheckInterval   Duration `json:"link_check_interval" env:"LINK_CHECK_INTERVAL"` // How often destinations are re-checked, 0 disables it
+
+	// Access control
+	APIKeys        []string `json:"api_keys" env:"API_KEYS"`     // Comma separated in the environment
+	RateLimit      int      `json:"rate_limit" env:"RATE_LIMIT"` // Requests per minute, 0 disables it
+	RateLimitBurst int      `json:"rate_limit_burst" env:"RATE_LIMIT_BURST"`
+	IdempotencyTTL Duration `json:"idempotency_ttl" env:"IDEMPOTENCY_TTL"`
+	StatsSecret    string   `json:"stats_secret" env:"STATS_SECRET"` // Signs stats tokens, stats are public without it
+
+	// Webhooks, see webhookSender
+	WebhookURL    string   `json:"webhook_url" env:"WEBHOOK_URL"`       // Receives a POST per event, off when empty
+	WebhookEvents []string `json:"webhook_events" env:"WEBHOOK_EVENTS"` // shorten and/or redirect
+
+	// Storage
+	StorageBackend string   `json:"storage_backend" env:"STORAGE_BACKEND"`
+	MaxEntries     int      `json:"max_entries" env:"MAX_ENTRIES"`
+	MemoryCapacity int      `json:"memory_capacity" env:"MEMORY_CAPACITY"` // Refuse new links past this many (507) instead of evicting
+	SQLitePath     string   `json:"sqlite_path" env:"SQLITE_PATH"`
+	RedisURL       string   `json:"redis_url" env:"REDIS_URL"`
+	DatabaseURL    string   `json:"database_url" env:"DATABASE_URL"`
+	StoreTimeout   Duration `json:"store_timeout" env:"STORE_TIMEOUT"`   // Per operation, 0 for none
+	SweepInterval  Duration `json:"sweep_interval" env:"SWEEP_INTERVAL"` // How often expired links are deleted, 0 to never
+	// Write click counts every ClickFlushInterval (0 for on each redirect),
+	// or once ClickFlushThreshold clicks are waiting, see clickBuffer
+	ClickFlushInterval  Duration `json:"click_flush_interval" env:"CLICK_FLUSH_INTERVAL"`
+	ClickFlushThreshold int      `json:"click_flush_threshold" env:"CLICK_FLUSH_THRESHOLD"`
+	// Memory backend only: keep the links in this JSON file across restarts,
+	// rewritten every SnapshotInterval (0 for only on shutdown)
+	SnapshotPath     string   `json:"snapshot_path" env:"SNAPSHOT_PATH"`
+	SnapshotInterval Duration `json:"snapshot_interval" env:"SNAPSHOT_INTERVAL"`
+
+	// Logging
+	LogLevel  string `json:"log_level" env:"LOG_LEVEL"`
+	LogFormat string `json:"log_format" env:"LOG_FORMAT"`
+	AccessLog bool   `json:"access_log" env:"ACCESS_LOG"` // A line per request, see withAccessLog
+}
+
+// defaultConfig is what the service runs with when nothing is configured
+func defaultConfig() Config {
+	return Config{
+		Port:            "8080",
+		AllowedOrigins:  defaultAllowedOrigins,
+		CORSCredentials: true,
+		TrustedProxies:  1, // Like on Railway
+		CodeLength:      defaultCodeLength,
+		CodeAlphabet:    "base62",
+		CodeStrategy:    "random",
+		RedirectStatus:  http.StatusFound,
+		MaxURLLength:    2048,
+		NormalizeURLs:   true,
+		RateLimit:       10,
+		IdempotencyTTL:  Duration(defaultIdempotencyTTL),
+		StorageBackend:  "memory",
+		SQLitePath:      "urls.db",
+		StoreTimeout:    Duration(defaultStoreTimeout),
+		SweepInterval:   Duration(time.Minute),
+		LogLevel:        "info",
+		LogFormat:       "json",
+		AutocertCache:   "autocert-cache",
+		WebhookEvents:   []string{"shorten", "redirect"},
+		// Slow clients can't hold connections open for long (slowloris)
+		ReadHeaderTimeout: Duration(5 * time.Second),
+		ReadTimeout:       Duration(30 * time.Second),
+		WriteTimeout:      Duration(60 * time.Second),
+		IdleTimeout:       Duration(2 * time.Minute),
+		SnapshotInterval:  Duration(defaultSnapshotInterval),
+		AccessLog:         true,
+		CodeLoadWarning:   defaultCodeLoadWarning,
+		// Only used once CLICK_FLUSH_INTERVAL turns buffering on
+		ClickFlushThreshold: defaultClickFlushThreshold,
+		AnalyticsSampleRate: 1,
+	}
+}
+
+// loadConfig builds the configuration from defaults, CONFIG_FILE and the environment
+func loadConfig() (Config, error) {
+	cfg := defaultConfig()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return cfg, fmt.Errorf("loading CONFIG_FILE: %w", err)
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+// loadFile merges a JSON or YAML file (by extension) into cfg. Unknown
+// keys are an error so typos don't go unnoticed.
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// Go through JSON so both formats share the json keys and decoding rules
+		var doc map[string]any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides fields with the environment variables named in their
+// env tags. Empty variables are ignored, like unset ones.
+func (cfg *Config) loadEnv() error {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := range v.NumField() {
+		name := v.Type().Field(i).Tag.Get("env")
+		raw := os.Getenv(name)
+		if name == "" || raw == "" {
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Addr().Interface().(type) {
+		case *Duration:
+			// Like in JSON, a plain number is seconds
+			d, err := time.ParseDuration(raw)
+			if secs, numErr := strconv.ParseFloat(raw, 64); numErr == nil {
+				d, err = time.Duration(secs*float64(time.Second)), nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid %s %q (expected a duration like \"24h\")", name, raw)
+			}
+			field.Set(reflect.ValueOf(Duration(d)))
+		case *string:
+			field.SetString(raw)
+		case *[]string:
+			field.Set(reflect.ValueOf(strings.Split(raw, ",")))
+		case *int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q (expected a number)", name, raw)
+			}
+			field.SetInt(int64(n))
+		case *float64:
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q (expected a number)", name, raw)
+			}
+			field.SetFloat(f)
+		case *bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q (expected true or false)", name, raw)
+			}
+			field.SetBool(b)
+		default:
+			return fmt.Errorf("config field %s has an unsupported type", v.Type().Field(i).Name)
+		}
+	}
+	return nil
+}
+
+// validate checks the ranges the rest of the service relies on
+func (cfg *Config) validate() error {
+	switch {
+	case cfg.CodeLength < minCodeLength || cfg.CodeLength > maxCodeLength:
+		return fmt.Errorf("invalid code_length %d (expected %d to %d)", cfg.CodeLength, minCodeLength, maxCodeLength)
+	case cfg.CORSCredentials && slices.ContainsFunc(cfg.AllowedOrigins, anyOrigin):
+		return fmt.Errorf("allowed_origins \"*\" can't be combined with cors_credentials (browsers reject credentialed responses for any origin): list the origins, or set cors_credentials to false")
+	case cfg.CodeLoadWarning < 0 || cfg.CodeLoadWarning >= 1:
+		return fmt.Errorf("invalid code_load_warning %g (expected a fraction from 0 to 1, 0 turns it off)", cfg.CodeLoadWarning)
+	case codeAlphabets[cfg.CodeAlphabet] == "":
+		return fmt.Errorf("invalid code_alphabet %q (expected base62 or readable)", cfg.CodeAlphabet)
+	case codeGenerators[cfg.CodeStrategy] == nil:
+		return fmt.Errorf("invalid code_strategy %q (expected random, sequential or hash)", cfg.CodeStrategy)
+	case !validRedirectDelay(time.Duration(cfg.RedirectDelay)):
+		return fmt.Errorf("invalid redirect_delay %s (expected whole seconds, at most %s)", time.Duration(cfg.RedirectDelay), maxRedirectDelay)
+	case cfg.RedirectStatus != http.StatusMovedPermanently && cfg.RedirectStatus != http.StatusFound:
+		return fmt.Errorf("invalid redirect_status %d (expected 301 or 302)", cfg.RedirectStatus)
+	case cfg.MaxURLLength <= 0:
+		return fmt.Errorf("invalid max_url_length %d (expected a positive number)", cfg.MaxURLLength)
+	case cfg.RateLimit < 0:
+		return fmt.Errorf("invalid rate_limit %d (expected requests per minute, or 0 to disable it)", cfg.RateLimit)
+	case cfg.TrustedProxies < 0:
+		return fmt.Errorf("invalid trusted_proxies %d (expected how many proxies add to X-Forwarded-For, or 0 for none)", cfg.TrustedProxies)
+	case cfg.RateLimitBurst < 0:
+		return fmt.Errorf("invalid rate_limit_burst %d (expected a positive number)", cfg.RateLimitBurst)
+	case cfg.IdempotencyTTL <= 0:
+		return fmt.Errorf("invalid idempotency_ttl %s (expected a positive duration)", time.Duration(cfg.IdempotencyTTL))
+	case cfg.StoreTimeout < 0:
+		return fmt.Errorf("invalid store_timeout %s (expected a positive duration, or 0 for none)", time.Duration(cfg.StoreTimeout))
+	case cfg.SweepInterval < 0:
+		return fmt.Errorf("invalid sweep_interval %s (expected a positive duration, or 0 to disable it)", time.Duration(cfg.SweepInterval))
+	case cfg.ClickFlushInterval < 0:
+		return fmt.Errorf("invalid click_flush_interval %s (expected a positive duration, or 0 to write every click)", time.Duration(cfg.ClickFlushInterval))
+	case cfg.AnalyticsSampleRate < 1:
+		return fmt.Errorf("invalid analytics_sample_rate %d (expected a positive number, 1 records every click)", cfg.AnalyticsSampleRate)
+	case cfg.ClickFlushThreshold < 0:
+		return fmt.Errorf("invalid click_flush_threshold %d (expected a positive number, or 0 to only flush on the interval)", cfg.ClickFlushThreshold)
+	case cfg.LinkCheckInterval < 0:
+		return fmt.Errorf("invalid link_check_interval %s (expected a positive duration, or 0 to disable it)", time.Duration(cfg.LinkCheckInterval))
+	case cfg.ReadHeaderTimeout < 0 || cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0:
+		return fmt.Errorf("invalid server timeout (expected a positive duration, or 0 for none)")
+	case (cfg.TLSCert == "") != (cfg.TLSKey == ""):
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	case cfg.TLSCert != "" && cfg.Domain != "":
+		return fmt.Errorf("set either tls_cert and tls_key or domain, not both")
+	case cfg.SnapshotInterval < 0:
+		return fmt.Errorf("invalid snapshot_interval %s (expected a positive duration, or 0 for only on shutdown)", time.Duration(cfg.SnapshotInterval))
+	case cfg.SnapshotPath != "" && cfg.StorageBackend != "memory" && cfg.StorageBackend != "":
+		return fmt.Errorf("snapshot_path only applies to the memory storage backend, not %q", cfg.StorageBackend)
+	case cfg.StatsSecret != "" && len(cfg.StatsSecret) < minStatsSecretLength:
+		return fmt.Errorf("stats_secret is too short (expected at least %d characters)", minStatsSecretLength)
+	case cfg.MaxEntries < 0:
+		return fmt.Errorf("invalid max_entries %d (expected a positive number, or 0 for no limit)", cfg.MaxEntries)
+	case cfg.MemoryCapacity < 0:
+		return fmt.Errorf("invalid memory_capacity %d (expected a positive number, or 0 for no limit)", cfg.MemoryCapacity)
+	case cfg.MemoryCapacity > 0 && cfg.MaxEntries > 0:
+		return fmt.Errorf("memory_capacity and max_entries can't both be set: one refuses new links when full, the other evicts old ones")
+	case cfg.MemoryCapacity > 0 && cfg.StorageBackend != "memory" && cfg.StorageBackend != "":
+		return fmt.Errorf("memory_capacity only applies to the memory storage backend, not %q", cfg.StorageBackend)
+	}
+	if cfg.WebhookURL != "" {
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook_url %q (expected an absolute http:// or https:// URL)", cfg.WebhookURL)
+		}
+	}
+	for _, event := range cfg.WebhookEvents {
+		if !webhookEventNames[event] {
+			return fmt.Errorf("invalid webhook_events entry %q (expected shorten or redirect)", event)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a config file named name and points CONFIG_FILE at it
+func writeConfigFile(t *testing.T, name, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+}
+
+func TestConfigFileAndEnvPrecedence(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{"base_url": "https://file.example", "code_length": 8, "rate_limit": 30, "storage_backend": "memory", "idempotency_ttl": "2h", "blocklist": ["a.test", "b.test"]}`,
+		"config.yaml": "base_url: https://file.example\ncode_length: 8\nrate_limit: 30\nstorage_backend: memory\nidempotency_ttl: 2h\nblocklist:\n  - a.test\n  - b.test\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			writeConfigFile(t, name, content)
+			t.Setenv("CODE_LENGTH", "10")
+			t.Setenv("RATE_LIMIT", "") // Empty is the same as unset
+
+			cfg, err := loadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The environment wins over the file
+			if cfg.CodeLength != 10 {
+				t.Errorf("code_length = %d, want 10 from the environment", cfg.CodeLength)
+			}
+			// The file wins over the defaults
+			if cfg.BaseURL != "https://file.example" || cfg.RateLimit != 30 || time.Duration(cfg.IdempotencyTTL) != 2*time.Hour {
+				t.Errorf("from the file: base_url %q, rate_limit %d, idempotency_ttl %s", cfg.BaseURL, cfg.RateLimit, time.Duration(cfg.IdempotencyTTL))
+			}
+			if !slices.Equal(cfg.Blocklist, []string{"a.test", "b.test"}) {
+				t.Errorf("blocklist = %q", cfg.Blocklist)
+			}
+			// And defaults fill in the rest
+			if cfg.RedirectStatus != defaultConfig().RedirectStatus || cfg.MaxURLLength != defaultConfig().MaxURLLength {
+				t.Errorf("defaults not kept: redirect_status %d, max_url_length %d", cfg.RedirectStatus, cfg.MaxURLLength)
+			}
+		})
+	}
+}
+
+func TestConfigEnvTypes(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("BLOCKLIST", "a.test,b.test")
+	t.Setenv("IDEMPOTENCY_TTL", "90") // Plain numbers are seconds
+	t.Setenv("DEDUPE", "false")
+	t.Setenv("CODE_LOAD_WARNING", "0.25")
+	t.Setenv("TRUSTED_PROXIES", "0") // Not ignored like an empty variable
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.Blocklist, []string{"a.test", "b.test"}) || time.Duration(cfg.IdempotencyTTL) != 90*time.Second || cfg.Dedupe || cfg.CodeLoadWarning != 0.25 || cfg.TrustedProxies != 0 {
+		t.Errorf("got blocklist %q, idempotency_ttl %s, dedupe %v, code_load_warning %g, trusted_proxies %d", cfg.Blocklist, time.Duration(cfg.IdempotencyTTL), cfg.Dedupe, cfg.CodeLoadWarning, cfg.TrustedProxies)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for name, setup := range map[string]func(t *testing.T){
+		"unknown key":      func(t *testing.T) { writeConfigFile(t, "config.json", `{"code_lenght": 8}`) },
+		"malformed file":   func(t *testing.T) { writeConfigFile(t, "config.yaml", "code_length: [") },
+		"missing file":     func(t *testing.T) { t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.json")) },
+		"not a number":     func(t *testing.T) { t.Setenv("RATE_LIMIT", "lots") },
+		"not a bool":       func(t *testing.T) { t.Setenv("DEDUPE", "sometimes") },
+		"not a duration":   func(t *testing.T) { t.Setenv("SWEEP_INTERVAL", "soon") },
+		"redirect status":  func(t *testing.T) { t.Setenv("REDIRECT_STATUS", "307") },
+		"invalid in file":  func(t *testing.T) { writeConfigFile(t, "config.json", `{"code_length": 2}`) },
+		"half of tls pair": func(t *testing.T) { t.Setenv("TLS_CERT", "cert.pem") },
+		"negative proxies": func(t *testing.T) { t.Setenv("TRUSTED_PROXIES", "-1") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			setup(t)
+			if _, err := loadConfig(); err == nil {
+				t.Error("loadConfig accepted it")
+			} else if strings.TrimSpace(err.Error()) == "" {
+				t.Error("empty error message")
+			}
+		})
+	}
+}
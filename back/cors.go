@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Origins allowed when ALLOWED_ORIGINS isn't set: local Next.js development
+// and the Vercel production domain
+var defaultAllowedOrigins = []string{
+	"http://localhost:3000",
+	"https://url-shortener-rayen-saids-projects.vercel.app",
+}
+
+// originMatcher decides which origins may call the API from a browser.
+// Each allowed origin is one of:
+//   - an exact origin, "https://example.com"
+//   - a wildcard pattern, "https://*.vercel.app", where * stands for
+//     anything but "/" and ":" (so it can't swallow a path or a port)
+//   - a regular expression starting with "^", "^https://pr-[0-9]+\.example\.com$"
+//   - "*" for any origin, only without CORS_CREDENTIALS (see anyOrigin)
+type originMatcher struct {
+	exact    map[string]bool
+	patterns []*regexp.Regexp
+	any      bool // "*" was allowed
+}
+
+// anyOrigin reports whether an ALLOWED_ORIGINS entry is "*". Browsers
+// refuse credentialed responses allowed for any origin, so config
+// validation rejects it together with CORS_CREDENTIALS.
+func anyOrigin(origin string) bool {
+	return strings.TrimSpace(origin) == "*"
+}
+
+// anyHostOrigin reports whether origin is a wildcard pattern for every
+// host of a scheme, like "https://*" or "https://*:8443". It isn't "*",
+// but with credentials it lets any site call the API as the user.
+func anyHostOrigin(origin string) bool {
+	_, host, found := strings.Cut(strings.TrimSuffix(strings.TrimSpace(origin), "/"), "://")
+	host, _, _ = strings.Cut(host, ":")
+	return found && host == "*"
+}
+
+// newOriginMatcher compiles the allowed origins. A trailing slash is
+// ignored, browsers never send one.
+func newOriginMatcher(origins []string) (*originMatcher, error) {
+	m := &originMatcher{exact: make(map[string]bool)}
+	for _, origin := range origins {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		switch {
+		case origin == "":
+			continue
+		case origin == "*":
+			m.any = true
+		case strings.HasPrefix(origin, "^"):
+			re, err := regexp.Compile(origin)
+			if err != nil {
+				return nil, fmt.Errorf("invalid origin pattern %q: %w", origin, err)
+			}
+			m.patterns = append(m.patterns, re)
+		case strings.Contains(origin, "*"):
+			parts := strings.Split(strings.ToLower(origin), "*")
+			for i, part := range parts {
+				parts[i] = regexp.QuoteMeta(part)
+			}
+			m.patterns = append(m.patterns, regexp.MustCompile("^"+strings.Join(parts, "[^/:]+")+"$"))
+		default:
+			m.exact[strings.ToLower(origin)] = true
+		}
+	}
+	return m, nil
+}
+
+// Allowed reports whether a request's Origin header is allowed.
+// Scheme and host are case insensitive, so origins are compared lowercased.
+func (m *originMatcher) Allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if m.any || m.exact[origin] {
+		return true
+	}
+	for _, re := range m.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOriginMatcher(t *testing.T) {
+	m, err := newOriginMatcher([]string{
+		"https://app.example.com/",
+		" https://*.vercel.app ",
+		`^https://pr-[0-9]+\.example\.org$`,
+		"http://localhost:*",
+		"",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://App.Example.com", true},
+		{"http://app.example.com", false},
+		{"https://app.example.com.evil.test", false},
+		{"https://url-shortener-git-feature-rayen.vercel.app", true},
+		{"https://pr-42.vercel.app", true},
+		{"https://vercel.app", false},
+		{"http://preview.vercel.app", false},
+		{"https://evil.test/.vercel.app", false},
+		{"https://preview.vercel.app:8443", false},
+		{"https://preview.vercel.app.evil.test", false},
+		{"https://pr-17.example.org", true},
+		{"https://pr-x.example.org", false},
+		{"https://pr-17.example.org.evil.test", false},
+		{"http://localhost:3000", true},
+		{"http://localhost", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := m.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if _, err := newOriginMatcher([]string{"^https://(unclosed"}); err == nil {
+		t.Error("invalid regular expression accepted")
+	}
+	anything, _ := newOriginMatcher([]string{"*"})
+	if !anything.Allowed("https://anywhere.test") {
+		t.Error(`"*" doesn't allow every origin`)
+	}
+}
+
+func TestAnyHostOrigin(t *testing.T) {
+	for origin, want := range map[string]bool{
+		"https://*":            true,
+		"https://*:8443":       true,
+		"http://*/":            true,
+		"https://*.vercel.app": false,
+		"*":                    false,
+		"https://example.com":  false,
+	} {
+		if got := anyHostOrigin(origin); got != want {
+			t.Errorf("anyHostOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestCORSPreviewDomains(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AllowedOrigins = []string{"https://*.vercel.app"}
+	handler, err := newRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, allowed := range map[string]bool{
+		"https://url-shortener-abc123.vercel.app": true,
+		"https://evil.test":                       false,
+	} {
+		req := httptest.NewRequest(http.MethodOptions, "/shorten", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		got := rec.Header().Get("Access-Control-Allow-Origin")
+		if allowed && got != origin {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want the origin", origin, got)
+		}
+		if !allowed && got != "" {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want none", origin, got)
+		}
+	}
+}
+
+// corsRequest sends method /shorten from origin through a router for cfg,
+// as a preflight for OPTIONS
+func corsRequest(t *testing.T, cfg Config, method, origin string) *httptest.ResponseRecorder {
+	t.Helper()
+	handler, err := newRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, "/shorten", nil)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	req.Header.Set("Origin", origin)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSReflectsOriginWithCredentials(t *testing.T) {
+	useMemoryStore(t)
+	cfg := defaultConfig()
+	cfg.AllowedOrigins = []string{"https://app.example.com", "https://*.vercel.app"}
+	cfg.CORSCredentials = true
+
+	for _, method := range []string{http.MethodOptions, http.MethodGet} {
+		for origin, allowed := range map[string]bool{
+			"https://app.example.com":      true,
+			"https://preview-1.vercel.app": true,
+			"https://evil.test":            false,
+		} {
+			rec := corsRequest(t, cfg, method, origin)
+			got := rec.Header().Get("Access-Control-Allow-Origin")
+			switch {
+			case allowed && got != origin:
+				t.Errorf("%s from %s: Access-Control-Allow-Origin = %q, want the origin reflected", method, origin, got)
+			case !allowed && got != "":
+				t.Errorf("%s from %s: Access-Control-Allow-Origin = %q, want none", method, origin, got)
+			}
+			if creds := rec.Header().Get("Access-Control-Allow-Credentials"); allowed && creds != "true" {
+				t.Errorf("%s from %s: Access-Control-Allow-Credentials = %q", method, origin, creds)
+			}
+			// Caches must not serve one origin's answer to another
+			if !strings.Contains(strings.Join(rec.Header().Values("Vary"), ","), "Origin") {
+				t.Errorf("%s from %s: Vary = %q, want Origin", method, origin, rec.Header().Values("Vary"))
+			}
+		}
+	}
+}
+
+func TestCORSWildcardWithoutCredentials(t *testing.T) {
+	useMemoryStore(t)
+	cfg := defaultConfig()
+	cfg.AllowedOrigins = []string{"*"}
+	cfg.CORSCredentials = false
+	rec := corsRequest(t, cfg, http.MethodOptions, "https://anywhere.test")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want none", got)
+	}
+
+	// "*" with credentials is refused at startup
+	cfg.CORSCredentials = true
+	if err := cfg.validate(); err == nil {
+		t.Error(`allowed_origins "*" with cors_credentials accepted`)
+	}
+	cfg.AllowedOrigins = []string{"https://app.example.com", " * "}
+	if err := cfg.validate(); err == nil {
+		t.Error(`allowed_origins " * " with cors_credentials accepted`)
+	}
+}
+
+// Browser clients can change and delete links, not only create them
+func TestCORSLinkMethods(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AllowedOrigins = []string{"https://app.example.com"}
+	handler, err := newRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodPut, http.MethodDelete} {
+		req := httptest.NewRequest(http.MethodOptions, "/links/abc123", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("%s preflight: Access-Control-Allow-Origin = %q, want the origin", method, got)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Methods"); got != method {
+			t.Errorf("%s preflight: Access-Control-Allow-Methods = %q", method, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// Longest a visitor can be kept on the countdown page
+const maxRedirectDelay = time.Minute
+
+// redirectDelay is how long the countdown page is shown before links
+// without their own delay redirect, 0 to redirect right away
+var redirectDelay time.Duration
+
+// linkRedirectDelay returns how long to show the countdown page for link,
+// 0 for an instant redirect
+func linkRedirectDelay(link Link) time.Duration {
+	if link.RedirectDelay > 0 {
+		return link.RedirectDelay
+	}
+	return redirectDelay
+}
+
+// validRedirectDelay reports whether d can be used as a redirect delay:
+// whole seconds, since that's what meta refresh takes, up to maxRedirectDelay
+func validRedirectDelay(d time.Duration) bool {
+	return d >= 0 && d <= maxRedirectDelay && d%time.Second == 0
+}
+
+// delayTemplate counts down before sending the visitor on. The meta refresh
+// redirects even with JavaScript off, the script only updates the count.
+// html/template escapes the URL in the content attribute and the href.
+var delayTemplate = template.Must(template.New("delay").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="{{.Seconds}};url={{.URL}}">
+<title>Redirecting</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #0f172a; color: #e2e8f0; display: flex; min-height: 100vh; align-items: center; justify-content: center; margin: 0; }
+main { background: #1e293b; padding: 2rem; border-radius: 0.5rem; max-width: 40rem; }
+code { display: block; word-break: break-all; background: #334155; padding: 0.75rem; border-radius: 0.25rem; margin: 1rem 0; }
+a { color: #38bdf8; }
+</style>
+</head>
+<body>
+<main>
+<h1>You're being redirected in <span id="countdown">{{.Seconds}}</span> seconds</h1>
+<code>{{.URL}}</code>
+<p><a href="{{.URL}}" rel="noopener noreferrer">Continue now</a></p>
+</main>
+<script>
+let remaining = {{.Seconds}};
+const countdown = document.getElementById("countdown");
+const timer = setInterval(() => {
+	remaining--;
+	countdown.textContent = Math.max(remaining, 0);
+	if (remaining <= 0) clearInterval(timer);
+}, 1000);
+</script>
+</body>
+</html>
+`))
+
+// delayPage is the data rendered by delayTemplate
+type delayPage struct {
+	URL     string
+	Seconds int
+}
+
+// serveDelayPage answers a visit with the countdown page instead of a
+// redirect. The visit has already been counted.
+func serveDelayPage(w http.ResponseWriter, r *http.Request, code, destination string, delay time.Duration) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Each visit must come back here to be counted, like temporary redirects
+	w.Header().Set("Cache-Control", "no-store")
+	page := delayPage{URL: destination, Seconds: int(delay / time.Second)}
+	if err := delayTemplate.Execute(w, page); err != nil {
+		logRequest(r, http.StatusInternalServerError, "redirect", "Error rendering countdown page", "code", code, "error", err)
+		return
+	}
+	redirectsTotal.Inc()
+	logRequest(r, http.StatusOK, "redirect", "Served countdown page", "code", code, "url", destination, "delay", page.Seconds)
+}
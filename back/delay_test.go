@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// metaRefresh returns the content of the page's <meta http-equiv="refresh">
+func metaRefresh(t *testing.T, page string) string {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "meta" {
+			var refresh bool
+			var value string
+			for _, a := range n.Attr {
+				switch a.Key {
+				case "http-equiv":
+					refresh = strings.EqualFold(a.Val, "refresh")
+				case "content":
+					value = a.Val
+				}
+			}
+			if refresh {
+				content = value
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	if content == "" {
+		t.Fatalf("no meta refresh in %s", page)
+	}
+	return content
+}
+
+func TestRedirectDelayPage(t *testing.T) {
+	useMemoryStore(t)
+	const dest = `https://golang.org/doc?a=1&b="x"<y>`
+	saveTestLink(t, "wait", Link{URL: dest, RedirectDelay: 5 * time.Second})
+
+	rec := getRedirect("/wait")
+	if rec.Code != http.StatusOK || rec.Header().Get("Location") != "" {
+		t.Fatalf("status %d, Location %q; want the page, not a redirect", rec.Code, rec.Header().Get("Location"))
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	page := rec.Body.String()
+	if got := metaRefresh(t, page); got != "5;url="+dest {
+		t.Errorf("meta refresh = %q, want 5 seconds to %s", got, dest)
+	}
+	// Escaped wherever it appears, so nothing of it is markup
+	if strings.Contains(page, `"x"<y>`) || strings.Contains(page, "a=1&b") {
+		t.Errorf("destination not escaped:\n%s", page)
+	}
+	if !regexp.MustCompile(`let remaining = +5 *;`).MatchString(page) {
+		t.Errorf("countdown doesn't start at 5:\n%s", page)
+	}
+	if link, _ := store.Lookup(t.Context(), "wait"); link.Clicks != 1 {
+		t.Errorf("clicks = %d, want the page view counted", link.Clicks)
+	}
+}
+
+func TestRedirectDelayScript(t *testing.T) {
+	useMemoryStore(t)
+	const dest = `https://golang.org/</script><script>alert(1)</script>`
+	saveTestLink(t, "xss", Link{URL: dest, RedirectDelay: time.Second})
+	page := getRedirect("/xss").Body.String()
+	if strings.Count(page, "<script>") != 1 {
+		t.Errorf("destination broke out into a script:\n%s", page)
+	}
+	if got := metaRefresh(t, page); got != "1;url="+dest {
+		t.Errorf("meta refresh = %q", got)
+	}
+}
+
+func TestRedirectDelayDefaults(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "plain", Link{URL: "https://golang.org/doc"})
+	saveTestLink(t, "own", Link{URL: "https://golang.org/pkg", RedirectDelay: 3 * time.Second})
+
+	// Instant by default
+	if rec := getRedirect("/plain"); rec.Code != http.StatusFound {
+		t.Errorf("no delay: status %d, want 302", rec.Code)
+	}
+
+	// REDIRECT_DELAY applies to links without their own
+	setForTest(t, &redirectDelay, 10*time.Second)
+	if got := metaRefresh(t, getRedirect("/plain").Body.String()); got != "10;url=https://golang.org/doc" {
+		t.Errorf("global delay: meta refresh = %q", got)
+	}
+	if got := metaRefresh(t, getRedirect("/own").Body.String()); got != "3;url=https://golang.org/pkg" {
+		t.Errorf("link delay: meta refresh = %q", got)
+	}
+}
+
+func TestShortenRedirectDelay(t *testing.T) {
+	useMemoryStore(t)
+	resp := shortenOK(t, `{"url": "https://golang.org/doc", "redirect_delay": "7s"}`)
+	if link, err := store.Lookup(t.Context(), resp.Code); err != nil || link.RedirectDelay != 7*time.Second {
+		t.Errorf("Lookup = %+v, %v; want a 7s delay", link, err)
+	}
+	for _, delay := range []string{`"1500ms"`, `"61s"`, `-1`} {
+		if rec := postShorten(t, `{"url": "https://golang.org/doc", "redirect_delay": `+delay+`}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("redirect_delay %s: status %d, want 400", delay, rec.Code)
+		}
+	}
+}
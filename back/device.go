@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Platforms a link can have its own destination for, see Link.Devices.
+// "mobile" covers iOS and Android too, when the link has no rule for them.
+var devicePlatforms = map[string]bool{"ios": true, "android": true, "mobile": true, "desktop": true}
+
+// devicePlatform classifies a User-Agent as "ios", "android", "mobile"
+// (any other phone or tablet) or "desktop". iPads on iPadOS 13 and later
+// claim to be Macs, so they count as desktop.
+func devicePlatform(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		return "ios"
+	case strings.Contains(ua, "android"):
+		return "android"
+	case strings.Contains(ua, "mobile"):
+		return "mobile"
+	default:
+		return "desktop"
+	}
+}
+
+// deviceURL is the destination of link for a visitor on platform, "" if
+// the link has no rule that applies
+func deviceURL(link Link, platform string) string {
+	if dest, ok := link.Devices[platform]; ok {
+		return dest
+	}
+	if platform == "ios" || platform == "android" {
+		return link.Devices["mobile"]
+	}
+	return ""
+}
+
+// newDeviceRules validates the platform rules of a shorten request.
+// Platform names are lowercased.
+func newDeviceRules(r *http.Request, rules map[string]string) (map[string]string, *shortenError) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	devices := make(map[string]string, len(rules))
+	for platform, dest := range rules {
+		platform = strings.ToLower(strings.TrimSpace(platform))
+		if !devicePlatforms[platform] {
+			return nil, &shortenError{http.StatusBadRequest, "Invalid device platform " + platform + " (expected ios, android, mobile or desktop)", errInvalidRequest}
+		}
+		dest, serr := vetRuleURL(r, "Device URL for "+platform, dest)
+		if serr != nil {
+			return nil, serr
+		}
+		devices[platform] = dest
+	}
+	return devices, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"
+	iPadUA    = "Mozilla/5.0 (iPad; CPU OS 12_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1 Mobile/15E148 Safari/604.1"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36"
+	kaiOSUA   = "Mozilla/5.0 (Mobile; LYF/F300B/LYF-F300B-001-01-15-130718-i; Android; rv:48.0) Gecko/48.0 Firefox/48.0 KAIOS/2.5"
+	nokiaUA   = "Mozilla/5.0 (Symbian/3; Series60/5.2 NokiaN8-00/012.002; Profile/MIDP-2.1 Configuration/CLDC-1.1 ) AppleWebKit/533.4 (KHTML, like Gecko) NokiaBrowser/7.3.0 Mobile Safari/533.4 3gpp-gba"
+)
+
+func TestDevicePlatform(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{iPhoneUA, "ios"},
+		{iPadUA, "ios"},
+		{androidUA, "android"},
+		{kaiOSUA, "android"}, // Says Android too
+		{nokiaUA, "mobile"},
+		{firefoxUA, "desktop"},
+		{chromeUA, "desktop"},
+		{safariUA, "desktop"}, // And iPads on iPadOS 13 and later
+		{"", "desktop"},
+	}
+	for _, tt := range tests {
+		if got := devicePlatform(tt.ua); got != tt.want {
+			t.Errorf("devicePlatform(%q) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceRedirect(t *testing.T) {
+	useMemoryStore(t)
+	stores := shortenOK(t, `{"url": "https://go.dev/doc", "devices": {"iOS": "https://apps.apple.com/app/id1", "android": "https://play.google.com/store/apps/details?id=dev.go"}}`)
+	mobile := shortenOK(t, `{"url": "https://go.dev/doc", "devices": {"mobile": "https://go.dev/m", "desktop": "https://go.dev/desktop"}}`)
+
+	tests := []struct {
+		code string
+		ua   string
+		want string
+	}{
+		{stores.Code, iPhoneUA, "https://apps.apple.com/app/id1"},
+		{stores.Code, iPadUA, "https://apps.apple.com/app/id1"},
+		{stores.Code, androidUA, "https://play.google.com/store/apps/details?id=dev.go"},
+		{stores.Code, firefoxUA, "https://go.dev/doc"}, // No desktop rule, the URL
+		{stores.Code, nokiaUA, "https://go.dev/doc"},
+		{mobile.Code, iPhoneUA, "https://go.dev/m"}, // "mobile" covers iOS and Android
+		{mobile.Code, androidUA, "https://go.dev/m"},
+		{mobile.Code, nokiaUA, "https://go.dev/m"},
+		{mobile.Code, chromeUA, "https://go.dev/desktop"},
+	}
+	for _, tt := range tests {
+		rec := redirectFrom("/"+tt.code, "192.0.2.1", tt.ua)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("%s: status = %d", tt.ua, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s for %s: Location = %q, want %q", tt.code, tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceRulesBeforeGeo(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &countryResolver, CountryResolver(fakeCountries{"192.0.2.1": "DE"}))
+	resp := shortenOK(t, `{"url": "https://go.dev/doc", "devices": {"ios": "https://apps.apple.com/app/id1"}, "geo": {"DE": "https://go.dev/de"}}`)
+
+	if got := redirectFrom("/"+resp.Code, "192.0.2.1", iPhoneUA).Header().Get("Location"); got != "https://apps.apple.com/app/id1" {
+		t.Errorf("iPhone in DE: Location = %q, want the device rule", got)
+	}
+	if got := redirectFrom("/"+resp.Code, "192.0.2.1", firefoxUA).Header().Get("Location"); got != "https://go.dev/de" {
+		t.Errorf("desktop in DE: Location = %q, want the geo rule", got)
+	}
+}
+
+func TestDeviceRulesValidation(t *testing.T) {
+	useMemoryStore(t)
+	for _, body := range []string{
+		`{"url": "https://go.dev/doc", "devices": {"windows": "https://go.dev/win"}}`,
+		`{"url": "https://go.dev/doc", "devices": {"ios": "itms-apps://apps.apple.com/app/id1"}}`,
+		`{"url": "https://go.dev/doc", "devices": {"ios": "http://example.com/loop"}}`,
+	} {
+		if rec := postShorten(t, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if got := storeSize(t); got != 0 {
+		t.Errorf("%d links saved with invalid device rules", got)
+	}
+}
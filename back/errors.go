@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Machine readable error codes sent along with error messages. Clients
+// can rely on these, so don't rename them; messages may change.
+const (
+	errInvalidRequest       = "invalid_request"        // Malformed body or query parameter
+	errInvalidURL           = "invalid_url"            // Missing, malformed, too long or self-referencing URL
+	errInvalidAlias         = "invalid_alias"          // Custom alias doesn't match customAliasPattern
+	errNotFound             = "not_found"              // Unknown short code
+	errExpired              = "expired"                // Short code exists but has expired
+	errDisabled             = "disabled"               // Short code exists but was disabled
+	errConflict             = "conflict"               // Custom alias already taken
+	errBlockedURL           = "blocked_url"            // URL refused by the URLChecker
+	errMethodNotAllowed     = "method_not_allowed"     // Wrong HTTP method for the endpoint
+	errTooLarge             = "payload_too_large"      // Body or batch over its limit
+	errUnsupportedMediaType = "unsupported_media_type" // Body in a format the endpoint doesn't read
+	errUnauthorized         = "unauthorized"           // No API key sent
+	errForbidden            = "forbidden"              // API key (or link password) not valid
+	errPasswordRequired     = "password_required"      // Link is protected, see /unlock/{code}
+	errRateLimited          = "rate_limited"           // Too many requests from this client
+	errUnavailable          = "unavailable"            // Storage backend or URL checker failed
+	errStorageFull          = "storage_full"           // The store is at MEMORY_CAPACITY, no new links
+	errIdempotencyKeyReused = "idempotency_key_reused" // Idempotency-Key sent again with a different body
+	errInternal             = "internal_error"         // Anything else on our side
+)
+
+// Error response structure for JSON errors
+type ErrorResponse struct {
+	Error       string   `json:"error"`
+	Code        string   `json:"code"`
+	Suggestions []string `json:"suggestions,omitempty"` // Free aliases to retry with, when a custom alias is taken
+}
+
+// writeJSONError replies with status and an ErrorResponse body,
+// the JSON counterpart of http.Error
+func writeJSONError(w http.ResponseWriter, status int, message, code string) {
+	writeErrorResponse(w, status, ErrorResponse{Error: message, Code: code})
+}
+
+// writeErrorResponse is writeJSONError for errors with more than a message and code
+func writeErrorResponse(w http.ResponseWriter, status int, resp ErrorResponse) {
+	h := w.Header()
+	// Drop headers meant for the content we're no longer sending, like http.Error does
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// methodNotAllowed is the reply to a request with the wrong HTTP method
+func methodNotAllowed(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method", errMethodNotAllowed)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSONErrors(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "taken", Link{URL: "https://golang.org/doc"})
+
+	tests := []struct {
+		name   string
+		rec    *httptest.ResponseRecorder
+		status int
+		code   string
+	}{
+		{"empty url", postShorten(t, `{"url": ""}`), http.StatusBadRequest, errInvalidURL},
+		{"bad url", postShorten(t, `{"url": "golang.org"}`), http.StatusBadRequest, errInvalidURL},
+		{"malformed body", postShorten(t, `{"url": `), http.StatusBadRequest, errInvalidRequest},
+		{"unknown field", postShorten(t, `{"link": "https://golang.org"}`), http.StatusBadRequest, errInvalidRequest},
+		{"alias taken", postShorten(t, `{"url": "https://golang.org/pkg", "custom_alias": "taken"}`), http.StatusConflict, errConflict},
+		{"unknown code", getRedirect("/missing"), http.StatusNotFound, errNotFound},
+		{"wrong method", serve("/stats/{code}", handleStats, httptest.NewRequest(http.MethodPost, "/stats/taken", nil)), http.StatusMethodNotAllowed, errMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rec.Code != tt.status {
+				t.Errorf("status %d, want %d", tt.rec.Code, tt.status)
+			}
+			if ct := tt.rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			// Exactly {"error": ..., "code": ...}
+			var body map[string]any
+			if err := json.Unmarshal(tt.rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("not JSON: %q", tt.rec.Body)
+			}
+			if message, _ := body["error"].(string); message == "" {
+				t.Errorf("no error message: %s", tt.rec.Body)
+			}
+			if body["code"] != tt.code {
+				t.Errorf("code %v, want %s", body["code"], tt.code)
+			}
+			for key := range body {
+				if key != "error" && key != "code" && key != "suggestions" {
+					t.Errorf("unexpected field %q: %s", key, tt.rec.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteJSONErrorDropsContentLength(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Length", "1234")
+	writeJSONError(rec, http.StatusTeapot, "Short and stout", errInternal)
+	if rec.Header().Get("Content-Length") != "" {
+		t.Error("Content-Length of the content that was meant to be sent is kept")
+	}
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("no X-Content-Type-Options: nosniff")
+	}
+	if body := decodeJSON[ErrorResponse](t, rec); rec.Code != http.StatusTeapot || body.Error != "Short and stout" || body.Code != errInternal {
+		t.Errorf("got %d %+v", rec.Code, body)
+	}
+}
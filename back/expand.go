@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Bounds on expanding a short URL: the whole expansion, and how many
+// redirects are followed before giving up
+const (
+	expandTimeout = 10 * time.Second
+	expandMaxHops = 10
+)
+
+// expandShortURLs turns on expandShortURL for submitted URLs, see EXPAND_SHORT_URLS
+var expandShortURLs bool
+
+// shortenerHosts are the public URL shorteners whose links are expanded.
+// Only these are followed: a redirect anywhere else is taken as the real
+// destination, so a site's own redirects (to a login page, say) aren't baked in.
+var shortenerHosts = map[string]bool{
+	"bit.ly": true, "bitly.com": true, "j.mp": true, "tinyurl.com": true, "t.co": true,
+	"goo.gl": true, "ow.ly": true, "is.gd": true, "v.gd": true, "buff.ly": true,
+	"rebrand.ly": true, "cutt.ly": true, "tiny.cc": true, "shorturl.at": true,
+	"rb.gy": true, "lnkd.in": true, "t.ly": true, "s.id": true, "bl.ink": true,
+}
+
+// errTooManyHops is returned when a short URL redirects more than expandMaxHops times
+var errTooManyHops = errors.New("too many redirects")
+
+// expandClient makes the requests of expandShortURL. It shares the
+// transport of linkCheckClient, so internal addresses are refused on every
+// hop, and returns redirects instead of following them so each hop is vetted.
+var expandClient = &http.Client{
+	Transport: linkCheckClient.Transport,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// isShortenerHost reports whether host belongs to a known URL shortener
+func isShortenerHost(host string) bool {
+	return shortenerHosts[normalizeHost(host)]
+}
+
+// expandShortURL follows the redirects of a link from a known shortener
+// and returns where they lead, once they leave shortenerHosts. It fails
+// with errInternalDestination if a hop points at an internal address and
+// errTooManyHops if they go on for more than expandMaxHops.
+func expandShortURL(ctx context.Context, u *url.URL) (*url.URL, error) {
+	ctx, cancel := context.WithTimeout(ctx, expandTimeout)
+	defer cancel()
+
+	for hops := 0; isShortenerHost(u.Hostname()); hops++ {
+		if hops == expandMaxHops {
+			return nil, errTooManyHops
+		}
+		location, err := nextHop(ctx, u.String())
+		if err != nil {
+			return nil, err
+		}
+		if location == "" {
+			return u, nil // Doesn't redirect (anymore), this is the destination
+		}
+		next, err := u.Parse(location) // Location may be relative
+		if err != nil || (next.Scheme != "http" && next.Scheme != "https") || next.Host == "" {
+			return nil, fmt.Errorf("redirect to invalid URL %q", location)
+		}
+		u = next
+	}
+	return u, nil
+}
+
+// nextHop requests target and returns the Location it redirects to, ""
+// if it doesn't. HEAD is tried first, GET if the server doesn't support HEAD.
+func nextHop(ctx context.Context, target string) (string, error) {
+	resp, err := expandRequest(ctx, http.MethodHead, target)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp, err = expandRequest(ctx, http.MethodGet, target)
+	}
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return "", nil
+	}
+	return resp.Header.Get("Location"), nil
+}
+
+// expandRequest makes one request for nextHop, discarding the body
+func expandRequest(ctx context.Context, method, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "url-shortener-expand")
+	resp, err := expandClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+// redirectChain serves /hop1 -> /hop2 -> /hop3 -> https://golang.org/final,
+// /loop redirecting to itself and /nohead which refuses HEAD, from an
+// address counted as a URL shortener until the test ends
+func redirectChain(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var requests atomic.Int64
+	chain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/hop1":
+			http.Redirect(w, r, "/hop2", http.StatusMovedPermanently)
+		case "/hop2":
+			http.Redirect(w, r, "hop3", http.StatusFound) // Relative to the current hop
+		case "/hop3":
+			http.Redirect(w, r, "https://golang.org/final?from=chain", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/nohead":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			http.Redirect(w, r, "https://golang.org/nohead", http.StatusFound)
+		case "/ftp":
+			http.Redirect(w, r, "ftp://golang.org/file", http.StatusFound)
+		}
+	}))
+	t.Cleanup(chain.Close)
+	setForTest(t, &shortenerHosts, map[string]bool{"127.0.0.1": true})
+	// The chain is on localhost, which the real client refuses
+	setForTest(t, &expandClient, &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	})
+	return chain, &requests
+}
+
+func TestExpandShortURL(t *testing.T) {
+	chain, requests := redirectChain(t)
+	tests := []struct {
+		path, want string
+	}{
+		{"/hop1", "https://golang.org/final?from=chain"},
+		{"/nohead", "https://golang.org/nohead"},
+		{"/notfound", chain.URL + "/notfound"}, // Doesn't redirect, so it's the destination
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(chain.URL + tt.path)
+		got, err := expandShortURL(t.Context(), u)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("expandShortURL(%s) = %v, %v; want %s", tt.path, got, err, tt.want)
+		}
+	}
+
+	// Bounded hops
+	requests.Store(0)
+	u, _ := url.Parse(chain.URL + "/loop")
+	if _, err := expandShortURL(t.Context(), u); !errors.Is(err, errTooManyHops) {
+		t.Errorf("loop: error = %v, want errTooManyHops", err)
+	}
+	if got := requests.Load(); got != expandMaxHops {
+		t.Errorf("loop: %d requests, want %d", got, expandMaxHops)
+	}
+	u, _ = url.Parse(chain.URL + "/ftp")
+	if _, err := expandShortURL(t.Context(), u); err == nil {
+		t.Error("redirect to ftp:// accepted")
+	}
+}
+
+func TestShortenExpandsShortURLs(t *testing.T) {
+	useMemoryStore(t)
+	chain, requests := redirectChain(t)
+
+	// Off by default: stored as submitted, nothing fetched
+	code := shortenOK(t, `{"url": "`+chain.URL+`/hop1"}`).Code
+	if link, _ := store.Lookup(t.Context(), code); link.URL != chain.URL+"/hop1" || requests.Load() != 0 {
+		t.Errorf("without EXPAND_SHORT_URLS: stored %q after %d requests", link.URL, requests.Load())
+	}
+
+	setForTest(t, &expandShortURLs, true)
+	code = shortenOK(t, `{"url": "`+chain.URL+`/hop1"}`).Code
+	if link, _ := store.Lookup(t.Context(), code); link.URL != "https://golang.org/final?from=chain" {
+		t.Errorf("stored %q, want the end of the chain", link.URL)
+	}
+	if rec := postShorten(t, `{"url": "`+chain.URL+`/loop"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("redirect loop: status = %d, want 400", rec.Code)
+	}
+	// Other hosts aren't followed
+	requests.Store(0)
+	shortenOK(t, `{"url": "https://golang.org/doc"}`)
+	if requests.Load() != 0 {
+		t.Error("a URL that isn't from a shortener was fetched")
+	}
+}
+
+func TestShortenExpandRefusesInternal(t *testing.T) {
+	useMemoryStore(t)
+	chain, _ := redirectChain(t)
+	setForTest(t, &expandClient, &http.Client{
+		Transport:     linkCheckClient.Transport, // The real, guarded transport
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	})
+	setForTest(t, &expandShortURLs, true)
+
+	rec := postShorten(t, `{"url": "`+chain.URL+`/hop1"}`)
+	if rec.Code != http.StatusForbidden || decodeJSON[ErrorResponse](t, rec).Code != errBlockedURL {
+		t.Errorf("status = %d: %s; want 403 for a chain on a private address", rec.Code, rec.Body)
+	}
+}
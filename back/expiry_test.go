@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShortenExpiresAt(t *testing.T) {
+	useMemoryStore(t)
+	expires := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	resp := shortenOK(t, `{"url": "https://golang.org/doc", "expires_at": "`+expires.In(time.FixedZone("", 2*3600)).Format(time.RFC3339)+`"}`)
+
+	link, err := store.Lookup(t.Context(), resp.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !link.ExpiresAt.Equal(expires) {
+		t.Errorf("ExpiresAt = %v, want %v", link.ExpiresAt, expires)
+	}
+	if rec := getRedirect("/" + resp.Code); rec.Code != http.StatusFound {
+		t.Errorf("before expires_at: status = %d, want 302", rec.Code)
+	}
+	// Past the date the link is gone, like with expires_in
+	if _, err := store.Update(t.Context(), resp.Code, func(l *Link) error {
+		l.ExpiresAt = time.Now().Add(-time.Second)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := getRedirect("/" + resp.Code); rec.Code != http.StatusGone {
+		t.Errorf("after expires_at: status = %d, want 410", rec.Code)
+	}
+}
+
+func TestShortenExpiresAtStoredLikeExpiresIn(t *testing.T) {
+	setForTest(t, &store, Store(openTestSQLite(t, filepath.Join(t.TempDir(), "links.db"))))
+	before := time.Now()
+	relative := shortenOK(t, `{"url": "https://golang.org/doc", "expires_in": "1h"}`)
+	absolute := shortenOK(t, `{"url": "https://golang.org/pkg", "expires_at": "`+before.Add(time.Hour).UTC().Format(time.RFC3339)+`"}`)
+
+	a, err := store.Lookup(t.Context(), relative.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.Lookup(t.Context(), absolute.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := a.ExpiresAt.Sub(b.ExpiresAt).Abs(); diff > 2*time.Second {
+		t.Errorf("expires_in gave %v, expires_at %v", a.ExpiresAt, b.ExpiresAt)
+	}
+}
+
+func TestShortenExpiresAtInvalid(t *testing.T) {
+	useMemoryStore(t)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name, body string
+		message    string // Part of the error message
+	}{
+		{"past", `{"url": "https://golang.org/doc", "expires_at": "` + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339) + `"}`, "in the future"},
+		{"now-ish", `{"url": "https://golang.org/doc", "expires_at": "` + time.Now().UTC().Format(time.RFC3339) + `"}`, "in the future"},
+		{"both", `{"url": "https://golang.org/doc", "expires_at": "` + future + `", "expires_in": 3600}`, "not both"},
+		{"not RFC 3339", `{"url": "https://golang.org/doc", "expires_at": "tomorrow"}`, "parsing time"},
+		{"no zone", `{"url": "https://golang.org/doc", "expires_at": "2099-01-02T03:04:05"}`, "parsing time"},
+	}
+	for _, tt := range tests {
+		rec := postShorten(t, tt.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400: %s", tt.name, rec.Code, rec.Body)
+			continue
+		}
+		if body := decodeJSON[ErrorResponse](t, rec); !strings.Contains(body.Error, tt.message) {
+			t.Errorf("%s: error = %q, want it to mention %q", tt.name, body.Error, tt.message)
+		}
+	}
+	if got := storeSize(t); got != 0 {
+		t.Errorf("%d links saved with invalid expiry dates", got)
+	}
+}
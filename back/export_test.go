@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// exportCSV fetches GET /export.csv and parses it
+func exportCSV(t *testing.T) (*httptest.ResponseRecorder, [][]string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleExportCSV(rec, httptest.NewRequest(http.MethodGet, "/export.csv", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing the export: %v", err)
+	}
+	return rec, records
+}
+
+func TestExportCSV(t *testing.T) {
+	useMemoryStore(t)
+	created := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	expires := created.Add(30 * 24 * time.Hour)
+	saveTestLink(t, "seeded", Link{URL: "https://golang.org/doc?a=1,b=2", CreatedAt: created, ExpiresAt: expires, Clicks: 42})
+	saveTestLink(t, "plain", Link{URL: "https://go.dev"})
+
+	rec, records := exportCSV(t)
+	if got := rec.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="links.csv"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%d records, want a header and 2 links: %q", len(records), records)
+	}
+	if want := []string{"code", "url", "created_at", "expires_at", "clicks"}; !slices.Equal(records[0], want) {
+		t.Errorf("header = %q, want %q", records[0], want)
+	}
+	// Ordered by code; optional times are left empty
+	if want := []string{"plain", "https://go.dev", "", "", "0"}; !slices.Equal(records[1], want) {
+		t.Errorf("row = %q, want %q", records[1], want)
+	}
+	if want := []string{"seeded", "https://golang.org/doc?a=1,b=2", "2024-05-06T07:08:09Z", "2024-06-05T07:08:09Z", "42"}; !slices.Equal(records[2], want) {
+		t.Errorf("row = %q, want %q", records[2], want)
+	}
+}
+
+func TestExportCSVPages(t *testing.T) {
+	useMemoryStore(t)
+	n := exportPageSize + 3
+	for i := range n {
+		saveTestLink(t, fmt.Sprintf("code%04d", i), Link{URL: "https://golang.org/doc"})
+	}
+	_, records := exportCSV(t)
+	if len(records) != n+1 {
+		t.Fatalf("%d records, want %d", len(records), n+1)
+	}
+	for i, record := range records[1:] {
+		if want := fmt.Sprintf("code%04d", i); record[0] != want {
+			t.Fatalf("row %d: code %q, want %q", i, record[0], want)
+		}
+	}
+}
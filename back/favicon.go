@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Bounds on fetching favicons: one attempt (page and icon together), the
+// page read looking for <link rel="icon">, the icon itself, and how many
+// prefetches run at once (more are skipped, it's best effort)
+const (
+	faviconTimeout     = 10 * time.Second
+	maxFaviconPageSize = 256 << 10
+	maxFaviconSize     = 100 << 10
+	faviconPrefetchers = 4
+)
+
+// How long favicons are cached, by site: found ones, and sites without one
+// (so each visit to /favicon/{code} doesn't fetch it again)
+const (
+	faviconTTL        = 24 * time.Hour
+	faviconMissingTTL = time.Hour
+	faviconCacheSize  = 1000 // Sites, an arbitrary one is evicted when full
+)
+
+// fetchFavicons turns favicon fetching on, see FETCH_FAVICONS
+var fetchFavicons bool
+
+// favicon is the cached icon of a site, data is nil if it has none
+type favicon struct {
+	data        []byte
+	contentType string
+	expires     time.Time
+}
+
+// faviconCache holds favicons by site (scheme and host), since every link
+// to a site shares its icon. Concurrent requests for a site not cached yet
+// wait for a single fetch.
+type faviconCache struct {
+	mu       sync.Mutex
+	icons    map[string]favicon
+	fetching map[string]chan struct{} // Closed once the fetch for the site is done
+	slots    chan struct{}            // Prefetches running, see faviconPrefetchers
+}
+
+var favicons = &faviconCache{
+	icons:    make(map[string]favicon),
+	fetching: make(map[string]chan struct{}),
+	slots:    make(chan struct{}, faviconPrefetchers),
+}
+
+// faviconSite is the cache key for the favicon of destination
+func faviconSite(destination string) (string, bool) {
+	u, err := url.Parse(destination)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	return u.Scheme + "://" + strings.ToLower(u.Host), true
+}
+
+// cached returns the icon of site if it's in the cache, without fetching it
+func (c *faviconCache) cached(site string) (favicon, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	icon, ok := c.icons[site]
+	if !ok || time.Now().After(icon.expires) {
+		return favicon{}, false
+	}
+	return icon, true
+}
+
+// get returns the icon of site, fetching it if it isn't cached. A site
+// without a (usable) favicon gives one with nil data, that's not an error.
+func (c *faviconCache) get(ctx context.Context, site string) favicon {
+	for {
+		if icon, ok := c.cached(site); ok {
+			return icon
+		}
+		c.mu.Lock()
+		wait, busy := c.fetching[site]
+		if !busy {
+			c.fetching[site] = make(chan struct{})
+		}
+		c.mu.Unlock()
+		if !busy {
+			break
+		}
+		select {
+		case <-wait: // Cached now, unless it expired right away
+		case <-ctx.Done():
+			return favicon{}
+		}
+	}
+
+	icon := fetchFavicon(ctx, site)
+	c.mu.Lock()
+	if icon.data != nil || ctx.Err() == nil { // Don't remember a site as iconless because we gave up early
+		if len(c.icons) >= faviconCacheSize {
+			for evict := range c.icons {
+				delete(c.icons, evict)
+				break
+			}
+		}
+		c.icons[site] = icon
+	}
+	close(c.fetching[site])
+	delete(c.fetching, site)
+	c.mu.Unlock()
+	return icon
+}
+
+// prefetch fetches the icon of destination's site in the background, so
+// it's ready for previews and the admin list. It never blocks: if enough
+// fetches are running already, this one is skipped.
+func (c *faviconCache) prefetch(destination string) {
+	site, ok := faviconSite(destination)
+	if !fetchFavicons || !ok {
+		return
+	}
+	if _, ok := c.cached(site); ok {
+		return
+	}
+	select {
+	case c.slots <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-c.slots }()
+		c.get(context.Background(), site) // fetchFavicon bounds it
+	}()
+}
+
+// fetchFavicon finds the icon of site: the one its home page declares
+// with <link rel="icon">, else /favicon.ico. Failures are logged and give
+// an icon with nil data.
+func fetchFavicon(ctx context.Context, site string) favicon {
+	ctx, cancel := context.WithTimeout(ctx, faviconTimeout)
+	defer cancel()
+
+	candidates := []string{site + "/favicon.ico"}
+	if declared, err := declaredFavicon(ctx, site); err != nil {
+		slog.DebugContext(ctx, "Could not read home page for favicon", "event", "favicon", "site", site, "error", err)
+	} else if declared != "" {
+		candidates = append([]string{declared}, candidates...)
+	}
+	for _, candidate := range candidates {
+		data, contentType, err := fetchIcon(ctx, candidate)
+		if err == nil {
+			return favicon{data: data, contentType: contentType, expires: time.Now().Add(faviconTTL)}
+		}
+		slog.DebugContext(ctx, "No favicon at URL", "event", "favicon", "site", site, "url", candidate, "error", err)
+	}
+	return favicon{expires: time.Now().Add(faviconMissingTTL)}
+}
+
+// faviconRequest GETs target with linkCheckClient, which refuses internal
+// addresses. The caller closes the body.
+func faviconRequest(ctx context.Context, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "url-shortener-favicon")
+	resp, err := linkCheckClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// declaredFavicon returns the absolute URL of the icon the home page of
+// site links to, "" if it doesn't
+func declaredFavicon(ctx context.Context, site string) (string, error) {
+	resp, err := faviconRequest(ctx, site+"/")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	tokens := html.NewTokenizer(io.LimitReader(resp.Body, maxFaviconPageSize))
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			return "", nil // End of the page (or of what we read), no icon
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokens.TagName()
+			if string(name) == "body" {
+				return "", nil // Icons are declared in <head>
+			}
+			if string(name) != "link" || !hasAttr {
+				continue
+			}
+			var rel, href string
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokens.TagAttr()
+				switch string(key) {
+				case "rel":
+					rel = strings.ToLower(string(value))
+				case "href":
+					href = string(value)
+				}
+			}
+			if href == "" || !isIconRel(rel) {
+				continue
+			}
+			// Relative to the page, which may have been redirected
+			icon, err := resp.Request.URL.Parse(href)
+			if err != nil || (icon.Scheme != "http" && icon.Scheme != "https") {
+				continue
+			}
+			return icon.String(), nil
+		}
+	}
+}
+
+// isIconRel reports whether a <link rel> declares a favicon: "icon",
+// "shortcut icon" and the like
+func isIconRel(rel string) bool {
+	for _, word := range strings.Fields(rel) {
+		if word == "icon" {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchIcon downloads the image at target. Only raster images are kept:
+// the type is sniffed from the data rather than trusted from the header,
+// and SVGs (which can carry scripts) don't pass, since we serve them from
+// our own origin.
+func fetchIcon(ctx context.Context, target string) ([]byte, string, error) {
+	resp, err := faviconRequest(ctx, target)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFaviconSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxFaviconSize {
+		return nil, "", errors.New("favicon too large")
+	}
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", errors.New("not an image: " + contentType)
+	}
+	return data, contentType, nil
+}
+
+// faviconPath is where the admin list finds the icon of link, "" if we
+// don't have one (yet)
+func faviconPath(code string, link Link) string {
+	site, ok := faviconSite(link.URL)
+	if !fetchFavicons || !ok {
+		return ""
+	}
+	if icon, ok := favicons.cached(site); !ok || icon.data == nil {
+		return ""
+	}
+	return "/favicon/" + code
+}
+
+// handleFavicon serves the favicon of a link's destination site, fetching
+// it first if it isn't cached. Sites without one get a 404.
+func handleFavicon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w)
+		return
+	}
+	if !fetchFavicons {
+		writeJSONError(w, http.StatusNotFound, "Favicons are turned off", errNotFound)
+		logRequest(r, http.StatusNotFound, "favicon", "Favicons are turned off")
+		return
+	}
+
+	shortCode := canonicalCode(r.PathValue("code"))
+	link, err := lookupLink(r.Context(), shortCode)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+			logRequest(r, http.StatusNotFound, "favicon", "Short code not found", "code", shortCode)
+			return
+		}
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "favicon", "Error looking up short code", "code", shortCode, "error", err)
+		return
+	}
+
+	site, ok := faviconSite(link.URL)
+	var icon favicon
+	if ok {
+		icon = favicons.get(r.Context(), site)
+	}
+	if icon.data == nil {
+		writeJSONError(w, http.StatusNotFound, "No favicon for this link", errNotFound)
+		logRequest(r, http.StatusNotFound, "favicon", "No favicon for this link", "code", shortCode, "site", site)
+		return
+	}
+
+	w.Header().Set("Content-Type", icon.contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(icon.data)
+	logRequest(r, http.StatusOK, "favicon", "Served favicon", "code", shortCode, "site", site)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Enough of each format for http.DetectContentType
+var (
+	pngIcon = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	icoIcon = []byte("\x00\x00\x01\x00\x01\x00\x10\x10")
+	svgIcon = []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)
+)
+
+// useFavicons turns favicons on with an empty cache, fetched through client
+func useFavicons(t *testing.T, client *http.Client) {
+	t.Helper()
+	setForTest(t, &fetchFavicons, true)
+	setForTest(t, &favicons, &faviconCache{
+		icons:    make(map[string]favicon),
+		fetching: make(map[string]chan struct{}),
+		slots:    make(chan struct{}, faviconPrefetchers),
+	})
+	// Test sites are on localhost, which the real client refuses
+	setForTest(t, &linkCheckClient, client)
+}
+
+// faviconServer serves home as its home page and files by path, counting
+// the requests for each path
+type faviconServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newFaviconServer(t *testing.T, home string, files map[string][]byte) *faviconServer {
+	t.Helper()
+	s := &faviconServer{requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		s.mu.Unlock()
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(home))
+			return
+		}
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png") // Claimed for all of them, it's sniffed anyway
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *faviconServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// getFavicon sends GET /favicon/{code} to handleFavicon
+func getFavicon(code string) *httptest.ResponseRecorder {
+	return serve("/favicon/{code}", handleFavicon, httptest.NewRequest(http.MethodGet, "/favicon/"+code, nil))
+}
+
+func TestFavicon(t *testing.T) {
+	tests := []struct {
+		name        string
+		home        string
+		files       map[string][]byte
+		want        []byte // nil for a 404
+		contentType string
+	}{
+		{
+			name:        "declared",
+			home:        `<html><head><link rel="Shortcut Icon" href="/static/icon.png"></head><body><link rel="icon" href="/late.png"></body></html>`,
+			files:       map[string][]byte{"/static/icon.png": pngIcon, "/favicon.ico": icoIcon, "/late.png": icoIcon},
+			want:        pngIcon,
+			contentType: "image/png",
+		},
+		{
+			name:        "declared missing, favicon.ico",
+			home:        `<head><link rel="icon" href="/gone.png"></head>`,
+			files:       map[string][]byte{"/favicon.ico": icoIcon},
+			want:        icoIcon,
+			contentType: "image/x-icon",
+		},
+		{
+			name:  "none",
+			home:  `<html><body>No icon here</body></html>`,
+			files: map[string][]byte{},
+		},
+		{
+			name:  "svg refused",
+			home:  `<head><link rel="icon" href="/icon.svg"></head>`,
+			files: map[string][]byte{"/icon.svg": svgIcon, "/favicon.ico": svgIcon},
+		},
+		{
+			name:  "too large",
+			home:  "",
+			files: map[string][]byte{"/favicon.ico": append(bytes.Clone(pngIcon), make([]byte, maxFaviconSize)...)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			site := newFaviconServer(t, tt.home, tt.files)
+			useFavicons(t, site.Client())
+			saveTestLink(t, "icon", Link{URL: site.URL + "/some/page"})
+
+			rec := getFavicon("icon")
+			if tt.want == nil {
+				if rec.Code != http.StatusNotFound {
+					t.Fatalf("status = %d, want 404", rec.Code)
+				}
+				if body := decodeJSON[ErrorResponse](t, rec); body.Code != errNotFound {
+					t.Errorf("error = %+v", body)
+				}
+				return
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), tt.want) {
+				t.Errorf("body = %q, want %q", rec.Body, tt.want)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q", got)
+			}
+		})
+	}
+}
+
+func TestFaviconCached(t *testing.T) {
+	useMemoryStore(t)
+	site := newFaviconServer(t, "", map[string][]byte{"/favicon.ico": icoIcon})
+	useFavicons(t, site.Client())
+	// Two links to the same site share its icon
+	saveTestLink(t, "one", Link{URL: site.URL + "/a"})
+	saveTestLink(t, "two", Link{URL: site.URL + "/b"})
+
+	for _, code := range []string{"one", "two", "one"} {
+		if rec := getFavicon(code); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", code, rec.Code)
+		}
+	}
+	if n := site.count("/favicon.ico"); n != 1 {
+		t.Errorf("favicon.ico fetched %d times, want once", n)
+	}
+	if got := faviconPath("two", Link{URL: site.URL + "/b"}); got != "/favicon/two" {
+		t.Errorf("faviconPath = %q, want /favicon/two", got)
+	}
+
+	// A site without one is remembered too
+	empty := newFaviconServer(t, "", map[string][]byte{})
+	setForTest(t, &linkCheckClient, empty.Client())
+	saveTestLink(t, "none", Link{URL: empty.URL})
+	for range 2 {
+		if rec := getFavicon("none"); rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want 404", rec.Code)
+		}
+	}
+	if n := empty.count("/favicon.ico"); n != 1 {
+		t.Errorf("missing favicon.ico fetched %d times, want once", n)
+	}
+	if got := faviconPath("none", Link{URL: empty.URL}); got != "" {
+		t.Errorf("faviconPath = %q for a site without an icon", got)
+	}
+}
+
+// Shortening a link makes the server fetch from its site, so it's opt-in
+func TestFaviconsOffByDefault(t *testing.T) {
+	if defaultConfig().FetchFavicons {
+		t.Error("FETCH_FAVICONS is on by default")
+	}
+}
+
+func TestFaviconTurnedOff(t *testing.T) {
+	useMemoryStore(t)
+	site := newFaviconServer(t, "", map[string][]byte{"/favicon.ico": icoIcon})
+	useFavicons(t, site.Client())
+	setForTest(t, &fetchFavicons, false)
+	saveTestLink(t, "icon", Link{URL: site.URL})
+
+	rec := getFavicon("icon")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "turned off") {
+		t.Errorf("status = %d: %s, want a 404", rec.Code, rec.Body)
+	}
+	favicons.prefetch(site.URL)
+	if n := site.count("/favicon.ico") + site.count("/"); n != 0 {
+		t.Errorf("site fetched %d times with favicons off", n)
+	}
+	if got := faviconPath("icon", Link{URL: site.URL}); got != "" {
+		t.Errorf("faviconPath = %q with favicons off", got)
+	}
+}
+
+func TestFaviconUnknownCode(t *testing.T) {
+	useMemoryStore(t)
+	useFavicons(t, http.DefaultClient)
+	if rec := getFavicon("nope"); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// With FORWARD_PATH, whatever follows the code in the short URL is appended
+// to the destination path: /abc/docs/intro with abc -> https://example.com/guide
+// redirects to https://example.com/guide/docs/intro. A lone trailing slash
+// (/abc/) redirects like /abc.
+var forwardPath bool
+
+// With FORWARD_QUERY, query parameters of the short URL are passed on to
+// the destination. The merge rules:
+//   - parameters only the destination has are kept as they are
+//   - parameters only the short URL has are appended
+//   - a parameter both have takes the short URL's value(s), so a link can
+//     be retargeted per visit, e.g. a different utm_source
+//   - our own parameters (like preview) are never forwarded
+//
+// The merged query is re-encoded sorted by name.
+var forwardQuery bool
+
+// With UPGRADE_HTTPS, visitors to http:// destinations are sent to the
+// https:// version instead. Only where they're sent changes, the stored
+// URL stays as it was (so turning it off again undoes it). URLs with a port
+// other than 80 are left alone, we can't guess the HTTPS one.
+var upgradeHTTPS bool
+
+// Query parameters the redirect handler reads itself
+var ownQueryParams = map[string]bool{"preview": true}
+
+// upgradeToHTTPS returns dest with the https scheme, if it's an http URL
+// on the default port
+func upgradeToHTTPS(dest string) string {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "http" {
+		return dest
+	}
+	if port := u.Port(); port != "" && port != "80" {
+		return dest
+	}
+	u.Scheme, u.Host = "https", u.Hostname()
+	if strings.Contains(u.Host, ":") {
+		u.Host = "[" + u.Host + "]" // IPv6 literal
+	}
+	return u.String()
+}
+
+// splitShortPath splits a redirect path ("/abc/rest") into the code and
+// the extra path to forward ("/rest"). Without FORWARD_PATH the whole path
+// is the code, as before.
+func splitShortPath(path string) (code, extra string) {
+	path = strings.TrimPrefix(path, "/")
+	if !forwardPath {
+		return path, ""
+	}
+	code, rest, found := strings.Cut(path, "/")
+	if !found || rest == "" {
+		return code, ""
+	}
+	return code, "/" + rest
+}
+
+// forwardedDestination applies FORWARD_PATH and FORWARD_QUERY to a
+// destination URL. It returns dest unchanged if there's nothing to forward.
+func forwardedDestination(dest, extraPath string, query url.Values) string {
+	forwarded := url.Values{}
+	if forwardQuery {
+		for name, values := range query {
+			if !ownQueryParams[name] {
+				forwarded[name] = values
+			}
+		}
+	}
+	if extraPath == "" && len(forwarded) == 0 {
+		return dest
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest // Stored URLs were validated, this shouldn't happen
+	}
+	if extraPath != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + extraPath
+		u.RawPath = ""
+	}
+	if len(forwarded) > 0 {
+		merged := u.Query()
+		for name, values := range forwarded {
+			merged[name] = values
+		}
+		u.RawQuery = merged.Encode()
+	}
+	return u.String()
+}
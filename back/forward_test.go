@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestForwardedDestination(t *testing.T) {
+	tests := []struct {
+		name                string
+		path, query         bool // FORWARD_PATH, FORWARD_QUERY
+		dest, extra, params string
+		want                string
+	}{
+		{"off", false, false, "https://golang.org/guide?a=1", "", "b=2", "https://golang.org/guide?a=1"},
+		{"path", true, false, "https://golang.org/guide", "/docs/intro", "", "https://golang.org/guide/docs/intro"},
+		{"path after slash", true, false, "https://golang.org/guide/", "/intro", "", "https://golang.org/guide/intro"},
+		{"path keeps query", true, false, "https://golang.org/guide?a=1", "/intro", "b=2", "https://golang.org/guide/intro?a=1"},
+		{"escaped path", true, false, "https://golang.org/", "/a b", "", "https://golang.org/a%20b"},
+		{"query appended", false, true, "https://golang.org/guide?a=1", "", "b=2", "https://golang.org/guide?a=1&b=2"},
+		{"short URL wins", false, true, "https://golang.org/?utm_source=site&x=1", "", "utm_source=mail", "https://golang.org/?utm_source=mail&x=1"},
+		{"repeated values", false, true, "https://golang.org/?tag=a", "", "tag=b&tag=c", "https://golang.org/?tag=b&tag=c"},
+		{"own params dropped", false, true, "https://golang.org/guide", "", "preview=1", "https://golang.org/guide"},
+		{"both", true, true, "https://golang.org/guide?z=1", "/intro", "a=2", "https://golang.org/guide/intro?a=2&z=1"},
+		{"keeps fragment", false, true, "https://golang.org/guide#top", "", "a=1", "https://golang.org/guide?a=1#top"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &forwardPath, tt.path)
+			setForTest(t, &forwardQuery, tt.query)
+			query, err := url.ParseQuery(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := forwardedDestination(tt.dest, tt.extra, query); got != tt.want {
+				t.Errorf("forwardedDestination(%q, %q, %q) = %q, want %q", tt.dest, tt.extra, tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitShortPath(t *testing.T) {
+	tests := []struct {
+		path        string
+		forward     bool
+		code, extra string
+	}{
+		{"/abc", false, "abc", ""},
+		{"/abc/docs", false, "abc/docs", ""},
+		{"/abc", true, "abc", ""},
+		{"/abc/", true, "abc", ""},
+		{"/abc/docs/intro", true, "abc", "/docs/intro"},
+	}
+	for _, tt := range tests {
+		setForTest(t, &forwardPath, tt.forward)
+		if code, extra := splitShortPath(tt.path); code != tt.code || extra != tt.extra {
+			t.Errorf("splitShortPath(%q) with FORWARD_PATH=%v = %q, %q; want %q, %q", tt.path, tt.forward, code, extra, tt.code, tt.extra)
+		}
+	}
+}
+
+func TestRedirectForwardsPathAndQuery(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "docs", Link{URL: "https://golang.org/doc?lang=en"})
+
+	if got := getRedirect("/docs?lang=fr").Header().Get("Location"); got != "https://golang.org/doc?lang=en" {
+		t.Errorf("Location by default = %q, want the destination exactly", got)
+	}
+
+	setForTest(t, &forwardPath, true)
+	setForTest(t, &forwardQuery, true)
+	rec := getRedirect("/docs/install?lang=fr&ref=x")
+	if want := "https://golang.org/doc/install?lang=fr&ref=x"; rec.Header().Get("Location") != want {
+		t.Errorf("Location = %q, want %q", rec.Header().Get("Location"), want)
+	}
+	if link, _ := store.Lookup(t.Context(), "docs"); link.Clicks != 2 {
+		t.Errorf("clicks = %d, want both visits counted for the code", link.Clicks)
+	}
+}
+
+func TestUpgradeToHTTPS(t *testing.T) {
+	tests := map[string]string{
+		"http://golang.org/doc?a=1#top": "https://golang.org/doc?a=1#top",
+		"http://golang.org:80/doc":      "https://golang.org/doc",
+		"http://[2001:db8::1]/doc":      "https://[2001:db8::1]/doc",
+		"http://golang.org:8080/doc":    "http://golang.org:8080/doc", // https is unlikely on another port
+		"https://golang.org/doc":        "https://golang.org/doc",
+	}
+	for dest, want := range tests {
+		if got := upgradeToHTTPS(dest); got != want {
+			t.Errorf("upgradeToHTTPS(%q) = %q, want %q", dest, got, want)
+		}
+	}
+}
+
+func TestRedirectUpgradesHTTPS(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "plain", Link{URL: "http://golang.org/doc"})
+
+	for _, upgrade := range []bool{false, true} {
+		setForTest(t, &upgradeHTTPS, upgrade)
+		want := "http://golang.org/doc"
+		if upgrade {
+			want = "https://golang.org/doc"
+		}
+		if got := getRedirect("/plain").Header().Get("Location"); got != want {
+			t.Errorf("UPGRADE_HTTPS=%v: Location = %q, want %q", upgrade, got, want)
+		}
+	}
+	// Only the Location, the link keeps its URL
+	if link, err := store.Lookup(t.Context(), "plain"); err != nil || link.URL != "http://golang.org/doc" {
+		t.Errorf("Lookup = %+v, %v", link, err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Most country rules one link can have
+const maxGeoRules = 50
+
+// ISO 3166-1 alpha-2 country codes, as GeoIP databases report them
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// CountryResolver finds the country a client IP is in, as an ISO 3166-1
+// alpha-2 code like "DE". It returns "" (and maybe an error) if it can't tell.
+type CountryResolver interface {
+	Country(ip net.IP) (string, error)
+}
+
+// countryResolver is used to pick geo destinations, nil without a GeoIP
+// database (GEOIP_DB): links with geo rules then always go to their URL
+var countryResolver CountryResolver
+
+// geoIPResolver looks countries up in a MaxMind GeoLite2/GeoIP2 database
+type geoIPResolver struct {
+	db *geoip2.Reader
+}
+
+// openGeoIP opens the GeoIP database file at path
+func openGeoIP(path string) (*geoIPResolver, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &geoIPResolver{db: db}, nil
+}
+
+func (g *geoIPResolver) Country(ip net.IP) (string, error) {
+	record, err := g.db.Country(ip)
+	if err != nil {
+		return "", err
+	}
+	return record.Country.IsoCode, nil
+}
+
+// Close releases the database
+func (g *geoIPResolver) Close() error {
+	return g.db.Close()
+}
+
+// requestCountry is the country of the client making r, "" if unknown
+func requestCountry(r *http.Request) string {
+	if countryResolver == nil {
+		return ""
+	}
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return ""
+	}
+	country, err := countryResolver.Country(ip)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Could not resolve client country", "event", "geo", "error", err)
+		return ""
+	}
+	return country
+}
+
+// geoURL is the destination of link for a visitor from country: its
+// rule for the country if it has one, otherwise its default URL
+func geoURL(link Link, country string) string {
+	if dest, ok := link.Geo[country]; ok && country != "" {
+		return dest
+	}
+	return link.URL
+}
+
+// newGeoRules validates the country rules of a shorten request, vetting
+// each destination like the link's own URL. Country codes are uppercased.
+func newGeoRules(r *http.Request, rules map[string]string) (map[string]string, *shortenError) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if len(rules) > maxGeoRules {
+		return nil, &shortenError{http.StatusBadRequest, fmt.Sprintf("Too many geo rules (maximum is %d)", maxGeoRules), errInvalidRequest}
+	}
+	geo := make(map[string]string, len(rules))
+	for country, dest := range rules {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if !countryCodePattern.MatchString(country) {
+			return nil, &shortenError{http.StatusBadRequest, fmt.Sprintf("Invalid geo country %q (expected a two letter code like DE)", country), errInvalidRequest}
+		}
+		dest, serr := vetRuleURL(r, "Geo URL for "+country, dest)
+		if serr != nil {
+			return nil, serr
+		}
+		geo[country] = dest
+	}
+	return geo, nil
+}
+
+// vetRuleURL checks an alternative destination of a link (a geo or device
+// rule) like buildLink checks its URL, and normalizes it the same way.
+// name says which rule it is in error messages.
+func vetRuleURL(r *http.Request, name, dest string) (string, *shortenError) {
+	if len(dest) > maxURLLength {
+		return "", &shortenError{http.StatusBadRequest, fmt.Sprintf("%s too long (maximum is %d characters)", name, maxURLLength), errInvalidURL}
+	}
+	parsed, err := url.Parse(dest)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", &shortenError{http.StatusBadRequest, name + " is invalid (must start with http:// or https://)", errInvalidURL}
+	}
+	if isSelfHost(r, parsed.Hostname()) {
+		return "", &shortenError{http.StatusBadRequest, name + " points to this shortener", errInvalidURL}
+	}
+	if urlChecker != nil {
+		blocked, reason, err := urlChecker.Check(r.Context(), parsed)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking URL", "event", "shorten", "url", dest, "error", err)
+			return "", &shortenError{http.StatusServiceUnavailable, "Could not check URL, please try again", errUnavailable}
+		}
+		if blocked {
+			return "", &shortenError{http.StatusForbidden, name + " is not allowed: " + reason, errBlockedURL}
+		}
+	}
+	if normalizeURLs {
+		return normalizeURL(parsed, stripTracking), nil
+	}
+	return dest, nil
+}
+
+// encodeRules and parseRules convert geo and device rules to and from
+// the query encoded form stores keep them in, like the UTM defaults
+func encodeRules(rules map[string]string) string {
+	values := make(url.Values, len(rules))
+	for key, dest := range rules {
+		values.Set(key, dest)
+	}
+	return values.Encode()
+}
+
+func parseRules(encoded string) map[string]string {
+	values, err := url.ParseQuery(encoded)
+	if err != nil || len(values) == 0 {
+		return nil // Empty, or something we didn't write
+	}
+	rules := make(map[string]string, len(values))
+	for key := range values {
+		rules[key] = values.Get(key)
+	}
+	return rules
+}
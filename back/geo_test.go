@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// fakeCountries resolves IPs from a map, others fail
+type fakeCountries map[string]string
+
+func (f fakeCountries) Country(ip net.IP) (string, error) {
+	if country, ok := f[ip.String()]; ok {
+		return country, nil
+	}
+	return "", errors.New("not in the database")
+}
+
+// redirectFrom sends GET path to handleRedirect from the client at ip
+func redirectFrom(path, ip, userAgent string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = net.JoinHostPort(ip, "1234")
+	r.Header.Set("User-Agent", userAgent)
+	rec := httptest.NewRecorder()
+	handleRedirect(rec, r)
+	return rec
+}
+
+func TestGeoRedirect(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &countryResolver, CountryResolver(fakeCountries{
+		"192.0.2.1":   "DE",
+		"192.0.2.2":   "FR",
+		"2001:db8::1": "US",
+		"192.0.2.9":   "JP",
+	}))
+	resp := shortenOK(t, `{"url": "https://go.dev/doc", "geo": {"de": "https://go.dev/de", " FR ": "https://go.dev/fr", "US": "https://go.dev/us"}}`)
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.1", "https://go.dev/de"},
+		{"192.0.2.2", "https://go.dev/fr"},
+		{"2001:db8::1", "https://go.dev/us"},
+		{"192.0.2.9", "https://go.dev/doc"},    // No rule for JP
+		{"198.51.100.7", "https://go.dev/doc"}, // Lookup fails
+	}
+	for _, tt := range tests {
+		rec := redirectFrom("/"+resp.Code, tt.ip, firefoxUA)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("%s: status = %d", tt.ip, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: Location = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	// Without a database every visitor gets the default
+	setForTest(t, &countryResolver, nil)
+	if got := redirectFrom("/"+resp.Code, "192.0.2.1", firefoxUA).Header().Get("Location"); got != "https://go.dev/doc" {
+		t.Errorf("without GeoIP: Location = %q, want the default", got)
+	}
+}
+
+func TestGeoRulesValidation(t *testing.T) {
+	useMemoryStore(t)
+	for _, body := range []string{
+		`{"url": "https://go.dev/doc", "geo": {"Germany": "https://go.dev/de"}}`,
+		`{"url": "https://go.dev/doc", "geo": {"D3": "https://go.dev/de"}}`,
+		`{"url": "https://go.dev/doc", "geo": {"DE": "ftp://go.dev/de"}}`,
+		`{"url": "https://go.dev/doc", "geo": {"DE": "http://example.com/loop"}}`,
+	} {
+		if rec := postShorten(t, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if got := storeSize(t); got != 0 {
+		t.Errorf("%d links saved with invalid geo rules", got)
+	}
+}
+
+func TestGeoRulesStored(t *testing.T) {
+	setForTest(t, &store, Store(openTestSQLite(t, filepath.Join(t.TempDir(), "links.db"))))
+	setForTest(t, &countryResolver, CountryResolver(fakeCountries{"192.0.2.1": "DE"}))
+	resp := shortenOK(t, `{"url": "https://go.dev/doc", "geo": {"DE": "https://go.dev/de?a=1&b=2"}}`)
+
+	if got := redirectFrom("/"+resp.Code, "192.0.2.1", firefoxUA).Header().Get("Location"); got != "https://go.dev/de?a=1&b=2" {
+		t.Errorf("Location = %q after a round trip through SQLite", got)
+	}
+	if got := parseRules(encodeRules(map[string]string{"DE": "https://go.dev/de?a=1&b=2", "FR": "https://go.dev/fr"})); len(got) != 2 || got["DE"] != "https://go.dev/de?a=1&b=2" {
+		t.Errorf("parseRules(encodeRules()) = %v", got)
+	}
+	if got := parseRules(""); got != nil {
+		t.Errorf("parseRules(\"\") = %v, want nil", got)
+	}
+}
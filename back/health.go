@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// version is the build's version, set at build time with
+//
+//	go build -ldflags "-X main.version=1.4.0"
+var version = "dev"
+
+// Response structure for GET /ping
+type PingResponse struct {
+	Time      time.Time `json:"time"` // Server clock, UTC
+	Version   string    `json:"version"`
+	GoVersion string    `json:"go_version"`
+}
+
+// handlePing answers with the server time and build version, without
+// touching storage: clients can tell a deploy happened by the version
+// changing. /healthz is the check for whether the service works.
+func handlePing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(PingResponse{Time: time.Now().UTC(), Version: version, GoVersion: runtime.Version()})
+}
+
+// Response structure for GET /healthz
+type HealthResponse struct {
+	Status string `json:"status"` // "ok" or "unavailable"
+}
+
+// handleHealth reports whether the service can reach its storage, so load
+// balancers and orchestrators can take broken instances out of rotation
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	// Any cheap read will do to check the backend answers
+	status, resp := http.StatusOK, HealthResponse{Status: "ok"}
+	if _, err := store.Exists(r.Context(), "healthz"); err != nil {
+		status, resp = http.StatusServiceUnavailable, HealthResponse{Status: "unavailable"}
+		logRequest(r, status, "health", "Storage backend unreachable", "error", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
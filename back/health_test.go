@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	setForTest(t, &version, "1.4.0")
+	before := time.Now().UTC()
+	rec := httptest.NewRecorder()
+	handlePing(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	resp := decodeJSON[PingResponse](t, rec)
+	if resp.Version != "1.4.0" || resp.GoVersion != runtime.Version() {
+		t.Errorf("ping = %+v", resp)
+	}
+	if resp.Time.Before(before.Add(-time.Second)) || resp.Time.After(time.Now().Add(time.Second)) || resp.Time.Location() != time.UTC {
+		t.Errorf("time = %v, want about %v in UTC", resp.Time, before)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handlePing(rec, httptest.NewRequest(http.MethodPost, "/ping", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rec.Code)
+	}
+}
+
+// The version is set at build time, so build the server with it and ask
+func TestPingVersionFromLdflags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the server")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+	bin := filepath.Join(t.TempDir(), "url-shortener")
+	build := exec.Command(goTool, "build", "-ldflags", "-X main.version=9.8.7-test", "-o", bin, ".")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	_, port, _ := net.SplitHostPort(freeAddr(t))
+	server := exec.Command(bin)
+	server.Env = append(os.Environ(), "PORT="+port, "STORAGE_BACKEND=memory")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Process.Kill()
+		server.Wait()
+	})
+
+	var resp *http.Response
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if resp, err = http.Get("http://127.0.0.1:" + port + "/ping"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server never answered: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	defer resp.Body.Close()
+	var ping PingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ping); err != nil {
+		t.Fatal(err)
+	}
+	if ping.Version != "9.8.7-test" {
+		t.Errorf("version = %q, want the one from -ldflags", ping.Version)
+	}
+}
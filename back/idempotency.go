@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default for IDEMPOTENCY_TTL, how long a response is replayed for its key
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// Longest Idempotency-Key we accept, plenty for a UUID or similar
+const maxIdempotencyKeyLength = 255
+
+// idempotentResponse is the response to the first request with a key.
+// done is closed once it's filled in, requests with the same key that
+// arrive meanwhile wait on it.
+type idempotentResponse struct {
+	done     chan struct{}
+	bodyHash [sha256.Size]byte // Of the first request, a retry sends the same body
+	kept     bool              // Whether the response below is replayed, see finish
+	status   int
+	header   http.Header
+	body     []byte
+	expires  time.Time
+}
+
+// idempotencyCache remembers responses by Idempotency-Key, so a client
+// retrying a request doesn't create a second link
+type idempotencyCache struct {
+	mu          sync.Mutex
+	responses   map[string]*idempotentResponse
+	ttl         time.Duration
+	lastCleanup time.Time
+}
+
+// newIdempotencyCache replays responses for ttl after they were made
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		responses:   make(map[string]*idempotentResponse),
+		ttl:         ttl,
+		lastCleanup: time.Now(),
+	}
+}
+
+// acquire returns the response for key. If there is none yet, a pending
+// one is created for a request with bodyHash and first is true: the caller
+// must run the request and call finish.
+func (c *idempotencyCache) acquire(key string, bodyHash [sha256.Size]byte) (resp *idempotentResponse, first bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// Drop stale responses every now and then so the map doesn't grow forever
+	if now.Sub(c.lastCleanup) > c.ttl {
+		for k, resp := range c.responses {
+			if resp.kept && now.After(resp.expires) {
+				delete(c.responses, k)
+			}
+		}
+		c.lastCleanup = now
+	}
+
+	if resp, exists := c.responses[key]; exists && !(resp.kept && now.After(resp.expires)) {
+		return resp, false
+	}
+	resp = &idempotentResponse{done: make(chan struct{}), bodyHash: bodyHash}
+	c.responses[key] = resp
+	return resp, true
+}
+
+// finish stores the recorded response and wakes up anyone waiting for it.
+// Server errors aren't kept, so a retry gets another chance to succeed.
+func (c *idempotencyCache) finish(key string, resp *idempotentResponse, rec *responseRecorder) {
+	c.mu.Lock()
+	if rec.status < http.StatusInternalServerError {
+		resp.kept = true
+		resp.status, resp.header, resp.body = rec.status, rec.header, rec.body.Bytes()
+		resp.expires = time.Now().Add(c.ttl)
+	} else {
+		delete(c.responses, key)
+	}
+	c.mu.Unlock()
+	close(resp.done)
+}
+
+// Middleware makes requests with an Idempotency-Key header safe to retry:
+// repeating the key replays the first response instead of running the
+// request again. Keys are scoped per path and API key, or client IP for
+// requests without one, so clients can't collide on a common key like "1".
+// Reusing a key with a different body is a client bug, answered with 422
+// rather than replaying a response to another request.
+func (c *idempotencyCache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+		if idempotencyKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key too long (maximum is %d characters)", maxIdempotencyKeyLength), errInvalidRequest)
+			return
+		}
+		scope := "ip:" + clientIP(r)
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			scope = "auth:" + auth
+		}
+		key := scope + "\x00" + r.URL.Path + "\x00" + idempotencyKey
+
+		// Read the body to compare it with the first request's. One over
+		// the largest the endpoints take is left to them to refuse.
+		limit := maxBatchSize * maxShortenBodySize()
+		body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Error reading request body", errInvalidRequest)
+			logRequest(r, http.StatusBadRequest, "idempotency", "Error reading request body", "error", err)
+			return
+		}
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		if int64(len(body)) > limit {
+			next.ServeHTTP(w, r)
+			return
+		}
+		bodyHash := sha256.Sum256(body)
+
+		for {
+			resp, first := c.acquire(key, bodyHash)
+			if resp.bodyHash != bodyHash {
+				writeJSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request body", errIdempotencyKeyReused)
+				logRequest(r, http.StatusUnprocessableEntity, "idempotency", "Idempotency-Key reused with a different body", "path", r.URL.Path)
+				return
+			}
+			if first {
+				rec := newResponseRecorder()
+				next.ServeHTTP(rec, r)
+				c.finish(key, resp, rec)
+				rec.writeTo(w)
+				return
+			}
+
+			// Same key in flight or done, wait for its response
+			select {
+			case <-resp.done:
+			case <-r.Context().Done():
+				return
+			}
+			if resp.kept {
+				maps.Copy(w.Header(), resp.header)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(resp.status)
+				w.Write(resp.body)
+				logRequest(r, resp.status, "idempotency", "Replayed response", "path", r.URL.Path)
+				return
+			}
+			// The first request failed and wasn't kept, run it ourselves
+		}
+	})
+}
+
+// responseRecorder buffers a response so it can be kept for replays
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (rec *responseRecorder) Header() http.Header { return rec.header }
+
+func (rec *responseRecorder) Write(b []byte) (int, error) { return rec.body.Write(b) }
+
+func (rec *responseRecorder) WriteHeader(status int) { rec.status = status }
+
+// writeTo sends the recorded response to w
+func (rec *responseRecorder) writeTo(w http.ResponseWriter) {
+	maps.Copy(w.Header(), rec.header)
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// idempotentShorten returns a handler shortening through an idempotency cache
+func idempotentShorten() http.Handler {
+	return newIdempotencyCache(time.Hour).Middleware(http.HandlerFunc(handleShorten))
+}
+
+// postWithKey sends a shorten body with an Idempotency-Key to handler
+func postWithKey(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	r := jsonRequest(http.MethodPost, "/shorten", body)
+	r.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	useMemoryStore(t)
+	handler := idempotentShorten()
+	body := `{"url": "https://golang.org/doc"}`
+
+	first := postWithKey(handler, "retry-1", body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first: status %d", first.Code)
+	}
+	replay := postWithKey(handler, "retry-1", body)
+	if replay.Code != first.Code || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay: %d %s, want %d %s", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay isn't marked Idempotent-Replayed")
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("first response is marked as replayed")
+	}
+	if n := storeSize(t); n != 1 {
+		t.Errorf("store has %d links, want 1", n)
+	}
+
+	// Another key is another request
+	other := postWithKey(handler, "retry-2", body)
+	if decodeJSON[ShortenResponse](t, other).Code == decodeJSON[ShortenResponse](t, first).Code {
+		t.Error("a new key replayed the first response")
+	}
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	useMemoryStore(t)
+	handler := idempotentShorten()
+
+	var wg sync.WaitGroup
+	responses := make([]string, 10)
+	for i := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = postWithKey(handler, "racing", `{"url": "https://golang.org/doc"}`).Body.String()
+		}()
+	}
+	wg.Wait()
+
+	for i, resp := range responses {
+		if resp != responses[0] {
+			t.Errorf("response %d = %s, want %s", i, resp, responses[0])
+		}
+	}
+	if n := storeSize(t); n != 1 {
+		t.Errorf("store has %d links, want 1", n)
+	}
+}
+
+func TestIdempotencyServerErrorsNotKept(t *testing.T) {
+	calls := 0
+	handler := newIdempotencyCache(time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			writeJSONError(w, http.StatusServiceUnavailable, "Try again", errUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	if rec := postWithKey(handler, "flaky", `{}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first: status %d", rec.Code)
+	}
+	if rec := postWithKey(handler, "flaky", `{}`); rec.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("retry: status %d after %d calls, want it run again", rec.Code, calls)
+	}
+}
+
+func TestIdempotencyKeyTooLong(t *testing.T) {
+	useMemoryStore(t)
+	rec := postWithKey(idempotentShorten(), strings.Repeat("k", maxIdempotencyKeyLength+1), `{"url": "https://golang.org/doc"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+	if n := storeSize(t); n != 0 {
+		t.Errorf("store has %d links, want none", n)
+	}
+}
+
+func TestIdempotencyKeyReusedWithAnotherBody(t *testing.T) {
+	useMemoryStore(t)
+	handler := idempotentShorten()
+	first := postWithKey(handler, "retry-1", `{"url": "https://golang.org/doc"}`)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first: status %d", first.Code)
+	}
+
+	rec := postWithKey(handler, "retry-1", `{"url": "https://golang.org/pkg"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("another body: status %d, want 422", rec.Code)
+	}
+	if got := decodeJSON[ErrorResponse](t, rec).Code; got != errIdempotencyKeyReused {
+		t.Errorf("error code = %q, want %q", got, errIdempotencyKeyReused)
+	}
+	if n := storeSize(t); n != 1 {
+		t.Errorf("store has %d links, want only the first", n)
+	}
+	// The key still replays for its own body
+	if replay := postWithKey(handler, "retry-1", `{"url": "https://golang.org/doc"}`); replay.Body.String() != first.Body.String() {
+		t.Errorf("replay after a mismatch: %d %s", replay.Code, replay.Body)
+	}
+}
+
+// Keys like "1" are common, clients without an API key mustn't get each
+// other's links for them
+func TestIdempotencyScopedPerClient(t *testing.T) {
+	useMemoryStore(t)
+	handler := idempotentShorten()
+	body := `{"url": "https://golang.org/doc"}`
+	post := func(ip, auth string) *httptest.ResponseRecorder {
+		r := jsonRequest(http.MethodPost, "/shorten", body)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("Idempotency-Key", "1")
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	alice := post("203.0.113.1", "")
+	bob := post("203.0.113.2", "")
+	if bob.Header().Get("Idempotent-Replayed") != "" || decodeJSON[ShortenResponse](t, bob).Code == decodeJSON[ShortenResponse](t, alice).Code {
+		t.Error("another anonymous client got the first one's response")
+	}
+	if replay := post("203.0.113.1", ""); replay.Body.String() != alice.Body.String() {
+		t.Errorf("same client's retry: %s, want %s", replay.Body, alice.Body)
+	}
+
+	// With an API key the key follows the client, wherever it retries from
+	withKey := post("203.0.113.1", "Bearer secret")
+	if withKey.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("an API key client got an anonymous client's response")
+	}
+	if replay := post("198.51.100.9", "Bearer secret"); replay.Body.String() != withKey.Body.String() {
+		t.Errorf("retry from another IP: %s, want %s", replay.Body, withKey.Body)
+	}
+	// An Authorization header can't pass for a client IP
+	if spoof := post("198.51.100.9", "203.0.113.1"); spoof.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("Authorization set to a client's IP replayed its response")
+	}
+}
+
+// Bodies over the limit aren't remembered, the handler still refuses them
+func TestIdempotencyBodyTooLarge(t *testing.T) {
+	useMemoryStore(t)
+	handler := idempotentShorten()
+	body := `{"url": "https://golang.org/` + strings.Repeat("a", int(maxBatchSize*maxShortenBodySize())) + `"}`
+	for range 2 {
+		rec := postWithKey(handler, "big", body)
+		if rec.Code != http.StatusRequestEntityTooLarge || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("status %d, replayed %q; want a 413 from the handler", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+		}
+	}
+}
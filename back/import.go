@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum number of links accepted in one import request
+const maxImportRows = 10000
+
+// ImportRow is one link to restore, in the columns of the CSV export
+type ImportRow struct {
+	Code      string    `json:"code"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Clicks    int64     `json:"clicks"`
+}
+
+// Result structure for one row of an import. Status is "created",
+// "skipped" (code taken, with on_conflict=skip), "overwritten" or
+// "error", in which case Error and ErrorCode say why.
+type ImportResult struct {
+	Row       int    `json:"row"` // 1-based, not counting the CSV header
+	Code      string `json:"code"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// Response structure for POST /import
+type ImportResponse struct {
+	Created     int            `json:"created"`
+	Skipped     int            `json:"skipped"`
+	Overwritten int            `json:"overwritten"`
+	Failed      int            `json:"failed"`
+	Results     []ImportResult `json:"results"`
+}
+
+// fail marks the result as failed with the given message and error code
+func (r *ImportResult) fail(message, code string) {
+	r.Status = "error"
+	r.Error = message
+	r.ErrorCode = code
+}
+
+// importRowsFromCSV reads rows with a header line naming the columns, like
+// the export writes them. Only code and url are required. A malformed row
+// is returned as an error in rowErrs instead of failing the whole file.
+func importRowsFromCSV(body io.Reader) (rows []ImportRow, rowErrs map[int]string, err error) {
+	in := csv.NewReader(body)
+	in.FieldsPerRecord = -1 // Checked per row below
+	header, err := in.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"code", "url"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("CSV header has no %q column", required)
+		}
+	}
+
+	rowErrs = make(map[int]string)
+	for {
+		record, err := in.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		rows = append(rows, ImportRow{})
+		row := &rows[len(rows)-1]
+		if err != nil {
+			rowErrs[len(rows)] = "Malformed CSV row"
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row.Code, row.URL = field("code"), field("url")
+		if len(record) != len(header) {
+			rowErrs[len(rows)] = fmt.Sprintf("Expected %d columns, got %d", len(header), len(record))
+			continue
+		}
+		if raw := field("created_at"); raw != "" {
+			if row.CreatedAt, err = time.Parse(time.RFC3339, raw); err != nil {
+				rowErrs[len(rows)] = "Invalid created_at (expected RFC 3339)"
+				continue
+			}
+		}
+		if raw := field("expires_at"); raw != "" {
+			if row.ExpiresAt, err = time.Parse(time.RFC3339, raw); err != nil {
+				rowErrs[len(rows)] = "Invalid expires_at (expected RFC 3339)"
+				continue
+			}
+		}
+		if raw := field("clicks"); raw != "" {
+			if row.Clicks, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				rowErrs[len(rows)] = "Invalid clicks (expected a number)"
+				continue
+			}
+		}
+	}
+	return rows, rowErrs, nil
+}
+
+// importedLink validates row, to be stored under key, and turns it into
+// the link to store. URLs are only checked for their shape: the URL
+// checkers and short URL expansion can go over the network, which
+// thousands of rows in one request can't wait for, and the links were
+// vetted when they were first shortened.
+func importedLink(r *http.Request, row ImportRow, key string) (Link, *shortenError) {
+	// Codes are either custom aliases or generated ones, which can be a
+	// single character with CODE_STRATEGY=sequential. Reserved codes are
+	// refused either way. Tenant links are exported as "tenant:code".
+	tenant, code := splitKey(key)
+	if (tenant == "" && strings.Contains(key, ":")) || (!validCustomAlias(code) && !isGeneratedCode(code)) {
+		return Link{}, &shortenError{http.StatusBadRequest, "Invalid code (expected a custom alias or a generated code)", errInvalidAlias}
+	}
+	if isReservedCode(code) {
+		return Link{}, &shortenError{http.StatusConflict, "Code is reserved", errConflict}
+	}
+	if tenant != "" && !tenants[tenant] {
+		return Link{}, &shortenError{http.StatusBadRequest, "Unknown tenant", errInvalidRequest}
+	}
+
+	parsed, serr := parseLongURL(row.URL)
+	if serr != nil {
+		return Link{}, serr
+	}
+	if isSelfHost(r, parsed.Hostname()) {
+		return Link{}, &shortenError{http.StatusBadRequest, "URL points to this shortener, shortening short links is not allowed", errInvalidURL}
+	}
+	longURL := row.URL
+	if normalizeURLs {
+		longURL = normalizeURL(parsed, stripTracking)
+	}
+
+	link := Link{URL: longURL, ExpiresAt: row.ExpiresAt, Clicks: row.Clicks, CreatedAt: time.Now().Truncate(time.Second),
+		CreatedBy: requestCreator(r), CreatorIP: clientIP(r)}
+	if !row.CreatedAt.IsZero() {
+		link.CreatedAt = row.CreatedAt.Truncate(time.Second)
+	}
+	return link, nil
+}
+
+// handleImport restores links from a CSV export or a JSON array of
+// ImportRow, picked by Content-Type. Every URL and code is validated, see
+// importedLink. Taken codes are skipped, or replaced with ?on_conflict=overwrite
+// (keeping the existing link's creation time). Admin only.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+
+	overwrite := false
+	switch onConflict := r.URL.Query().Get("on_conflict"); onConflict {
+	case "", "skip":
+	case "overwrite":
+		overwrite = true
+	default:
+		writeJSONError(w, http.StatusBadRequest, "Invalid on_conflict (expected skip or overwrite)", errInvalidRequest)
+		return
+	}
+
+	// Enough for a full import of maximum length URLs
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportRows*maxShortenBodySize())
+	defer r.Body.Close()
+	var rows []ImportRow
+	rowErrs := map[int]string{}
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		rows, rowErrs, err = importRowsFromCSV(r.Body)
+	case "application/json":
+		err = json.NewDecoder(r.Body).Decode(&rows)
+	default:
+		writeJSONError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Type (expected text/csv or application/json)", errUnsupportedMediaType)
+		return
+	}
+	if bodyTooLarge(err) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large", errTooLarge)
+		logRequest(r, http.StatusRequestEntityTooLarge, "import", "Request body too large", "error", err)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid import file: "+err.Error(), errInvalidRequest)
+		logRequest(r, http.StatusBadRequest, "import", "Error decoding import", "error", err)
+		return
+	}
+	if len(rows) > maxImportRows {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Too many links in import (maximum is %d)", maxImportRows), errTooLarge)
+		logRequest(r, http.StatusRequestEntityTooLarge, "import", "Import too large", "size", len(rows))
+		return
+	}
+
+	resp := ImportResponse{Results: make([]ImportResult, len(rows))}
+	var entries []Entry
+	var entryRows []int // Index in rows of each entry
+	for i, row := range rows {
+		result := &resp.Results[i]
+		result.Row, result.Code = i+1, canonicalCode(row.Code)
+		if msg, bad := rowErrs[i+1]; bad {
+			result.fail(msg, errInvalidRequest)
+			continue
+		}
+		if result.Code == "" {
+			result.fail("Code cannot be empty", errInvalidAlias)
+			continue
+		}
+		if row.Clicks < 0 {
+			result.fail("clicks can't be negative", errInvalidRequest)
+			continue
+		}
+
+		link, serr := importedLink(r, row, result.Code)
+		if serr != nil {
+			result.fail(serr.message, serr.code)
+			continue
+		}
+		entries = append(entries, Entry{Code: result.Code, Link: link})
+		entryRows = append(entryRows, i)
+	}
+
+	// One SaveMany for all rows, so the store is locked (or the
+	// transaction opened) once. Only conflicts to overwrite need more.
+	for j, err := range saveEntries(r.Context(), entries) {
+		result, entry := &resp.Results[entryRows[j]], entries[j]
+		switch {
+		case err == nil:
+			result.Status = "created"
+		case errors.Is(err, ErrStoreFull):
+			result.fail("Storage is full, no more links can be created", errStorageFull)
+		case errors.Is(err, ErrCodeExists) && !overwrite:
+			result.Status = "skipped"
+		case errors.Is(err, ErrCodeExists):
+			_, err := store.Update(r.Context(), entry.Code, func(link *Link) error {
+				*link = entry.Link
+				return nil
+			})
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error overwriting link", "event", "import", "code", entry.Code, "error", err)
+				result.fail("Error overwriting link", errUnavailable)
+				continue
+			}
+			result.Status = "overwritten"
+		default:
+			slog.ErrorContext(r.Context(), "Error saving link", "event", "import", "code", entry.Code, "error", err)
+			result.fail("Error saving link", errUnavailable)
+		}
+	}
+
+	for _, result := range resp.Results {
+		switch result.Status {
+		case "created":
+			resp.Created++
+		case "skipped":
+			resp.Skipped++
+		case "overwritten":
+			resp.Overwritten++
+		default:
+			resp.Failed++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "import", "Error encoding response", "error", err)
+		return
+	}
+	logRequest(r, http.StatusOK, "import", "Imported links", "created", resp.Created, "skipped", resp.Skipped,
+		"overwritten", resp.Overwritten, "failed", resp.Failed)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postImport sends body to POST /import?query with the given Content-Type
+func postImport(t *testing.T, query, contentType, body string) ImportResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/import?"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	handleImport(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	return decodeJSON[ImportResponse](t, rec)
+}
+
+// importStatuses returns the status of each result, in row order
+func importStatuses(resp ImportResponse) []string {
+	statuses := make([]string, len(resp.Results))
+	for i, result := range resp.Results {
+		statuses[i] = result.Status
+	}
+	return statuses
+}
+
+func TestImportCSV(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "taken", Link{URL: "https://golang.org/original"})
+
+	csvBody := "code,url,created_at,expires_at,clicks\n" +
+		"new1,https://golang.org/a,2024-05-06T07:08:09Z,,12\n" +
+		"taken,https://golang.org/replacement,,,0\n" +
+		"bad1,ftp://golang.org,,,0\n" +
+		"bad2,https://golang.org/b,yesterday,,0\n" +
+		"bad3,https://golang.org/c,,,lots\n" +
+		"bad4,https://golang.org/d\n" +
+		",https://golang.org/e,,,0\n" +
+		"admin,https://golang.org/f,,,0\n"
+	resp := postImport(t, "", "text/csv", csvBody)
+	want := []string{"created", "skipped", "error", "error", "error", "error", "error", "error"}
+	if got := importStatuses(resp); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("statuses = %q, want %q", got, want)
+	}
+	if resp.Created != 1 || resp.Skipped != 1 || resp.Overwritten != 0 || resp.Failed != 6 {
+		t.Errorf("counts = %d created, %d skipped, %d overwritten, %d failed", resp.Created, resp.Skipped, resp.Overwritten, resp.Failed)
+	}
+	for _, result := range resp.Results {
+		if result.Status == "error" && (result.Error == "" || result.ErrorCode == "") {
+			t.Errorf("row %d: failed without a reason: %+v", result.Row, result)
+		}
+	}
+
+	link, err := store.Lookup(t.Context(), "new1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.URL != "https://golang.org/a" || link.Clicks != 12 || !link.CreatedAt.Equal(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)) {
+		t.Errorf("imported link = %+v", link)
+	}
+	// Skipped, so the original is kept
+	if link, _ := store.Lookup(t.Context(), "taken"); link.URL != "https://golang.org/original" {
+		t.Errorf("taken code now points at %q", link.URL)
+	}
+}
+
+func TestImportJSONOverwrite(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "taken", Link{URL: "https://golang.org/original", Clicks: 3})
+
+	resp := postImport(t, "on_conflict=overwrite", "application/json",
+		`[{"code": "taken", "url": "https://golang.org/replacement", "clicks": 9}, {"code": "fresh", "url": "https://go.dev"}]`)
+	if got := importStatuses(resp); strings.Join(got, " ") != "overwritten created" {
+		t.Errorf("statuses = %q", got)
+	}
+	if link, _ := store.Lookup(t.Context(), "taken"); link.URL != "https://golang.org/replacement" || link.Clicks != 9 {
+		t.Errorf("overwritten link = %+v", link)
+	}
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "one", Link{URL: "https://golang.org/one", CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Clicks: 5})
+	saveTestLink(t, "two", Link{URL: "https://golang.org/two", CreatedAt: time.Date(2024, 6, 7, 8, 9, 10, 0, time.UTC), ExpiresAt: time.Now().Add(time.Hour).Truncate(time.Second)})
+	rec := httptest.NewRecorder()
+	handleExportCSV(rec, httptest.NewRequest(http.MethodGet, "/export.csv", nil))
+	exported := rec.Body.String()
+
+	useMemoryStore(t)
+	if resp := postImport(t, "", "text/csv; charset=utf-8", exported); resp.Created != 2 {
+		t.Fatalf("reimported %d links: %+v", resp.Created, resp.Results)
+	}
+	rec = httptest.NewRecorder()
+	handleExportCSV(rec, httptest.NewRequest(http.MethodGet, "/export.csv", nil))
+	if rec.Body.String() != exported {
+		t.Errorf("export after reimport differs:\n%s\nwant:\n%s", rec.Body, exported)
+	}
+}
+
+// Sequential codes can be one character, shorter than any custom alias
+func TestImportSequentialRoundTrip(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &codeGenerator, CodeGenerator(SequentialBase62Generator{}))
+	var codes []string
+	for _, path := range []string{"one", "two", "three"} {
+		codes = append(codes, shortenOK(t, `{"url": "https://golang.org/`+path+`"}`).Code)
+	}
+	if codes[0] != "b" {
+		t.Fatalf("first sequential code %q, want b", codes[0])
+	}
+	rec := httptest.NewRecorder()
+	handleExportCSV(rec, httptest.NewRequest(http.MethodGet, "/export.csv", nil))
+
+	useMemoryStore(t)
+	if resp := postImport(t, "", "text/csv", rec.Body.String()); resp.Created != 3 {
+		t.Fatalf("reimported %d links: %+v", resp.Created, resp.Results)
+	}
+	for _, code := range codes {
+		if _, err := store.Lookup(t.Context(), code); err != nil {
+			t.Errorf("Lookup(%q) after import: %v", code, err)
+		}
+	}
+}
+
+func TestImportCodes(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &tenants, map[string]bool{"acme": true})
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"b", "created"},
+		{"Zz9", "created"},
+		{"go-doc", "created"},
+		{"acme:x", "created"},
+		{"admin", "error"}, // Reserved
+		{"acme:x:y", "error"},
+		{":abc", "error"},
+		{"no spaces", "error"},
+		{"other:abc", "error"}, // Unknown tenant
+	}
+	var rows []string
+	for _, tt := range tests {
+		rows = append(rows, `{"code": "`+tt.code+`", "url": "https://golang.org/doc"}`)
+	}
+	resp := postImport(t, "", "application/json", "["+strings.Join(rows, ",")+"]")
+	for i, tt := range tests {
+		if got := resp.Results[i].Status; got != tt.want {
+			t.Errorf("%q: status %s, want %s (%s)", tt.code, got, tt.want, resp.Results[i].Error)
+		}
+	}
+}
+
+// Rows are only checked for their shape, so a big import doesn't wait on
+// URL checkers and short URL expansion for every row
+func TestImportSkipsNetworkChecks(t *testing.T) {
+	useMemoryStore(t)
+	chain, requests := redirectChain(t)
+	setForTest(t, &expandShortURLs, true)
+	setForTest(t, &urlChecker, URLChecker(failingChecker{}))
+
+	resp := postImport(t, "", "application/json", `[{"code": "hops", "url": "`+chain.URL+`/hop1"}, {"code": "plain", "url": "https://golang.org/doc"}]`)
+	if resp.Created != 2 {
+		t.Fatalf("created %d links: %+v", resp.Created, resp.Results)
+	}
+	if link, _ := store.Lookup(t.Context(), "hops"); link.URL != chain.URL+"/hop1" {
+		t.Errorf("imported URL = %q, want it as exported", link.URL)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("short URL fetched %d times during import", n)
+	}
+}
+
+func TestImportRejectsFile(t *testing.T) {
+	useMemoryStore(t)
+	tests := []struct {
+		name, query, contentType, body string
+		want                           int
+	}{
+		{"bad on_conflict", "on_conflict=merge", "text/csv", "code,url\n", http.StatusBadRequest},
+		{"no url column", "", "text/csv", "code,destination\nabc,https://golang.org\n", http.StatusBadRequest},
+		{"empty CSV", "", "text/csv", "", http.StatusBadRequest},
+		{"malformed JSON", "", "application/json", `[{"code": "abc"`, http.StatusBadRequest},
+		{"other content type", "", "text/plain", "code,url\n", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/import?"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			handleImport(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+	if got := storeSize(t); got != 0 {
+		t.Errorf("%d links saved from rejected files", got)
+	}
+}
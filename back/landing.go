@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// What GET / shows, from LANDING_PAGE: an HTML file served as is, or an
+// http(s) URL (e.g. the frontend) to redirect to. With neither, / is a
+// 404 like any unknown code.
+var (
+	landingPage []byte
+	landingURL  string
+)
+
+// loadLandingPage sets up LANDING_PAGE, either a URL or a file path
+func loadLandingPage(value string) error {
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		if u, err := url.Parse(value); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid LANDING_PAGE URL %q", value)
+		}
+		landingURL = value
+		return nil
+	}
+	page, err := os.ReadFile(value)
+	if err != nil {
+		return fmt.Errorf("loading LANDING_PAGE: %w", err)
+	}
+	landingPage = page
+	return nil
+}
+
+// serveLanding answers a request for exactly "/"
+func serveLanding(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case landingURL != "":
+		http.Redirect(w, r, landingURL, http.StatusFound)
+	case landingPage != nil:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(landingPage)
+	default:
+		serveNotFound(w, r, "")
+	}
+}
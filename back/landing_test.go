@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLandingPage(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc"})
+	setForTest(t, &landingPage, nil)
+	setForTest(t, &landingURL, "")
+
+	// Unset, / is a 404 like an unknown code
+	if rec := getRedirect("/"); rec.Code != http.StatusNotFound {
+		t.Errorf("no landing page: status = %d, want 404", rec.Code)
+	}
+
+	path := filepath.Join(t.TempDir(), "landing.html")
+	if err := os.WriteFile(path, []byte("<h1>Welcome</h1>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadLandingPage(path); err != nil {
+		t.Fatal(err)
+	}
+	rec := getRedirect("/")
+	if rec.Code != http.StatusOK || rec.Body.String() != "<h1>Welcome</h1>" || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("landing file: status %d, Content-Type %q, body %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	// Codes are unaffected
+	if rec := getRedirect("/abc123"); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://golang.org/doc" {
+		t.Errorf("code with a landing page: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	setForTest(t, &landingPage, nil)
+	if err := loadLandingPage("https://app.golang.org/"); err != nil {
+		t.Fatal(err)
+	}
+	rec = getRedirect("/")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://app.golang.org/" {
+		t.Errorf("landing URL: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := getRedirect("/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown code with a landing URL: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestLoadLandingPageErrors(t *testing.T) {
+	setForTest(t, &landingPage, nil)
+	setForTest(t, &landingURL, "")
+	for _, value := range []string{"https://", filepath.Join(t.TempDir(), "missing.html")} {
+		if err := loadLandingPage(value); err == nil {
+			t.Errorf("loadLandingPage(%q) accepted it", value)
+		}
+	}
+}
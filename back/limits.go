@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// errLinkUsed means a one-time link was claimed by an earlier visit, or a
+// limited link ran out of clicks
+var errLinkUsed = errors.New("link has no visits left")
+
+// limitedVisits reports whether visits to link are budgeted (one-time or
+// max_clicks) and so must go through claimLimitedVisit
+func limitedVisits(link Link) bool {
+	return link.OneTime || link.MaxClicks > 0
+}
+
+// visitsUsedUp reports whether an expired link expired because its visits ran out
+func visitsUsedUp(link Link) bool {
+	return link.OneTime || (link.MaxClicks > 0 && link.Clicks >= link.MaxClicks)
+}
+
+// claimLimitedVisit counts a visit to a one-time or max_clicks link before
+// it's followed. The visit that uses up the budget expires the link on the
+// spot rather than removing it, so later visits get 410 until the sweeper
+// deletes it. Update is atomic, so simultaneous visits can't overshoot: only
+// as many as are left find the link still open. It reports whether this
+// visit may go on, with the updated link (expired once used up, so caches
+// don't keep the redirect); otherwise the response has been written.
+func claimLimitedVisit(w http.ResponseWriter, r *http.Request, event, code string) (Link, bool) {
+	now := time.Now()
+	link, err := store.Update(r.Context(), code, func(link *Link) error {
+		if link.Disabled || link.Expired(now) {
+			return errLinkUsed
+		}
+		link.Clicks++
+		if visitsUsedUp(*link) {
+			link.ExpiresAt = now
+		}
+		return nil
+	})
+	if errors.Is(err, errLinkUsed) || errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusGone, "Short URL has no visits left", errExpired)
+		logRequest(r, http.StatusGone, event, "Short code has no visits left", "code", code)
+		return Link{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error counting limited visit", "event", event, "code", code, "error", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		return Link{}, false
+	}
+	return link, true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// concurrentRedirects sends n simultaneous visits to path and counts the
+// responses by status
+func concurrentRedirects(path string, n int) map[int]int {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	statuses := make(map[int]int)
+	start := make(chan struct{})
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			rec := getRedirect(path)
+			mu.Lock()
+			statuses[rec.Code]++
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return statuses
+}
+
+func TestOneTimeLink(t *testing.T) {
+	useMemoryStore(t)
+	code := shortenOK(t, `{"url": "https://golang.org/doc", "one_time": true}`).Code
+
+	rec := getRedirect("/" + code)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://golang.org/doc" {
+		t.Fatalf("first visit: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("first visit: Cache-Control = %q, must not be cached", got)
+	}
+	for range 3 {
+		rec := getRedirect("/" + code)
+		if rec.Code != http.StatusGone {
+			t.Fatalf("later visit: status = %d, want 410", rec.Code)
+		}
+		if got := decodeJSON[ErrorResponse](t, rec).Code; got != errExpired {
+			t.Errorf("later visit: code = %q, want %q", got, errExpired)
+		}
+	}
+}
+
+func TestOneTimeLinkConcurrent(t *testing.T) {
+	useMemoryStore(t)
+	code := shortenOK(t, `{"url": "https://golang.org/doc", "one_time": true}`).Code
+
+	statuses := concurrentRedirects("/"+code, 50)
+	if statuses[http.StatusFound] != 1 || statuses[http.StatusGone] != 49 {
+		t.Errorf("statuses = %v, want one 302 and 49 410s", statuses)
+	}
+}
+
+func TestMaxClicks(t *testing.T) {
+	useMemoryStore(t)
+	code := shortenOK(t, `{"url": "https://golang.org/doc", "max_clicks": 3}`).Code
+
+	for i := range 3 {
+		if rec := getRedirect("/" + code); rec.Code != http.StatusFound {
+			t.Fatalf("visit %d: status = %d, want 302", i+1, rec.Code)
+		}
+	}
+	link, err := store.Lookup(t.Context(), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Clicks != 3 || link.ExpiresAt.IsZero() {
+		t.Errorf("after the budget: clicks %d, expires_at %v; want 3 and expired", link.Clicks, link.ExpiresAt)
+	}
+	if rec := getRedirect("/" + code); rec.Code != http.StatusGone {
+		t.Errorf("visit 4: status = %d, want 410", rec.Code)
+	}
+	if link, _ := store.Lookup(t.Context(), code); link.Clicks != 3 {
+		t.Errorf("clicks = %d after the cutoff, want 3", link.Clicks)
+	}
+}
+
+func TestMaxClicksConcurrent(t *testing.T) {
+	useMemoryStore(t)
+	code := shortenOK(t, `{"url": "https://golang.org/doc", "max_clicks": 10}`).Code
+
+	statuses := concurrentRedirects("/"+code, 40)
+	if statuses[http.StatusFound] != 10 || statuses[http.StatusGone] != 30 {
+		t.Errorf("statuses = %v, want ten 302s and 30 410s", statuses)
+	}
+	if link, _ := store.Lookup(t.Context(), code); link.Clicks != 10 {
+		t.Errorf("clicks = %d, want exactly 10", link.Clicks)
+	}
+}
+
+func TestMaxClicksValidation(t *testing.T) {
+	useMemoryStore(t)
+	if rec := postShorten(t, `{"url": "https://golang.org/doc", "max_clicks": -1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative max_clicks: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Bounds on a destination check: the whole check including redirects,
+// and how many redirects are followed
+const (
+	linkCheckTimeout      = 10 * time.Second
+	linkCheckMaxRedirects = 5
+	linkCheckWorkers      = 4 // Destinations checked at once by the background checker
+)
+
+// errInternalDestination is returned when a check would connect to an internal address
+var errInternalDestination = errors.New("destination is a private address")
+
+// refuseInternalAddr is the dialer's Control hook for linkCheckClient. It
+// runs on the resolved address of every connection, redirects included, so
+// a public name pointing inside can't be used to probe internal services.
+func refuseInternalAddr(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || internalAddr(ip) {
+		return errInternalDestination
+	}
+	return nil
+}
+
+// linkCheckClient fetches destinations for health checks. These are
+// requests the server makes on its own, so internal addresses are refused
+// whether or not BLOCK_PRIVATE_HOSTS is on.
+var linkCheckClient = &http.Client{
+	Timeout: linkCheckTimeout,
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 5 * time.Second, Control: refuseInternalAddr}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost:   1,
+	},
+	CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+		if len(via) >= linkCheckMaxRedirects {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+// fetchStatus makes one request to url and returns the final status code
+func fetchStatus(ctx context.Context, method, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "url-shortener-link-check")
+	resp, err := linkCheckClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Read a little so the connection can be reused, but never a whole download
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// checkDestination returns the status a destination answers with. HEAD
+// is tried first, GET if the server doesn't support HEAD.
+func checkDestination(ctx context.Context, url string) (int, error) {
+	status, err := fetchStatus(ctx, http.MethodHead, url)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = fetchStatus(ctx, http.MethodGet, url)
+	}
+	return status, err
+}
+
+// checkLinkHealth checks the destination of the link stored under code
+// and records the result with it. A destination that can't be reached is
+// recorded with status 0; only store errors are returned.
+func checkLinkHealth(ctx context.Context, code string, link Link) (Link, error) {
+	status, err := checkDestination(ctx, link.URL)
+	if err != nil {
+		slog.InfoContext(ctx, "Destination unreachable", "event", "link_check", "code", code, "url", link.URL, "error", err)
+	}
+	checkedAt := time.Now().Truncate(time.Second)
+	return store.Update(ctx, code, func(link *Link) error {
+		link.CheckStatus, link.CheckedAt = status, checkedAt
+		return nil
+	})
+}
+
+// LinkHealth is the last destination check of a link, in stats and link listings
+type LinkHealth struct {
+	Status    int       `json:"status"` // 0 if the destination couldn't be reached
+	Alive     bool      `json:"alive"`  // Status is 2xx or 3xx
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// newLinkHealth describes the last check of link, nil if it was never checked
+func newLinkHealth(link Link) *LinkHealth {
+	if link.CheckedAt.IsZero() {
+		return nil
+	}
+	return &LinkHealth{
+		Status:    link.CheckStatus,
+		Alive:     link.CheckStatus >= 200 && link.CheckStatus < 400,
+		CheckedAt: link.CheckedAt,
+	}
+}
+
+// handleCheckLink checks a link's destination right away and returns the
+// link with the result. Admin only.
+func handleCheckLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+
+	shortCode := canonicalCode(r.PathValue("code"))
+	link, err := lookupLink(r.Context(), shortCode)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		logRequest(r, http.StatusNotFound, "link_check", "Short code not found", "code", shortCode)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "link_check", "Error looking up short code", "code", shortCode, "error", err)
+		return
+	}
+
+	link, err = checkLinkHealth(r.Context(), shortCode, link)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error saving check result", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "link_check", "Error saving check result", "code", shortCode, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newLinkInfo(shortCode, link)); err != nil {
+		logRequest(r, http.StatusInternalServerError, "link_check", "Error encoding response", "error", err)
+		return
+	}
+	logRequest(r, http.StatusOK, "link_check", "Checked destination", "code", shortCode, "check_status", link.CheckStatus)
+}
+
+// Links are fetched in pages of this size by the background checker
+const linkCheckPageSize = 500
+
+// checkLinksPeriodically re-checks every active link whose last check is
+// older than interval, with a few checks in flight at a time. It returns
+// when ctx is cancelled.
+func checkLinksPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checked := 0
+		slots := make(chan struct{}, linkCheckWorkers)
+		var wg sync.WaitGroup
+		for offset := 0; ctx.Err() == nil; offset += linkCheckPageSize {
+			entries, _, err := store.List(ctx, ListOptions{Offset: offset, Limit: linkCheckPageSize})
+			if err != nil {
+				slog.Error("Error listing links to check", "event", "link_check", "error", err)
+				break
+			}
+			now := time.Now()
+			for _, entry := range entries {
+				link := entry.Link
+				if link.Disabled || link.Expired(now) || now.Sub(link.CheckedAt) < interval {
+					continue
+				}
+				slots <- struct{}{}
+				wg.Add(1)
+				checked++
+				go func() {
+					defer func() { <-slots; wg.Done() }()
+					if _, err := checkLinkHealth(ctx, entry.Code, link); err != nil && !errors.Is(err, ErrNotFound) {
+						slog.Error("Error saving check result", "event", "link_check", "code", entry.Code, "error", err)
+					}
+				}()
+			}
+			if len(entries) < linkCheckPageSize {
+				break
+			}
+		}
+		wg.Wait()
+		if checked > 0 {
+			slog.Info("Checked link destinations", "event", "link_check", "checked", checked)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// checkLink sends POST /links/{code}/check to handleCheckLink
+func checkLink(t *testing.T, code string) LinkInfo {
+	t.Helper()
+	rec := serve("/links/{code}/check", handleCheckLink, httptest.NewRequest(http.MethodPost, "/links/"+code+"/check", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("check %s: status = %d: %s", code, rec.Code, rec.Body)
+	}
+	return decodeJSON[LinkInfo](t, rec)
+}
+
+func TestCheckLink(t *testing.T) {
+	useMemoryStore(t)
+	var mu sync.Mutex
+	var methods []string
+	destination := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/ok":
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer destination.Close()
+	// The destination is on localhost, which the real client refuses
+	setForTest(t, &linkCheckClient, destination.Client())
+
+	tests := []struct {
+		path   string
+		status int
+		alive  bool
+	}{
+		{"/ok", http.StatusOK, true},
+		{"/no-head", http.StatusOK, true},
+		{"/moved", http.StatusOK, true},
+		{"/gone", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		code := "c" + tt.path[1:]
+		saveTestLink(t, code, Link{URL: destination.URL + tt.path})
+		info := checkLink(t, code)
+		if info.Health == nil || info.Health.Status != tt.status || info.Health.Alive != tt.alive || info.Health.CheckedAt.IsZero() {
+			t.Errorf("%s: health = %+v, want status %d alive %v", tt.path, info.Health, tt.status, tt.alive)
+		}
+		// Recorded with the link, and shown in its stats
+		stats := decodeJSON[StatsResponse](t, getStats("/stats/"+code, nil))
+		if stats.Health == nil || stats.Health.Status != tt.status {
+			t.Errorf("%s: stats health = %+v", tt.path, stats.Health)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if methods[0] != "HEAD /ok" || methods[1] != "HEAD /no-head" || methods[2] != "GET /no-head" {
+		t.Errorf("requests = %q, want HEAD first and GET after a 405", methods)
+	}
+}
+
+func TestCheckLinkRefusesInternal(t *testing.T) {
+	useMemoryStore(t)
+	var hit atomic.Bool
+	destination := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { hit.Store(true) }))
+	defer destination.Close()
+	saveTestLink(t, "inside", Link{URL: destination.URL})
+
+	info := checkLink(t, "inside")
+	if hit.Load() {
+		t.Error("the check connected to a loopback address")
+	}
+	if info.Health == nil || info.Health.Status != 0 || info.Health.Alive {
+		t.Errorf("health = %+v, want unreachable", info.Health)
+	}
+}
+
+func TestCheckLinkErrors(t *testing.T) {
+	useMemoryStore(t)
+	if rec := serve("/links/{code}/check", handleCheckLink, httptest.NewRequest(http.MethodPost, "/links/missing/check", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("missing code: status = %d, want 404", rec.Code)
+	}
+	if rec := serve("/links/{code}/check", handleCheckLink, httptest.NewRequest(http.MethodGet, "/links/missing/check", nil)); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want 405", rec.Code)
+	}
+	// Never checked, no health to show
+	saveTestLink(t, "fresh", Link{URL: "https://golang.org"})
+	if health := decodeJSON[StatsResponse](t, getStats("/stats/fresh", nil)).Health; health != nil {
+		t.Errorf("unchecked link: health = %+v", health)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Page size bounds for GET /links
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
+// One link in the list response
+type LinkInfo struct {
+	Code       string            `json:"code"`
+	URL        string            `json:"url"`
+	CreatedAt  time.Time         `json:"created_at,omitzero"`
+	Clicks     int64             `json:"clicks"`
+	Enabled    bool              `json:"enabled"`
+	Protected  bool              `json:"protected"` // Has a password
+	OneTime    bool              `json:"one_time"`
+	MaxClicks  int64             `json:"max_clicks,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	UTM        map[string]string `json:"utm,omitempty"`        // Defaults added on redirect, by parameter name
+	Health     *LinkHealth       `json:"health,omitempty"`     // Left out until the destination is checked
+	CreatedBy  string            `json:"created_by,omitempty"` // API key fingerprint or "anonymous", see requestCreator
+	CreatorIP  string            `json:"creator_ip,omitempty"`
+	Geo        map[string]string `json:"geo,omitempty"`         // Destinations by visitor country
+	Devices    map[string]string `json:"devices,omitempty"`     // Destinations by device platform
+	Favicon    string            `json:"favicon,omitempty"`     // Path of the destination's icon, once it's been fetched
+	StatsToken string            `json:"stats_token,omitempty"` // For /stats/{code}?token=, with STATS_SECRET set
+	// Seconds of countdown page before redirecting, left out for REDIRECT_DELAY
+	RedirectDelay int    `json:"redirect_delay,omitempty"`
+	Campaign      string `json:"campaign,omitempty"`
+}
+
+// newLinkInfo describes a stored link for the admin endpoints
+func newLinkInfo(code string, link Link) LinkInfo {
+	info := LinkInfo{
+		Code:      code,
+		URL:       link.URL,
+		CreatedAt: link.CreatedAt,
+		Clicks:    link.Clicks,
+		Enabled:   !link.Disabled,
+		Protected: link.PasswordHash != "",
+		OneTime:   link.OneTime,
+		MaxClicks: link.MaxClicks,
+		Tags:      link.Tags,
+		Health:    newLinkHealth(link),
+		CreatedBy: link.CreatedBy,
+		CreatorIP: link.CreatorIP,
+		Geo:       link.Geo,
+		Devices:   link.Devices,
+		Favicon:   faviconPath(code, link),
+	}
+	info.RedirectDelay = int(link.RedirectDelay / time.Second)
+	info.Campaign = link.Campaign
+	if statsSecret != nil {
+		info.StatsToken = statsToken(code)
+	}
+	if len(link.UTM) > 0 {
+		info.UTM = make(map[string]string, len(link.UTM))
+		for name := range link.UTM {
+			info.UTM[name] = link.UTM.Get(name)
+		}
+	}
+	return info
+}
+
+// Request structure for PATCH /links/{code}. Fields left out stay as they are.
+type UpdateLinkRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// Request structure for PUT /links/{code}
+type ReplaceLinkRequest struct {
+	URL string `json:"url"`
+}
+
+// Response structure for GET /links
+type LinkListResponse struct {
+	Links      []LinkInfo `json:"links"`
+	Total      int        `json:"total"`
+	Limit      int        `json:"limit"`
+	Offset     int        `json:"offset"`
+	NextCursor string     `json:"next_cursor,omitempty"` // Pass as ?cursor= for the next page, left out on the last one
+}
+
+// A list cursor is the last code of a page, encoded so clients treat it
+// as opaque rather than building their own
+func encodeListCursor(code string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(code))
+}
+
+func decodeListCursor(cursor string) (string, bool) {
+	code, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(code) == 0 {
+		return "", false
+	}
+	return string(code), true
+}
+
+// handleListLinks returns a page of all stored links (or those with
+// ?tag=), ordered by code. Pages are picked by ?offset= or, to iterate
+// without skips or repeats while links come and go, by ?cursor= from the
+// previous page's next_cursor.
+// It exposes every link, so it must be wrapped with requireAPIKey.
+func handleListLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	opts := ListOptions{Limit: defaultListLimit}
+	query := r.URL.Query()
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid limit (must be a positive number)", errInvalidRequest)
+			return
+		}
+		opts.Limit = min(limit, maxListLimit)
+	}
+	if raw := query.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid offset (must be zero or more)", errInvalidRequest)
+			return
+		}
+		opts.Offset = offset
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		after, ok := decodeListCursor(raw)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "Invalid cursor", errInvalidRequest)
+			return
+		}
+		if opts.Offset != 0 {
+			writeJSONError(w, http.StatusBadRequest, "Use either cursor or offset, not both", errInvalidRequest)
+			return
+		}
+		opts.After = after
+	}
+	if raw := query.Get("tag"); raw != "" {
+		tag, err := normalizeTag(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid tag", errInvalidRequest)
+			return
+		}
+		opts.Tag = tag
+	}
+	if raw := query.Get("campaign"); raw != "" {
+		campaign, err := normalizeCampaign(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid campaign", errInvalidRequest)
+			return
+		}
+		opts.Campaign = campaign
+	}
+
+	// One extra link tells whether there's a next page
+	page := opts
+	page.Limit++
+	entries, total, err := store.List(r.Context(), page)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error listing links", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "list_links", "Error listing links", "error", err)
+		return
+	}
+	more := len(entries) > opts.Limit
+	entries = entries[:min(len(entries), opts.Limit)]
+
+	resp := LinkListResponse{
+		Links:  make([]LinkInfo, 0, len(entries)), // Encode an empty page as [] rather than null
+		Total:  total,
+		Limit:  opts.Limit,
+		Offset: opts.Offset,
+	}
+	for _, entry := range entries {
+		resp.Links = append(resp.Links, newLinkInfo(entry.Code, entry.Link))
+	}
+	if more {
+		resp.NextCursor = encodeListCursor(entries[len(entries)-1].Code)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "list_links", "Error encoding response", "error", err)
+	}
+}
+
+// Response structure for GET /links/by-url
+type URLLinksResponse struct {
+	URL   string   `json:"url"`   // The destination looked up, normalized like stored URLs
+	Codes []string `json:"codes"` // Every code pointing at it, sorted
+}
+
+// handleLinksByURL returns the codes of every link to ?url=, e.g. to find
+// the duplicates DEDUPE would have avoided. Admin only, like handleListLinks.
+func handleLinksByURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	raw := r.URL.Query().Get("url")
+	parsed, err := url.Parse(raw)
+	if raw == "" || err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid url (must start with http:// or https://)", errInvalidURL)
+		return
+	}
+	// Stored URLs were normalized when shortened, so the query must be too
+	destination := raw
+	if normalizeURLs {
+		destination = normalizeURL(parsed, stripTracking)
+	}
+
+	codes, err := store.CodesForURL(r.Context(), destination)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up links", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "links_by_url", "Error looking up links by URL", "url", destination, "error", err)
+		return
+	}
+	if codes == nil {
+		codes = []string{} // Encode no matches as [] rather than null
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(URLLinksResponse{URL: destination, Codes: codes}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "links_by_url", "Error encoding response", "error", err)
+	}
+}
+
+// handleLink changes a stored link. PATCH turns it on or off: disabled
+// links answer 410 on redirect but keep their code and stats, so they can
+// be turned back on. PUT points it at a new URL. Admin only, like handleListLinks.
+func handleLink(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPatch:
+		handlePatchLink(w, r)
+	case http.MethodPut:
+		handlePutLink(w, r)
+	case http.MethodDelete:
+		handleDeleteLink(w, r)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// decodeLinkBody reads the JSON body of a link update into v,
+// replying with an error and returning false if it can't
+func decodeLinkBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	return decodeJSONBody(w, r, "update_link", maxShortenBodySize(), v)
+}
+
+// handlePatchLink enables or disables a link
+func handlePatchLink(w http.ResponseWriter, r *http.Request) {
+	var req UpdateLinkRequest
+	if !decodeLinkBody(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		writeJSONError(w, http.StatusBadRequest, "Nothing to update (expected \"enabled\")", errInvalidRequest)
+		return
+	}
+
+	updateLink(w, r, func(link *Link) error {
+		link.Disabled = !*req.Enabled
+		return nil
+	})
+}
+
+// handlePutLink repoints a link, e.g. a campaign link, to a new URL. The URL
+// is validated like on creation; the code, clicks and creation time stay.
+func handlePutLink(w http.ResponseWriter, r *http.Request) {
+	var req ReplaceLinkRequest
+	if !decodeLinkBody(w, r, &req) {
+		return
+	}
+	newLink, shortenErr := buildLink(r, ShortenRequest{URL: req.URL})
+	if shortenErr != nil {
+		writeJSONError(w, shortenErr.status, shortenErr.message, shortenErr.code)
+		logRequest(r, shortenErr.status, "update_link", shortenErr.message)
+		return
+	}
+
+	updateLink(w, r, func(link *Link) error {
+		link.URL = newLink.URL
+		return nil
+	})
+}
+
+// handleDeleteLink removes a link for good, its code can be used again.
+// Use PATCH to turn it off while keeping it.
+func handleDeleteLink(w http.ResponseWriter, r *http.Request) {
+	shortCode := canonicalCode(r.PathValue("code"))
+	err := store.DeleteMany(r.Context(), []string{shortCode})[0]
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		logRequest(r, http.StatusNotFound, "delete_link", "Short code not found", "code", shortCode)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error deleting link", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "delete_link", "Error deleting link", "code", shortCode, "error", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	logRequest(r, http.StatusNoContent, "delete_link", "Deleted link", "code", shortCode)
+}
+
+// Most codes one POST /links/delete takes, and the body room for each:
+// plenty for a quoted, escaped alias
+const (
+	maxDeleteBatch    = 1000
+	deleteCodeMaxSize = 256
+)
+
+// Request structure for POST /links/delete
+type DeleteLinksRequest struct {
+	Codes []string `json:"codes"`
+}
+
+// DeleteResult is the outcome for one code of POST /links/delete
+type DeleteResult struct {
+	Code      string `json:"code"`
+	Status    string `json:"status"`               // "deleted", "not_found" or "error"
+	Error     string `json:"error,omitempty"`      // Set with status "error"
+	ErrorCode string `json:"error_code,omitempty"` // One of the err* error codes, set along with Error
+}
+
+// Response structure for POST /links/delete
+type DeleteLinksResponse struct {
+	Deleted int            `json:"deleted"`
+	Results []DeleteResult `json:"results"` // In the order of the request's codes
+}
+
+// handleDeleteLinks removes several links at once, for cleanup scripts.
+// Codes that don't exist are reported, not an error. Admin only, like
+// handleListLinks.
+func handleDeleteLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+
+	var req DeleteLinksRequest
+	if !decodeJSONBody(w, r, "delete_links", maxDeleteBatch*deleteCodeMaxSize, &req) {
+		return
+	}
+	defer r.Body.Close()
+	if len(req.Codes) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "No codes to delete (expected \"codes\")", errInvalidRequest)
+		return
+	}
+	if len(req.Codes) > maxDeleteBatch {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Too many codes (maximum is %d)", maxDeleteBatch), errTooLarge)
+		logRequest(r, http.StatusRequestEntityTooLarge, "delete_links", "Too many codes", "size", len(req.Codes))
+		return
+	}
+
+	codes := make([]string, len(req.Codes))
+	for i, code := range req.Codes {
+		codes[i] = canonicalCode(code)
+	}
+	resp := DeleteLinksResponse{Results: make([]DeleteResult, len(codes))}
+	for i, err := range store.DeleteMany(r.Context(), codes) {
+		result := &resp.Results[i]
+		result.Code = codes[i]
+		switch {
+		case err == nil:
+			result.Status = "deleted"
+			resp.Deleted++
+		case errors.Is(err, ErrNotFound):
+			result.Status = "not_found"
+		default:
+			slog.ErrorContext(r.Context(), "Error deleting link", "event", "delete_links", "code", codes[i], "error", err)
+			result.Status, result.Error, result.ErrorCode = "error", "Error deleting link", errUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "delete_links", "Error encoding response", "error", err)
+		return
+	}
+	logRequest(r, http.StatusOK, "delete_links", "Deleted links", "requested", len(codes), "deleted", resp.Deleted)
+}
+
+// updateLink applies fn to the link named in the path and replies with the result
+func updateLink(w http.ResponseWriter, r *http.Request, fn func(*Link) error) {
+	shortCode := canonicalCode(r.PathValue("code"))
+	link, err := store.Update(r.Context(), shortCode, fn)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		logRequest(r, http.StatusNotFound, "update_link", "Short code not found", "code", shortCode)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error updating link", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "update_link", "Error updating link", "code", shortCode, "error", err)
+		return
+	}
+	logRequest(r, http.StatusOK, "update_link", "Link updated", "code", shortCode, "url", link.URL, "enabled", !link.Disabled)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newLinkInfo(shortCode, link)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "update_link", "Error encoding response", "error", err)
+	}
+}
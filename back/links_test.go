@@ -0,0 +1,467 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listLinks sends GET /links?query to handleListLinks
+func listLinks(query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleListLinks(rec, httptest.NewRequest(http.MethodGet, "/links?"+query, nil))
+	return rec
+}
+
+// linkCodes returns the codes of a list response, in order
+func linkCodes(resp LinkListResponse) []string {
+	codes := make([]string, 0, len(resp.Links))
+	for _, link := range resp.Links {
+		codes = append(codes, link.Code)
+	}
+	return codes
+}
+
+func TestListLinksPagination(t *testing.T) {
+	useMemoryStore(t)
+	// Saved out of order, listed by code
+	for _, code := range []string{"d", "b", "e", "a", "c"} {
+		saveTestLink(t, code, Link{URL: "https://golang.org/" + code})
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"a", "b", "c", "d", "e"}},
+		{"limit=2", []string{"a", "b"}},
+		{"limit=2&offset=2", []string{"c", "d"}},
+		{"limit=2&offset=4", []string{"e"}},
+		{"offset=5", []string{}},
+		{"offset=100", []string{}},
+		{"limit=100000", []string{"a", "b", "c", "d", "e"}}, // Capped, not refused
+	}
+	for _, tt := range tests {
+		rec := listLinks(tt.query)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: status %d, body %s", tt.query, rec.Code, rec.Body)
+			continue
+		}
+		resp := decodeJSON[LinkListResponse](t, rec)
+		if got := linkCodes(resp); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: codes %v, want %v", tt.query, got, tt.want)
+		}
+		if resp.Total != 5 {
+			t.Errorf("%q: total %d, want 5", tt.query, resp.Total)
+		}
+	}
+
+	resp := decodeJSON[LinkListResponse](t, listLinks("limit=100000"))
+	if resp.Limit != maxListLimit {
+		t.Errorf("limit = %d, want it capped at %d", resp.Limit, maxListLimit)
+	}
+	if resp.Links[0].URL != "https://golang.org/a" {
+		t.Errorf("first link = %+v", resp.Links[0])
+	}
+
+	// Empty pages are [], not null
+	if rec := listLinks("offset=5"); !strings.Contains(rec.Body.String(), `"links":[]`) {
+		t.Errorf("empty page: %s", rec.Body)
+	}
+}
+
+func TestListLinksInvalidQuery(t *testing.T) {
+	useMemoryStore(t)
+	for _, query := range []string{"limit=0", "limit=-1", "limit=ten", "offset=-1", "offset=x"} {
+		rec := listLinks(query)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", query, rec.Code)
+			continue
+		}
+		if body := decodeJSON[ErrorResponse](t, rec); body.Code != errInvalidRequest {
+			t.Errorf("%q: error code %q", query, body.Code)
+		}
+	}
+}
+
+func TestListLinksCursor(t *testing.T) {
+	stores := map[string]func(t *testing.T){
+		"memory": func(t *testing.T) { useMemoryStore(t) },
+		"sqlite": func(t *testing.T) {
+			setForTest(t, &store, Store(openTestSQLite(t, filepath.Join(t.TempDir(), "links.db"))))
+		},
+	}
+	for name, use := range stores {
+		t.Run(name, func(t *testing.T) {
+			use(t)
+			var want []string
+			for i := range 25 {
+				code := fmt.Sprintf("link%02d", i)
+				saveTestLink(t, code, Link{URL: "https://golang.org/" + code})
+				want = append(want, code)
+			}
+
+			var got []string
+			cursor := ""
+			for page := 0; ; page++ {
+				if page > 10 {
+					t.Fatal("the cursor never ran out")
+				}
+				query := "limit=4"
+				if cursor != "" {
+					query += "&cursor=" + url.QueryEscape(cursor)
+				}
+				rec := listLinks(query)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("%q: status %d, body %s", query, rec.Code, rec.Body)
+				}
+				resp := decodeJSON[LinkListResponse](t, rec)
+				got = append(got, linkCodes(resp)...)
+				if resp.Total < 24 {
+					t.Errorf("page %d: total %d", page, resp.Total)
+				}
+
+				// Links come and go between pages: before the cursor, they
+				// mustn't shift the pages, after it they're listed (or not)
+				switch page {
+				case 1:
+					saveTestLink(t, "link00a", Link{URL: "https://golang.org/new"})
+					if errs := store.DeleteMany(t.Context(), []string{"link01"}); errs[0] != nil {
+						t.Fatal(errs[0])
+					}
+				case 2:
+					saveTestLink(t, "link20a", Link{URL: "https://golang.org/new"})
+					if errs := store.DeleteMany(t.Context(), []string{"link21"}); errs[0] != nil {
+						t.Fatal(errs[0])
+					}
+					want = slices.Insert(want, slices.Index(want, "link21"), "link20a")
+					want = slices.DeleteFunc(want, func(code string) bool { return code == "link21" })
+				}
+				if resp.NextCursor == "" {
+					break
+				}
+				cursor = resp.NextCursor
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("iterated %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestListLinksCursorErrors(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "a", Link{URL: "https://golang.org/a"})
+	for _, query := range []string{"cursor=!!!", "cursor=" + encodeListCursor("a") + "&offset=1"} {
+		if rec := listLinks(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", query, rec.Code)
+		}
+	}
+	// The last page has no next cursor, and a cursor past the end gives none
+	if resp := decodeJSON[LinkListResponse](t, listLinks("limit=1")); resp.NextCursor != "" {
+		t.Errorf("next_cursor = %q on the only page", resp.NextCursor)
+	}
+	resp := decodeJSON[LinkListResponse](t, listLinks("cursor="+encodeListCursor("z")))
+	if len(resp.Links) != 0 || resp.NextCursor != "" {
+		t.Errorf("past the end: %+v", resp)
+	}
+}
+
+// linksByURL sends GET /links/by-url?url=dest to handleLinksByURL
+func linksByURL(t *testing.T, dest string) URLLinksResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleLinksByURL(rec, httptest.NewRequest(http.MethodGet, "/links/by-url?url="+url.QueryEscape(dest), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("by-url %s: status %d, body %s", dest, rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), `"codes":null`) {
+		t.Errorf("by-url %s: codes encoded as null", dest)
+	}
+	return decodeJSON[URLLinksResponse](t, rec)
+}
+
+func TestLinksByURL(t *testing.T) {
+	stores := map[string]func(t *testing.T){
+		"memory": func(t *testing.T) { useMemoryStore(t) },
+		"sqlite": func(t *testing.T) {
+			setForTest(t, &store, Store(openTestSQLite(t, filepath.Join(t.TempDir(), "links.db"))))
+		},
+	}
+	for name, use := range stores {
+		t.Run(name, func(t *testing.T) {
+			use(t)
+			setForTest(t, &dedupe, false)
+			var want []string
+			for _, body := range []string{
+				`{"url": "https://golang.org/doc"}`,
+				`{"url": "HTTPS://GoLang.org:443/pkg/../doc"}`, // Stored normalized, the same URL
+				`{"url": "https://golang.org/doc", "custom_alias": "go-docs"}`,
+			} {
+				want = append(want, shortenOK(t, body).Code)
+			}
+			shortenOK(t, `{"url": "https://golang.org/pkg"}`)
+			shortenOK(t, `{"url": "https://golang.org/doc?lang=fr"}`)
+			slices.Sort(want)
+
+			// Looked up in any equivalent form
+			for _, dest := range []string{"https://golang.org/doc", "https://GOLANG.org:443/./doc"} {
+				resp := linksByURL(t, dest)
+				if resp.URL != "https://golang.org/doc" || !slices.Equal(resp.Codes, want) {
+					t.Errorf("%s: %+v, want codes %v", dest, resp, want)
+				}
+			}
+			if resp := linksByURL(t, "https://golang.org/nothing"); len(resp.Codes) != 0 {
+				t.Errorf("no match: codes %v", resp.Codes)
+			}
+
+			// A link pointed elsewhere leaves the old URL's codes
+			if rec := changeLink(http.MethodPut, "go-docs", `{"url": "https://go.dev/doc"}`); rec.Code != http.StatusOK {
+				t.Fatalf("PUT: status %d, body %s", rec.Code, rec.Body)
+			}
+			if resp := linksByURL(t, "https://golang.org/doc"); slices.Contains(resp.Codes, "go-docs") || len(resp.Codes) != 2 {
+				t.Errorf("after PUT: codes %v", resp.Codes)
+			}
+			if resp := linksByURL(t, "https://go.dev/doc"); !slices.Equal(resp.Codes, []string{"go-docs"}) {
+				t.Errorf("new URL: codes %v", resp.Codes)
+			}
+		})
+	}
+}
+
+func TestLinksByURLErrors(t *testing.T) {
+	useMemoryStore(t)
+	for _, query := range []string{"", "url=", "url=golang.org", "url=ftp%3A%2F%2Fgolang.org"} {
+		rec := httptest.NewRecorder()
+		handleLinksByURL(rec, httptest.NewRequest(http.MethodGet, "/links/by-url?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", query, rec.Code)
+		}
+	}
+
+	// Admin only, like the list
+	setForTest(t, &apiKeys, []string{"secret"})
+	router := testRouter(t)
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "secret": http.StatusOK} {
+		r := httptest.NewRequest(http.MethodGet, "/links/by-url?url=https%3A%2F%2Fgolang.org", nil)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		if rec.Code != want {
+			t.Errorf("key %q: status %d, want %d", key, rec.Code, want)
+		}
+	}
+}
+
+// changeLink sends method /links/{code} with body to handleLink
+func changeLink(method, code, body string) *httptest.ResponseRecorder {
+	return serve("/links/{code}", handleLink, jsonRequest(method, "/links/"+code, body))
+}
+
+func TestDisableAndEnableLink(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "toggle", Link{URL: "https://golang.org/doc", Clicks: 2})
+
+	rec := changeLink(http.MethodPatch, "toggle", `{"enabled": false}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("disable: status = %d: %s", rec.Code, rec.Body)
+	}
+	if info := decodeJSON[LinkInfo](t, rec); info.Enabled || info.Clicks != 2 {
+		t.Errorf("disable: enabled %v, clicks %d", info.Enabled, info.Clicks)
+	}
+	rec = getRedirect("/toggle")
+	if rec.Code != http.StatusGone {
+		t.Errorf("disabled redirect: status = %d, want 410", rec.Code)
+	}
+	if got := decodeJSON[ErrorResponse](t, rec).Code; got != errDisabled {
+		t.Errorf("disabled redirect: code = %q, want %q", got, errDisabled)
+	}
+	// Still stored, with its stats
+	if link, err := store.Lookup(t.Context(), "toggle"); err != nil || !link.Disabled || link.Clicks != 2 {
+		t.Errorf("stored link = %+v, %v", link, err)
+	}
+
+	if rec := changeLink(http.MethodPatch, "toggle", `{"enabled": true}`); rec.Code != http.StatusOK || !decodeJSON[LinkInfo](t, rec).Enabled {
+		t.Fatalf("enable: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := getRedirect("/toggle"); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://golang.org/doc" {
+		t.Errorf("enabled redirect: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestPatchLinkErrors(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "here", Link{URL: "https://golang.org/doc"})
+	tests := []struct {
+		name, code, body string
+		want             int
+	}{
+		{"missing code", "nothere", `{"enabled": false}`, http.StatusNotFound},
+		{"nothing to update", "here", `{}`, http.StatusBadRequest},
+		{"unknown field", "here", `{"enabled": false, "url": "https://golang.org"}`, http.StatusBadRequest},
+		{"malformed", "here", `{"enabled": `, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := changeLink(http.MethodPatch, tt.code, tt.body); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+	if rec := changeLink(http.MethodPost, "here", `{"enabled": false}`); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want 405", rec.Code)
+	}
+}
+
+func TestReplaceLinkURL(t *testing.T) {
+	useMemoryStore(t)
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	saveTestLink(t, "campaign", Link{URL: "https://golang.org/old", Clicks: 7, CreatedAt: created})
+
+	rec := changeLink(http.MethodPut, "campaign", `{"url": "https://go.dev/new"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	info := decodeJSON[LinkInfo](t, rec)
+	if info.URL != "https://go.dev/new" || info.Clicks != 7 || !info.CreatedAt.Equal(created) {
+		t.Errorf("got url %q, clicks %d, created_at %v; want the new URL with clicks and creation kept", info.URL, info.Clicks, info.CreatedAt)
+	}
+	if got := getRedirect("/campaign").Header().Get("Location"); got != "https://go.dev/new" {
+		t.Errorf("Location = %q, want https://go.dev/new", got)
+	}
+}
+
+func TestReplaceLinkURLErrors(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "here", Link{URL: "https://golang.org/doc"})
+	tests := []struct {
+		name, code, body string
+		want             int
+	}{
+		{"missing code", "nothere", `{"url": "https://go.dev"}`, http.StatusNotFound},
+		{"not http", "here", `{"url": "ftp://go.dev"}`, http.StatusBadRequest},
+		{"no host", "here", `{"url": "https://"}`, http.StatusBadRequest},
+		{"self reference", "here", `{"url": "http://example.com/abc"}`, http.StatusBadRequest},
+		{"empty", "here", `{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := changeLink(http.MethodPut, tt.code, tt.body); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+	// Failed updates leave the link alone
+	if got := getRedirect("/here").Header().Get("Location"); got != "https://golang.org/doc" {
+		t.Errorf("Location = %q, want the original URL", got)
+	}
+}
+
+// deleteLinks sends POST /links/delete with body to handleDeleteLinks
+func deleteLinks(body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleDeleteLinks(rec, jsonRequest(http.MethodPost, "/links/delete", body))
+	return rec
+}
+
+func TestDeleteLinks(t *testing.T) {
+	stores := map[string]func(t *testing.T){
+		"memory": func(t *testing.T) { useMemoryStore(t) },
+		"sqlite": func(t *testing.T) {
+			setForTest(t, &store, Store(openTestSQLite(t, filepath.Join(t.TempDir(), "links.db"))))
+		},
+	}
+	for name, use := range stores {
+		t.Run(name, func(t *testing.T) {
+			use(t)
+			for _, code := range []string{"one", "two", "three"} {
+				saveTestLink(t, code, Link{URL: "https://golang.org/" + code})
+			}
+			saveTestLink(t, "two-again", Link{URL: "https://golang.org/two"})
+
+			rec := deleteLinks(`{"codes": ["one", "missing", "two", "one", "two-again"]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+			}
+			resp := decodeJSON[DeleteLinksResponse](t, rec)
+			want := []DeleteResult{
+				{Code: "one", Status: "deleted"},
+				{Code: "missing", Status: "not_found"},
+				{Code: "two", Status: "deleted"},
+				{Code: "one", Status: "not_found"}, // Already deleted by the first
+				{Code: "two-again", Status: "deleted"},
+			}
+			if resp.Deleted != 3 || !slices.Equal(resp.Results, want) {
+				t.Errorf("response = %+v, want 3 deleted and %+v", resp, want)
+			}
+
+			if got := storeSize(t); got != 1 {
+				t.Errorf("%d links left, want 1", got)
+			}
+			// The reverse index forgets them too
+			for _, dest := range []string{"https://golang.org/one", "https://golang.org/two"} {
+				if codes, err := store.CodesForURL(t.Context(), dest); err != nil || len(codes) != 0 {
+					t.Errorf("CodesForURL(%s) = %v, %v after deleting", dest, codes, err)
+				}
+				if code, err := store.LookupURL(t.Context(), dest); err == nil {
+					t.Errorf("LookupURL(%s) = %q after deleting", dest, code)
+				}
+			}
+			if rec := getRedirect("/one"); rec.Code != http.StatusNotFound {
+				t.Errorf("redirect after delete: status %d, want 404", rec.Code)
+			}
+			if _, err := store.Lookup(t.Context(), "three"); err != nil {
+				t.Errorf("Lookup(three) = %v, it wasn't in the request", err)
+			}
+		})
+	}
+}
+
+func TestDeleteLinksErrors(t *testing.T) {
+	useMemoryStore(t)
+	tooMany := make([]string, maxDeleteBatch+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("%q", fmt.Sprint("c", i))
+	}
+	for _, tt := range []struct {
+		body   string
+		status int
+	}{
+		{`{"codes": []}`, http.StatusBadRequest},
+		{`{}`, http.StatusBadRequest},
+		{`["one"]`, http.StatusBadRequest},
+		{`{"codes": [` + strings.Join(tooMany, ",") + `]}`, http.StatusRequestEntityTooLarge},
+	} {
+		if rec := deleteLinks(tt.body); rec.Code != tt.status {
+			t.Errorf("%.40s: status %d, want %d", tt.body, rec.Code, tt.status)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handleDeleteLinks(rec, httptest.NewRequest(http.MethodGet, "/links/delete", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", rec.Code)
+	}
+}
+
+func TestDeleteLink(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "gone", Link{URL: "https://golang.org/doc"})
+	if rec := changeLink(http.MethodDelete, "gone", ""); rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("DELETE: status %d, body %q; want an empty 204", rec.Code, rec.Body)
+	}
+	rec := changeLink(http.MethodDelete, "gone", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("DELETE again: status %d, want 404", rec.Code)
+	}
+	if body := decodeJSON[ErrorResponse](t, rec); body.Code != errNotFound {
+		t.Errorf("error = %+v", body)
+	}
+}
@@ -1,100 +1,813 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os" // Import os to get the PORT environment variable
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/text/unicode/norm"
 )
 
-// Store our URL mappings in memory (for simplicity)
-// In a production app, use a database (e.g., Redis, PostgreSQL)
+// store holds our URL mappings. It's chosen in main based on STORAGE_BACKEND
+// (in memory by default, or SQLite so links survive restarts).
 var (
-	urlStore        = make(map[string]string)
-	mu              sync.RWMutex // To safely access urlStore concurrently
-	letterRunes     = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
-	shortCodeLength = 6
+	store           Store
+	baseURL         string             // Base for generated short links, from BASE_URL (no trailing slash)
+	redirectStatus  = http.StatusFound // From REDIRECT_STATUS, 301 or 302
+	dedupe          bool               // From DEDUPE, reuse the existing code for an already shortened URL
+	normalizeURLs   = true             // From NORMALIZE_URLS, store equivalent URLs in one canonical form
+	stripTracking   bool               // From STRIP_TRACKING_PARAMS, drop utm_* and similar params when normalizing
+	maxURLLength    = 2048             // From MAX_URL_LENGTH, longest URL we accept (in bytes)
+	caseInsensitive bool               // From CASE_INSENSITIVE, codes are lowercase and looked up regardless of case
+	// Alphabet of random codes from CODE_ALPHABET, unless a request picks another
+	letterRunes = []rune(codeAlphabets["base62"])
+	// Length of random codes, from CODE_LENGTH. With 62 characters there are
+	// 62^n possible codes (about 56.8 billion for 6). By the birthday bound,
+	// collisions become likely after roughly sqrt(62^n) links (~240k for 6,
+	// ~15k for 4); they are retried, but shorter codes retry more often.
+	shortCodeLength = defaultCodeLength
+	// Custom aliases may use letters, digits and dashes, 3 to 32 characters long
+	customAliasPattern = regexp.MustCompile(`^[A-Za-z0-9-]{3,32}$`)
+	// With UNICODE_ALIASES, letters and digits of any script, combining
+	// marks and emoji (with their joiners and skin tones) too
+	unicodeAliasPattern = regexp.MustCompile(`^[\p{L}\p{N}\p{M}\p{So}\x{200D}\x{1F3FB}-\x{1F3FF}-]+$`)
+	unicodeAliases      bool // From UNICODE_ALIASES
 )
 
+// Length bounds of custom aliases, in characters (not bytes)
+const (
+	minAliasLength = 3
+	maxAliasLength = 32
+)
+
+// validCustomAlias reports whether alias may be used as a custom alias
+func validCustomAlias(alias string) bool {
+	if !unicodeAliases {
+		return customAliasPattern.MatchString(alias)
+	}
+	length := utf8.RuneCountInString(alias)
+	return length >= minAliasLength && length <= maxAliasLength && unicodeAliasPattern.MatchString(alias)
+}
+
+// canonicalCode returns the form a short code is stored and looked up in.
+// With UNICODE_ALIASES that's also NFC, so "é" matches whether it was
+// typed as one character or as "e" plus an accent.
+func canonicalCode(code string) string {
+	if caseInsensitive {
+		code = strings.ToLower(code)
+	}
+	if unicodeAliases {
+		code = norm.NFC.String(code)
+	}
+	return code
+}
+
+// Bounds and default for CODE_LENGTH
+const (
+	defaultCodeLength = 6
+	minCodeLength     = 4
+	maxCodeLength     = 32
+)
+
+// Room for the other fields of a shorten request on top of the URL,
+// see maxShortenBodySize
+const shortenBodyOverhead = 4 << 10
+
+// maxShortenBodySize is the largest body a single shorten request may have.
+// Anything bigger can't hold a valid URL, so we stop reading early.
+func maxShortenBodySize() int64 {
+	return int64(maxURLLength) + shortenBodyOverhead
+}
+
+// bodyTooLarge reports whether a decode failed because the body hit its MaxBytesReader limit
+func bodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// decodeJSONBody reads a JSON body of at most limit bytes into v. Unknown
+// fields are refused so typos like "custom_alais" don't pass silently. On
+// failure it replies with 413 for a body over the limit or 400 saying what
+// was wrong with the JSON, logs which case it was, and returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, event string, limit int64, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errTrailingData
+	}
+	if err == nil {
+		return true
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var message, reason string
+	switch {
+	case bodyTooLarge(err):
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body too large (maximum is %d bytes)", limit), errTooLarge)
+		logRequest(r, http.StatusRequestEntityTooLarge, event, "Request body too large", "reason", "too_large", "limit", limit)
+		return false
+	case errors.Is(err, io.EOF):
+		message, reason = "Request body is empty", "empty"
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, errTrailingData):
+		message, reason = "Malformed JSON in request body", "malformed"
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for this, only the message
+		message, reason = "Unknown field "+strings.TrimPrefix(err.Error(), "json: unknown field ")+" in request body", "unknown_field"
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		message, reason = fmt.Sprintf("Invalid value for %q (expected %s)", typeErr.Field, typeErr.Type), "wrong_type"
+	default:
+		message, reason = "Invalid request body: "+err.Error(), "invalid"
+	}
+	writeJSONError(w, http.StatusBadRequest, message, errInvalidRequest)
+	logRequest(r, http.StatusBadRequest, event, "Error decoding request body", "reason", reason, "error", err)
+	return false
+}
+
+// errTrailingData means a request body had more after its JSON value
+var errTrailingData = errors.New("data after the JSON value")
+
+// Form fields a urlencoded /shorten body may have, for curl and plain HTML
+// forms. Everything else needs JSON.
+var shortenFormFields = map[string]bool{"url": true, "custom_alias": true}
+
+// decodeShortenBody reads a /shorten body by its Content-Type: JSON (see
+// decodeJSONBody), or a urlencoded form with the url and optionally
+// custom_alias fields. Any other type gets a 415. Like decodeJSONBody it
+// replies itself on failure and returns false.
+func decodeShortenBody(w http.ResponseWriter, r *http.Request, req *ShortenRequest) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		return decodeJSONBody(w, r, "shorten", maxShortenBodySize(), req)
+	case "application/x-www-form-urlencoded":
+	default:
+		writeJSONError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Type (expected application/json or application/x-www-form-urlencoded)", errUnsupportedMediaType)
+		logRequest(r, http.StatusUnsupportedMediaType, "shorten", "Unsupported Content-Type", "content_type", r.Header.Get("Content-Type"))
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxShortenBodySize())
+	if err := r.ParseForm(); err != nil {
+		if bodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body too large (maximum is %d bytes)", maxShortenBodySize()), errTooLarge)
+			logRequest(r, http.StatusRequestEntityTooLarge, "shorten", "Request body too large", "reason", "too_large", "limit", maxShortenBodySize())
+			return false
+		}
+		writeJSONError(w, http.StatusBadRequest, "Malformed form in request body", errInvalidRequest)
+		logRequest(r, http.StatusBadRequest, "shorten", "Error decoding request body", "reason", "malformed", "error", err)
+		return false
+	}
+	// PostForm rather than Form, query parameters like dry_run aren't fields
+	for field := range r.PostForm {
+		if !shortenFormFields[field] {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Unknown field %q in request body (forms only take url and custom_alias, use JSON for more)", field), errInvalidRequest)
+			logRequest(r, http.StatusBadRequest, "shorten", "Error decoding request body", "reason", "unknown_field", "field", field)
+			return false
+		}
+	}
+	if len(r.PostForm) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Request body is empty", errInvalidRequest)
+		logRequest(r, http.StatusBadRequest, "shorten", "Error decoding request body", "reason", "empty")
+		return false
+	}
+	req.URL = r.PostForm.Get("url")
+	req.CustomAlias = r.PostForm.Get("custom_alias")
+	return true
+}
+
+// How long in-flight requests get to finish once a shutdown signal arrives
+const shutdownTimeout = 15 * time.Second
+
 // Request structure for shortening a URL
 type ShortenRequest struct {
-	URL string `json:"url"`
+	URL         string    `json:"url"`
+	CustomAlias string    `json:"custom_alias,omitempty"` // Optional vanity code instead of a random one
+	ReuseAlias  bool      `json:"reuse_alias,omitempty"`  // If custom_alias links to the same URL already, answer with it instead of 409
+	ExpiresIn   Duration  `json:"expires_in,omitempty"`   // Optional TTL, e.g. 3600 or "24h"
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`    // Optional RFC 3339 expiry date instead of expires_in
+	Permanent   bool      `json:"permanent,omitempty"`    // Use a 301 redirect for this link regardless of REDIRECT_STATUS
+	Password    string    `json:"password,omitempty"`     // Optional, visitors must enter it before being redirected
+	OneTime     bool      `json:"one_time,omitempty"`     // The link stops working after its first redirect
+	MaxClicks   int64     `json:"max_clicks,omitempty"`   // The link stops working after this many redirects
+	Tags        []string  `json:"tags,omitempty"`         // Labels to filter GET /links by
+	Campaign    string    `json:"campaign,omitempty"`     // Counted in the totals of GET /campaigns/{name}
+	Tenant      string    `json:"tenant,omitempty"`       // One of TENANTS, or the X-Tenant header; empty for the root code space
+	Alphabet    string    `json:"alphabet,omitempty"`     // Preset for the random code (see codeAlphabets), CODE_ALPHABET if empty
+	Length      int       `json:"length,omitempty"`       // Of the random or hashed code, CODE_LENGTH if 0. Shorter codes collide sooner.
+	// Added to the destination's query on redirect unless it already has them
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+	// Destinations by visitor country, e.g. {"DE": "https://example.de"}.
+	// Visitors from elsewhere (or with GEOIP_DB unset) go to URL.
+	Geo map[string]string `json:"geo,omitempty"`
+	// Destinations by device: "ios", "android", "mobile" (both of these and
+	// other phones) or "desktop". They win over geo rules.
+	Devices map[string]string `json:"devices,omitempty"`
+	// Show a countdown page for this long before redirecting, e.g. 5 or
+	// "5s", instead of REDIRECT_DELAY
+	RedirectDelay Duration `json:"redirect_delay,omitempty"`
+}
+
+// Duration is a time.Duration that can be given in JSON either as a number
+// of seconds (3600) or as a Go duration string ("24h").
+type Duration time.Duration
+
+// UnmarshalJSON accepts both forms of Duration
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a number of seconds or a string like \"24h\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
 }
 
 // Response structure for a shortened URL
 type ShortenResponse struct {
-	ShortURL string `json:"short_url"`
+	ShortURL    string `json:"short_url"`
+	Code        string `json:"code"`                // The raw short code, so callers don't have to parse ShortURL
+	OriginalURL string `json:"original_url"`        // The long URL that was shortened
+	QRCode      string `json:"qr_code,omitempty"`   // PNG data URI of the short URL's QR code, only with ?include_qr=1
+	DryRun      bool   `json:"dry_run,omitempty"`   // Set for ?dry_run=1, nothing was saved and the code isn't reserved
+	StatsURL    string `json:"stats_url,omitempty"` // Shareable read-only stats, with STATS_SECRET set
+}
+
+// Response structure for a redirect requested with Accept: application/json
+type RedirectResponse struct {
+	URL string `json:"url"` // Where the redirect would have gone
+}
+
+// Response structure for link statistics
+type StatsResponse struct {
+	Code      string      `json:"code"`
+	Clicks    int64       `json:"clicks"`
+	URL       string      `json:"url,omitempty"` // Left out for password protected links
+	Protected bool        `json:"protected,omitempty"`
+	CreatedAt time.Time   `json:"created_at,omitzero"`
+	Health    *LinkHealth `json:"health,omitempty"` // Last destination check, if any
+}
+
+// newStatsResponse builds the public stats of a link. The destination of
+// a protected link is only revealed to visitors who know the password.
+func newStatsResponse(code string, link Link) StatsResponse {
+	resp := StatsResponse{Code: code, Clicks: link.Clicks, URL: link.URL, CreatedAt: link.CreatedAt, Health: newLinkHealth(link)}
+	if link.PasswordHash != "" {
+		resp.URL, resp.Protected = "", true
+	}
+	return resp
+}
+
+// parseBaseURL validates the BASE_URL setting: it must be an absolute
+// http(s) URL. Any trailing slash is stripped so we can append "/<code>".
+func parseBaseURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid BASE_URL %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid BASE_URL %q: must be an absolute http:// or https:// URL", raw)
+	}
+	return strings.TrimRight(raw, "/"), nil
+}
+
+// shortURLBase returns the base for short links. If BASE_URL isn't set,
+// it's built from the incoming request's scheme and Host header.
+func shortURLBase(r *http.Request) string {
+	if baseURL != "" {
+		return baseURL
+	}
+	scheme := "http"
+	// Behind a proxy (e.g., Railway) TLS is terminated before reaching us
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// findReusableCode returns an existing code for url that a plain shorten
+// request in tenant can reuse, or "" if there is none. Links created with
+// options (expiry, permanent, one-time...) are never reused since they
+// behave differently, and neither are other tenants' links.
+func findReusableCode(ctx context.Context, tenant, longURL string) (string, error) {
+	code, err := store.LookupURL(ctx, longURL)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if codeTenant, _ := splitKey(code); codeTenant != tenant {
+		return "", nil
+	}
+	link, err := store.Lookup(ctx, code)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil // Removed in the meantime
+	}
+	if err != nil {
+		return "", err
+	}
+	if !plainLink(link) {
+		return "", nil
+	}
+	return code, nil
+}
+
+// plainLink reports whether link was shortened without any options, so a
+// plain request for its URL can get it back
+func plainLink(link Link) bool {
+	return link.ExpiresAt.IsZero() && !link.Permanent && !link.Disabled && link.PasswordHash == "" && !limitedVisits(link) && len(link.Tags) == 0 && len(link.UTM) == 0 && len(link.Geo) == 0 && len(link.Devices) == 0 && link.RedirectDelay == 0 && link.Campaign == ""
+}
+
+// trustedProxies is how many proxies in front of the service add to
+// X-Forwarded-For, see TRUSTED_PROXIES
+var trustedProxies = 1
+
+// clientIP returns the IP of the client making the request. Each proxy
+// (e.g., Railway's) appends the address it got the request from to
+// X-Forwarded-For, so the client is the entry the outermost of the
+// trustedProxies added, counting from the right. Anything further left came
+// from the client itself and can be made up. With no proxies, only the
+// connection counts.
+func clientIP(r *http.Request) string {
+	if trustedProxies > 0 {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(header, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					hops = append(hops, hop)
+				}
+			}
+		}
+		if len(hops) > 0 {
+			// Fewer hops than proxies: the request skipped some of them
+			return hops[max(len(hops)-trustedProxies, 0)]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// shortenError is a failure while creating a link, with the HTTP status
+// it should be reported as
+type shortenError struct {
+	status  int
+	message string
+	code    string // One of the err* error codes
+}
+
+func (e *shortenError) Error() string { return e.message }
+
+// writeShortenError reports err to the client
+func writeShortenError(w http.ResponseWriter, r *http.Request, err *shortenError) {
+	logRequest(r, err.status, "shorten", err.message)
+	writeJSONError(w, err.status, err.message, err.code)
+}
+
+// isSelfHost reports whether host is our own short link host (from
+// BASE_URL, or the request's host if unset). Shortening our own links
+// would create redirect loops and chains.
+func isSelfHost(r *http.Request, host string) bool {
+	base, err := url.Parse(shortURLBase(r))
+	if err != nil {
+		return false
+	}
+	return normalizeHost(host) == normalizeHost(base.Hostname())
+}
+
+// parseLongURL checks the shape of a URL to shorten, without looking it up:
+// it must be an absolute http(s) URL with a host, at most MAX_URL_LENGTH long
+func parseLongURL(rawURL string) (*url.URL, *shortenError) {
+	if rawURL == "" {
+		return nil, &shortenError{http.StatusBadRequest, "URL cannot be empty", errInvalidURL}
+	}
+
+	if len(rawURL) > maxURLLength {
+		return nil, &shortenError{http.StatusBadRequest, fmt.Sprintf("URL too long (maximum is %d characters)", maxURLLength), errInvalidURL}
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, &shortenError{http.StatusBadRequest, "Invalid URL format (must start with http:// or https://)", errInvalidURL}
+	}
+	return parsed, nil
+}
+
+// buildLink validates a shorten request and turns it into the link to store
+func buildLink(r *http.Request, req ShortenRequest) (Link, *shortenError) {
+	parsed, serr := parseLongURL(req.URL)
+	if serr != nil {
+		return Link{}, serr
+	}
+
+	// Store where a bit.ly (or similar) link really goes, so it's vetted
+	// below and our link doesn't break if theirs does
+	if expandShortURLs && isShortenerHost(parsed.Hostname()) {
+		expanded, err := expandShortURL(r.Context(), parsed)
+		switch {
+		case errors.Is(err, errInternalDestination):
+			return Link{}, &shortenError{http.StatusForbidden, "URL is not allowed: short URL leads to a private address", errBlockedURL}
+		case errors.Is(err, errTooManyHops):
+			return Link{}, &shortenError{http.StatusBadRequest, "Short URL redirects too many times", errInvalidURL}
+		case err != nil:
+			// Their shortener being down shouldn't stop ours, keep the URL as submitted
+			slog.WarnContext(r.Context(), "Could not expand short URL", "event", "shorten", "url", req.URL, "error", err)
+		case expanded.String() != req.URL:
+			if len(expanded.String()) > maxURLLength {
+				return Link{}, &shortenError{http.StatusBadRequest, fmt.Sprintf("Expanded URL too long (maximum is %d characters)", maxURLLength), errInvalidURL}
+			}
+			slog.InfoContext(r.Context(), "Expanded short URL", "event", "shorten", "url", req.URL, "expanded", expanded.String())
+			parsed, req.URL = expanded, expanded.String()
+		}
+	}
+
+	if isSelfHost(r, parsed.Hostname()) {
+		return Link{}, &shortenError{http.StatusBadRequest, "URL points to this shortener, shortening short links is not allowed", errInvalidURL}
+	}
+
+	if urlChecker != nil {
+		blocked, reason, err := urlChecker.Check(r.Context(), parsed)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking URL", "event", "shorten", "url", req.URL, "error", err)
+			return Link{}, &shortenError{http.StatusServiceUnavailable, "Could not check URL, please try again", errUnavailable}
+		}
+		if blocked {
+			return Link{}, &shortenError{http.StatusForbidden, "URL is not allowed: " + reason, errBlockedURL}
+		}
+	}
+
+	if req.ExpiresIn < 0 {
+		return Link{}, &shortenError{http.StatusBadRequest, "expires_in must be positive", errInvalidRequest}
+	}
+	if !req.ExpiresAt.IsZero() {
+		if req.ExpiresIn != 0 {
+			return Link{}, &shortenError{http.StatusBadRequest, "Use either expires_in or expires_at, not both", errInvalidRequest}
+		}
+		if !req.ExpiresAt.After(time.Now()) {
+			return Link{}, &shortenError{http.StatusBadRequest, "expires_at must be in the future", errInvalidRequest}
+		}
+	}
+
+	if req.CustomAlias != "" && !validCustomAlias(req.CustomAlias) {
+		if unicodeAliases {
+			return Link{}, &shortenError{http.StatusBadRequest, "Invalid custom alias (use 3-32 letters, digits, emoji or dashes)", errInvalidAlias}
+		}
+		return Link{}, &shortenError{http.StatusBadRequest, "Invalid custom alias (use 3-32 letters, digits or dashes)", errInvalidAlias}
+	}
+	if isReservedCode(req.CustomAlias) {
+		return Link{}, &shortenError{http.StatusConflict, "Custom alias is reserved", errConflict}
+	}
+	if req.ReuseAlias && req.CustomAlias == "" {
+		return Link{}, &shortenError{http.StatusBadRequest, "reuse_alias needs a custom_alias", errInvalidRequest}
+	}
+
+	if _, ok := codeAlphabets[req.Alphabet]; req.Alphabet != "" && !ok {
+		return Link{}, &shortenError{http.StatusBadRequest, "Invalid alphabet (expected base62 or readable)", errInvalidRequest}
+	}
+	if req.Length != 0 {
+		if req.Length < minCodeLength || req.Length > maxCodeLength {
+			return Link{}, &shortenError{http.StatusBadRequest, fmt.Sprintf("Invalid length (must be between %d and %d)", minCodeLength, maxCodeLength), errInvalidRequest}
+		}
+		if req.CustomAlias != "" {
+			return Link{}, &shortenError{http.StatusBadRequest, "length only applies to random codes, not a custom_alias", errInvalidRequest}
+		}
+		if _, sequential := codeGenerator.(SequentialBase62Generator); sequential {
+			return Link{}, &shortenError{http.StatusBadRequest, "length needs random or hashed codes (CODE_STRATEGY=random or hash)", errInvalidRequest}
+		}
+	}
+
+	if req.Tenant != "" && !tenants[req.Tenant] {
+		return Link{}, &shortenError{http.StatusBadRequest, "Unknown tenant", errInvalidRequest}
+	}
+
+	if req.MaxClicks < 0 {
+		return Link{}, &shortenError{http.StatusBadRequest, "max_clicks must be positive", errInvalidRequest}
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return Link{}, &shortenError{http.StatusBadRequest, "Invalid tags: " + err.Error(), errInvalidRequest}
+	}
+	var campaign string
+	if req.Campaign != "" {
+		if campaign, err = normalizeCampaign(req.Campaign); err != nil {
+			return Link{}, &shortenError{http.StatusBadRequest, "Invalid campaign: " + err.Error(), errInvalidRequest}
+		}
+	}
+
+	utm, err := newUTM(req)
+	if err != nil {
+		return Link{}, &shortenError{http.StatusBadRequest, "Invalid UTM parameters: " + err.Error(), errInvalidRequest}
+	}
+
+	geo, serr := newGeoRules(r, req.Geo)
+	if serr != nil {
+		return Link{}, serr
+	}
+	devices, serr := newDeviceRules(r, req.Devices)
+	if serr != nil {
+		return Link{}, serr
+	}
+
+	if !validRedirectDelay(time.Duration(req.RedirectDelay)) {
+		return Link{}, &shortenError{http.StatusBadRequest, fmt.Sprintf("redirect_delay must be whole seconds, at most %d", int(maxRedirectDelay/time.Second)), errInvalidRequest}
+	}
+
+	if len(req.Password) > maxPasswordLength {
+		return Link{}, &shortenError{http.StatusBadRequest, fmt.Sprintf("Password too long (maximum is %d bytes)", maxPasswordLength), errInvalidRequest}
+	}
+
+	longURL := req.URL
+	if normalizeURLs {
+		longURL = normalizeURL(parsed, stripTracking)
+	}
+
+	now := time.Now()
+	// Stores keep timestamps to the second, so do the same here
+	link := Link{URL: longURL, Permanent: req.Permanent, OneTime: req.OneTime, MaxClicks: req.MaxClicks, Tags: tags, UTM: utm, Geo: geo, Devices: devices,
+		RedirectDelay: time.Duration(req.RedirectDelay), Campaign: campaign, CreatedAt: now.Truncate(time.Second), CreatedBy: requestCreator(r), CreatorIP: clientIP(r)}
+	if req.ExpiresIn > 0 {
+		link.ExpiresAt = now.Add(time.Duration(req.ExpiresIn))
+	} else if !req.ExpiresAt.IsZero() {
+		link.ExpiresAt = req.ExpiresAt
+	}
+	if req.Password != "" {
+		if link.PasswordHash, err = hashPassword(req.Password); err != nil {
+			slog.ErrorContext(r.Context(), "Error hashing password", "event", "shorten", "error", err)
+			return Link{}, &shortenError{http.StatusInternalServerError, "Error protecting short URL", errInternal}
+		}
+	}
+	return link, nil
+}
+
+// requestAlphabet returns the alphabet random codes for req are made of
+func requestAlphabet(req ShortenRequest) []rune {
+	if alphabet, ok := codeAlphabet(req.Alphabet); ok {
+		return alphabet
+	}
+	return letterRunes
+}
+
+// requestCodeLength returns the length random codes for req have
+func requestCodeLength(req ShortenRequest) int {
+	if req.Length != 0 {
+		return req.Length
+	}
+	return shortCodeLength
+}
+
+// canDedupe reports whether req may reuse the code of an identical URL
+func canDedupe(req ShortenRequest) bool {
+	return dedupe && plainRequest(req)
+}
+
+// plainRequest reports whether req asks for nothing but a code for its URL
+func plainRequest(req ShortenRequest) bool {
+	return req.CustomAlias == "" && req.Length == 0 && req.ExpiresIn == 0 && req.ExpiresAt.IsZero() && !req.Permanent && req.Password == "" && !req.OneTime && req.MaxClicks == 0 && len(req.Tags) == 0 &&
+		req.UTMSource == "" && req.UTMMedium == "" && req.UTMCampaign == "" && len(req.Geo) == 0 && len(req.Devices) == 0 && req.RedirectDelay == 0 && req.Campaign == ""
+}
+
+// errStoreFullShorten answers shortening with the store at MEMORY_CAPACITY
+var errStoreFullShorten = &shortenError{http.StatusInsufficientStorage, "Storage is full, no more links can be created", errStorageFull}
+
+// saveLink stores link under the requested custom alias, an existing code
+// for the same URL (with DEDUPE on) or a fresh random code, and returns the code.
+func saveLink(ctx context.Context, req ShortenRequest, link Link) (string, *shortenError) {
+	if req.CustomAlias != "" {
+		// Save checks and claims the alias atomically, so two requests
+		// can't both get the same alias.
+		key := tenantKey(req.Tenant, req.CustomAlias)
+		err := saveCode(ctx, key, link)
+		if errors.Is(err, ErrCodeExists) {
+			return existingAlias(ctx, req, key, link)
+		}
+		if errors.Is(err, ErrStoreFull) {
+			return "", errStoreFullShorten
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error saving short URL", "event", "shorten", "code", key, "error", err)
+			return "", &shortenError{http.StatusServiceUnavailable, "Error saving short URL", errUnavailable}
+		}
+		return key, nil
+	}
+
+	// The reverse index is keyed by the normalized URL
+	if canDedupe(req) {
+		code, err := findReusableCode(ctx, req.Tenant, link.URL)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error looking up existing short URL", "event", "shorten", "url", link.URL, "error", err)
+			return "", &shortenError{http.StatusServiceUnavailable, "Error looking up existing short URL", errUnavailable}
+		}
+		if code != "" {
+			return code, nil
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		code, err := codeGenerator.Code(ctx, attempt, link.URL, requestAlphabet(req), requestCodeLength(req))
+		if errors.Is(err, errNoFreeCode) {
+			slog.ErrorContext(ctx, "Could not find a free short code", "event", "shorten", "attempts", attempt)
+			return "", &shortenError{http.StatusInternalServerError, "Could not generate a unique short code, please try again", errInternal}
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error generating short code", "event", "shorten", "error", err)
+			return "", &shortenError{http.StatusServiceUnavailable, "Error saving short URL", errUnavailable}
+		}
+		// Save fails with ErrCodeExists if the code is taken, so just try another one
+		code = tenantKey(req.Tenant, code)
+		err = saveCode(ctx, code, link)
+		if err == nil {
+			return code, nil
+		}
+		if errors.Is(err, ErrStoreFull) {
+			return "", errStoreFullShorten
+		}
+		if !errors.Is(err, ErrCodeExists) {
+			slog.ErrorContext(ctx, "Error saving short URL", "event", "shorten", "code", code, "error", err)
+			return "", &shortenError{http.StatusServiceUnavailable, "Error saving short URL", errUnavailable}
+		}
+		if same, err := hashedLinkExists(ctx, code, req, link); err != nil {
+			slog.ErrorContext(ctx, "Error looking up existing short URL", "event", "shorten", "code", code, "error", err)
+			return "", &shortenError{http.StatusServiceUnavailable, "Error looking up existing short URL", errUnavailable}
+		} else if same {
+			return code, nil
+		}
+	}
+}
+
+// errAliasTaken answers a custom alias that's already in use
+var errAliasTaken = &shortenError{http.StatusConflict, "Custom alias is already in use", errConflict}
+
+// existingAlias answers a request for a custom alias that's taken: with
+// reuse_alias the alias is returned if it points at the same URL, which is
+// all that's compared, otherwise it's a conflict
+func existingAlias(ctx context.Context, req ShortenRequest, key string, link Link) (string, *shortenError) {
+	if !req.ReuseAlias {
+		return "", errAliasTaken
+	}
+	existing, err := store.Lookup(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return "", errAliasTaken // Deleted in the meantime, but it was taken when we tried
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error looking up existing custom alias", "event", "shorten", "code", key, "error", err)
+		return "", &shortenError{http.StatusServiceUnavailable, "Error looking up existing short URL", errUnavailable}
+	}
+	if existing.URL != link.URL {
+		return "", &shortenError{http.StatusConflict, "Custom alias is already in use for a different URL", errConflict}
+	}
+	return key, nil
 }
 
-// Initialize random seed
-func init() {
-	rand.Seed(time.Now().UnixNano())
+// hashedLinkExists reports whether code, which is taken, is the hashed code
+// of an earlier plain request for the same URL. That's the point of hashed
+// codes, so it's reused whatever DEDUPE says.
+func hashedLinkExists(ctx context.Context, code string, req ShortenRequest, link Link) (bool, error) {
+	if _, hashed := codeGenerator.(HashGenerator); !hashed || !plainRequest(req) {
+		return false, nil
+	}
+	existing, err := store.Lookup(ctx, code)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil // Removed in the meantime, the next attempt gets a longer code
+	}
+	if err != nil {
+		return false, err
+	}
+	return existing.URL == link.URL && plainLink(existing), nil
 }
 
-// generateShortCode creates a random string of a fixed length
-func generateShortCode() string {
-	b := make([]rune, shortCodeLength)
-	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
+// dryRunCode works out the code saveLink would most likely return, without
+// saving the link. A random code isn't reserved, the real request gets a
+// different one. A sequential code uses up its number, like a collision would.
+func dryRunCode(ctx context.Context, req ShortenRequest, link Link) (string, *shortenError) {
+	if req.CustomAlias != "" {
+		key := tenantKey(req.Tenant, req.CustomAlias)
+		taken, err := store.Exists(ctx, key)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error checking custom alias", "event", "shorten", "code", key, "error", err)
+			return "", &shortenError{http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable}
+		}
+		if taken {
+			return existingAlias(ctx, req, key, link)
+		}
+		return key, nil
+	}
+
+	if canDedupe(req) {
+		code, err := findReusableCode(ctx, req.Tenant, link.URL)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error looking up existing short URL", "event", "shorten", "url", link.URL, "error", err)
+			return "", &shortenError{http.StatusServiceUnavailable, "Error looking up existing short URL", errUnavailable}
+		}
+		if code != "" {
+			return code, nil
+		}
+	}
+
+	code, err := codeGenerator.Code(ctx, 0, link.URL, requestAlphabet(req), requestCodeLength(req))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error generating short code", "event", "shorten", "error", err)
+		return "", &shortenError{http.StatusServiceUnavailable, "Error saving short URL", errUnavailable}
 	}
-	return string(b)
+	return tenantKey(req.Tenant, code), nil
 }
 
-// handleShorten handles requests to shorten a URL
+// handleShorten handles requests to shorten a URL. With ?dry_run=1 it only
+// validates the request and shows what the response would look like.
 func handleShorten(w http.ResponseWriter, r *http.Request) {
 	// CORS middleware handles OPTIONS requests and sets headers,
 	// so we only need to handle POST here.
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
+	shortenRequestsTotal.Inc()
 
 	var req ShortenRequest
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		log.Printf("Error decoding request body: %v", err)
+	if !decodeShortenBody(w, r, &req) {
 		return
 	}
 	defer r.Body.Close()
+	req.CustomAlias = canonicalCode(req.CustomAlias)
+	req.Tenant = requestTenant(r, req.Tenant)
 
-	if req.URL == "" {
-		http.Error(w, "URL cannot be empty", http.StatusBadRequest)
+	link, serr := buildLink(r, req)
+	if serr != nil {
+		writeShortenConflict(w, r, req, serr)
 		return
 	}
 
-	// Basic URL validation (can be more sophisticated)
-	// Note: This is a simple check. A more robust validator is recommended.
-	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
-		http.Error(w, "Invalid URL format (must start with http:// or https://)", http.StatusBadRequest)
+	save := saveLink
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	if dryRun {
+		save = dryRunCode
+	}
+	shortCode, serr := save(r.Context(), req, link)
+	if serr != nil {
+		writeShortenConflict(w, r, req, serr)
 		return
 	}
 
-	mu.Lock() // Lock for writing
-	var shortCode string
-	for {
-		shortCode = generateShortCode()
-		if _, exists := urlStore[shortCode]; !exists { // Ensure code is unique
-			break
-		}
+	// Construct the short URL from BASE_URL (or the request's host if unset)
+	shortenedURL := shortLink(r, shortCode)
+	if !dryRun {
+		notifyWebhook(newShortenEvent(r, shortCode, link.URL))
+		favicons.prefetch(link.URL)
 	}
-	urlStore[shortCode] = req.URL
-	mu.Unlock()
 
-	// Construct the short URL using the expected frontend domain
-	// It's better to get this base URL from an environment variable
-	// in a real deployment (e.g., VERCEL_URL for the frontend).
-	// For now, using the hardcoded Vercel domain from your example.
-	shortenedURL := fmt.Sprintf("https://url-shortener-seven-theta.vercel.app/%s", shortCode)
-
-	resp := ShortenResponse{ShortURL: shortenedURL}
+	resp := ShortenResponse{
+		ShortURL:    shortenedURL,
+		Code:        shortCode,
+		OriginalURL: link.URL,
+		DryRun:      dryRun,
+	}
+	if !dryRun {
+		resp.StatsURL = statsURL(r, shortCode)
+	}
+	// ?include_qr=1 inlines the QR code so clients don't need a second request.
+	// The link already exists, so a rendering failure only drops the QR code.
+	if includeQR, _ := strconv.ParseBool(r.URL.Query().Get("include_qr")); includeQR {
+		qr, err := qrDataURI(shortenedURL)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error generating QR code", "event", "shorten", "code", shortCode, "error", err)
+		}
+		resp.QRCode = qr
+	}
 	w.Header().Set("Content-Type", "application/json")
 	// CORS headers are handled by the middleware now, remove manual setting
 	// w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -102,88 +815,599 @@ func handleShorten(w http.ResponseWriter, r *http.Request) {
 	// w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
-		log.Printf("Error encoding response: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "shorten", "Error encoding response", "error", err)
+		return
 	}
-	log.Printf("Shortened URL: %s -> %s", req.URL, shortenedURL)
+	logRequest(r, http.StatusOK, "shorten", "Shortened URL", "code", shortCode, "url", link.URL, "short_url", shortenedURL, "dry_run", dryRun)
 }
 
 // handleRedirect handles requests to redirect from a short code to the original URL
 func handleRedirect(w http.ResponseWriter, r *http.Request) {
 	// CORS middleware handles OPTIONS requests, so we only need to handle GET here.
 	if r.Method != http.MethodGet {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
 	// Extract the short code from the URL path
-	// r.URL.Path will be like "/ABCDEF"
-	shortCode := strings.TrimPrefix(r.URL.Path, "/")
+	// r.URL.Path will be like "/ABCDEF" (or "/ABCDEF/more" with FORWARD_PATH)
+	// or "/acme/ABCDEF" for a tenant's code
+	shortCode, extraPath := splitTenantPath(r.URL.Path)
+	if r.URL.Path == "/" {
+		// Not a short code: the landing page, if one is configured
+		serveLanding(w, r)
+		return
+	}
 	if shortCode == "" {
-		// If path is just "/", it's not a short code, maybe serve a landing page?
-		// For this example, we'll treat it as not found for a short code.
-		http.NotFound(w, r)
+		serveNotFound(w, r, shortCode)
+		return
+	}
+
+	link, err := lookupLink(r.Context(), shortCode)
+	if errors.Is(err, ErrNotFound) {
+		serveNotFound(w, r, shortCode)
+		redirectMissesTotal.Inc()
+		logRequest(r, http.StatusNotFound, "redirect", "Short code not found", "code", shortCode)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "redirect", "Error looking up short code", "code", shortCode, "error", err)
+		return
+	}
+
+	if writeLinkGone(w, r, "redirect", shortCode, link) {
 		return
 	}
 
-	mu.RLock() // Lock for reading
-	longURL, exists := urlStore[shortCode]
-	mu.RUnlock()
+	// Protected links ask for their password first, see handleUnlock
+	if link.PasswordHash != "" {
+		servePasswordPrompt(w, r, shortCode, extraPath, http.StatusUnauthorized)
+		logRequest(r, http.StatusUnauthorized, "redirect", "Password required", "code", shortCode)
+		return
+	}
+
+	// ?preview=1 shows the destination with a "continue" link instead of
+	// redirecting right away. Previews aren't counted as clicks.
+	if preview, _ := strconv.ParseBool(r.URL.Query().Get("preview")); preview {
+		servePreview(w, r, shortCode, link)
+		return
+	}
 
-	if !exists {
-		http.NotFound(w, r)
-		log.Printf("Short code not found: %s", shortCode)
+	link, ok := countVisit(w, r, "redirect", shortCode, link)
+	if !ok {
 		return
 	}
 
-	// Perform the redirect
-	http.Redirect(w, r, longURL, http.StatusFound) // 302 Found redirect
-	log.Printf("Redirected %s to %s", shortCode, longURL)
+	// Perform the redirect, 302 Found unless configured (or asked per link) to be permanent
+	status := redirectStatus
+	if link.Permanent {
+		status = http.StatusMovedPermanently
+	}
+	destination := forwardedDestination(linkDestination(r, link), extraPath, r.URL.Query())
+	setRedirectCacheHeaders(w, link, status, time.Now())
+	// Browsers and API clients get different answers, caches must tell them apart
+	w.Header().Add("Vary", "Accept")
+	if wantsJSON(r) {
+		// API clients that follow redirects themselves get the destination
+		// as JSON, still counted as a visit
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(RedirectResponse{URL: destination}); err != nil {
+			logRequest(r, http.StatusInternalServerError, "redirect", "Error encoding response", "error", err)
+			return
+		}
+		redirectsTotal.Inc()
+		logRequest(r, http.StatusOK, "redirect", "Resolved as JSON", "code", shortCode, "url", destination)
+		return
+	}
+	// Browsers get the countdown page first if the link (or REDIRECT_DELAY) asks for one
+	if delay := linkRedirectDelay(link); delay > 0 {
+		serveDelayPage(w, r, shortCode, destination, delay)
+		return
+	}
+	http.Redirect(w, r, destination, status)
+	redirectsTotal.Inc()
+	logRequest(r, status, "redirect", "Redirected", "code", shortCode, "url", destination)
+}
+
+// writeLinkGone answers with 410 Gone if the link was disabled or has
+// expired, and reports whether it did
+func writeLinkGone(w http.ResponseWriter, r *http.Request, event, code string, link Link) bool {
+	// Disabled links are kept, so they can be turned back on
+	if link.Disabled {
+		writeJSONError(w, http.StatusGone, "Short URL has been disabled", errDisabled)
+		logRequest(r, http.StatusGone, event, "Short code disabled", "code", code)
+		return true
+	}
+
+	// Expired links stay around until the sweeper removes them,
+	// tell clients they're gone for good rather than missing
+	if link.Expired(time.Now()) {
+		message := "Short URL has expired"
+		if visitsUsedUp(link) {
+			message = "Short URL has no visits left"
+		}
+		writeJSONError(w, http.StatusGone, message, errExpired)
+		logRequest(r, http.StatusGone, event, "Short code expired", "code", code)
+		return true
+	}
+	return false
+}
+
+// countVisit records a click and its analytics. Visits to one-time and
+// max_clicks links are claimed with claimLimitedVisit, which can turn the
+// visit away: then ok is false and the response has been written. For
+// other links a failure here shouldn't stop the redirect, so it's only logged.
+// With SKIP_BOT_CLICKS, bots visiting other links aren't counted at all.
+func countVisit(w http.ResponseWriter, r *http.Request, event, code string, link Link) (Link, bool) {
+	if limitedVisits(link) {
+		// Still claimed for bots: the User-Agent is easy to fake, and
+		// skipping it would let anyone past a one-time or max_clicks limit
+		var ok bool
+		if link, ok = claimLimitedVisit(w, r, event, code); !ok {
+			return Link{}, false
+		}
+	} else if skipBotClicks && isBot(r.UserAgent()) {
+		botVisitsTotal.Inc()
+		return link, true
+	} else if _, err := store.IncrementClicks(r.Context(), code); err != nil {
+		slog.ErrorContext(r.Context(), "Error counting click", "event", event, "code", code, "error", err)
+	}
+	analytics.Record(code, r)
+	notifyWebhook(newVisitEvent(r, code, link))
+	return link, true
+}
+
+// How long clients and proxies may cache a permanent redirect
+const permanentRedirectMaxAge = time.Hour
+
+// setRedirectCacheHeaders tells caches when the link was created and whether
+// they may reuse the redirect. Temporary redirects are revalidated every time,
+// so each visit is counted. Permanent ones can be cached, but not past expiry.
+func setRedirectCacheHeaders(w http.ResponseWriter, link Link, status int, now time.Time) {
+	if len(link.Devices) > 0 {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	if !link.CreatedAt.IsZero() {
+		w.Header().Set("Last-Modified", link.CreatedAt.UTC().Format(http.TimeFormat))
+	}
+	if status != http.StatusMovedPermanently {
+		w.Header().Set("Cache-Control", "private, no-cache")
+		return
+	}
+	// Where a geo or device link goes depends on who asks, shared caches can't reuse it
+	visibility := "public"
+	if len(link.Geo) > 0 || len(link.Devices) > 0 {
+		visibility = "private"
+	}
+	maxAge := permanentRedirectMaxAge
+	if !link.ExpiresAt.IsZero() {
+		maxAge = min(maxAge, link.ExpiresAt.Sub(now))
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, int(maxAge.Seconds())))
+}
+
+// statsETag derives an entity tag from the encoded stats. Any change,
+// like a new click, changes the body and so the tag.
+func statsETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag.
+// It's a list of tags (weak ones prefixed with W/) or "*".
+func etagMatches(ifNoneMatch, etag string) bool {
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// handleStats returns the click count for a short code
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	shortCode := canonicalCode(r.PathValue("code"))
+	// Before the lookup, so codes can't be probed for without a token
+	if !authorizeStats(w, r, shortCode) {
+		return
+	}
+	link, err := lookupLink(r.Context(), shortCode)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		logRequest(r, http.StatusNotFound, "stats", "Short code not found", "code", shortCode)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "stats", "Error looking up short code", "code", shortCode, "error", err)
+		return
+	}
+
+	resp := newStatsResponse(shortCode, link)
+	body, err := json.Marshal(resp)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "stats", "Error encoding response", "error", err)
+		return
+	}
+
+	// Dashboards poll this, let them revalidate cheaply with If-None-Match
+	etag := statsETag(body)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
+// sweepExpired periodically removes expired links from the store
+// so they don't pile up forever. It returns when ctx is cancelled.
+func sweepExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		removed, err := store.DeleteExpired(ctx, start)
+		if ctx.Err() != nil {
+			return // Shutting down mid-sweep, the next start picks it up
+		}
+		if err != nil {
+			sweepErrorsTotal.Inc()
+			slog.Error("Error sweeping expired links", "event", "sweep", "error", err)
+			continue
+		}
+		linksSweptTotal.Add(float64(removed))
+		// Every cycle is logged, the quiet ones only at debug level
+		level := slog.LevelDebug
+		if removed > 0 {
+			level = slog.LevelInfo
+		}
+		slog.Log(ctx, level, "Swept expired links", "event", "sweep", "removed", removed, "duration", time.Since(start).String())
+	}
 }
 
 func main() {
-	// Define your allowed origins (the domains your frontend will be hosted on)
-	// This should include your Vercel production domain, preview domains, and localhost for dev.
-	// Reading this from an environment variable in Railway is a good practice for production.
-	allowedOrigins := []string{
-		"http://localhost:3000",                                  // For local Next.js development
-		"https://url-shortener-rayen-saids-projects.vercel.app/", // Your Vercel production domain
-		// Add other Vercel preview domains if needed, or use a wildcard cautiously in dev/staging
-		// e.g., "https://*-url-shortener-seven-theta.vercel.app" // Wildcard for preview deployments (use with caution)
-	}
-
-	// Configure the CORS middleware
-	c := cors.New(cors.Options{
-		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"}, // Only need methods used by frontend for API calls and redirects
-		AllowedHeaders:   []string{"Content-Type"},           // Only need headers your frontend sends for API calls
-		AllowCredentials: true,                               // Set to true if your frontend sends cookies or auth headers
+	// Settings come from CONFIG_FILE and the environment, parsed once here
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+
+	if err := setupLogger(cfg.LogLevel, cfg.LogFormat); err != nil {
+		fatal("Could not set up logging", "error", err)
+	}
+	accessLog = cfg.AccessLog
+
+	// Tracing is a no-op unless an OTLP endpoint is configured
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		fatal("Could not set up tracing", "error", err)
+	}
+
+	store, err = newStore(cfg)
+	if err != nil {
+		fatal("Could not initialize storage", "error", err)
+	}
+	// Kept to write snapshots of, see SNAPSHOT_PATH
+	memory, _ := store.(*MemoryStore)
+	// A slow backend fails single requests instead of hanging them
+	store = withStoreTimeout(store, time.Duration(cfg.StoreTimeout))
+	// Busy links cost a write per interval rather than one per redirect
+	store = withClickBuffer(store, time.Duration(cfg.ClickFlushInterval), cfg.ClickFlushThreshold)
+
+	// The code length trades shorter URLs against a higher collision probability
+	shortCodeLength = cfg.CodeLength
+	// A 301 redirect status makes all redirects permanent (better for SEO)
+	redirectStatus = cfg.RedirectStatus
+	// The URL length cap also bounds request body sizes
+	maxURLLength = cfg.MaxURLLength
+
+	// Reserved codes add to the built-in ones, which keep our routes reachable
+	reservedCodes = newReservedCodes(cfg.ReservedCodes)
+
+	// Tenant names become path prefixes, so they can't be codes themselves
+	tenants, err = newTenants(cfg.Tenants)
+	if err != nil {
+		fatal("Invalid TENANTS", "error", err)
+	}
+	for tenant := range tenants {
+		reservedCodes[tenant] = true
+	}
+
+	// Case insensitive codes are lowercase, so "AbC123" and "abc123" are
+	// the same link. Existing codes with uppercase letters become unreachable,
+	// so only turn it on for a fresh store.
+	caseInsensitive = cfg.CaseInsensitive
+	// Off by default: lookalike characters from other scripts make it easy
+	// to create aliases that pass for someone else's
+	unicodeAliases = cfg.UnicodeAliases
+	letterRunes, _ = codeAlphabet(cfg.CodeAlphabet) // Checked by validate
+	codeGenerator = codeGenerators[cfg.CodeStrategy]
+	codeLoadWarning = cfg.CodeLoadWarning
+	redirectDelay = time.Duration(cfg.RedirectDelay)
+	analytics.sampleRate = cfg.AnalyticsSampleRate
+	skipBotClicks = cfg.SkipBotClicks
+	trustedProxies = cfg.TrustedProxies
+
+	// An HTML page shown for unknown codes instead of the JSON error
+	if cfg.NotFoundTemplate != "" {
+		notFoundTemplate, err = loadNotFoundTemplate(cfg.NotFoundTemplate)
+		if err != nil {
+			fatal("Invalid NOT_FOUND_TEMPLATE", "error", err)
+		}
+	}
+
+	// What / shows instead of a 404
+	if cfg.LandingPage != "" {
+		if err := loadLandingPage(cfg.LandingPage); err != nil {
+			fatal("Invalid LANDING_PAGE", "error", err)
+		}
+	}
+	if cfg.RobotsTXT != "" {
+		if err := loadRobotsTxt(cfg.RobotsTXT); err != nil {
+			fatal("Invalid ROBOTS_TXT", "error", err)
+		}
+	}
+
+	// The blocklist and blocklist file name domains that can't be shortened
+	blocklist, err := loadBlocklist(cfg.Blocklist, cfg.BlocklistFile)
+	if err != nil {
+		fatal("Could not load blocklist", "error", err)
+	}
+	var checkers multiChecker
+	if blocklist != nil {
+		checkers = append(checkers, blocklist)
+		slog.Info("URL blocklist loaded", "hosts", len(blocklist.hosts))
+	}
+	// Refuse destinations on internal addresses (SSRF guard). Off by
+	// default, as internal deployments may shorten intranet links.
+	if cfg.BlockPrivateHosts {
+		checkers = append(checkers, privateHostChecker{resolver: net.DefaultResolver})
+	}
+	if len(checkers) > 0 {
+		urlChecker = checkers
+	}
+
+	// Dedupe returns the same code when a URL is shortened twice
+	dedupe = cfg.Dedupe
+	// URLs are normalized by default, turning it off keeps them exactly as submitted
+	normalizeURLs = cfg.NormalizeURLs
+	stripTracking = cfg.StripTrackingParams
+	// Off by default: redirects go exactly to the stored URL
+	forwardPath, forwardQuery = cfg.ForwardPath, cfg.ForwardQuery
+	upgradeHTTPS = cfg.UpgradeHTTPS
+	// Off by default, it makes shortening wait on other shorteners
+	expandShortURLs = cfg.ExpandShortURLs
+	fetchFavicons = cfg.FetchFavicons
+	fetchPreviews = cfg.FetchPreviews
+
+	// Geo rules need to know where visitors are, without a database they're ignored
+	if cfg.GeoIPDB != "" {
+		geoIP, err := openGeoIP(cfg.GeoIPDB)
+		if err != nil {
+			fatal("Could not open GEOIP_DB", "error", err)
+		}
+		defer geoIP.Close()
+		countryResolver = geoIP
+	}
+
+	// With a secret, stats need a signed token from the shorten response
+	if cfg.StatsSecret != "" {
+		statsSecret = []byte(cfg.StatsSecret)
+	}
+
+	// Short links are built from the base URL, e.g. "https://sho.rt"
+	if cfg.BaseURL != "" {
+		baseURL, err = parseBaseURL(cfg.BaseURL)
+		if err != nil {
+			fatal("Could not parse BASE_URL", "error", err)
+		}
+	}
+
+	// Creating links and admin endpoints (like listing all links) require one of these keys
+	apiKeys = parseAPIKeys(strings.Join(cfg.APIKeys, ","))
+
+	handler, err := newRouter(cfg)
+	if err != nil {
+		fatal("Invalid ALLOWED_ORIGINS", "error", err)
+	}
+
+	// Railway provides the port in PORT, it defaults to 8080 for local testing
+	listenAddr := fmt.Sprintf(":%s", cfg.Port)
+
+	// Cancelled on SIGINT/SIGTERM (Railway sends SIGTERM on redeploy)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Clean up expired links in the background
+	if cfg.SweepInterval > 0 {
+		go sweepExpired(ctx, time.Duration(cfg.SweepInterval))
+	}
+	// Save the memory store now and then, not only on shutdown, so a crash loses less
+	if memory != nil && cfg.SnapshotPath != "" && cfg.SnapshotInterval > 0 {
+		go memory.snapshotPeriodically(ctx, time.Duration(cfg.SnapshotInterval))
+	}
+	// and, if asked to, check their destinations
+	if cfg.LinkCheckInterval > 0 {
+		go checkLinksPeriodically(ctx, time.Duration(cfg.LinkCheckInterval))
+	}
+	// Keep an eye on how full the random code space is getting
+	go checkCodeLoadPeriodically(ctx)
+	// Webhook events are sent from their own goroutine, see notifyWebhook
+	if cfg.WebhookURL != "" {
+		webhooks = newWebhookSender(cfg.WebhookURL, cfg.WebhookEvents)
+		go webhooks.run()
+	}
+
+	// Use the wrapped handler here
+	server := newServer(listenAddr, handler, cfg)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- listenAndServe(server, cfg)
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("Could not start server", "error", err)
+	case <-ctx.Done():
+	}
+
+	// Stop accepting new connections and let in-flight requests drain
+	slog.Info("Shutdown signal received, draining connections", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error during server shutdown", "error", err)
+	} else {
+		slog.Info("All connections drained")
+	}
+	// No more events can come in, send the ones still queued
+	if webhooks != nil {
+		webhooks.stop(shutdownCtx)
+	}
+
+	// Flush and close the store last, once no handler can use it anymore
+	if err := store.Close(); err != nil {
+		slog.Error("Error closing storage", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	slog.Info("Shutdown complete")
+}
+
+// newServer configures the HTTP server with the timeouts from cfg, so slow
+// clients can't hold connections open forever
+func newServer(addr string, handler http.Handler, cfg Config) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+	}
+}
+
+// newRouter builds the handler serving every endpoint, with its
+// middleware, from the settings main doesn't keep in globals
+func newRouter(cfg Config) (http.Handler, error) {
+	// Limit how fast a single IP can create links, RateLimit requests
+	// per minute (0 disables it) with bursts of up to RateLimitBurst
+	rateLimit, rateBurst := cfg.RateLimit, cfg.RateLimitBurst
+	if rateBurst == 0 {
+		rateBurst = rateLimit // Default to allowing a full minute's worth at once
+	}
+
+	// How long a repeated Idempotency-Key replays the first response
+	idempotencyTTL := time.Duration(cfg.IdempotencyTTL)
+
+	// The allowed origins are the domains your frontend is hosted on. Set
+	// ALLOWED_ORIGINS in Railway to add preview deployments, e.g.
+	// "https://*-url-shortener-seven-theta.vercel.app" (use wildcards with caution).
+	allowedOrigins, err := newOriginMatcher(cfg.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CORSCredentials {
+		for _, origin := range cfg.AllowedOrigins {
+			if anyHostOrigin(origin) {
+				slog.Warn("ALLOWED_ORIGINS lets any site make credentialed requests, list the origins instead", "event", "cors", "origin", origin)
+			}
+		}
+	}
+
+	// Configure the CORS middleware. Allowed origins are echoed back in
+	// Access-Control-Allow-Origin (with Vary: Origin), as credentials
+	// require; only "*" without credentials sends a literal "*".
+	corsOptions := cors.Options{
+		AllowOriginFunc:  allowedOrigins.Allowed,
+		AllowedMethods:   []string{"GET", "POST", "PATCH", "PUT", "DELETE", "OPTIONS"},                                               // The methods the API answers, PATCH, PUT and DELETE for /links/{code}
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Idempotency-Key", "If-None-Match", "X-Tenant", requestIDHeader}, // Only need headers your frontend sends for API calls
+		ExposedHeaders:   []string{"ETag", requestIDHeader},                                                                          // Let browser clients revalidate stats and report request IDs
+		AllowCredentials: cfg.CORSCredentials,                                                                                        // CORS_CREDENTIALS, on if your frontend sends cookies or auth headers
 		// Debug: true, // Uncomment in development to see CORS logs
-	})
+	}
+	if allowedOrigins.any {
+		corsOptions.AllowOriginFunc, corsOptions.AllowedOrigins = nil, []string{"*"}
+	}
+	c := cors.New(corsOptions)
 
 	// Create your main router
 	router := http.NewServeMux()
 
 	// Register your handlers with the router
-	router.HandleFunc("/shorten", handleShorten) // POST to create a short URL
+	var shorten, shortenBatch http.Handler = http.HandlerFunc(handleShorten), http.HandlerFunc(handleShortenBatch)
+	// Retries with the same Idempotency-Key get the first response back.
+	// This sits inside auth, so only the same API key can replay it.
+	idempotency := newIdempotencyCache(idempotencyTTL)
+	shorten, shortenBatch = idempotency.Middleware(shorten), idempotency.Middleware(shortenBatch)
+	// With API_KEYS set, creating links needs a key too. Redirects stay public.
+	if len(apiKeys) > 0 {
+		shorten, shortenBatch = requireAPIKey(shorten), requireAPIKey(shortenBatch)
+	} else {
+		slog.Warn("API_KEYS is not set: anyone can create links and admin endpoints are disabled")
+	}
+	// Rate limiting wraps auth so it also slows down key guessing,
+	// and password guessing on /unlock
+	var unlock http.Handler = http.HandlerFunc(handleUnlock)
+	if rateLimit > 0 {
+		limiter := newRateLimiter(rateLimit, rateBurst)
+		shorten, shortenBatch = limiter.Middleware(shorten), limiter.Middleware(shortenBatch)
+		unlock = limiter.Middleware(unlock)
+	}
+	router.Handle("/shorten", instrument("shorten", shorten))                                                        // POST to create a short URL
+	router.Handle("/shorten/batch", instrument("shorten_batch", shortenBatch))                                       // POST to create many short URLs at once
+	router.Handle("/stats", instrument("stats_summary", http.HandlerFunc(handleStatsSummary)))                       // GET service wide totals
+	router.Handle("/stats/{code}", instrument("stats", http.HandlerFunc(handleStats)))                               // GET click stats for a short code
+	router.Handle("/stats/{code}/detail", instrument("stats_detail", http.HandlerFunc(handleStatsDetail)))           // GET referrer and browser breakdowns
+	router.Handle("/resolve/{code}", instrument("resolve", http.HandlerFunc(handleResolve)))                         // GET where a short code points, without redirecting
+	router.Handle("/qr/{code}", instrument("qr", http.HandlerFunc(handleQR)))                                        // GET a QR code image for a short link
+	router.Handle("/favicon/{code}", instrument("favicon", http.HandlerFunc(handleFavicon)))                         // GET the destination site's icon
+	router.Handle("/preview/{code}", instrument("preview", http.HandlerFunc(handlePreview)))                         // GET the destination's Open Graph title, description and image
+	router.Handle("/unlock/{code}", instrument("unlock", unlock))                                                    // POST the password of a protected link
+	router.Handle("/unlock/{code}/{rest...}", instrument("unlock", unlock))                                          // The same with a path to forward, see FORWARD_PATH
+	router.Handle("/links", instrument("links", requireAPIKey(http.HandlerFunc(handleListLinks))))                   // GET all links (admin)
+	router.Handle("/campaigns/{name}", instrument("campaign", requireAPIKey(http.HandlerFunc(handleCampaign))))      // GET a campaign's clicks (admin)
+	router.Handle("/links/{code}", instrument("link", requireAPIKey(http.HandlerFunc(handleLink))))                  // PATCH to disable a link, PUT to change its URL, DELETE it (admin)
+	router.Handle("/links/by-url", instrument("links_by_url", requireAPIKey(http.HandlerFunc(handleLinksByURL))))    // GET the codes of a destination (admin)
+	router.Handle("/links/delete", instrument("delete_links", requireAPIKey(http.HandlerFunc(handleDeleteLinks))))   // POST codes to delete at once (admin)
+	router.Handle("/links/{code}/check", instrument("link_check", requireAPIKey(http.HandlerFunc(handleCheckLink)))) // POST to check a link's destination now (admin)
+	router.Handle("/export.csv", instrument("export", requireAPIKey(http.HandlerFunc(handleExportCSV))))             // GET all links as CSV (admin)
+	router.Handle("/import", instrument("import", requireAPIKey(http.HandlerFunc(handleImport))))                    // POST links from a CSV or JSON export (admin)
+	router.Handle("/admin/flush", instrument("flush", requireAPIKey(http.HandlerFunc(handleFlush))))                 // POST to delete every link (admin)
+	router.Handle("/healthz", http.HandlerFunc(handleHealth))                                                        // GET liveness and storage check
+	router.Handle("/ping", http.HandlerFunc(handlePing))                                                             // GET server time and version
+	router.Handle("/openapi.json", http.HandlerFunc(handleOpenAPI))                                                  // GET the API spec
+	router.Handle("/robots.txt", http.HandlerFunc(handleRobotsTxt))                                                  // GET crawler rules
+	router.Handle("/metrics", promhttp.Handler())                                                                    // Prometheus scrape endpoint
 	// The root path "/" will be handled by handleRedirect for short codes
-	router.HandleFunc("/", handleRedirect) // GET /<shortCode> to redirect
+	router.Handle("/", instrument("redirect", http.HandlerFunc(handleRedirect))) // GET /<shortCode> to redirect
 
 	// Wrap your router with the CORS middleware
 	// This is the key change: http.ListenAndServe will now use the handler
 	// provided by the CORS middleware, which wraps your router.
-	handler := c.Handler(router)
-
-	// Get the port from the environment variable provided by Railway
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // Default port if PORT is not set (e.g., for local testing outside Railway)
-	}
-	listenAddr := fmt.Sprintf(":%s", port)
-
-	log.Printf("Starting URL shortener service on %s", listenAddr)
-	// Use the wrapped handler here
-	if err := http.ListenAndServe(listenAddr, handler); err != nil {
-		log.Fatalf("Could not start server: %s\n", err)
-	}
+	// Compression sits outside CORS so preflight responses pass through untouched.
+	// Tracing goes right outside them: otelhttp names spans after the route the
+	// router matched, which it only sees if nothing in between copies the request.
+	// The access log wraps those, so it sees the final status and size.
+	// The request ID is outermost, so every response and log line has one.
+	return withRequestID(withAccessLog(otelhttp.NewHandler(withGzip(c.Handler(router)), "url-shortener"))), nil
 }
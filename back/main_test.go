@@ -0,0 +1,769 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain keeps request logs out of the test output
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// setForTest sets *p to v until the test ends. Handlers read their
+// settings from globals, like main sets them.
+func setForTest[T any](t testing.TB, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// useMemoryStore makes handlers use a fresh MemoryStore until the test ends
+func useMemoryStore(t testing.TB) *MemoryStore {
+	t.Helper()
+	s := NewMemoryStore(0)
+	setForTest(t, &store, Store(s))
+	return s
+}
+
+// saveTestLink stores link under code, failing the test if it can't
+func saveTestLink(t testing.TB, code string, link Link) {
+	t.Helper()
+	if err := store.Save(t.Context(), code, link); err != nil {
+		t.Fatalf("Save(%q): %v", code, err)
+	}
+}
+
+// serve runs r through a mux with handler registered at pattern, so path
+// values like {code} are set as in main
+func serve(pattern string, handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle(pattern, handler)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, r)
+	return rec
+}
+
+// jsonRequest builds a request with a JSON body
+func jsonRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// postShorten sends a JSON body to handleShorten. Requests are for
+// example.com, so destinations must be on another host.
+func postShorten(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleShorten(rec, jsonRequest(http.MethodPost, "/shorten", body))
+	return rec
+}
+
+// decodeJSON decodes the body of rec, failing the test if it isn't JSON
+func decodeJSON[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+// shortenOK shortens body and returns the response, failing the test unless it's a 200
+func shortenOK(t *testing.T, body string) ShortenResponse {
+	t.Helper()
+	rec := postShorten(t, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("shorten %s: status %d, body %s", body, rec.Code, rec.Body)
+	}
+	return decodeJSON[ShortenResponse](t, rec)
+}
+
+func TestShortenResponseCode(t *testing.T) {
+	useMemoryStore(t)
+
+	resp := shortenOK(t, `{"url": "https://golang.org/doc"}`)
+	if resp.Code == "" {
+		t.Fatalf("code is empty: %+v", resp)
+	}
+	if !strings.HasSuffix(resp.ShortURL, "/"+resp.Code) {
+		t.Errorf("short_url %q doesn't end with the code %q", resp.ShortURL, resp.Code)
+	}
+	if resp.OriginalURL != "https://golang.org/doc" {
+		t.Errorf("original_url = %q, want https://golang.org/doc", resp.OriginalURL)
+	}
+
+	// A custom alias is the code too
+	resp = shortenOK(t, `{"url": "https://golang.org/doc", "custom_alias": "go-docs"}`)
+	if resp.Code != "go-docs" || !strings.HasSuffix(resp.ShortURL, "/go-docs") {
+		t.Errorf("custom alias: code %q, short_url %q, want go-docs", resp.Code, resp.ShortURL)
+	}
+}
+
+func TestShortURLUsesBaseURL(t *testing.T) {
+	useMemoryStore(t)
+	t.Setenv("BASE_URL", "https://sho.rt/")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := parseBaseURL(cfg.BaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &baseURL, base)
+
+	resp := shortenOK(t, `{"url": "https://golang.org/doc"}`)
+	if want := "https://sho.rt/" + resp.Code; resp.ShortURL != want {
+		t.Errorf("short_url = %q, want %q", resp.ShortURL, want)
+	}
+}
+
+func TestShortURLWithoutBaseURL(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &baseURL, "")
+
+	// Built from the request, which httptest sends to example.com
+	resp := shortenOK(t, `{"url": "https://golang.org/doc"}`)
+	if want := "http://example.com/" + resp.Code; resp.ShortURL != want {
+		t.Errorf("short_url = %q, want %q", resp.ShortURL, want)
+	}
+}
+
+func TestParseBaseURL(t *testing.T) {
+	for _, raw := range []string{"sho.rt", "/links", "ftp://sho.rt", "https://"} {
+		if _, err := parseBaseURL(raw); err == nil {
+			t.Errorf("parseBaseURL(%q) accepted it", raw)
+		}
+	}
+	if got, err := parseBaseURL("https://sho.rt/go//"); err != nil || got != "https://sho.rt/go" {
+		t.Errorf(`parseBaseURL("https://sho.rt/go//") = %q, %v, want https://sho.rt/go`, got, err)
+	}
+}
+
+// getRedirect sends a browser-like GET for path to handleRedirect
+func getRedirect(path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleRedirect(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestRedirectStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int // REDIRECT_STATUS
+		permanent bool
+		want      int
+	}{
+		{"default", http.StatusFound, false, http.StatusFound},
+		{"configured permanent", http.StatusMovedPermanently, false, http.StatusMovedPermanently},
+		{"permanent link", http.StatusFound, true, http.StatusMovedPermanently},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			setForTest(t, &redirectStatus, tt.status)
+			saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc", Permanent: tt.permanent})
+
+			rec := getRedirect("/abc123")
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Location"); got != "https://golang.org/doc" {
+				t.Errorf("Location = %q, want https://golang.org/doc", got)
+			}
+		})
+	}
+}
+
+func TestRedirectStatusPerRequest(t *testing.T) {
+	useMemoryStore(t)
+
+	resp := shortenOK(t, `{"url": "https://golang.org/doc", "permanent": true}`)
+	if rec := getRedirect("/" + resp.Code); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("permanent link: status = %d, want 301", rec.Code)
+	}
+	resp = shortenOK(t, `{"url": "https://golang.org/pkg"}`)
+	if rec := getRedirect("/" + resp.Code); rec.Code != http.StatusFound {
+		t.Errorf("plain link: status = %d, want 302", rec.Code)
+	}
+}
+
+// storeSize returns how many links the store has
+func storeSize(t *testing.T) int {
+	t.Helper()
+	_, total, err := store.List(t.Context(), ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
+
+func TestConfiguredCodeLength(t *testing.T) {
+	useMemoryStore(t)
+	t.Setenv("CODE_LENGTH", "9")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &shortCodeLength, cfg.CodeLength)
+
+	for i := range 20 {
+		if resp := shortenOK(t, fmt.Sprintf(`{"url": "https://golang.org/doc/%d"}`, i)); len(resp.Code) != 9 {
+			t.Fatalf("code %q has %d characters, want 9", resp.Code, len(resp.Code))
+		}
+	}
+
+	for _, length := range []string{"3", "33", "-1"} {
+		t.Setenv("CODE_LENGTH", length)
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("CODE_LENGTH=%s was accepted", length)
+		}
+	}
+}
+
+func TestShortenLength(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &shortCodeLength, 7)
+	for _, length := range []int{minCodeLength, minCodeLength + 1, defaultCodeLength, maxCodeLength - 1, maxCodeLength} {
+		for i := range 5 {
+			resp := shortenOK(t, fmt.Sprintf(`{"url": "https://golang.org/doc/%d/%d", "length": %d}`, length, i, length))
+			if len(resp.Code) != length {
+				t.Errorf("length %d: code %q", length, resp.Code)
+			}
+			if link, err := store.Lookup(t.Context(), resp.Code); err != nil || link.URL != fmt.Sprintf("https://golang.org/doc/%d/%d", length, i) {
+				t.Errorf("length %d: Lookup(%q) = %+v, %v", length, resp.Code, link, err)
+			}
+		}
+	}
+	// Left out (or 0), the configured length
+	for _, body := range []string{`{"url": "https://golang.org/x"}`, `{"url": "https://golang.org/y", "length": 0}`} {
+		if resp := shortenOK(t, body); len(resp.Code) != 7 {
+			t.Errorf("%s: code %q, want CODE_LENGTH 7", body, resp.Code)
+		}
+	}
+
+	before := storeSize(t)
+	for _, tt := range []struct{ body, message string }{
+		{fmt.Sprintf(`{"url": "https://golang.org/doc", "length": %d}`, minCodeLength-1), "Invalid length"},
+		{fmt.Sprintf(`{"url": "https://golang.org/doc", "length": %d}`, maxCodeLength+1), "Invalid length"},
+		{`{"url": "https://golang.org/doc", "length": -6}`, "Invalid length"},
+		{`{"url": "https://golang.org/doc", "length": "6"}`, `Invalid value for "length"`},
+		{`{"url": "https://golang.org/doc", "length": 8, "custom_alias": "my-alias"}`, "custom_alias"},
+	} {
+		rec := postShorten(t, tt.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", tt.body, rec.Code)
+			continue
+		}
+		if got := decodeJSON[ErrorResponse](t, rec).Error; !strings.Contains(got, tt.message) {
+			t.Errorf("%s: error %q, want it to mention %q", tt.body, got, tt.message)
+		}
+	}
+	if got := storeSize(t); got != before {
+		t.Errorf("%d links saved from invalid lengths", got-before)
+	}
+}
+
+// getStats sends GET target (a /stats/{code} path) to handleStats
+func getStats(target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	return serve("/stats/{code}", handleStats, r)
+}
+
+func TestCreatedAt(t *testing.T) {
+	useMemoryStore(t)
+	before := time.Now().Truncate(time.Second) // Stored to the second
+	generated := shortenOK(t, `{"url": "https://golang.org/doc"}`)
+	alias := shortenOK(t, `{"url": "https://golang.org/pkg", "custom_alias": "go-pkg"}`)
+	after := time.Now()
+
+	for _, code := range []string{generated.Code, alias.Code} {
+		link, err := store.Lookup(t.Context(), code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link.CreatedAt.Before(before) || link.CreatedAt.After(after) {
+			t.Errorf("%s: created_at %v, want between %v and %v", code, link.CreatedAt, before, after)
+		}
+
+		rec := getStats("/stats/"+code, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("stats: status %d", rec.Code)
+		}
+		if stats := decodeJSON[StatsResponse](t, rec); !stats.CreatedAt.Equal(link.CreatedAt) {
+			t.Errorf("%s: stats created_at %v, want %v", code, stats.CreatedAt, link.CreatedAt)
+		}
+	}
+
+	list := decodeJSON[LinkListResponse](t, listLinks(""))
+	for _, info := range list.Links {
+		if info.CreatedAt.Before(before) || info.CreatedAt.After(after) {
+			t.Errorf("%s: listed created_at %v", info.Code, info.CreatedAt)
+		}
+	}
+}
+
+func TestShortenRejectsSelfReferences(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &baseURL, "https://sho.rt")
+
+	for _, u := range []string{
+		"https://sho.rt/abc123",
+		"https://SHO.RT/abc123",
+		"http://sho.rt",
+		"https://sho.rt./abc123", // Trailing dot, same host
+		"https://sho.rt:8443/abc123",
+	} {
+		rec := postShorten(t, fmt.Sprintf(`{"url": %q}`, u))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", u, rec.Code)
+			continue
+		}
+		if body := decodeJSON[ErrorResponse](t, rec); body.Code != errInvalidURL || !strings.Contains(body.Error, "shortener") {
+			t.Errorf("%s: error %+v", u, body)
+		}
+	}
+
+	for _, u := range []string{"https://golang.org/doc", "https://sho.rt.example/abc", "https://www.sho.rt/abc"} {
+		if rec := postShorten(t, fmt.Sprintf(`{"url": %q}`, u)); rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", u, rec.Code)
+		}
+	}
+
+	// Without BASE_URL, the host the request came in on
+	setForTest(t, &baseURL, "")
+	if rec := postShorten(t, `{"url": "https://example.com/abc123"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("request host: status %d, want 400", rec.Code)
+	}
+}
+
+func TestShortenURLLengthLimit(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &maxURLLength, 100)
+	prefix := "https://golang.org/"
+	atLimit := prefix + strings.Repeat("a", 100-len(prefix))
+
+	if rec := postShorten(t, fmt.Sprintf(`{"url": %q}`, atLimit)); rec.Code != http.StatusOK {
+		t.Errorf("at the limit: status %d, want 200", rec.Code)
+	}
+	rec := postShorten(t, fmt.Sprintf(`{"url": %q}`, atLimit+"a"))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("over the limit: status %d, want 400", rec.Code)
+	}
+	if body := decodeJSON[ErrorResponse](t, rec); body.Code != errInvalidURL || !strings.Contains(body.Error, "100") {
+		t.Errorf("over the limit: error %+v, want the limit stated", body)
+	}
+
+	// Bodies are cut off before being decoded in full
+	huge := fmt.Sprintf(`{"url": %q}`, prefix+strings.Repeat("a", int(maxShortenBodySize())))
+	rec = postShorten(t, huge)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("huge body: status %d, want 413", rec.Code)
+	}
+	if body := decodeJSON[ErrorResponse](t, rec); body.Code != errTooLarge {
+		t.Errorf("huge body: error %+v", body)
+	}
+}
+
+func TestStatsETag(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc"})
+
+	first := getStats("/stats/abc123", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first: status %d, ETag %q", first.Code, etag)
+	}
+
+	rec := getStats("/stats/abc123", http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("unchanged: status %d, body %q, want an empty 304", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("ETag") != etag {
+		t.Errorf("304 ETag %q, want %q", rec.Header().Get("ETag"), etag)
+	}
+	if rec := getStats("/stats/abc123", http.Header{"If-None-Match": {`"other", ` + etag}}); rec.Code != http.StatusNotModified {
+		t.Errorf("in a list: status %d, want 304", rec.Code)
+	}
+	if rec := getStats("/stats/abc123", http.Header{"If-None-Match": {"*"}}); rec.Code != http.StatusNotModified {
+		t.Errorf("*: status %d, want 304", rec.Code)
+	}
+
+	// A click changes it
+	getRedirect("/abc123")
+	rec = getStats("/stats/abc123", http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("after a click: status %d, want 200", rec.Code)
+	}
+	if rec.Header().Get("ETag") == etag {
+		t.Error("ETag didn't change after a click")
+	}
+	if stats := decodeJSON[StatsResponse](t, rec); stats.Clicks != 1 {
+		t.Errorf("clicks = %d, want 1", stats.Clicks)
+	}
+}
+
+func TestCaseInsensitiveCodes(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &caseInsensitive, true)
+	alphabet, _ := codeAlphabet("base62")
+	setForTest(t, &letterRunes, alphabet)
+
+	if strings.ContainsAny(string(alphabet), "ABCXYZ") {
+		t.Errorf("alphabet %q has uppercase letters", string(alphabet))
+	}
+	for i := range 20 {
+		if resp := shortenOK(t, fmt.Sprintf(`{"url": "https://golang.org/doc/%d"}`, i)); resp.Code != strings.ToLower(resp.Code) {
+			t.Errorf("generated code %q isn't lowercase", resp.Code)
+		}
+	}
+
+	resp := shortenOK(t, `{"url": "https://golang.org/pkg", "custom_alias": "Go-Pkg"}`)
+	if resp.Code != "go-pkg" {
+		t.Errorf("alias stored as %q, want go-pkg", resp.Code)
+	}
+	for _, path := range []string{"/go-pkg", "/Go-Pkg", "/GO-PKG"} {
+		if rec := getRedirect(path); rec.Code != http.StatusFound {
+			t.Errorf("%s: status %d, want 302", path, rec.Code)
+		}
+	}
+	if rec := getStats("/stats/GO-pkg", nil); rec.Code != http.StatusOK {
+		t.Errorf("stats: status %d, want 200", rec.Code)
+	}
+	// Only differs in case from a taken alias, so it's taken too
+	if rec := postShorten(t, `{"url": "https://golang.org/ref", "custom_alias": "go-PKG"}`); rec.Code != http.StatusConflict {
+		t.Errorf("alias differing in case: status %d, want 409", rec.Code)
+	}
+}
+
+func TestCaseSensitiveCodes(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc"})
+	if rec := getRedirect("/ABC123"); rec.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", rec.Code)
+	}
+}
+
+// dryRunShorten sends a body to handleShorten with ?dry_run=1
+func dryRunShorten(body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleShorten(rec, jsonRequest(http.MethodPost, "/shorten?dry_run=1", body))
+	return rec
+}
+
+func TestShortenDryRun(t *testing.T) {
+	useMemoryStore(t)
+
+	rec := dryRunShorten(`{"url": "https://golang.org/doc"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	resp := decodeJSON[ShortenResponse](t, rec)
+	if !resp.DryRun || resp.Code == "" || resp.StatsURL != "" {
+		t.Errorf("response %+v, want a dry run with a code and no stats URL", resp)
+	}
+	dryAlias := decodeJSON[ShortenResponse](t, dryRunShorten(`{"url": "https://golang.org/pkg", "custom_alias": "go-pkg"}`))
+	if dryAlias.Code != "go-pkg" || !dryAlias.DryRun {
+		t.Errorf("custom alias: %+v", dryAlias)
+	}
+	if n := storeSize(t); n != 0 {
+		t.Errorf("store has %d links after dry runs, want none", n)
+	}
+	if rec := getRedirect("/" + resp.Code); rec.Code != http.StatusNotFound {
+		t.Errorf("dry run code redirects: status %d", rec.Code)
+	}
+
+	// The alias is still free for real
+	if real := shortenOK(t, `{"url": "https://golang.org/pkg", "custom_alias": "go-pkg"}`); real.DryRun {
+		t.Error("real request reported as a dry run")
+	}
+
+	// And validation runs in full
+	if rec := dryRunShorten(`{"url": "golang.org"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid URL: status %d, want 400", rec.Code)
+	}
+	if rec := dryRunShorten(`{"url": "https://golang.org/ref", "custom_alias": "go-pkg"}`); rec.Code != http.StatusConflict {
+		t.Errorf("taken alias: status %d, want 409", rec.Code)
+	}
+}
+
+func TestRedirectCacheHeaders(t *testing.T) {
+	useMemoryStore(t)
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	saveTestLink(t, "temp", Link{URL: "https://golang.org/doc", CreatedAt: created})
+	saveTestLink(t, "perm", Link{URL: "https://golang.org/doc", CreatedAt: created, Permanent: true})
+	saveTestLink(t, "perm2", Link{URL: "https://golang.org/doc", CreatedAt: created, Permanent: true, ExpiresAt: time.Now().Add(10 * time.Minute)})
+	saveTestLink(t, "old", Link{URL: "https://golang.org/doc"})
+
+	rec := getRedirect("/temp")
+	if got := rec.Header().Get("Last-Modified"); got != "Fri, 01 Mar 2024 12:30:00 GMT" {
+		t.Errorf("Last-Modified = %q, want the creation time", got)
+	}
+	if got, err := http.ParseTime(rec.Header().Get("Last-Modified")); err != nil || !got.Equal(created) {
+		t.Errorf("Last-Modified parses to %v (%v), want %v", got, err, created)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("temporary redirect: Cache-Control = %q", got)
+	}
+
+	if got := getRedirect("/perm").Header().Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Errorf("permanent redirect: Cache-Control = %q", got)
+	}
+	// Not cached past expiry
+	var maxAge int
+	if _, err := fmt.Sscanf(getRedirect("/perm2").Header().Get("Cache-Control"), "public, max-age=%d", &maxAge); err != nil || maxAge <= 0 || maxAge > 600 {
+		t.Errorf("expiring permanent redirect: max-age = %d (%v), want at most 600", maxAge, err)
+	}
+	// Links from before CreatedAt was recorded have no date to give
+	if got := getRedirect("/old").Header().Get("Last-Modified"); got != "" {
+		t.Errorf("link without CreatedAt: Last-Modified = %q", got)
+	}
+}
+
+func TestRedirectLastModifiedMatchesStore(t *testing.T) {
+	useMemoryStore(t)
+	resp := shortenOK(t, `{"url": "https://golang.org/doc"}`)
+	link, err := store.Lookup(t.Context(), resp.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := http.ParseTime(getRedirect("/" + resp.Code).Header().Get("Last-Modified"))
+	if err != nil || !got.Equal(link.CreatedAt) {
+		t.Errorf("Last-Modified = %v (%v), want stored CreatedAt %v", got, err, link.CreatedAt)
+	}
+}
+
+func TestShortenDecodeErrors(t *testing.T) {
+	useMemoryStore(t)
+	tests := []struct {
+		name, body string
+		status     int
+		message    string // Start of the error message
+		reason     string // reason logged
+	}{
+		{"too large", `{"url": "https://golang.org/` + strings.Repeat("a", int(maxShortenBodySize())) + `"}`, http.StatusRequestEntityTooLarge, "Request body too large", "too_large"},
+		{"empty", ``, http.StatusBadRequest, "Request body is empty", "empty"},
+		{"malformed", `{"url": "https://golang.org"`, http.StatusBadRequest, "Malformed JSON", "malformed"},
+		{"not JSON", `url=https://golang.org`, http.StatusBadRequest, "Malformed JSON", "malformed"},
+		{"trailing data", `{"url": "https://golang.org"} {}`, http.StatusBadRequest, "Malformed JSON", "malformed"},
+		{"unknown field", `{"url": "https://golang.org", "ur1": "x"}`, http.StatusBadRequest, `Unknown field "ur1"`, "unknown_field"},
+		{"wrong type", `{"url": 42}`, http.StatusBadRequest, `Invalid value for "url"`, "wrong_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs strings.Builder
+			old := slog.Default()
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+			t.Cleanup(func() { slog.SetDefault(old) })
+
+			rec := postShorten(t, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if got := decodeJSON[ErrorResponse](t, rec).Error; !strings.HasPrefix(got, tt.message) {
+				t.Errorf("error = %q, want it to start with %q", got, tt.message)
+			}
+			if want := `"reason":"` + tt.reason + `"`; !strings.Contains(logs.String(), want) {
+				t.Errorf("logs don't have %s:\n%s", want, logs.String())
+			}
+		})
+	}
+	if got := storeSize(t); got != 0 {
+		t.Errorf("%d links saved from bad bodies", got)
+	}
+}
+
+func TestShortenContentTypes(t *testing.T) {
+	useMemoryStore(t)
+	tests := []struct {
+		name, contentType, body string
+		status                  int
+		alias                   string // Expected code, if the body set one
+	}{
+		{"json", "application/json", `{"url": "https://golang.org/doc"}`, http.StatusOK, ""},
+		{"json with charset", "application/json; charset=utf-8", `{"url": "https://golang.org/doc", "custom_alias": "json-alias"}`, http.StatusOK, "json-alias"},
+		{"form", "application/x-www-form-urlencoded", "url=https%3A%2F%2Fgolang.org%2Fpkg%3Fa%3D1&custom_alias=form-alias", http.StatusOK, "form-alias"},
+		{"form without alias", "application/x-www-form-urlencoded", "url=https://golang.org/pkg", http.StatusOK, ""},
+		{"form with JSON only fields", "application/x-www-form-urlencoded", "url=https://golang.org/pkg&one_time=true", http.StatusBadRequest, ""},
+		{"empty form", "application/x-www-form-urlencoded", "", http.StatusBadRequest, ""},
+		{"text", "text/plain", `{"url": "https://golang.org/doc"}`, http.StatusUnsupportedMediaType, ""},
+		{"multipart", "multipart/form-data; boundary=x", "--x--", http.StatusUnsupportedMediaType, ""},
+		{"none", "", `{"url": "https://golang.org/doc"}`, http.StatusUnsupportedMediaType, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/shorten", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		rec := httptest.NewRecorder()
+		handleShorten(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		switch {
+		case tt.status == http.StatusUnsupportedMediaType:
+			if body := decodeJSON[ErrorResponse](t, rec); body.Code != errUnsupportedMediaType {
+				t.Errorf("%s: error = %+v", tt.name, body)
+			}
+		case tt.status == http.StatusOK && tt.alias != "":
+			if got := decodeJSON[ShortenResponse](t, rec).Code; got != tt.alias {
+				t.Errorf("%s: code = %q, want %q", tt.name, got, tt.alias)
+			}
+		}
+	}
+	if link, err := store.Lookup(t.Context(), "form-alias"); err != nil || link.URL != "https://golang.org/pkg?a=1" {
+		t.Errorf("form link = %+v, %v", link, err)
+	}
+	if got := storeSize(t); got != 4 {
+		t.Errorf("%d links saved, want 4", got)
+	}
+}
+
+func TestRedirectContentNegotiation(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc"})
+
+	for _, accept := range []string{"", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "*/*"} {
+		r := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		r.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		handleRedirect(rec, r)
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://golang.org/doc" {
+			t.Errorf("Accept %q: status %d, Location %q; want a 302", accept, rec.Code, rec.Header().Get("Location"))
+		}
+		if !strings.Contains(rec.Header().Get("Vary"), "Accept") {
+			t.Errorf("Accept %q: Vary = %q", accept, rec.Header().Get("Vary"))
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	r.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	handleRedirect(rec, r)
+	if rec.Code != http.StatusOK || rec.Header().Get("Location") != "" {
+		t.Fatalf("JSON: status %d, Location %q; want 200 without a redirect", rec.Code, rec.Header().Get("Location"))
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("JSON: Content-Type = %q", got)
+	}
+	if got := decodeJSON[RedirectResponse](t, rec).URL; got != "https://golang.org/doc" {
+		t.Errorf("JSON: url = %q", got)
+	}
+	if !strings.Contains(rec.Header().Get("Vary"), "Accept") {
+		t.Errorf("JSON: Vary = %q", rec.Header().Get("Vary"))
+	}
+
+	// Both are visits
+	if link, _ := store.Lookup(t.Context(), "abc123"); link.Clicks != 4 {
+		t.Errorf("clicks = %d, want 4", link.Clicks)
+	}
+}
+
+// benchmarkLinks saves n links and returns their redirect paths
+func benchmarkLinks(b *testing.B, n int) []string {
+	b.Helper()
+	useMemoryStore(b)
+	paths := make([]string, n)
+	for i := range paths {
+		code := fmt.Sprintf("bench%d", i)
+		saveTestLink(b, code, Link{URL: "https://golang.org/doc/" + code})
+		paths[i] = "/" + code
+	}
+	return paths
+}
+
+func BenchmarkRedirect(b *testing.B) {
+	paths := benchmarkLinks(b, 1000)
+	b.ReportAllocs()
+	i := 0
+	for b.Loop() {
+		rec := httptest.NewRecorder()
+		handleRedirect(rec, httptest.NewRequest(http.MethodGet, paths[i%len(paths)], nil))
+		if rec.Code != http.StatusFound {
+			b.Fatalf("status = %d", rec.Code)
+		}
+		i++
+	}
+}
+
+// BenchmarkRedirectParallel is the one the store's locking is tuned for:
+// redirects only take the read lock and count clicks atomically, so
+// throughput should grow with -cpu instead of serializing on one mutex
+func BenchmarkRedirectParallel(b *testing.B) {
+	paths := benchmarkLinks(b, 1000)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.IntN(len(paths))
+		for pb.Next() {
+			rec := httptest.NewRecorder()
+			handleRedirect(rec, httptest.NewRequest(http.MethodGet, paths[i%len(paths)], nil))
+			if rec.Code != http.StatusFound {
+				b.Errorf("status = %d", rec.Code)
+				return
+			}
+			i++
+		}
+	})
+}
+
+// BenchmarkRedirectParallelHotLink sends every redirect to the same code,
+// the worst case for contention on its click count
+func BenchmarkRedirectParallelHotLink(b *testing.B) {
+	paths := benchmarkLinks(b, 1)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rec := httptest.NewRecorder()
+			handleRedirect(rec, httptest.NewRequest(http.MethodGet, paths[0], nil))
+			if rec.Code != http.StatusFound {
+				b.Errorf("status = %d", rec.Code)
+				return
+			}
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxies   int
+		forwarded []string
+		want      string
+	}{
+		{"direct", 0, nil, "192.0.2.1"},
+		{"forwarded header ignored without proxies", 0, []string{"203.0.113.9"}, "192.0.2.1"},
+		{"one proxy", 1, []string{"203.0.113.9"}, "203.0.113.9"},
+		{"one proxy, spoofed entry", 1, []string{"10.0.0.1, 203.0.113.9"}, "203.0.113.9"},
+		{"one proxy, spoofed header", 1, []string{"10.0.0.1", "203.0.113.9"}, "203.0.113.9"},
+		{"two proxies", 2, []string{"10.0.0.1, 203.0.113.9, 198.51.100.7"}, "203.0.113.9"},
+		{"fewer hops than proxies", 3, []string{"203.0.113.9 , 198.51.100.7"}, "203.0.113.9"},
+		{"empty entries", 1, []string{"203.0.113.9, ,"}, "203.0.113.9"},
+		{"proxy without the header", 1, nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		setForTest(t, &trustedProxies, tt.proxies)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("%s: clientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
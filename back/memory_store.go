@@ -0,0 +1,380 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryStore keeps URL mappings in memory (for simplicity).
+// Everything is lost on restart unless SNAPSHOT_PATH is set (see
+// OpenMemorySnapshot), use SQLiteStore if every write must survive.
+//
+// Redirects are by far the most common operation, so they only take the read
+// lock: click counts are atomic and the recently used list has its own
+// mutex. Writers take mu for writing and then recentMu when they need it,
+// never the other way round.
+type MemoryStore struct {
+	mu    sync.RWMutex // To safely access links (and the reverse index) concurrently
+	links map[string]*memoryLink
+	byURL map[string]string // Reverse index: long URL -> latest code, kept in sync with links
+	// Codes from most to least recently used (saved or redirected to),
+	// with each code's element so it can be moved in O(1). Only kept up to
+	// date with maxEntries set, nothing is evicted otherwise.
+	recentMu   sync.Mutex
+	recent     *list.List
+	recentElem map[string]*list.Element
+	maxEntries int // 0 means unlimited, otherwise the least recently used links are evicted
+	capacity   int // 0 means unlimited, otherwise saves fail with ErrStoreFull once there are this many links
+	sequence   atomic.Uint64
+	// Snapshot file the links are written to on Close, "" for none
+	snapshotPath string
+}
+
+// memoryLink is a stored link with its click count, which redirects bump
+// under the read lock. link.Clicks is not used.
+type memoryLink struct {
+	link   Link
+	clicks atomic.Int64
+}
+
+// newMemoryLink wraps link for storing
+func newMemoryLink(link Link) *memoryLink {
+	m := &memoryLink{link: link}
+	m.clicks.Store(link.Clicks)
+	return m
+}
+
+// get returns the link with its current click count
+func (m *memoryLink) get() Link {
+	link := m.link
+	link.Clicks = m.clicks.Load()
+	return link
+}
+
+// NewMemoryStore creates an empty in-memory store holding at most
+// maxEntries links (0 for no limit)
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		links:      make(map[string]*memoryLink),
+		byURL:      make(map[string]string),
+		recent:     list.New(),
+		recentElem: make(map[string]*list.Element),
+		maxEntries: maxEntries,
+	}
+}
+
+// insert adds a new link as the most recently used one, evicting the least
+// recently used links if that goes over maxEntries. Callers hold the write lock.
+func (s *MemoryStore) insert(code string, link Link) {
+	s.links[code] = newMemoryLink(link)
+	s.byURL[link.URL] = code
+	if s.maxEntries == 0 {
+		return
+	}
+
+	s.recentMu.Lock()
+	s.recentElem[code] = s.recent.PushFront(code)
+	var evict []string
+	for elem := s.recent.Back(); len(s.links)-len(evict) > s.maxEntries; elem = elem.Prev() {
+		evict = append(evict, elem.Value.(string))
+	}
+	s.recentMu.Unlock()
+	for _, code := range evict {
+		s.remove(code)
+	}
+}
+
+// remove deletes code and its index entries. Callers hold the write lock.
+func (s *MemoryStore) remove(code string) {
+	stored, ok := s.links[code]
+	if !ok {
+		return
+	}
+	delete(s.links, code)
+	if s.byURL[stored.link.URL] == code {
+		delete(s.byURL, stored.link.URL)
+	}
+	s.recentMu.Lock()
+	if elem, ok := s.recentElem[code]; ok {
+		s.recent.Remove(elem)
+		delete(s.recentElem, code)
+	}
+	s.recentMu.Unlock()
+}
+
+// Save stores link under code, failing if the code is already taken
+func (s *MemoryStore) Save(_ context.Context, code string, link Link) error {
+	s.mu.Lock() // Lock for writing
+	defer s.mu.Unlock()
+
+	if _, exists := s.links[code]; exists {
+		return ErrCodeExists
+	}
+	if s.full() {
+		return ErrStoreFull
+	}
+	s.insert(code, link)
+	return nil
+}
+
+// full reports whether the store is at its capacity. Callers hold the lock.
+func (s *MemoryStore) full() bool {
+	return s.capacity > 0 && len(s.links) >= s.capacity
+}
+
+// SaveMany stores all entries while holding the write lock once
+func (s *MemoryStore) SaveMany(_ context.Context, entries []Entry) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(entries))
+	for i, entry := range entries {
+		if _, exists := s.links[entry.Code]; exists {
+			errs[i] = ErrCodeExists
+			continue
+		}
+		if s.full() {
+			errs[i] = ErrStoreFull
+			continue
+		}
+		s.insert(entry.Code, entry.Link)
+	}
+	return errs
+}
+
+// Lookup returns the link for code
+func (s *MemoryStore) Lookup(_ context.Context, code string) (Link, error) {
+	s.mu.RLock() // Lock for reading
+	defer s.mu.RUnlock()
+
+	stored, exists := s.links[code]
+	if !exists {
+		return Link{}, ErrNotFound
+	}
+	return stored.get(), nil
+}
+
+// Exists reports whether code is already in use
+func (s *MemoryStore) Exists(_ context.Context, code string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, exists := s.links[code]
+	return exists, nil
+}
+
+// ExistsMany checks all codes under one read lock
+func (s *MemoryStore) ExistsMany(_ context.Context, codes []string) ([]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	exists := make([]bool, len(codes))
+	for i, code := range codes {
+		_, exists[i] = s.links[code]
+	}
+	return exists, nil
+}
+
+// LookupURL returns the latest code saved for url
+func (s *MemoryStore) LookupURL(_ context.Context, url string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	code, exists := s.byURL[url]
+	if !exists {
+		return "", ErrNotFound
+	}
+	return code, nil
+}
+
+// CodesForURL walks all links, byURL only remembers the latest code of a URL
+func (s *MemoryStore) CodesForURL(_ context.Context, url string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var codes []string
+	for code, stored := range s.links {
+		if stored.link.URL == url {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+// IncrementClicks adds one to the click count of code. Clicks come from
+// redirects, so they also mark the link as recently used.
+func (s *MemoryStore) IncrementClicks(_ context.Context, code string) (int64, error) {
+	s.mu.RLock() // The count is atomic, so redirects don't wait on each other
+	defer s.mu.RUnlock()
+
+	stored, exists := s.links[code]
+	if !exists {
+		return 0, ErrNotFound
+	}
+	clicks := stored.clicks.Add(1)
+	if s.maxEntries > 0 {
+		s.recentMu.Lock()
+		s.recent.MoveToFront(s.recentElem[code])
+		s.recentMu.Unlock()
+	}
+	return clicks, nil
+}
+
+// AddClicks adds to the counts under the read lock, like IncrementClicks.
+// It's not a visit, so eviction order doesn't change.
+func (s *MemoryStore) AddClicks(_ context.Context, clicks map[string]int64) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for code, n := range clicks {
+		if stored, exists := s.links[code]; exists {
+			stored.clicks.Add(n)
+		}
+	}
+	return nil
+}
+
+// Update modifies the link under the write lock, keeping the URL index in sync
+func (s *MemoryStore) Update(_ context.Context, code string, fn func(*Link) error) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.links[code]
+	if !exists {
+		return Link{}, ErrNotFound
+	}
+	// No click can be counted while we hold the write lock
+	link := stored.get()
+	updated := link
+	if err := fn(&updated); err != nil {
+		return Link{}, err
+	}
+	updated.CreatedAt, updated.CreatedBy, updated.CreatorIP = link.CreatedAt, link.CreatedBy, link.CreatorIP
+	if updated.URL != link.URL {
+		if s.byURL[link.URL] == code {
+			delete(s.byURL, link.URL)
+		}
+		s.byURL[updated.URL] = code
+	}
+	stored.link = updated
+	stored.clicks.Store(updated.Clicks)
+	return updated, nil
+}
+
+// List returns a page of links ordered by code. Maps have no stable
+// order, so the codes are sorted on each call.
+func (s *MemoryStore) List(_ context.Context, opts ListOptions) ([]Entry, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	codes := make([]string, 0, len(s.links))
+	for code, stored := range s.links {
+		if (opts.Tag == "" || slices.Contains(stored.link.Tags, opts.Tag)) && (opts.Campaign == "" || stored.link.Campaign == opts.Campaign) {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+
+	total := len(codes)
+	if opts.After != "" {
+		// Everything up to and including After was on earlier pages
+		first, found := slices.BinarySearch(codes, opts.After)
+		if found {
+			first++
+		}
+		codes = codes[first:]
+	}
+	start := min(opts.Offset, len(codes))
+	end := min(start+opts.Limit, len(codes))
+	entries := make([]Entry, 0, end-start)
+	for _, code := range codes[start:end] {
+		entries = append(entries, Entry{Code: code, Link: s.links[code].get()})
+	}
+	return entries, total, nil
+}
+
+// DeleteMany removes all codes while holding the write lock once
+func (s *MemoryStore) DeleteMany(_ context.Context, codes []string) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(codes))
+	for i, code := range codes {
+		if _, exists := s.links[code]; !exists {
+			errs[i] = ErrNotFound
+			continue
+		}
+		s.remove(code)
+	}
+	return errs
+}
+
+// Summary walks all links under the read lock, so redirects keep flowing
+func (s *MemoryStore) Summary(_ context.Context, now, since time.Time) (StoreSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	summary := StoreSummary{Stored: len(s.links)}
+	for _, stored := range s.links {
+		link := stored.get()
+		if !link.Expired(now) {
+			summary.Active++
+		}
+		if link.CreatedAt.After(since) {
+			summary.CreatedSince++
+		}
+		summary.Clicks += link.Clicks
+	}
+	return summary, nil
+}
+
+// DeleteExpired removes links whose expiry has passed
+func (s *MemoryStore) DeleteExpired(_ context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for code, stored := range s.links {
+		if stored.link.Expired(now) {
+			s.remove(code)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Flush removes every link along with the reverse index
+func (s *MemoryStore) Flush(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := len(s.links)
+	s.links = make(map[string]*memoryLink)
+	s.byURL = make(map[string]string)
+	s.recentMu.Lock()
+	s.recent.Init()
+	s.recentElem = make(map[string]*list.Element)
+	s.recentMu.Unlock()
+	return removed, nil
+}
+
+// NextSequence returns the next value of the store's counter. Like the
+// links it starts over after a restart, unless it's kept in a snapshot.
+func (s *MemoryStore) NextSequence(_ context.Context) (uint64, error) {
+	return s.sequence.Add(1), nil
+}
+
+// Close writes the final snapshot, if the store has a snapshot file.
+// There is nothing else to flush for an in-memory store.
+func (s *MemoryStore) Close() error {
+	if s.snapshotPath == "" {
+		return nil
+	}
+	return s.WriteSnapshot()
+}
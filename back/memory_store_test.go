@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := t.Context()
+	s := NewMemoryStore(3)
+	for _, code := range []string{"a", "b", "c"} {
+		if err := s.Save(ctx, code, Link{URL: "https://golang.org/" + code}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A redirect is a use, so "a" is kept and "b" is now the oldest
+	if _, err := s.IncrementClicks(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	// Lookups aren't, or stats requests would keep links alive
+	if _, err := s.Lookup(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(ctx, "d", Link{URL: "https://golang.org/d"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for code, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if exists, _ := s.Exists(ctx, code); exists != want {
+			t.Errorf("Exists(%q) = %v, want %v", code, exists, want)
+		}
+	}
+	// Its reverse index entry goes with it
+	if _, err := s.LookupURL(ctx, "https://golang.org/b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LookupURL of an evicted link: %v, want ErrNotFound", err)
+	}
+
+	// A batch past the limit evicts as many as needed
+	errs := s.SaveMany(ctx, []Entry{{Code: "e", Link: Link{URL: "https://golang.org/e"}}, {Code: "f", Link: Link{URL: "https://golang.org/f"}}})
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	}
+	for code, want := range map[string]bool{"a": false, "c": false, "d": true, "e": true, "f": true} {
+		if exists, _ := s.Exists(ctx, code); exists != want {
+			t.Errorf("after the batch, Exists(%q) = %v, want %v", code, exists, want)
+		}
+	}
+	if _, total, _ := s.List(ctx, ListOptions{Limit: 10}); total != 3 {
+		t.Errorf("%d links, want 3", total)
+	}
+}
+
+func TestMemoryStoreUnlimited(t *testing.T) {
+	ctx := t.Context()
+	s := NewMemoryStore(0)
+	for _, code := range []string{"a", "b", "c", "d", "e"} {
+		if err := s.Save(ctx, code, Link{URL: "https://golang.org/" + code}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, total, _ := s.List(ctx, ListOptions{Limit: 10}); total != 5 {
+		t.Errorf("%d links, want all 5", total)
+	}
+}
+
+// BenchmarkMemoryStoreLookupWhileCounting runs lookups in parallel with
+// click increments on the same links: lookups only take the read lock,
+// so they shouldn't wait on the counters
+func TestMemoryStoreCapacity(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.MemoryCapacity = 3
+	st, err := newStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &store, st)
+
+	var codes []string
+	for i := range 3 {
+		codes = append(codes, shortenOK(t, fmt.Sprintf(`{"url": "https://golang.org/doc/%d"}`, i)).Code)
+	}
+	for _, body := range []string{`{"url": "https://golang.org/full"}`, `{"url": "https://golang.org/full", "custom_alias": "one-more"}`} {
+		rec := postShorten(t, body)
+		if rec.Code != http.StatusInsufficientStorage {
+			t.Fatalf("%s: status %d, want 507: %s", body, rec.Code, rec.Body)
+		}
+		if body := decodeJSON[ErrorResponse](t, rec); body.Code != errStorageFull || !strings.Contains(body.Error, "Storage is full") {
+			t.Errorf("error = %+v", body)
+		}
+	}
+	// Refused, not evicted: the first links are all still there
+	for _, code := range codes {
+		if _, err := store.Lookup(t.Context(), code); err != nil {
+			t.Errorf("Lookup(%q) = %v after filling up", code, err)
+		}
+	}
+	if got := storeSize(t); got != 3 {
+		t.Errorf("%d links, want 3", got)
+	}
+
+	// Batches fail the items past the capacity, one by one
+	results := decodeJSON[[]BatchResult](t, postBatch(`["https://golang.org/a", "https://golang.org/b"]`))
+	for i, result := range results {
+		if result.ErrorCode != errStorageFull {
+			t.Errorf("batch result %d: %+v, want storage_full", i, result)
+		}
+	}
+
+	// Deleting one makes room again
+	if errs := store.DeleteMany(t.Context(), codes[:1]); errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	shortenOK(t, `{"url": "https://golang.org/room"}`)
+	if rec := postShorten(t, `{"url": "https://golang.org/full"}`); rec.Code != http.StatusInsufficientStorage {
+		t.Errorf("full again: status %d, want 507", rec.Code)
+	}
+}
+
+func TestMemoryStoreCapacitySaveMany(t *testing.T) {
+	s := NewMemoryStore(0)
+	s.capacity = 2
+	errs := s.SaveMany(t.Context(), []Entry{
+		{Code: "a", Link: Link{URL: "https://golang.org/a"}},
+		{Code: "a", Link: Link{URL: "https://golang.org/a"}},
+		{Code: "b", Link: Link{URL: "https://golang.org/b"}},
+		{Code: "c", Link: Link{URL: "https://golang.org/c"}},
+	})
+	want := []error{nil, ErrCodeExists, nil, ErrStoreFull}
+	for i := range want {
+		if !errors.Is(errs[i], want[i]) {
+			t.Errorf("entry %d: %v, want %v", i, errs[i], want[i])
+		}
+	}
+	// Taken codes are reported as such even when full
+	if err := s.Save(t.Context(), "a", Link{URL: "https://golang.org/a"}); !errors.Is(err, ErrCodeExists) {
+		t.Errorf("Save(taken) = %v, want ErrCodeExists", err)
+	}
+}
+
+func TestMemoryCapacityConfig(t *testing.T) {
+	cfg := defaultConfig()
+	if cfg.MemoryCapacity != 0 {
+		t.Errorf("memory_capacity defaults to %d, want 0 (no limit)", cfg.MemoryCapacity)
+	}
+	for _, tt := range []struct {
+		capacity, maxEntries int
+		backend              string
+	}{
+		{-1, 0, "memory"},
+		{10, 5, "memory"}, // Refusing and evicting at once
+		{10, 0, "sqlite"}, // Only for memory
+	} {
+		cfg := defaultConfig()
+		cfg.MemoryCapacity, cfg.MaxEntries, cfg.StorageBackend = tt.capacity, tt.maxEntries, tt.backend
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%+v accepted", tt)
+		}
+	}
+}
+
+func BenchmarkMemoryStoreLookupWhileCounting(b *testing.B) {
+	s := NewMemoryStore(0)
+	ctx := context.Background()
+	codes := make([]string, 100)
+	for i := range codes {
+		codes[i] = fmt.Sprintf("code%d", i)
+		if err := s.Save(ctx, codes[i], Link{URL: "https://golang.org/" + codes[i]}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := n.Add(1)
+			code := codes[i%int64(len(codes))]
+			if i%2 == 0 {
+				if _, err := s.IncrementClicks(ctx, code); err != nil {
+					b.Error(err)
+					return
+				}
+			} else if _, err := s.Lookup(ctx, code); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
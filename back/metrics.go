@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, exposed on /metrics
+var (
+	shortenRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "urlshortener_shorten_requests_total",
+		Help: "Total number of requests to shorten a URL.",
+	})
+	redirectsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "urlshortener_redirects_total",
+		Help: "Total number of successful redirects.",
+	})
+	redirectMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "urlshortener_redirect_misses_total",
+		Help: "Total number of redirect lookups for unknown short codes.",
+	})
+	botVisitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "urlshortener_bot_visits_total",
+		Help: "Total number of visits from bots left out of click counts, see SKIP_BOT_CLICKS.",
+	})
+	webhookDeliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "urlshortener_webhook_deliveries_total",
+		Help: "Webhook events by outcome: delivered, failed (after retries) or dropped (queue full).",
+	}, []string{"result"})
+	linksSweptTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "urlshortener_links_swept_total",
+		Help: "Total number of expired links removed by the sweeper.",
+	})
+	sweepErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "urlshortener_sweep_errors_total",
+		Help: "Total number of sweeps that failed.",
+	})
+	codeLoadFactor = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "urlshortener_code_load_factor",
+		Help: "Share of the possible random codes (at CODE_LENGTH) already taken, the chance a new one collides.",
+	})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "urlshortener_request_duration_seconds",
+		Help:    "Request latency by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+)
+
+// instrument records the latency of every request handled by next
+// under the given endpoint label
+func instrument(endpoint string, next http.Handler) http.Handler {
+	observer := requestDuration.WithLabelValues(endpoint)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		observer.Observe(time.Since(start).Seconds())
+	})
+}
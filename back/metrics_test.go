@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testRouter returns the full router, as main serves it, with the default settings
+func testRouter(t *testing.T) http.Handler {
+	t.Helper()
+	router, err := newRouter(defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	useMemoryStore(t)
+	server := httptest.NewServer(testRouter(t))
+	defer server.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Post(server.URL+"/shorten", "application/json", strings.NewReader(`{"url": "https://golang.org/doc"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("shorten: status %d", resp.StatusCode)
+	}
+	resp, err = client.Get(server.URL + "/no-such-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = client.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// Not taken for a short code by the catch-all
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/metrics: status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"urlshortener_shorten_requests_total",
+		"urlshortener_redirects_total",
+		"urlshortener_redirect_misses_total",
+		`urlshortener_request_duration_seconds_count{endpoint="shorten"}`,
+		`urlshortener_request_duration_seconds_count{endpoint="redirect"}`,
+	} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("/metrics has no %s", name)
+		}
+	}
+}
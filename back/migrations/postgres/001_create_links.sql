@@ -0,0 +1,11 @@
+CREATE TABLE links (
+	code       TEXT PRIMARY KEY,
+	url        TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ, -- NULL for links that never expire
+	clicks     BIGINT NOT NULL DEFAULT 0,
+	permanent  BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- For DEDUPE lookups by URL
+CREATE INDEX links_url ON links (url, created_at);
@@ -0,0 +1,2 @@
+-- Disabled links answer 410 on redirect but are kept
+ALTER TABLE links ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- bcrypt hash for password protected links, empty for public ones
+ALTER TABLE links ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Links that are burned by their first redirect
+ALTER TABLE links ADD COLUMN one_time BOOLEAN NOT NULL DEFAULT FALSE;
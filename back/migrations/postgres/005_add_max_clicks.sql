@@ -0,0 +1,2 @@
+-- Click budget after which a link expires, 0 for no limit
+ALTER TABLE links ADD COLUMN max_clicks BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Comma separated tags, see joinTags
+ALTER TABLE links ADD COLUMN tags TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Last destination health check, see checkLinkHealth
+ALTER TABLE links ADD COLUMN check_status INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE links ADD COLUMN checked_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+-- Default UTM parameters, query encoded, see parseUTM
+ALTER TABLE links ADD COLUMN utm TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- Counters for Store.NextSequence, by name
+CREATE TABLE sequences (
+	name  TEXT PRIMARY KEY,
+	value BIGINT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Who created the link, see Link.CreatedBy
+ALTER TABLE links ADD COLUMN created_by TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN creator_ip TEXT NOT NULL DEFAULT '';
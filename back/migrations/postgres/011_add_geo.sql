@@ -0,0 +1,2 @@
+-- Destinations by visitor country, query encoded, see parseRules
+ALTER TABLE links ADD COLUMN geo TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Destinations by device platform, query encoded, see parseRules
+ALTER TABLE links ADD COLUMN devices TEXT NOT NULL DEFAULT '';
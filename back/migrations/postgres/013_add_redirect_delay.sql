@@ -0,0 +1,2 @@
+-- Seconds of countdown page before redirecting, 0 for REDIRECT_DELAY
+ALTER TABLE links ADD COLUMN redirect_delay INTEGER NOT NULL DEFAULT 0;
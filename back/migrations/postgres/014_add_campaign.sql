@@ -0,0 +1,3 @@
+-- Campaign the link belongs to, see GET /campaigns/{name}
+ALTER TABLE links ADD COLUMN campaign TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS links_campaign ON links (campaign);
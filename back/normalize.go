@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Query parameters added by analytics and ad platforms, removed when
+// STRIP_TRACKING_PARAMS is on. Anything starting with "utm_" is removed too.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"igshid":  true,
+}
+
+// normalizeURL rewrites equivalent URLs to the same form, so that e.g.
+// "https://Example.com:443/a/../" and "https://example.com" are stored
+// (and deduplicated) as one URL. It lowercases the scheme and host, drops
+// default ports, resolves dot segments and, if stripTracking is set,
+// removes tracking query parameters.
+func normalizeURL(u *url.URL, stripTracking bool) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+
+	// Drop the port if it's the default one for the scheme
+	if port := n.Port(); (n.Scheme == "http" && port == "80") || (n.Scheme == "https" && port == "443") {
+		n.Host = strings.TrimSuffix(n.Host, ":"+port)
+	}
+
+	// Resolving against an empty reference removes "." and ".." segments
+	// while keeping the query and fragment
+	n = *n.ResolveReference(&url.URL{})
+	if n.Path == "/" {
+		n.Path, n.RawPath = "", ""
+	}
+
+	if stripTracking && n.RawQuery != "" {
+		query := n.Query()
+		for key := range query {
+			if trackingParams[strings.ToLower(key)] || strings.HasPrefix(strings.ToLower(key), "utm_") {
+				query.Del(key)
+			}
+		}
+		n.RawQuery = query.Encode()
+	}
+	return n.String()
+}
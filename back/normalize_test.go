@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		in            string
+		stripTracking bool
+		want          string
+	}{
+		{"https://golang.org/doc", false, "https://golang.org/doc"},
+		{"HTTPS://GoLang.ORG/Doc", false, "https://golang.org/Doc"}, // Paths are case sensitive
+		{"https://golang.org/", false, "https://golang.org"},
+		{"https://golang.org:443/doc", false, "https://golang.org/doc"},
+		{"http://golang.org:80/doc", false, "http://golang.org/doc"},
+		{"https://golang.org:8443/doc", false, "https://golang.org:8443/doc"},
+		{"http://golang.org:443/doc", false, "http://golang.org:443/doc"}, // Not the default for http
+		{"https://golang.org/a/./b/../doc", false, "https://golang.org/a/doc"},
+		{"https://golang.org/a/../", false, "https://golang.org"},
+		{"https://golang.org/doc?b=2&a=1#intro", false, "https://golang.org/doc?b=2&a=1#intro"},
+		{"https://golang.org/doc?utm_source=x&fbclid=y&q=go", false, "https://golang.org/doc?utm_source=x&fbclid=y&q=go"},
+		{"https://golang.org/doc?utm_source=x&UTM_Medium=y&fbclid=z&q=go", true, "https://golang.org/doc?q=go"},
+		{"https://golang.org/doc?gclid=x", true, "https://golang.org/doc"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := normalizeURL(u, tt.stripTracking); got != tt.want {
+			t.Errorf("normalizeURL(%q, %v) = %q, want %q", tt.in, tt.stripTracking, got, tt.want)
+		}
+	}
+}
+
+func TestShortenNormalizes(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &dedupe, true)
+
+	// Equivalent URLs are stored once, so they dedupe to one code
+	first := shortenOK(t, `{"url": "https://GoLang.org:443/doc/"}`)
+	if first.OriginalURL != "https://golang.org/doc/" {
+		t.Errorf("original_url = %q, want https://golang.org/doc/", first.OriginalURL)
+	}
+	second := shortenOK(t, `{"url": "https://golang.org/x/../doc/"}`)
+	if second.Code != first.Code {
+		t.Errorf("equivalent URLs got codes %q and %q, want the same", first.Code, second.Code)
+	}
+
+	// Turned off, URLs are kept exactly as sent
+	setForTest(t, &normalizeURLs, false)
+	exact := shortenOK(t, `{"url": "https://GoLang.org:443/doc/"}`)
+	if exact.OriginalURL != "https://GoLang.org:443/doc/" || exact.Code == first.Code {
+		t.Errorf("without normalizing: %+v, want the URL as sent under a new code", exact)
+	}
+}
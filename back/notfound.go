@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// notFoundTemplate is the operator supplied page for unknown short codes,
+// from NOT_FOUND_TEMPLATE. nil means the plain JSON error.
+var notFoundTemplate *template.Template
+
+// notFoundPage is the data rendered by notFoundTemplate, e.g. {{.Code}}.
+// html/template escapes it, so a crafted code can't inject markup.
+type notFoundPage struct {
+	Code string
+}
+
+// loadNotFoundTemplate parses the HTML template file at path
+func loadNotFoundTemplate(path string) (*template.Template, error) {
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading NOT_FOUND_TEMPLATE: %w", err)
+	}
+	return tmpl, nil
+}
+
+// wantsJSON reports whether the client asked for JSON rather than a page
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// wantsHTML reports whether the client accepts a page, like browsers and
+// link unfurlers do
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// serveNotFound answers a redirect for an unknown code: the custom page if
+// one is configured (unless the client wants JSON), the JSON error otherwise
+func serveNotFound(w http.ResponseWriter, r *http.Request, code string) {
+	if notFoundTemplate == nil || wantsJSON(r) {
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		return
+	}
+
+	// Render first, so a broken template doesn't leave a half written page
+	var buf bytes.Buffer
+	if err := notFoundTemplate.Execute(&buf, notFoundPage{Code: code}); err != nil {
+		logRequest(r, http.StatusInternalServerError, "redirect", "Error rendering not found page", "code", code, "error", err)
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNotFoundPage(t *testing.T) {
+	useMemoryStore(t)
+	path := filepath.Join(t.TempDir(), "404.html")
+	if err := os.WriteFile(path, []byte(`<h1>No link called {{.Code}}</h1>`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := loadNotFoundTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &notFoundTemplate, tmpl)
+
+	rec := getRedirect("/missing")
+	if rec.Code != http.StatusNotFound || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("status %d, Content-Type %q, want an HTML 404", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if got := rec.Body.String(); got != "<h1>No link called missing</h1>" {
+		t.Errorf("page = %q", got)
+	}
+
+	// The code comes from the URL, it's escaped
+	rec = httptest.NewRecorder()
+	serveNotFound(rec, httptest.NewRequest(http.MethodGet, "/x", nil), "<script>x</script>")
+	if strings.Contains(rec.Body.String(), "<script>") {
+		t.Errorf("code isn't escaped: %s", rec.Body)
+	}
+
+	// API clients still get JSON
+	r := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	r.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	handleRedirect(rec, r)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("JSON: status %d, want 404", rec.Code)
+	}
+	if body := decodeJSON[ErrorResponse](t, rec); body.Code != errNotFound {
+		t.Errorf("JSON: error %+v", body)
+	}
+}
+
+func TestNotFoundWithoutTemplate(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &notFoundTemplate, nil)
+
+	rec := getRedirect("/missing")
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d, Content-Type %q, want the JSON 404", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if _, err := loadNotFoundTemplate(filepath.Join(t.TempDir(), "missing.html")); err == nil {
+		t.Error("a missing NOT_FOUND_TEMPLATE was accepted")
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Bounds on fetching a destination for its Open Graph tags: the whole
+// fetch, how much of the page is read, and how long a title or
+// description may be
+const (
+	openGraphTimeout      = 5 * time.Second
+	maxOpenGraphPageSize  = 512 << 10
+	maxOpenGraphTextRunes = 300
+)
+
+// How long previews are cached, by destination: ones read from the page,
+// and fallbacks for pages that couldn't be fetched
+const (
+	openGraphTTL        = 6 * time.Hour
+	openGraphFailedTTL  = 10 * time.Minute
+	openGraphCacheSize  = 1000 // Destinations, an arbitrary one is evicted when full
+	openGraphFetchLimit = 4    // Fetches running at once, more wait for a slot
+)
+
+// fetchPreviews turns GET /preview/{code} on, see FETCH_PREVIEWS
+var fetchPreviews bool
+
+// openGraph is what a destination says about itself. Title is always set,
+// to the host when the page has none.
+type openGraph struct {
+	Title       string
+	Description string
+	Image       string
+	SiteName    string
+	expires     time.Time
+}
+
+// openGraphCache holds previews by destination URL. Like faviconCache,
+// concurrent requests for a destination not cached yet wait for one fetch.
+type openGraphCache struct {
+	mu       sync.Mutex
+	previews map[string]openGraph
+	fetching map[string]chan struct{} // Closed once the fetch for the URL is done
+	slots    chan struct{}            // Fetches running, see openGraphFetchLimit
+}
+
+var openGraphs = &openGraphCache{
+	previews: make(map[string]openGraph),
+	fetching: make(map[string]chan struct{}),
+	slots:    make(chan struct{}, openGraphFetchLimit),
+}
+
+// cached returns the preview of destination if it's in the cache
+func (c *openGraphCache) cached(destination string) (openGraph, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	preview, ok := c.previews[destination]
+	if !ok || time.Now().After(preview.expires) {
+		return openGraph{}, false
+	}
+	return preview, true
+}
+
+// get returns the preview of destination, fetching it if it isn't cached.
+// It doesn't fail: a page that can't be read gives the fallback preview.
+func (c *openGraphCache) get(ctx context.Context, destination string) openGraph {
+	for {
+		if preview, ok := c.cached(destination); ok {
+			return preview
+		}
+		c.mu.Lock()
+		wait, busy := c.fetching[destination]
+		if !busy {
+			c.fetching[destination] = make(chan struct{})
+		}
+		c.mu.Unlock()
+		if !busy {
+			break
+		}
+		select {
+		case <-wait: // Cached now, unless it expired right away
+		case <-ctx.Done():
+			return fallbackOpenGraph(destination)
+		}
+	}
+
+	preview := fetchOpenGraph(ctx, c.slots, destination)
+	c.mu.Lock()
+	if ctx.Err() == nil { // Don't cache the fallback because the client gave up early
+		if len(c.previews) >= openGraphCacheSize {
+			for evict := range c.previews {
+				delete(c.previews, evict)
+				break
+			}
+		}
+		c.previews[destination] = preview
+	}
+	close(c.fetching[destination])
+	delete(c.fetching, destination)
+	c.mu.Unlock()
+	return preview
+}
+
+// fallbackOpenGraph is the preview of a destination without tags (or that
+// couldn't be fetched): its host as the title
+func fallbackOpenGraph(destination string) openGraph {
+	preview := openGraph{Title: destination, expires: time.Now().Add(openGraphFailedTTL)}
+	if u, err := url.Parse(destination); err == nil && u.Hostname() != "" {
+		preview.Title = strings.TrimPrefix(u.Hostname(), "www.")
+	}
+	return preview
+}
+
+// fetchOpenGraph reads the Open Graph tags of destination, with the
+// <title> and description meta tags as fallbacks. Failures are logged and
+// give fallbackOpenGraph.
+func fetchOpenGraph(ctx context.Context, slots chan struct{}, destination string) openGraph {
+	ctx, cancel := context.WithTimeout(ctx, openGraphTimeout)
+	defer cancel()
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-ctx.Done():
+		return fallbackOpenGraph(destination)
+	}
+
+	preview, err := readOpenGraph(ctx, destination)
+	if err != nil {
+		slog.DebugContext(ctx, "Could not read destination for preview", "event", "preview", "url", destination, "error", err)
+		return fallbackOpenGraph(destination)
+	}
+	if preview.Title == "" {
+		preview.Title = fallbackOpenGraph(destination).Title
+	}
+	preview.expires = time.Now().Add(openGraphTTL)
+	return preview
+}
+
+// readOpenGraph GETs destination with linkCheckClient, which refuses
+// internal addresses and bounds redirects, and parses its <head>
+func readOpenGraph(ctx context.Context, destination string) (openGraph, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return openGraph{}, err
+	}
+	req.Header.Set("User-Agent", "url-shortener-preview")
+	req.Header.Set("Accept", "text/html")
+	resp, err := linkCheckClient.Do(req)
+	if err != nil {
+		return openGraph{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return openGraph{}, fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+			return openGraph{}, errors.New("not a web page: " + contentType)
+		}
+	}
+
+	var preview, fallback openGraph // fallback has what plain HTML says, used where OG tags are missing
+	tokens := html.NewTokenizer(io.LimitReader(resp.Body, maxOpenGraphPageSize))
+	inTitle := false
+	for {
+		tokenType := tokens.Next()
+		if tokenType == html.ErrorToken {
+			break // End of the page, or of what we read
+		}
+		if tokenType == html.TextToken && inTitle && fallback.Title == "" {
+			fallback.Title = string(tokens.Text())
+			continue
+		}
+		if tokenType == html.EndTagToken {
+			if name, _ := tokens.TagName(); string(name) == "title" {
+				inTitle = false
+			}
+			continue
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+		name, hasAttr := tokens.TagName()
+		if string(name) == "body" {
+			break // Meta tags are in <head>
+		}
+		if string(name) == "title" {
+			inTitle = tokenType == html.StartTagToken
+			continue
+		}
+		if string(name) != "meta" || !hasAttr {
+			continue
+		}
+		// Sites use property (per the spec) or name, for og: and twitter: alike
+		var property, content string
+		for hasAttr {
+			var key, value []byte
+			key, value, hasAttr = tokens.TagAttr()
+			switch string(key) {
+			case "property", "name":
+				property = strings.ToLower(strings.TrimSpace(string(value)))
+			case "content":
+				content = string(value)
+			}
+		}
+		switch property {
+		case "og:title":
+			setOnce(&preview.Title, content)
+		case "og:description":
+			setOnce(&preview.Description, content)
+		case "og:image", "og:image:url", "og:image:secure_url":
+			setOnce(&preview.Image, imageURL(resp.Request.URL, content))
+		case "og:site_name":
+			setOnce(&preview.SiteName, content)
+		case "twitter:title":
+			setOnce(&fallback.Title, content)
+		case "twitter:description", "description":
+			setOnce(&fallback.Description, content)
+		case "twitter:image", "twitter:image:src":
+			setOnce(&fallback.Image, imageURL(resp.Request.URL, content))
+		}
+	}
+
+	setOnce(&preview.Title, fallback.Title)
+	setOnce(&preview.Description, fallback.Description)
+	setOnce(&preview.Image, fallback.Image)
+	preview.Title = clipText(preview.Title)
+	preview.Description = clipText(preview.Description)
+	preview.SiteName = clipText(preview.SiteName)
+	return preview, nil
+}
+
+// setOnce sets *field to value unless it already has one: the first tag wins
+func setOnce(field *string, value string) {
+	if value = strings.TrimSpace(value); *field == "" {
+		*field = value
+	}
+}
+
+// imageURL resolves an image tag against the page, which may have been
+// redirected. Anything but an http(s) URL gives "".
+func imageURL(page *url.URL, content string) string {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return ""
+	}
+	image, err := page.Parse(content)
+	if err != nil || (image.Scheme != "http" && image.Scheme != "https") {
+		return ""
+	}
+	return image.String()
+}
+
+// clipText collapses whitespace and cuts text to maxOpenGraphTextRunes
+func clipText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxOpenGraphTextRunes {
+		text = strings.TrimSpace(string(runes[:maxOpenGraphTextRunes-1])) + "…"
+	}
+	return text
+}
+
+// Response structure for GET /preview/{code}
+type PreviewResponse struct {
+	Code        string `json:"code"`
+	URL         string `json:"url"`
+	Title       string `json:"title"` // The destination's host if the page has no title
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"` // Absolute URL, on the destination's site or wherever it points
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// openGraphTemplate is the preview as a page of Open Graph tags, for
+// unfurlers that read pages rather than JSON. html/template escapes the
+// values in the attributes and drops a non-http(s) URL from the href.
+var openGraphTemplate = template.Must(template.New("opengraph").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:title" content="{{.Title}}">
+{{- with .Description}}
+<meta property="og:description" content="{{.}}">
+<meta name="description" content="{{.}}">
+{{- end}}
+{{- with .Image}}
+<meta property="og:image" content="{{.}}">
+{{- end}}
+{{- with .SiteName}}
+<meta property="og:site_name" content="{{.}}">
+{{- end}}
+</head>
+<body>
+<p><a href="{{.URL}}" rel="noopener noreferrer">{{.Title}}</a></p>
+</body>
+</html>
+`))
+
+// handlePreview returns the Open Graph title, description and image of a
+// link's destination, for chat apps unfurling short links: as JSON, or as
+// a page of og: tags for clients that accept HTML. It isn't a visit, so
+// clicks aren't counted. Disabled, expired and password protected links
+// aren't previewed, like on redirect.
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	if !fetchPreviews {
+		writeJSONError(w, http.StatusNotFound, "Previews are turned off", errNotFound)
+		logRequest(r, http.StatusNotFound, "preview", "Previews are turned off")
+		return
+	}
+
+	shortCode := canonicalCode(r.PathValue("code"))
+	link, err := lookupLink(r.Context(), shortCode)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		logRequest(r, http.StatusNotFound, "preview", "Short code not found", "code", shortCode)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "preview", "Error looking up short code", "code", shortCode, "error", err)
+		return
+	}
+	if writeLinkGone(w, r, "preview", shortCode, link) {
+		return
+	}
+	// The page could give away where the link goes
+	if link.PasswordHash != "" {
+		writeJSONError(w, http.StatusUnauthorized, "This link requires a password, POST it to /unlock/"+shortCode, errPasswordRequired)
+		logRequest(r, http.StatusUnauthorized, "preview", "Password required", "code", shortCode)
+		return
+	}
+
+	preview := openGraphs.get(r.Context(), link.URL)
+	resp := PreviewResponse{
+		Code:        shortCode,
+		URL:         link.URL,
+		Title:       preview.Title,
+		Description: preview.Description,
+		Image:       preview.Image,
+		SiteName:    preview.SiteName,
+	}
+	// Cached here already, and the link may be disabled or changed meanwhile
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
+	if wantsHTML(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := openGraphTemplate.Execute(w, resp); err != nil {
+			logRequest(r, http.StatusInternalServerError, "preview", "Error rendering preview page", "code", shortCode, "error", err)
+			return
+		}
+		logRequest(r, http.StatusOK, "preview", "Served link preview page", "code", shortCode, "url", link.URL)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "preview", "Error encoding response", "error", err)
+		return
+	}
+	logRequest(r, http.StatusOK, "preview", "Served link preview", "code", shortCode, "url", link.URL)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// usePreviews turns previews on with an empty cache, fetched through client
+func usePreviews(t *testing.T, client *http.Client) {
+	t.Helper()
+	setForTest(t, &fetchPreviews, true)
+	setForTest(t, &openGraphs, &openGraphCache{
+		previews: make(map[string]openGraph),
+		fetching: make(map[string]chan struct{}),
+		slots:    make(chan struct{}, openGraphFetchLimit),
+	})
+	// Test sites are on localhost, which the real client refuses
+	setForTest(t, &linkCheckClient, client)
+}
+
+// pageServer serves page as HTML for every path, counting the requests
+func pageServer(t *testing.T, page string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// getPreview asks handlePreview about code
+func getPreview(code string) *httptest.ResponseRecorder {
+	return serve("/preview/{code}", handlePreview, httptest.NewRequest(http.MethodGet, "/preview/"+code, nil))
+}
+
+func TestPreviewOpenGraph(t *testing.T) {
+	useMemoryStore(t)
+	server, requests := pageServer(t, `<!DOCTYPE html><html><head>
+<title>Plain title</title>
+<meta property="og:title" content="The Go   Programming Language">
+<meta property="og:description" content="Build simple, secure, scalable systems">
+<meta property="og:image" content="/images/gopher.png">
+<meta property="og:site_name" content="go.dev">
+<meta property="og:title" content="A second title, ignored">
+</head><body><meta property="og:description" content="In the body, ignored"></body></html>`)
+	usePreviews(t, server.Client())
+	saveTestLink(t, "gopher", Link{URL: server.URL + "/learn"})
+
+	rec := getPreview("gopher")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	want := PreviewResponse{
+		Code:        "gopher",
+		URL:         server.URL + "/learn",
+		Title:       "The Go Programming Language",
+		Description: "Build simple, secure, scalable systems",
+		Image:       server.URL + "/images/gopher.png", // Resolved against the page
+		SiteName:    "go.dev",
+	}
+	if got := decodeJSON[PreviewResponse](t, rec); got != want {
+		t.Errorf("preview = %+v\nwant %+v", got, want)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+
+	// From the cache the second time
+	if got := decodeJSON[PreviewResponse](t, getPreview("gopher")); got != want {
+		t.Errorf("cached preview = %+v", got)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("destination fetched %d times, want once", n)
+	}
+	// Not a visit
+	if link, _ := store.Lookup(t.Context(), "gopher"); link.Clicks != 0 {
+		t.Errorf("clicks = %d after previews", link.Clicks)
+	}
+}
+
+func TestPreviewFallbacks(t *testing.T) {
+	useMemoryStore(t)
+	plain, _ := pageServer(t, `<html><head><title> Just a title </title>
+<meta name="description" content="From the description tag">
+<meta name="twitter:image" content="https://cdn.example/card.png">
+</head></html>`)
+	bare, _ := pageServer(t, `<html><head><meta property="og:image" content="javascript:alert(1)"></head></html>`)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	t.Cleanup(broken.Close)
+	usePreviews(t, plain.Client())
+
+	tests := []struct {
+		name string
+		url  string
+		want PreviewResponse
+	}{
+		{"plain HTML", plain.URL, PreviewResponse{Title: "Just a title", Description: "From the description tag", Image: "https://cdn.example/card.png"}},
+		{"no tags", bare.URL, PreviewResponse{Title: "127.0.0.1"}}, // And a javascript: image is dropped
+		{"error page", broken.URL, PreviewResponse{Title: "127.0.0.1"}},
+	}
+	for i, tt := range tests {
+		code := fmt.Sprintf("fallback%d", i)
+		saveTestLink(t, code, Link{URL: tt.url})
+		tt.want.Code, tt.want.URL = code, tt.url
+		rec := getPreview(code)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want a preview anyway", tt.name, rec.Code)
+			continue
+		}
+		if got := decodeJSON[PreviewResponse](t, rec); got != tt.want {
+			t.Errorf("%s: preview = %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// The real client refuses to fetch internal addresses, so a link can't be
+// used to read them
+func TestPreviewRefusesInternalAddresses(t *testing.T) {
+	useMemoryStore(t)
+	server, requests := pageServer(t, `<meta property="og:title" content="Internal">`)
+	usePreviews(t, linkCheckClient)
+	saveTestLink(t, "internal", Link{URL: server.URL})
+	if got := decodeJSON[PreviewResponse](t, getPreview("internal")); got.Title != "127.0.0.1" {
+		t.Errorf("title = %q, want the fallback", got.Title)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("internal address fetched %d times", n)
+	}
+}
+
+func TestPreviewUnavailableLinks(t *testing.T) {
+	useMemoryStore(t)
+	server, requests := pageServer(t, `<meta property="og:title" content="Secret">`)
+	usePreviews(t, server.Client())
+	saveTestLink(t, "off", Link{URL: server.URL, Disabled: true})
+	saveTestLink(t, "old", Link{URL: server.URL, ExpiresAt: time.Now().Add(-time.Minute)})
+	saveTestLink(t, "locked", Link{URL: server.URL, PasswordHash: "x"})
+	// Expired on the visit that used it up, like claimLimitedVisit does
+	saveTestLink(t, "used", Link{URL: server.URL, MaxClicks: 2, Clicks: 2, ExpiresAt: time.Now().Add(-time.Second)})
+
+	for code, status := range map[string]int{
+		"off":     http.StatusGone,
+		"old":     http.StatusGone,
+		"used":    http.StatusGone,
+		"locked":  http.StatusUnauthorized,
+		"missing": http.StatusNotFound,
+	} {
+		rec := getPreview(code)
+		if rec.Code != status {
+			t.Errorf("%s: status %d, want %d", code, rec.Code, status)
+		}
+		if strings.Contains(rec.Body.String(), "Secret") || strings.Contains(rec.Body.String(), server.URL) {
+			t.Errorf("%s: destination given away: %s", code, rec.Body)
+		}
+	}
+	if got := decodeJSON[ErrorResponse](t, getPreview("used")).Error; got != "Short URL has no visits left" {
+		t.Errorf("used up link: error %q, want the same as on redirect", got)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("destination fetched %d times for links that aren't previewed", n)
+	}
+
+	rec := serve("/preview/{code}", handlePreview, httptest.NewRequest(http.MethodPost, "/preview/off", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rec.Code)
+	}
+}
+
+// ogTags returns the og: meta tags of page by property
+func ogTags(t *testing.T, page string) map[string]string {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := make(map[string]string)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "meta" {
+			var property, content string
+			for _, a := range n.Attr {
+				switch a.Key {
+				case "property":
+					property = a.Val
+				case "content":
+					content = a.Val
+				}
+			}
+			if strings.HasPrefix(property, "og:") {
+				tags[property] = content
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return tags
+}
+
+func TestPreviewPage(t *testing.T) {
+	useMemoryStore(t)
+	server, requests := pageServer(t, `<head>
+<meta property="og:title" content="Tom &amp; Jerry&quot;><script>alert(1)</script>">
+<meta property="og:description" content="Cat and mouse">
+<meta property="og:image" content="https://cdn.example/tom.png">
+</head>`)
+	usePreviews(t, server.Client())
+	saveTestLink(t, "cartoon", Link{URL: server.URL + "/tom"})
+
+	r := httptest.NewRequest(http.MethodGet, "/preview/cartoon", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	rec := serve("/preview/{code}", handlePreview, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept" {
+		t.Errorf("Vary = %q, the answer depends on Accept", got)
+	}
+	page := rec.Body.String()
+	want := map[string]string{
+		"og:type":        "website",
+		"og:url":         server.URL + "/tom",
+		"og:title":       `Tom & Jerry"><script>alert(1)</script>`,
+		"og:description": "Cat and mouse",
+		"og:image":       "https://cdn.example/tom.png",
+	}
+	if got := ogTags(t, page); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("og: tags = %v\nwant %v", got, want)
+	}
+	if strings.Contains(page, "<script>") {
+		t.Errorf("title not escaped:\n%s", page)
+	}
+
+	// JSON is still the default, from the same cached preview
+	if got := decodeJSON[PreviewResponse](t, getPreview("cartoon")); got.Title != want["og:title"] {
+		t.Errorf("JSON title = %q", got.Title)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("destination fetched %d times, want once", n)
+	}
+}
+
+// Unfurlers fetch whatever is linked, so it's opt-in
+func TestPreviewsOffByDefault(t *testing.T) {
+	if defaultConfig().FetchPreviews {
+		t.Error("FETCH_PREVIEWS is on by default")
+	}
+}
+
+func TestPreviewTurnedOff(t *testing.T) {
+	useMemoryStore(t)
+	server, requests := pageServer(t, `<meta property="og:title" content="Go">`)
+	usePreviews(t, server.Client())
+	setForTest(t, &fetchPreviews, false)
+	saveTestLink(t, "gopher", Link{URL: server.URL})
+	if rec := getPreview("gopher"); rec.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", rec.Code)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("destination fetched %d times with previews off", n)
+	}
+}
+
+func TestClipText(t *testing.T) {
+	if got := clipText("  a \n\t b  "); got != "a b" {
+		t.Errorf("clipText = %q", got)
+	}
+	long := strings.Repeat("é", maxOpenGraphTextRunes+10)
+	got := []rune(clipText(long))
+	if len(got) != maxOpenGraphTextRunes || got[len(got)-1] != '…' {
+		t.Errorf("clipText of %d runes gave %d, ending %q", maxOpenGraphTextRunes+10, len(got), got[len(got)-1])
+	}
+}
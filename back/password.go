@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt only looks at the first 72 bytes, longer passwords are refused
+// rather than silently truncated
+const maxPasswordLength = 72
+
+// hashPassword returns the bcrypt hash stored for a protected link
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// unlockTemplate asks for the password of a protected link and posts it
+// to /unlock/{code}, along with the path and query to forward. It's styled
+// like the preview page.
+var unlockTemplate = template.Must(template.New("unlock").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #0f172a; color: #e2e8f0; display: flex; min-height: 100vh; align-items: center; justify-content: center; margin: 0; }
+main { background: #1e293b; padding: 2rem; border-radius: 0.5rem; max-width: 40rem; }
+input, button { font: inherit; padding: 0.5rem; border-radius: 0.25rem; border: none; }
+button { background: #38bdf8; color: #0f172a; cursor: pointer; }
+.error { color: #f87171; }
+</style>
+</head>
+<body>
+<main>
+<h1>This link is password protected</h1>
+{{if .Wrong}}<p class="error">Wrong password, please try again.</p>{{end}}
+<form method="post" action="/unlock/{{.Code}}{{.Forward}}">
+<input type="password" name="password" placeholder="Password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</main>
+</body>
+</html>
+`))
+
+// unlockPage is the data rendered by unlockTemplate
+type unlockPage struct {
+	Code    string
+	Forward string // The visit's extra path and query, for forwardedDestination
+	Wrong   bool   // Set after a wrong password was posted
+}
+
+// servePasswordPrompt answers a visit to a protected link: the password
+// form for browsers, an error for JSON clients. status is 401 when no
+// password was given yet and 403 after a wrong one.
+func servePasswordPrompt(w http.ResponseWriter, r *http.Request, code, extraPath string, status int) {
+	if wantsJSON(r) {
+		if status == http.StatusForbidden {
+			writeJSONError(w, status, "Wrong password", errForbidden)
+		} else {
+			writeJSONError(w, status, "This link requires a password, POST it to /unlock/"+code, errPasswordRequired)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	page := unlockPage{Code: code, Forward: forwardSuffix(r, extraPath), Wrong: status == http.StatusForbidden}
+	if err := unlockTemplate.Execute(w, page); err != nil {
+		logRequest(r, http.StatusInternalServerError, "unlock", "Error rendering password page", "code", code, "error", err)
+	}
+}
+
+// Request structure for POST /unlock/{code} with a JSON body. The form
+// in unlockTemplate sends the same field form encoded.
+type UnlockRequest struct {
+	Password string `json:"password"`
+}
+
+// Response structure for a successful JSON unlock
+type UnlockResponse struct {
+	URL string `json:"url"`
+}
+
+// forwardSuffix is what a visit adds to the short link for FORWARD_PATH
+// and FORWARD_QUERY, carried through the password form
+func forwardSuffix(r *http.Request, extraPath string) string {
+	suffix := (&url.URL{Path: extraPath}).EscapedPath()
+	if r.URL.RawQuery != "" {
+		suffix += "?" + r.URL.RawQuery
+	}
+	return suffix
+}
+
+// unlockExtraPath is the path to forward in /unlock/{code}/{rest...}
+func unlockExtraPath(r *http.Request) string {
+	if rest := r.PathValue("rest"); forwardPath && rest != "" {
+		return "/" + rest
+	}
+	return ""
+}
+
+// handleUnlock checks the password of a protected link. Browsers are sent
+// on to the destination with a 303 (so the POST becomes a GET), JSON
+// clients get the URL back. The path after the code and the query of the
+// unlock URL are forwarded like on redirect. Attempts are rate limited
+// like link creation.
+func handleUnlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	var password string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var req UnlockRequest
+		if !decodeJSONBody(w, r, "unlock", shortenBodyOverhead, &req) {
+			return
+		}
+		password = req.Password
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, shortenBodyOverhead)
+		if err := r.ParseForm(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid form body", errInvalidRequest)
+			return
+		}
+		password = r.PostForm.Get("password")
+	}
+
+	shortCode := canonicalCode(r.PathValue("code"))
+	extraPath := unlockExtraPath(r)
+	link, err := lookupLink(r.Context(), shortCode)
+	if errors.Is(err, ErrNotFound) {
+		serveNotFound(w, r, shortCode)
+		logRequest(r, http.StatusNotFound, "unlock", "Short code not found", "code", shortCode)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "unlock", "Error looking up short code", "code", shortCode, "error", err)
+		return
+	}
+	if writeLinkGone(w, r, "unlock", shortCode, link) {
+		return
+	}
+	if link.PasswordHash == "" {
+		// Nothing to unlock, send them the usual way
+		http.Redirect(w, r, "/"+keyPath(shortCode)+forwardSuffix(r, extraPath), http.StatusSeeOther)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+		if mediaType == "application/json" {
+			// A JSON body is a JSON client, whatever it accepts
+			writeJSONError(w, http.StatusForbidden, "Wrong password", errForbidden)
+		} else {
+			servePasswordPrompt(w, r, shortCode, extraPath, http.StatusForbidden)
+		}
+		logRequest(r, http.StatusForbidden, "unlock", "Wrong password", "code", shortCode)
+		return
+	}
+
+	link, ok := countVisit(w, r, "unlock", shortCode, link)
+	if !ok {
+		return
+	}
+	destination := forwardedDestination(linkDestination(r, link), extraPath, r.URL.Query())
+	if wantsJSON(r) || mediaType == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(UnlockResponse{URL: destination}); err != nil {
+			logRequest(r, http.StatusInternalServerError, "unlock", "Error encoding response", "error", err)
+		}
+	} else {
+		http.Redirect(w, r, destination, http.StatusSeeOther)
+	}
+	redirectsTotal.Inc()
+	logRequest(r, http.StatusOK, "unlock", "Unlocked", "code", shortCode)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// postUnlock posts a password to /unlock/{code}, form encoded like the
+// prompt page sends it, or as JSON if asJSON is set
+func postUnlock(code, password string, asJSON bool) *httptest.ResponseRecorder {
+	var req *http.Request
+	if asJSON {
+		req = jsonRequest(http.MethodPost, "/unlock/"+code, `{"password": "`+password+`"}`)
+	} else {
+		req = httptest.NewRequest(http.MethodPost, "/unlock/"+code, strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return serve("/unlock/{code}", handleUnlock, req)
+}
+
+func TestPasswordIsHashed(t *testing.T) {
+	useMemoryStore(t)
+	resp := shortenOK(t, `{"url": "https://golang.org/doc", "password": "hunter2"}`)
+	link, err := store.Lookup(t.Context(), resp.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.PasswordHash == "" || strings.Contains(link.PasswordHash, "hunter2") {
+		t.Errorf("stored hash = %q", link.PasswordHash)
+	}
+	if rec := postShorten(t, `{"url": "https://golang.org/doc", "password": "`+strings.Repeat("x", maxPasswordLength+1)+`"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("too long password: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestProtectedRedirect(t *testing.T) {
+	useMemoryStore(t)
+	code := shortenOK(t, `{"url": "https://golang.org/doc", "password": "hunter2"}`).Code
+
+	// Browsers get the form, and aren't sent anywhere
+	rec := getRedirect("/" + code)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Location") != "" {
+		t.Fatalf("status = %d, Location %q; want 401 without a redirect", rec.Code, rec.Header().Get("Location"))
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), `action="/unlock/`+code+`"`) {
+		t.Errorf("prompt page: %s", rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "golang.org") {
+		t.Error("the prompt gives away the destination")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+	req.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	handleRedirect(rec, req)
+	if rec.Code != http.StatusUnauthorized || decodeJSON[ErrorResponse](t, rec).Code != errPasswordRequired {
+		t.Errorf("JSON client: status = %d: %s", rec.Code, rec.Body)
+	}
+	if link, _ := store.Lookup(t.Context(), code); link.Clicks != 0 {
+		t.Errorf("clicks = %d before unlocking", link.Clicks)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	useMemoryStore(t)
+	code := shortenOK(t, `{"url": "https://golang.org/doc", "password": "hunter2"}`).Code
+
+	rec := postUnlock(code, "wrong", false)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "Wrong password") {
+		t.Errorf("wrong password: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := postUnlock(code, "wrong", true); rec.Code != http.StatusForbidden || decodeJSON[ErrorResponse](t, rec).Code != errForbidden {
+		t.Errorf("wrong JSON password: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = postUnlock(code, "hunter2", false)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "https://golang.org/doc" {
+		t.Errorf("form unlock: status %d, Location %q; want 303 to the destination", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = postUnlock(code, "hunter2", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("JSON unlock: status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := decodeJSON[UnlockResponse](t, rec).URL; got != "https://golang.org/doc" {
+		t.Errorf("JSON unlock: url = %q", got)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	// Only the successful unlocks are visits
+	if link, _ := store.Lookup(t.Context(), code); link.Clicks != 2 {
+		t.Errorf("clicks = %d, want 2", link.Clicks)
+	}
+}
+
+func TestUnlockWithoutPassword(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "open", Link{URL: "https://golang.org/doc"})
+	saveTestLink(t, "off", Link{URL: "https://golang.org/doc", PasswordHash: "x", Disabled: true})
+
+	if rec := postUnlock("open", "anything", false); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/open" {
+		t.Errorf("public link: status %d, Location %q; want 303 to /open", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := postUnlock("missing", "anything", true); rec.Code != http.StatusNotFound {
+		t.Errorf("missing link: status = %d, want 404", rec.Code)
+	}
+	if rec := postUnlock("off", "anything", true); rec.Code != http.StatusGone {
+		t.Errorf("disabled link: status = %d, want 410", rec.Code)
+	}
+	rec := serve("/unlock/{code}", handleUnlock, httptest.NewRequest(http.MethodGet, "/unlock/open", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want 405", rec.Code)
+	}
+}
+
+// What a visit adds to the short link survives the password form
+func TestUnlockForwards(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &forwardPath, true)
+	setForTest(t, &forwardQuery, true)
+	code := shortenOK(t, `{"url": "https://golang.org/doc?lang=en", "password": "hunter2"}`).Code
+	router := testRouter(t)
+
+	prompt := getRedirect("/" + code + "/go%20tour/intro?lang=fr&ref=mail")
+	match := regexp.MustCompile(`action="([^"]*)"`).FindStringSubmatch(prompt.Body.String())
+	if match == nil {
+		t.Fatalf("no form in %s", prompt.Body)
+	}
+	action := html.UnescapeString(match[1])
+	if want := "/unlock/" + code + "/go%20tour/intro?lang=fr&ref=mail"; action != want {
+		t.Fatalf("form action = %q, want %q", action, want)
+	}
+
+	const want = "https://golang.org/doc/go%20tour/intro?lang=fr&ref=mail"
+	form := httptest.NewRequest(http.MethodPost, action, strings.NewReader("password=hunter2"))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, form)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != want {
+		t.Errorf("form unlock: status %d, Location %q; want 303 to %s", rec.Code, rec.Header().Get("Location"), want)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, jsonRequest(http.MethodPost, action, `{"password": "hunter2"}`))
+	if got := decodeJSON[UnlockResponse](t, rec).URL; got != want {
+		t.Errorf("JSON unlock: url = %q, want %q", got, want)
+	}
+
+	// A wrong password shows the form again, still forwarding
+	form = httptest.NewRequest(http.MethodPost, action, strings.NewReader("password=wrong"))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, form)
+	if rec.Code != http.StatusForbidden || !strings.Contains(html.UnescapeString(rec.Body.String()), `action="`+action+`"`) {
+		t.Errorf("wrong password: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUnlockForwardingOff(t *testing.T) {
+	useMemoryStore(t)
+	code := shortenOK(t, `{"url": "https://golang.org/doc", "password": "hunter2"}`).Code
+	r := httptest.NewRequest(http.MethodPost, "/unlock/"+code+"/more?lang=fr", strings.NewReader("password=hunter2"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	testRouter(t).ServeHTTP(rec, r)
+	if got := rec.Header().Get("Location"); got != "https://golang.org/doc" {
+		t.Errorf("Location = %q, want the destination as is", got)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadPostgresMigrations(t *testing.T) {
+	migrations, err := loadPostgresMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	// Numbered from 1 without gaps or duplicates, so none is skipped
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %d is %s (version %d)", i+1, m.name, m.version)
+		}
+	}
+	first, err := postgresMigrations.ReadFile(migrations[0].name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(first), "CREATE TABLE") || !strings.Contains(string(first), "links") {
+		t.Errorf("%s doesn't create the links table", migrations[0].name)
+	}
+}
+
+// Integration test against the database at POSTGRES_TEST_URL, skipped if
+// it isn't set. Migrations are applied to it.
+func TestPostgresStore(t *testing.T) {
+	databaseURL := os.Getenv("POSTGRES_TEST_URL")
+	if databaseURL == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+	s, err := NewPostgresStore(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStore(t, s)
+}
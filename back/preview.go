@@ -0,0 +1,52 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// previewTemplate shows where a short link goes instead of redirecting.
+// html/template escapes the URL both in the text and in the href.
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link preview</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #0f172a; color: #e2e8f0; display: flex; min-height: 100vh; align-items: center; justify-content: center; margin: 0; }
+main { background: #1e293b; padding: 2rem; border-radius: 0.5rem; max-width: 40rem; }
+code { display: block; word-break: break-all; background: #334155; padding: 0.75rem; border-radius: 0.25rem; margin: 1rem 0; }
+a { color: #38bdf8; }
+</style>
+</head>
+<body>
+<main>
+<h1>This short link goes to</h1>
+<code>{{if .Favicon}}<img src="/favicon/{{.Code}}" width="16" height="16" alt=""> {{end}}{{.URL}}</code>
+<p><a href="{{.URL}}" rel="noopener noreferrer">Continue to the destination</a></p>
+</main>
+</body>
+</html>
+`))
+
+// previewPage is the data rendered by previewTemplate
+type previewPage struct {
+	Code    string
+	URL     string
+	Favicon bool // Shown if we have it already, the page doesn't wait on a fetch
+}
+
+// servePreview renders the preview page for a link instead of redirecting
+func servePreview(w http.ResponseWriter, r *http.Request, code string, link Link) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	destination := linkDestination(r, link)
+	page := previewPage{Code: code, URL: destination, Favicon: faviconPath(code, link) != ""}
+	if err := previewTemplate.Execute(w, page); err != nil {
+		logRequest(r, http.StatusInternalServerError, "preview", "Error rendering preview page", "code", code, "error", err)
+		return
+	}
+	logRequest(r, http.StatusOK, "preview", "Served preview page", "code", code, "url", destination)
+}
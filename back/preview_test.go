@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPreviewPageEscapesDestination(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "abc123", Link{URL: `https://golang.org/?q="><script>alert(1)</script>`})
+
+	rec := getRedirect("/abc123?preview=1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "<script>") || strings.Contains(body, `"><`) {
+		t.Errorf("destination isn't escaped:\n%s", body)
+	}
+	if !strings.Contains(body, "&lt;script&gt;") {
+		t.Errorf("escaped destination missing from the page:\n%s", body)
+	}
+
+	// Showing the page isn't a visit
+	if link, _ := store.Lookup(t.Context(), "abc123"); link.Clicks != 0 {
+		t.Errorf("clicks = %d after a preview, want 0", link.Clicks)
+	}
+	// Without ?preview=1 it's still a plain redirect
+	if rec := getRedirect("/abc123"); rec.Code != http.StatusFound {
+		t.Errorf("without preview: status %d, want 302", rec.Code)
+	}
+}
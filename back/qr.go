@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Allowed range (in pixels) for the ?size= query parameter
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 1024
+)
+
+// qrPNG renders content as a PNG QR code of size x size pixels
+func qrPNG(content string, size int) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, size)
+}
+
+// qrDataURI renders content as a default size PNG QR code in a data: URI,
+// ready to be used as an <img> src
+func qrDataURI(content string) (string, error) {
+	png, err := qrPNG(content, defaultQRSize)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// qrSVG renders content as an SVG QR code of size x size pixels
+func qrSVG(content string, size int) ([]byte, error) {
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := q.Bitmap() // Includes the quiet zone border
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bitmap), len(bitmap))
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String()), nil
+}
+
+// handleQR serves a QR code image for the full short URL of a code.
+// PNG by default, SVG if the client asks for image/svg+xml.
+func handleQR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	shortCode := canonicalCode(r.PathValue("code"))
+	if _, err := lookupLink(r.Context(), shortCode); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+			logRequest(r, http.StatusNotFound, "qr", "Short code not found", "code", shortCode)
+			return
+		}
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "qr", "Error looking up short code", "code", shortCode, "error", err)
+		return
+	}
+
+	// Clamp the requested size to a sane range
+	size := defaultQRSize
+	if raw := r.URL.Query().Get("size"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid size", errInvalidRequest)
+			return
+		}
+		size = min(max(n, minQRSize), maxQRSize)
+	}
+
+	shortenedURL := shortLink(r, shortCode)
+	contentType := "image/png"
+	render := qrPNG
+	if strings.Contains(r.Header.Get("Accept"), "image/svg+xml") {
+		contentType = "image/svg+xml"
+		render = qrSVG
+	}
+
+	img, err := render(shortenedURL, size)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error generating QR code", errInternal)
+		logRequest(r, http.StatusInternalServerError, "qr", "Error generating QR code", "code", shortCode, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Vary", "Accept")
+	// The short URL for a code never changes, so the image can be cached
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(img)
+}
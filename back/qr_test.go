@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShortenIncludeQR(t *testing.T) {
+	useMemoryStore(t)
+
+	rec := httptest.NewRecorder()
+	handleShorten(rec, jsonRequest(http.MethodPost, "/shorten?include_qr=1", `{"url": "https://golang.org/doc"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	resp := decodeJSON[ShortenResponse](t, rec)
+	encoded, ok := strings.CutPrefix(resp.QRCode, "data:image/png;base64,")
+	if !ok {
+		t.Fatalf("qr_code %.40q... isn't a PNG data URI", resp.QRCode)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decoding the data URI: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("not a valid PNG: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != defaultQRSize || size.Y != defaultQRSize {
+		t.Errorf("image is %v, want %dx%d", size, defaultQRSize, defaultQRSize)
+	}
+
+	// Left out unless asked for
+	if rec := postShorten(t, `{"url": "https://golang.org/pkg"}`); strings.Contains(rec.Body.String(), "qr_code") {
+		t.Errorf("qr_code sent without include_qr: %s", rec.Body)
+	}
+}
+
+func TestQREndpoint(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc"})
+
+	rec := serve("/qr/{code}", handleQR, httptest.NewRequest(http.MethodGet, "/qr/abc123?size=10", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("status %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("not a valid PNG: %v", err)
+	}
+	if size := img.Bounds().Dx(); size != minQRSize {
+		t.Errorf("size 10 gave a %dpx image, want it clamped to %d", size, minQRSize)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/qr/abc123", nil)
+	r.Header.Set("Accept", "image/svg+xml")
+	if rec := serve("/qr/{code}", handleQR, r); !strings.HasPrefix(rec.Body.String(), "<svg") {
+		t.Errorf("SVG asked for, got %.40q", rec.Body)
+	}
+	if rec := serve("/qr/{code}", handleQR, httptest.NewRequest(http.MethodGet, "/qr/missing", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown code: status %d, want 404", rec.Code)
+	}
+}
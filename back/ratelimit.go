@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Clients that haven't made a request for this long are forgotten
+const visitorTTL = 10 * time.Minute
+
+// visitor is the token bucket of a single client IP
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter hands out a token bucket per client IP
+type rateLimiter struct {
+	mu          sync.Mutex
+	visitors    map[string]*visitor
+	limit       rate.Limit
+	burst       int
+	lastCleanup time.Time
+}
+
+// newRateLimiter allows perMinute requests per minute per IP,
+// with bursts of up to burst requests.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		visitors:    make(map[string]*visitor),
+		limit:       rate.Limit(float64(perMinute) / 60),
+		burst:       burst,
+		lastCleanup: time.Now(),
+	}
+}
+
+// limiterFor returns the bucket for ip, creating it if needed
+func (l *rateLimiter) limiterFor(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// Forget idle clients every now and then so the map doesn't grow forever
+	if now.Sub(l.lastCleanup) > visitorTTL {
+		for key, v := range l.visitors {
+			if now.Sub(v.lastSeen) > visitorTTL {
+				delete(l.visitors, key)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	v, exists := l.visitors[ip]
+	if !exists {
+		v = &visitor{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.visitors[ip] = v
+	}
+	v.lastSeen = now
+	return v.limiter
+}
+
+// Middleware rejects requests over the limit with 429 Too Many Requests.
+// It can wrap any handler, not just /shorten.
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reservation := l.limiterFor(clientIP(r)).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			// Give the token back, the request isn't going to be served
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "Too many requests, please slow down", errRateLimited)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
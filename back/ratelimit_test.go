@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(1, 3)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	request := func(ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/shorten", nil)
+		r.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	// The burst goes through, then the limit kicks in
+	for i := range 3 {
+		if rec := request("203.0.113.1"); rec.Code != http.StatusNoContent {
+			t.Fatalf("request %d: status %d, want 204", i+1, rec.Code)
+		}
+	}
+	rec := request("203.0.113.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the limit: status %d, want 429", rec.Code)
+	}
+	if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("Retry-After = %q, want 1 to 60 seconds", rec.Header().Get("Retry-After"))
+	}
+	body := decodeJSON[ErrorResponse](t, rec)
+	if body.Code != errRateLimited {
+		t.Errorf("error code = %q, want %q", body.Code, errRateLimited)
+	}
+
+	// Other clients have their own bucket
+	if rec := request("203.0.113.2"); rec.Code != http.StatusNoContent {
+		t.Errorf("other client: status %d, want 204", rec.Code)
+	}
+}
+
+// A client can't get a fresh bucket by making up X-Forwarded-For entries
+func TestRateLimiterSpoofedForwardedFor(t *testing.T) {
+	setForTest(t, &trustedProxies, 1)
+	limiter := newRateLimiter(1, 1)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for i, spoofed := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+		r := httptest.NewRequest(http.MethodPost, "/shorten", nil)
+		// What the proxy sends on: the client's header, then its address
+		r.Header.Set("X-Forwarded-For", spoofed+", 203.0.113.1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		want := http.StatusTooManyRequests // Same client, same bucket
+		if i == 0 {
+			want = http.StatusNoContent
+		}
+		if rec.Code != want {
+			t.Errorf("request %d claiming %s: status %d, want %d", i+1, spoofed, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// Integration test against a real server: REDIS_TEST_URL, or a local one.
+// It's skipped when there's no server to talk to.
+func TestRedisStore(t *testing.T) {
+	redisURL := os.Getenv("REDIS_TEST_URL")
+	if redisURL == "" {
+		redisURL = "redis://localhost:6379/15"
+	}
+	s, err := NewRedisStore(redisURL)
+	if err != nil {
+		t.Skipf("Redis not reachable at %s: %v", redisURL, err)
+	}
+	defer s.Close()
+	testStore(t, s)
+}
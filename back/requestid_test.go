@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFromContext(r.Context())
+	}))
+	request := func(incoming string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		if incoming != "" {
+			r.Header.Set(requestIDHeader, incoming)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	// Generated, and the same in the response and the context
+	rec := request("")
+	id := rec.Header().Get(requestIDHeader)
+	if len(id) != 32 || id != seen {
+		t.Errorf("generated ID %q, handler saw %q", id, seen)
+	}
+	if other := request("").Header().Get(requestIDHeader); other == id {
+		t.Error("two requests got the same ID")
+	}
+
+	// An incoming one is kept
+	if rec := request("proxy-abc-123"); rec.Header().Get(requestIDHeader) != "proxy-abc-123" || seen != "proxy-abc-123" {
+		t.Errorf("incoming ID: echoed %q, handler saw %q", rec.Header().Get(requestIDHeader), seen)
+	}
+
+	// Unless it isn't safe to log
+	for _, bad := range []string{"has space", "line\nbreak", strings.Repeat("x", maxRequestIDLength+1)} {
+		if got := request(bad).Header().Get(requestIDHeader); got == bad || len(got) != 32 {
+			t.Errorf("incoming %q: echoed %q, want a new ID", bad, got)
+		}
+	}
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	var logs bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(&logs, nil)}))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	useMemoryStore(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	r.Header.Set(requestIDHeader, "trace-me")
+	withRequestID(http.HandlerFunc(handleRedirect)).ServeHTTP(httptest.NewRecorder(), r)
+
+	if logs.Len() == 0 {
+		t.Fatal("nothing logged")
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["request_id"] != "trace-me" {
+			t.Errorf("log line without the request ID: %s", line)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Response structure for GET /resolve/{code}
+type ResolveResponse struct {
+	Code    string `json:"code"`
+	URL     string `json:"url"`
+	Expired bool   `json:"expired"`
+	Clicks  int64  `json:"clicks"`
+}
+
+// handleResolve tells where a short code points without redirecting, e.g.
+// for link previews. It isn't a visit, so clicks aren't counted. Expired
+// links are still resolved (with expired set) until the sweeper removes
+// them; disabled and password protected ones aren't, like on redirect.
+func handleResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	shortCode := canonicalCode(r.PathValue("code"))
+	link, err := lookupLink(r.Context(), shortCode)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Short code not found", errNotFound)
+		logRequest(r, http.StatusNotFound, "resolve", "Short code not found", "code", shortCode)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error looking up short URL", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "resolve", "Error looking up short code", "code", shortCode, "error", err)
+		return
+	}
+	if link.Disabled {
+		writeJSONError(w, http.StatusGone, "Short URL has been disabled", errDisabled)
+		logRequest(r, http.StatusGone, "resolve", "Short code disabled", "code", shortCode)
+		return
+	}
+	if link.PasswordHash != "" {
+		writeJSONError(w, http.StatusUnauthorized, "This link requires a password, POST it to /unlock/"+shortCode, errPasswordRequired)
+		logRequest(r, http.StatusUnauthorized, "resolve", "Password required", "code", shortCode)
+		return
+	}
+
+	resp := ResolveResponse{Code: shortCode, URL: link.URL, Expired: link.Expired(time.Now()), Clicks: link.Clicks}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "resolve", "Error encoding response", "error", err)
+		return
+	}
+	logRequest(r, http.StatusOK, "resolve", "Resolved short code", "code", shortCode, "url", link.URL)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// resolve sends GET /resolve/{code} to handleResolve
+func resolve(code string) *httptest.ResponseRecorder {
+	return serve("/resolve/{code}", handleResolve, httptest.NewRequest(http.MethodGet, "/resolve/"+code, nil))
+}
+
+func TestResolveDoesNotCount(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc"})
+
+	for range 3 {
+		rec := resolve("abc123")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		if rec.Header().Get("Location") != "" {
+			t.Error("resolve redirected")
+		}
+		if got := decodeJSON[ResolveResponse](t, rec); got != (ResolveResponse{Code: "abc123", URL: "https://golang.org/doc"}) {
+			t.Errorf("resolve = %+v", got)
+		}
+	}
+
+	// A redirect counts, the next resolve reports it
+	getRedirect("/abc123")
+	if got := decodeJSON[ResolveResponse](t, resolve("abc123")).Clicks; got != 1 {
+		t.Errorf("clicks = %d after one redirect and four resolves, want 1", got)
+	}
+}
+
+func TestResolveStates(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "expired", Link{URL: "https://golang.org/doc", ExpiresAt: time.Now().Add(-time.Minute), Clicks: 4})
+	saveTestLink(t, "off", Link{URL: "https://golang.org/doc", Disabled: true})
+	saveTestLink(t, "locked", Link{URL: "https://golang.org/doc", PasswordHash: "x"})
+
+	// Expired links are reported as such, where a redirect gives 410
+	rec := resolve("expired")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expired: status = %d", rec.Code)
+	}
+	if got := decodeJSON[ResolveResponse](t, rec); !got.Expired || got.Clicks != 4 {
+		t.Errorf("expired: %+v", got)
+	}
+	if rec := getRedirect("/expired"); rec.Code != http.StatusGone {
+		t.Errorf("expired redirect: status = %d, want 410", rec.Code)
+	}
+
+	tests := []struct {
+		code      string
+		status    int
+		errorCode string
+	}{
+		{"missing", http.StatusNotFound, errNotFound},
+		{"off", http.StatusGone, errDisabled},
+		{"locked", http.StatusUnauthorized, errPasswordRequired},
+	}
+	for _, tt := range tests {
+		rec := resolve(tt.code)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.code, rec.Code, tt.status)
+			continue
+		}
+		if got := decodeJSON[ErrorResponse](t, rec).Code; got != tt.errorCode {
+			t.Errorf("%s: code = %q, want %q", tt.code, got, tt.errorCode)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultRobotsTxt keeps well-behaved crawlers off everything: following
+// short links would count as clicks and hit expired links, and the API
+// isn't worth indexing. ROBOTS_TXT replaces it.
+const defaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+// robotsTxt is what GET /robots.txt serves
+var robotsTxt = []byte(defaultRobotsTxt)
+
+// skipBotClicks leaves visits from crawlers and link unfurlers out of
+// click counts and analytics, see SKIP_BOT_CLICKS
+var skipBotClicks bool
+
+// loadRobotsTxt reads ROBOTS_TXT, served instead of the default
+func loadRobotsTxt(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("loading ROBOTS_TXT: %w", err)
+	}
+	robotsTxt = content
+	return nil
+}
+
+// handleRobotsTxt serves robots.txt
+func handleRobotsTxt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(robotsTxt)
+}
+
+// User-Agent fragments of crawlers and link preview fetchers, lowercased.
+// Most say "bot", the rest are listed by name.
+var botTokens = []string{
+	"bot", "spider", "crawler", "slurp", "facebookexternalhit", "embedly",
+	"bingpreview", "whatsapp", "skypeuripreview", "headlesschrome",
+}
+
+// isBot reports whether a User-Agent belongs to a known crawler or unfurler.
+// Anyone can send any User-Agent, so this is only good for statistics.
+func isBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, token := range botTokens {
+		if strings.Contains(ua, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const (
+	googlebotUA = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	slackUA     = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	facebookUA  = "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)"
+)
+
+func TestRobotsTxt(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleRobotsTxt(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != defaultRobotsTxt {
+		t.Errorf("status %d, body %q; want the default", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handleRobotsTxt(rec, httptest.NewRequest(http.MethodPost, "/robots.txt", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rec.Code)
+	}
+}
+
+func TestRobotsTxtFromFile(t *testing.T) {
+	setForTest(t, &robotsTxt, robotsTxt)
+	path := filepath.Join(t.TempDir(), "robots.txt")
+	const custom = "User-agent: *\nDisallow: /links\n"
+	if err := os.WriteFile(path, []byte(custom), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadRobotsTxt(path); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	testRouter(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if rec.Body.String() != custom {
+		t.Errorf("body = %q, want ROBOTS_TXT", rec.Body)
+	}
+	if err := loadRobotsTxt(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("no error for a missing ROBOTS_TXT")
+	}
+}
+
+func TestIsBot(t *testing.T) {
+	for ua, want := range map[string]bool{
+		googlebotUA: true,
+		slackUA:     true,
+		facebookUA:  true,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0 Safari/537.36": true,
+		firefoxUA: false,
+		chromeUA:  false,
+		safariUA:  false,
+		"":        false,
+	} {
+		if got := isBot(ua); got != want {
+			t.Errorf("isBot(%q) = %v, want %v", ua, got, want)
+		}
+	}
+}
+
+func TestSkipBotClicks(t *testing.T) {
+	useMemoryStore(t)
+	a := useAnalytics(t, 1)
+	saveTestLink(t, "crawled", Link{URL: "https://golang.org/doc"})
+	setForTest(t, &skipBotClicks, true)
+	before := testutil.ToFloat64(botVisitsTotal)
+
+	for _, ua := range []string{googlebotUA, slackUA, facebookUA} {
+		// Still redirected, only not counted
+		if rec := visit("crawled", "", ua); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://golang.org/doc" {
+			t.Fatalf("%s: status %d, Location %q", ua, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+	if link, _ := store.Lookup(t.Context(), "crawled"); link.Clicks != 0 {
+		t.Errorf("%d clicks counted from bots", link.Clicks)
+	}
+	if _, browsers := a.Breakdown("crawled"); len(browsers) != 0 {
+		t.Errorf("bot visits in analytics: %v", browsers)
+	}
+	if got := testutil.ToFloat64(botVisitsTotal) - before; got != 3 {
+		t.Errorf("bot visits metric went up by %g, want 3", got)
+	}
+
+	visit("crawled", "", firefoxUA)
+	if link, _ := store.Lookup(t.Context(), "crawled"); link.Clicks != 1 {
+		t.Errorf("clicks = %d, want the browser visit counted", link.Clicks)
+	}
+}
+
+func TestBotClicksCountedByDefault(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "crawled", Link{URL: "https://golang.org/doc"})
+	visit("crawled", "", googlebotUA)
+	if link, _ := store.Lookup(t.Context(), "crawled"); link.Clicks != 1 {
+		t.Errorf("clicks = %d without SKIP_BOT_CLICKS, want 1", link.Clicks)
+	}
+}
+
+// Skipping the claim would let anyone past a limit by faking a User-Agent
+func TestSkipBotClicksOneTime(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &skipBotClicks, true)
+	saveTestLink(t, "once", Link{URL: "https://golang.org/doc", OneTime: true})
+	if rec := visit("once", "", googlebotUA); rec.Code != http.StatusFound {
+		t.Fatalf("bot visit: status %d", rec.Code)
+	}
+	if rec := visit("once", "", firefoxUA); rec.Code != http.StatusGone {
+		t.Errorf("after a bot used the visit: status %d, want 410", rec.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startServer runs newServer with cfg on a local port until the test ends
+func startServer(t *testing.T, cfg Config) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(l.Addr().String(), http.HandlerFunc(handlePing), cfg)
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- server.Serve(l) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-serverErr; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+func TestSlowHeadersCutOff(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ReadHeaderTimeout = Duration(100 * time.Millisecond)
+	addr := startServer(t, cfg)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Slowloris: start a request and never finish its headers
+	if _, err := io.WriteString(conn, "GET /ping HTTP/1.1\r\nHost: localhost\r\nX-Slow: "); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadAll(conn) // Returns once the server hangs up
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("the server kept the connection open")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cut off after %s, want about the 100ms read header timeout", elapsed)
+	}
+}
+
+func TestPromptClientServed(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ReadHeaderTimeout = Duration(100 * time.Millisecond)
+	addr := startServer(t, cfg)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET /ping HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestNewServerTimeouts(t *testing.T) {
+	cfg := defaultConfig()
+	server := newServer(":0", http.NotFoundHandler(), cfg)
+	if server.ReadHeaderTimeout <= 0 || server.ReadTimeout <= 0 || server.WriteTimeout <= 0 || server.IdleTimeout <= 0 {
+		t.Errorf("default timeouts not all set: header %s, read %s, write %s, idle %s",
+			server.ReadHeaderTimeout, server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+	if server.ReadHeaderTimeout != time.Duration(cfg.ReadHeaderTimeout) || server.IdleTimeout != time.Duration(cfg.IdleTimeout) {
+		t.Error("timeouts not taken from the config")
+	}
+}
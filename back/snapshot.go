@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Default for SNAPSHOT_INTERVAL
+const defaultSnapshotInterval = 5 * time.Minute
+
+// memorySnapshot is the file SNAPSHOT_PATH holds: everything a
+// MemoryStore needs to pick up where it left off after a restart
+type memorySnapshot struct {
+	Version  int            `json:"version"` // Bumped if the format ever changes incompatibly
+	SavedAt  time.Time      `json:"saved_at"`
+	Sequence uint64         `json:"sequence"` // So sequential codes aren't handed out twice
+	Links    []snapshotLink `json:"links"`
+}
+
+// snapshotLink is one stored link in a snapshot, every Link field spelled
+// out so renaming one in Go doesn't break existing files
+type snapshotLink struct {
+	Code          string            `json:"code"`
+	URL           string            `json:"url"`
+	ExpiresAt     time.Time         `json:"expires_at,omitzero"`
+	Clicks        int64             `json:"clicks"`
+	Permanent     bool              `json:"permanent,omitempty"`
+	CreatedAt     time.Time         `json:"created_at,omitzero"`
+	Disabled      bool              `json:"disabled,omitempty"`
+	PasswordHash  string            `json:"password_hash,omitempty"`
+	OneTime       bool              `json:"one_time,omitempty"`
+	MaxClicks     int64             `json:"max_clicks,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	UTM           url.Values        `json:"utm,omitempty"`
+	Geo           map[string]string `json:"geo,omitempty"`
+	Devices       map[string]string `json:"devices,omitempty"`
+	CreatedBy     string            `json:"created_by,omitempty"`
+	CreatorIP     string            `json:"creator_ip,omitempty"`
+	CheckStatus   int               `json:"check_status,omitempty"`
+	CheckedAt     time.Time         `json:"checked_at,omitzero"`
+	RedirectDelay int64             `json:"redirect_delay,omitempty"` // Seconds, like in the SQL stores
+	Campaign      string            `json:"campaign,omitempty"`
+}
+
+const snapshotVersion = 1
+
+func newSnapshotLink(code string, link Link) snapshotLink {
+	return snapshotLink{
+		Code: code, URL: link.URL, ExpiresAt: link.ExpiresAt, Clicks: link.Clicks,
+		Permanent: link.Permanent, CreatedAt: link.CreatedAt, Disabled: link.Disabled,
+		PasswordHash: link.PasswordHash, OneTime: link.OneTime, MaxClicks: link.MaxClicks,
+		Tags: link.Tags, UTM: link.UTM, Geo: link.Geo, Devices: link.Devices, CreatedBy: link.CreatedBy, CreatorIP: link.CreatorIP,
+		CheckStatus: link.CheckStatus, CheckedAt: link.CheckedAt, RedirectDelay: int64(link.RedirectDelay / time.Second),
+		Campaign: link.Campaign,
+	}
+}
+
+func (l snapshotLink) link() Link {
+	return Link{
+		URL: l.URL, ExpiresAt: l.ExpiresAt, Clicks: l.Clicks,
+		Permanent: l.Permanent, CreatedAt: l.CreatedAt, Disabled: l.Disabled,
+		PasswordHash: l.PasswordHash, OneTime: l.OneTime, MaxClicks: l.MaxClicks,
+		Tags: l.Tags, UTM: l.UTM, Geo: l.Geo, Devices: l.Devices, CreatedBy: l.CreatedBy, CreatorIP: l.CreatorIP,
+		CheckStatus: l.CheckStatus, CheckedAt: l.CheckedAt, RedirectDelay: time.Duration(l.RedirectDelay) * time.Second,
+		Campaign: l.Campaign,
+	}
+}
+
+// OpenMemorySnapshot creates a memory store that keeps its links in the
+// snapshot file at path: they are loaded from it now if it exists, and
+// written back to it by WriteSnapshot and on Close
+func OpenMemorySnapshot(maxEntries int, path string) (*MemoryStore, error) {
+	s := NewMemoryStore(maxEntries)
+	s.snapshotPath = path
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadSnapshot fills the (empty) store from its snapshot file. A missing
+// file is a first start, not an error.
+func (s *MemoryStore) loadSnapshot() error {
+	data, err := os.ReadFile(s.snapshotPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Info("No snapshot to load, starting empty", "event", "snapshot", "path", s.snapshotPath)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("parsing snapshot %s: %w", s.snapshotPath, err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return fmt.Errorf("snapshot %s has version %d, expected %d", s.snapshotPath, snapshot.Version, snapshotVersion)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range snapshot.Links {
+		s.insert(l.Code, l.link())
+	}
+	s.sequence.Store(snapshot.Sequence)
+	slog.Info("Loaded snapshot", "event", "snapshot", "path", s.snapshotPath, "links", len(s.links), "saved_at", snapshot.SavedAt)
+	return nil
+}
+
+// WriteSnapshot saves every link to the snapshot file. The links are
+// copied under the read lock, so redirects keep flowing while it's encoded
+// and written. The file is replaced by a rename, so a crash halfway leaves
+// the previous snapshot intact rather than a truncated one.
+func (s *MemoryStore) WriteSnapshot() error {
+	s.mu.RLock()
+	snapshot := memorySnapshot{
+		Version:  snapshotVersion,
+		SavedAt:  time.Now().UTC(),
+		Sequence: s.sequence.Load(),
+		Links:    make([]snapshotLink, 0, len(s.links)),
+	}
+	// Least recently used first, so loading it rebuilds the same order
+	if s.maxEntries > 0 {
+		s.recentMu.Lock()
+		for elem := s.recent.Back(); elem != nil; elem = elem.Prev() {
+			code := elem.Value.(string)
+			snapshot.Links = append(snapshot.Links, newSnapshotLink(code, s.links[code].get()))
+		}
+		s.recentMu.Unlock()
+	} else {
+		for code, stored := range s.links {
+			snapshot.Links = append(snapshot.Links, newSnapshotLink(code, stored.get()))
+		}
+	}
+	s.mu.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+	// The temporary file must be on the same filesystem for the rename
+	tmp, err := os.CreateTemp(filepath.Dir(s.snapshotPath), filepath.Base(s.snapshotPath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	// Make sure the data is on disk before the rename makes it the snapshot
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.snapshotPath); err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	return nil
+}
+
+// snapshotPeriodically writes the snapshot every interval until ctx is
+// done. The last one is written by Close on shutdown.
+func (s *MemoryStore) snapshotPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		if err := s.WriteSnapshot(); err != nil {
+			slog.Error("Error writing snapshot", "event", "snapshot", "path", s.snapshotPath, "error", err)
+			continue
+		}
+		slog.Debug("Wrote snapshot", "event", "snapshot", "path", s.snapshotPath, "duration", time.Since(start).String())
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSnapshotSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.json")
+	s, err := OpenMemorySnapshot(0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &store, Store(s))
+	created := time.Now().Truncate(time.Second)
+	want := Link{
+		URL: "https://golang.org/doc", CreatedAt: created, ExpiresAt: created.Add(time.Hour), Permanent: true,
+		Tags: []string{"docs", "go"}, MaxClicks: 100, CreatedBy: "anonymous", CreatorIP: "192.0.2.1", Campaign: "spring",
+	}
+	saveTestLink(t, "keep", want)
+	saveTestLink(t, "other", Link{URL: "https://go.dev"})
+	for range 3 {
+		getRedirect("/keep")
+	}
+	want.Clicks = 3
+	if _, err := s.NextSequence(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shutdown writes the snapshot
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := OpenMemorySnapshot(0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := restarted.Lookup(t.Context(), "keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.URL != want.URL || got.Clicks != 3 || !got.CreatedAt.Equal(want.CreatedAt) || !got.ExpiresAt.Equal(want.ExpiresAt) || !got.Permanent ||
+		!slices.Equal(got.Tags, want.Tags) || got.MaxClicks != 100 || got.CreatedBy != "anonymous" || got.CreatorIP != "192.0.2.1" || got.Campaign != "spring" {
+		t.Errorf("after restart = %+v, want %+v", got, want)
+	}
+	// The URL index is rebuilt, and the counter carries on
+	if code, err := restarted.LookupURL(t.Context(), "https://go.dev"); err != nil || code != "other" {
+		t.Errorf("LookupURL after restart = %q, %v", code, err)
+	}
+	if n, _ := restarted.NextSequence(t.Context()); n != 2 {
+		t.Errorf("sequence after restart = %d, want 2", n)
+	}
+}
+
+func TestSnapshotFirstStart(t *testing.T) {
+	s, err := OpenMemorySnapshot(0, filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("a missing snapshot is a first start: %v", err)
+	}
+	if _, total, _ := s.List(t.Context(), ListOptions{Limit: 1}); total != 0 {
+		t.Errorf("%d links from nowhere", total)
+	}
+}
+
+func TestSnapshotRejectsBadFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"corrupt.json": `{"version": 1, "links": [`,
+		"future.json":  `{"version": 99, "links": []}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenMemorySnapshot(0, path); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
+
+func TestSnapshotPeriodically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "links.json")
+	s, err := OpenMemorySnapshot(0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(t.Context(), "abc", Link{URL: "https://golang.org"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go s.snapshotPeriodically(ctx, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if restored, err := OpenMemorySnapshot(0, path); err == nil {
+			if exists, _ := restored.Exists(t.Context(), "abc"); exists {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	// Written by rename, no temporary files are left behind
+	time.Sleep(20 * time.Millisecond)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "links.json" {
+			t.Errorf("left over file %s", entry.Name())
+		}
+	}
+}
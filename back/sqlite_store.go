@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver, no cgo needed on Railway
+)
+
+// sqliteMigrations are applied in order on startup. The number of applied
+// migrations is tracked in PRAGMA user_version, so only append to this list.
+var sqliteMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS links (
+		code TEXT PRIMARY KEY,
+		url  TEXT NOT NULL
+	)`,
+	// Unix seconds, NULL for links that never expire
+	`ALTER TABLE links ADD COLUMN expires_at INTEGER`,
+	`ALTER TABLE links ADD COLUMN clicks INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE links ADD COLUMN permanent INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS links_url ON links (url)`,
+	// Unix seconds, NULL for links created before it was recorded
+	`ALTER TABLE links ADD COLUMN created_at INTEGER`,
+	`ALTER TABLE links ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE links ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE links ADD COLUMN one_time INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE links ADD COLUMN max_clicks INTEGER NOT NULL DEFAULT 0`,
+	// Comma separated, see joinTags
+	`ALTER TABLE links ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+	// Destination health, checked_at in Unix seconds (NULL until checked)
+	`ALTER TABLE links ADD COLUMN check_status INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE links ADD COLUMN checked_at INTEGER`,
+	// Query encoded, see parseUTM
+	`ALTER TABLE links ADD COLUMN utm TEXT NOT NULL DEFAULT ''`,
+	// Counters for Store.NextSequence, by name
+	`CREATE TABLE IF NOT EXISTS sequences (name TEXT PRIMARY KEY, value INTEGER NOT NULL)`,
+	// Who created the link, see Link.CreatedBy
+	`ALTER TABLE links ADD COLUMN created_by TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE links ADD COLUMN creator_ip TEXT NOT NULL DEFAULT ''`,
+	// Query encoded, see parseRules
+	`ALTER TABLE links ADD COLUMN geo TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE links ADD COLUMN devices TEXT NOT NULL DEFAULT ''`,
+	// Seconds, see Link.RedirectDelay
+	`ALTER TABLE links ADD COLUMN redirect_delay INTEGER NOT NULL DEFAULT 0`,
+	// See Link.Campaign, indexed for GET /campaigns/{name}
+	`ALTER TABLE links ADD COLUMN campaign TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS links_campaign ON links (campaign)`,
+}
+
+// SQLiteStore persists URL mappings in a SQLite database file
+// so they survive restarts.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the database at path and makes sure
+// the schema is up to date.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening sqlite database: %w", err)
+	}
+	// SQLite only allows a single writer, so use one connection
+	// to avoid "database is locked" errors under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// migrateSQLite applies any migrations the database hasn't seen yet
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("reading sqlite schema version: %w", err)
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		if err := applySQLiteMigration(db, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySQLiteMigration runs migration i and bumps user_version in one
+// transaction. Done apart, a crash in between would leave the column added
+// but the version behind, and the ALTER TABLE failing on every start after.
+func applySQLiteMigration(db *sql.DB, i int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("applying sqlite migration %d: %w", i+1, err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+		return fmt.Errorf("applying sqlite migration %d: %w", i+1, err)
+	}
+	// PRAGMA doesn't support placeholders
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+		return fmt.Errorf("updating sqlite schema version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("applying sqlite migration %d: %w", i+1, err)
+	}
+	return nil
+}
+
+// toUnix converts an optional timestamp to a nullable column value
+func toUnix(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+// fromUnix converts a nullable column value back to a timestamp
+func fromUnix(v sql.NullInt64) time.Time {
+	if !v.Valid {
+		return time.Time{}
+	}
+	return time.Unix(v.Int64, 0)
+}
+
+// Inserts a link, doing nothing if the code is taken (no rows affected).
+// Arguments come from sqliteInsertArgs.
+const sqliteInsert = `INSERT INTO links (code, url, expires_at, permanent, created_at, disabled, clicks, password_hash, one_time, max_clicks, tags, check_status, checked_at, utm, created_by, creator_ip, geo, devices, redirect_delay, campaign) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (code) DO NOTHING`
+
+// sqliteInsertArgs returns the arguments of sqliteInsert for a link
+func sqliteInsertArgs(code string, link Link) []any {
+	return []any{code, link.URL, toUnix(link.ExpiresAt), link.Permanent, toUnix(link.CreatedAt), link.Disabled, link.Clicks, link.PasswordHash, link.OneTime, link.MaxClicks, joinTags(link.Tags), link.CheckStatus, toUnix(link.CheckedAt), link.UTM.Encode(), link.CreatedBy, link.CreatorIP, encodeRules(link.Geo), encodeRules(link.Devices), int64(link.RedirectDelay / time.Second), link.Campaign}
+}
+
+// Columns selected for a link, in the order scanSQLiteEntry expects
+const sqliteColumns = `code, url, expires_at, clicks, permanent, created_at, disabled, password_hash, one_time, max_clicks, tags, check_status, checked_at, utm, created_by, creator_ip, geo, devices, redirect_delay, campaign`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSQLiteEntry reads a row selected with sqliteColumns
+func scanSQLiteEntry(row rowScanner) (Entry, error) {
+	var entry Entry
+	var expiresAt, createdAt, checkedAt sql.NullInt64
+	var tags, utm, geo, devices string
+	var redirectDelay int64
+	if err := row.Scan(&entry.Code, &entry.Link.URL, &expiresAt, &entry.Link.Clicks, &entry.Link.Permanent, &createdAt, &entry.Link.Disabled,
+		&entry.Link.PasswordHash, &entry.Link.OneTime, &entry.Link.MaxClicks, &tags, &entry.Link.CheckStatus, &checkedAt, &utm, &entry.Link.CreatedBy, &entry.Link.CreatorIP, &geo, &devices, &redirectDelay, &entry.Link.Campaign); err != nil {
+		return Entry{}, err
+	}
+	entry.Link.CheckedAt = fromUnix(checkedAt)
+	entry.Link.Tags = splitTags(tags)
+	entry.Link.UTM = parseUTM(utm)
+	entry.Link.Geo = parseRules(geo)
+	entry.Link.Devices = parseRules(devices)
+	entry.Link.RedirectDelay = time.Duration(redirectDelay) * time.Second
+	entry.Link.ExpiresAt = fromUnix(expiresAt)
+	entry.Link.CreatedAt = fromUnix(createdAt)
+	return entry, nil
+}
+
+// Save stores link under code, failing if the code is already taken
+func (s *SQLiteStore) Save(ctx context.Context, code string, link Link) error {
+	res, err := s.db.ExecContext(ctx, sqliteInsert, sqliteInsertArgs(code, link)...)
+	if err != nil {
+		return fmt.Errorf("saving link: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("saving link: %w", err)
+	}
+	if n == 0 {
+		return ErrCodeExists
+	}
+	return nil
+}
+
+// SaveMany stores all entries in a single transaction
+func (s *SQLiteStore) SaveMany(ctx context.Context, entries []Entry) []error {
+	errs := make([]error, len(entries))
+	fail := func(err error) []error {
+		for i := range errs {
+			errs[i] = fmt.Errorf("saving links: %w", err)
+		}
+		return errs
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	stmt, err := tx.PrepareContext(ctx, sqliteInsert)
+	if err != nil {
+		return fail(err)
+	}
+	defer stmt.Close()
+
+	for i, entry := range entries {
+		res, err := stmt.ExecContext(ctx, sqliteInsertArgs(entry.Code, entry.Link)...)
+		if err != nil {
+			return fail(err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return fail(err)
+		} else if n == 0 {
+			errs[i] = ErrCodeExists
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(err)
+	}
+	return errs
+}
+
+// Lookup returns the link for code
+func (s *SQLiteStore) Lookup(ctx context.Context, code string) (Link, error) {
+	entry, err := scanSQLiteEntry(s.db.QueryRowContext(ctx, `SELECT `+sqliteColumns+` FROM links WHERE code = ?`, code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Link{}, ErrNotFound
+	}
+	if err != nil {
+		return Link{}, fmt.Errorf("looking up link: %w", err)
+	}
+	return entry.Link, nil
+}
+
+// Exists reports whether code is already in use
+func (s *SQLiteStore) Exists(ctx context.Context, code string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM links WHERE code = ?)`, code).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("checking link: %w", err)
+	}
+	return exists, nil
+}
+
+// ExistsMany looks all codes up in a single query
+func (s *SQLiteStore) ExistsMany(ctx context.Context, codes []string) ([]bool, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(codes))
+	for i, code := range codes {
+		args[i] = code
+	}
+	placeholders := strings.Repeat("?, ", len(codes)-1) + "?"
+	rows, err := s.db.QueryContext(ctx, `SELECT code FROM links WHERE code IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("checking links: %w", err)
+	}
+	defer rows.Close()
+	return existingCodes(rows, codes)
+}
+
+// existingCodes reads the codes a SQL store found (rows of one code
+// column) into ExistsMany's answer for codes
+func existingCodes(rows *sql.Rows, codes []string) ([]bool, error) {
+	found := make(map[string]bool)
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("checking links: %w", err)
+		}
+		found[code] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("checking links: %w", err)
+	}
+	exists := make([]bool, len(codes))
+	for i, code := range codes {
+		exists[i] = found[code]
+	}
+	return exists, nil
+}
+
+// scanCodes reads rows of one code column, for CodesForURL in SQL stores
+func scanCodes(rows *sql.Rows) ([]string, error) {
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("looking up url: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("looking up url: %w", err)
+	}
+	return codes, nil
+}
+
+// LookupURL returns the latest code saved for url
+func (s *SQLiteStore) LookupURL(ctx context.Context, url string) (string, error) {
+	var code string
+	err := s.db.QueryRowContext(ctx, `SELECT code FROM links WHERE url = ? ORDER BY rowid DESC LIMIT 1`, url).Scan(&code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("looking up url: %w", err)
+	}
+	return code, nil
+}
+
+// CodesForURL uses the url index, like LookupURL
+func (s *SQLiteStore) CodesForURL(ctx context.Context, url string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT code FROM links WHERE url = ? ORDER BY code`, url)
+	if err != nil {
+		return nil, fmt.Errorf("looking up url: %w", err)
+	}
+	defer rows.Close()
+	return scanCodes(rows)
+}
+
+// IncrementClicks adds one to the click count of code
+func (s *SQLiteStore) IncrementClicks(ctx context.Context, code string) (int64, error) {
+	var clicks int64
+	err := s.db.QueryRowContext(ctx, `UPDATE links SET clicks = clicks + 1 WHERE code = ? RETURNING clicks`, code).Scan(&clicks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("incrementing clicks: %w", err)
+	}
+	return clicks, nil
+}
+
+// AddClicks runs one UPDATE per code in a single transaction
+func (s *SQLiteStore) AddClicks(ctx context.Context, clicks map[string]int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("adding clicks: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE links SET clicks = clicks + ? WHERE code = ?`)
+	if err != nil {
+		return fmt.Errorf("adding clicks: %w", err)
+	}
+	defer stmt.Close()
+
+	for code, n := range clicks {
+		if _, err := stmt.ExecContext(ctx, n, code); err != nil {
+			return fmt.Errorf("adding clicks: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("adding clicks: %w", err)
+	}
+	return nil
+}
+
+// Update reads and rewrites the row in one transaction. There's a single
+// connection, so nothing else can write in between.
+func (s *SQLiteStore) Update(ctx context.Context, code string, fn func(*Link) error) (Link, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Link{}, fmt.Errorf("updating link: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	entry, err := scanSQLiteEntry(tx.QueryRowContext(ctx, `SELECT `+sqliteColumns+` FROM links WHERE code = ?`, code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Link{}, ErrNotFound
+	}
+	if err != nil {
+		return Link{}, fmt.Errorf("updating link: %w", err)
+	}
+	link := entry.Link
+	if err := fn(&link); err != nil {
+		return Link{}, err
+	}
+	link.CreatedAt, link.CreatedBy, link.CreatorIP = entry.Link.CreatedAt, entry.Link.CreatedBy, entry.Link.CreatorIP
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET url = ?, expires_at = ?, clicks = ?, permanent = ?, disabled = ?, password_hash = ?, one_time = ?, max_clicks = ?, tags = ?, check_status = ?, checked_at = ?, utm = ?, geo = ?, devices = ?, redirect_delay = ?, campaign = ? WHERE code = ?`,
+		link.URL, toUnix(link.ExpiresAt), link.Clicks, link.Permanent, link.Disabled, link.PasswordHash, link.OneTime, link.MaxClicks, joinTags(link.Tags), link.CheckStatus, toUnix(link.CheckedAt), link.UTM.Encode(), encodeRules(link.Geo), encodeRules(link.Devices), int64(link.RedirectDelay/time.Second), link.Campaign, code)
+	if err != nil {
+		return Link{}, fmt.Errorf("updating link: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Link{}, fmt.Errorf("updating link: %w", err)
+	}
+	return link, nil
+}
+
+// sqlWhere joins conditions into a WHERE clause, "" if there are none.
+// Shared with the Postgres store.
+func sqlWhere(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return ` WHERE ` + strings.Join(conds, ` AND `)
+}
+
+// List returns a page of links ordered by code, plus the total count
+func (s *SQLiteStore) List(ctx context.Context, opts ListOptions) ([]Entry, int, error) {
+	// Tags can't contain commas, so wrapping the list in them matches whole tags only
+	var conds []string
+	var args []any
+	if opts.Tag != "" {
+		conds, args = append(conds, `instr(',' || tags || ',', ?) > 0`), append(args, ","+opts.Tag+",")
+	}
+	if opts.Campaign != "" {
+		conds, args = append(conds, `campaign = ?`), append(args, opts.Campaign)
+	}
+	where := sqlWhere(conds)
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM links`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("counting links: %w", err)
+	}
+
+	// The cursor narrows the page but not the total
+	if opts.After != "" {
+		conds, args = append(conds, `code > ?`), append(args, opts.After)
+		where = sqlWhere(conds)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteColumns+` FROM links`+where+` ORDER BY code LIMIT ? OFFSET ?`, append(args, opts.Limit, opts.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing links: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		entry, err := scanSQLiteEntry(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("listing links: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("listing links: %w", err)
+	}
+	return entries, total, nil
+}
+
+// DeleteMany deletes codes in one transaction. The URL index is the
+// table's own, nothing else to update.
+func (s *SQLiteStore) DeleteMany(ctx context.Context, codes []string) []error {
+	errs := make([]error, len(codes))
+	fail := func(err error) []error {
+		for i := range errs {
+			errs[i] = fmt.Errorf("deleting links: %w", err)
+		}
+		return errs
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	stmt, err := tx.PrepareContext(ctx, `DELETE FROM links WHERE code = ?`)
+	if err != nil {
+		return fail(err)
+	}
+	defer stmt.Close()
+
+	for i, code := range codes {
+		res, err := stmt.ExecContext(ctx, code)
+		if err != nil {
+			return fail(err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return fail(err)
+		} else if n == 0 {
+			errs[i] = ErrNotFound
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(err)
+	}
+	return errs
+}
+
+// Summary aggregates the links table in a single scan
+func (s *SQLiteStore) Summary(ctx context.Context, now, since time.Time) (StoreSummary, error) {
+	var summary StoreSummary
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*),
+		COUNT(*) FILTER (WHERE expires_at IS NULL OR expires_at > ?),
+		COALESCE(SUM(clicks), 0),
+		COUNT(*) FILTER (WHERE created_at > ?)
+		FROM links`, now.Unix(), since.Unix()).
+		Scan(&summary.Stored, &summary.Active, &summary.Clicks, &summary.CreatedSince)
+	if err != nil {
+		return StoreSummary{}, fmt.Errorf("summarizing links: %w", err)
+	}
+	return summary, nil
+}
+
+// DeleteExpired removes links whose expiry has passed
+func (s *SQLiteStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM links WHERE expires_at IS NOT NULL AND expires_at <= ?`, now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired links: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired links: %w", err)
+	}
+	return int(n), nil
+}
+
+// Flush removes every link
+func (s *SQLiteStore) Flush(ctx context.Context) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM links`)
+	if err != nil {
+		return 0, fmt.Errorf("flushing links: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("flushing links: %w", err)
+	}
+	return int(n), nil
+}
+
+// NextSequence increments the code counter in the sequences table
+func (s *SQLiteStore) NextSequence(ctx context.Context) (uint64, error) {
+	var value uint64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO sequences (name, value) VALUES ('codes', 1)
+		ON CONFLICT (name) DO UPDATE SET value = value + 1 RETURNING value`).Scan(&value)
+	if err != nil {
+		return 0, fmt.Errorf("incrementing sequence: %w", err)
+	}
+	return value, nil
+}
+
+// Close closes the underlying database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openTestSQLite opens a SQLite store at path, closed when the test ends
+func openTestSQLite(t *testing.T, path string) *SQLiteStore {
+	t.Helper()
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, openTestSQLite(t, filepath.Join(t.TempDir(), "links.db")))
+}
+
+func TestSQLiteStoreKeepsCreatedAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.db")
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	s := openTestSQLite(t, path)
+	if err := s.Save(t.Context(), "abc123", Link{URL: "https://golang.org/doc", CreatedAt: created}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// Reopened, like after a restart
+	link, err := openTestSQLite(t, path).Lookup(t.Context(), "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !link.CreatedAt.Equal(created) {
+		t.Errorf("created_at %v after reopening, want %v", link.CreatedAt, created)
+	}
+}
+
+// schemaVersion reads PRAGMA user_version of the database at path
+func schemaVersion(t *testing.T, path string) int {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.db")
+	openTestSQLite(t, path).Close()
+	if got := schemaVersion(t, path); got != len(sqliteMigrations) {
+		t.Errorf("user_version = %d, want all %d migrations", got, len(sqliteMigrations))
+	}
+	// Nothing left to apply on the next start
+	openTestSQLite(t, path).Close()
+	if got := schemaVersion(t, path); got != len(sqliteMigrations) {
+		t.Errorf("user_version = %d after reopening", got)
+	}
+}
+
+// A migration that fails halfway leaves neither its changes nor the
+// version bump behind, so the next start runs it again from scratch
+func TestSQLiteMigrationRolledBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.db")
+	openTestSQLite(t, path).Close()
+	applied := len(sqliteMigrations)
+
+	setForTest(t, &sqliteMigrations, append(slices.Clip(sqliteMigrations),
+		`ALTER TABLE links ADD COLUMN note TEXT NOT NULL DEFAULT ''; ALTER TABLE missing ADD COLUMN x INTEGER`))
+	if _, err := NewSQLiteStore(path); err == nil {
+		t.Fatal("NewSQLiteStore succeeded with a failing migration")
+	}
+	if got := schemaVersion(t, path); got != applied {
+		t.Errorf("user_version = %d after a failed migration, want %d", got, applied)
+	}
+
+	// Fixed, it applies cleanly: the column wasn't left behind
+	sqliteMigrations[applied] = `ALTER TABLE links ADD COLUMN note TEXT NOT NULL DEFAULT ''`
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("retrying the migration: %v", err)
+	}
+	s.Close()
+	if got := schemaVersion(t, path); got != applied+1 {
+		t.Errorf("user_version = %d, want %d", got, applied+1)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+)
+
+// Shortest STATS_SECRET accepted, shorter ones could be brute forced
+const minStatsSecretLength = 16
+
+// statsSecret signs stats tokens, from STATS_SECRET. While it's unset
+// stats are public; once set, /stats/{code} needs the code's token (or an
+// API key). Changing it invalidates every token handed out so far.
+var statsSecret []byte
+
+// statsToken is the token granting read access to the stats of code: an
+// HMAC of the code, so it can't be forged without the secret and doesn't
+// need to be stored. 16 bytes of it are plenty and keep URLs short.
+func statsToken(code string) string {
+	mac := hmac.New(sha256.New, statsSecret)
+	mac.Write([]byte("stats:" + code))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// validStatsToken reports whether token is the stats token of code, in
+// constant time
+func validStatsToken(code, token string) bool {
+	return hmac.Equal([]byte(token), []byte(statsToken(code)))
+}
+
+// statsURL is the shareable stats URL of code, "" while stats are public
+func statsURL(r *http.Request, code string) string {
+	if statsSecret == nil {
+		return ""
+	}
+	return shortURLBase(r) + "/stats/" + code + "?token=" + url.QueryEscape(statsToken(code))
+}
+
+// authorizeStats checks that r may read the stats of code: anyone can
+// while STATS_SECRET is unset, otherwise it takes ?token= or an API key.
+// It answers 403 itself and returns false if not.
+func authorizeStats(w http.ResponseWriter, r *http.Request, code string) bool {
+	if statsSecret == nil {
+		return true
+	}
+	if key, ok := bearerToken(r); ok && validAPIKey(key) {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeJSONError(w, http.StatusForbidden, "Missing stats token", errForbidden)
+		logRequest(r, http.StatusForbidden, "stats", "Missing stats token", "code", code)
+		return false
+	}
+	if !validStatsToken(code, token) {
+		writeJSONError(w, http.StatusForbidden, "Invalid stats token", errForbidden)
+		logRequest(r, http.StatusForbidden, "stats", "Invalid stats token", "code", code)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestStatsToken(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &statsSecret, []byte("0123456789abcdef"))
+	setForTest(t, &apiKeys, nil)
+	resp := shortenOK(t, `{"url": "https://golang.org/doc"}`)
+	other := shortenOK(t, `{"url": "https://golang.org/pkg"}`)
+
+	u, err := url.Parse(resp.StatsURL)
+	if err != nil || u.Path != "/stats/"+resp.Code {
+		t.Fatalf("stats_url = %q (%v)", resp.StatsURL, err)
+	}
+	token := u.Query().Get("token")
+	if token != statsToken(resp.Code) {
+		t.Fatalf("token = %q, want %q", token, statsToken(resp.Code))
+	}
+	tampered := []byte(token)
+	if tampered[0] == 'A' {
+		tampered[0] = 'B'
+	} else {
+		tampered[0] = 'A'
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		want   int
+		error  string
+	}{
+		{"valid", u.RequestURI(), nil, http.StatusOK, ""},
+		{"missing", "/stats/" + resp.Code, nil, http.StatusForbidden, "Missing stats token"},
+		{"empty", "/stats/" + resp.Code + "?token=", nil, http.StatusForbidden, "Missing stats token"},
+		{"tampered", "/stats/" + resp.Code + "?token=" + string(tampered), nil, http.StatusForbidden, "Invalid stats token"},
+		{"truncated", "/stats/" + resp.Code + "?token=" + token[:len(token)-1], nil, http.StatusForbidden, "Invalid stats token"},
+		{"another code's", "/stats/" + other.Code + "?token=" + token, nil, http.StatusForbidden, "Invalid stats token"},
+	}
+	for _, tt := range tests {
+		rec := getStats(tt.target, tt.header)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+			continue
+		}
+		if tt.want == http.StatusOK {
+			if stats := decodeJSON[StatsResponse](t, rec); stats.Code != resp.Code {
+				t.Errorf("%s: stats for %q", tt.name, stats.Code)
+			}
+			continue
+		}
+		if body := decodeJSON[ErrorResponse](t, rec); body.Code != errForbidden || body.Error != tt.error {
+			t.Errorf("%s: error = %+v, want %q", tt.name, body, tt.error)
+		}
+	}
+
+	// Unknown codes get a 403 too, so they can't be probed for
+	if rec := getStats("/stats/nosuchcode", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("unknown code: status = %d, want 403", rec.Code)
+	}
+}
+
+func TestStatsTokenSecret(t *testing.T) {
+	setForTest(t, &statsSecret, []byte("0123456789abcdef"))
+	token := statsToken("abc")
+	if statsToken("abc") != token {
+		t.Error("tokens aren't deterministic")
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("token %q isn't URL safe", token)
+	}
+	setForTest(t, &statsSecret, []byte("fedcba9876543210"))
+	if validStatsToken("abc", token) {
+		t.Error("token still valid with another secret")
+	}
+}
+
+func TestStatsTokenAPIKey(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &statsSecret, []byte("0123456789abcdef"))
+	setForTest(t, &apiKeys, []string{"secret"})
+	saveTestLink(t, "keyed", Link{URL: "https://golang.org/doc"})
+
+	if rec := getStats("/stats/keyed", http.Header{"Authorization": {"Bearer secret"}}); rec.Code != http.StatusOK {
+		t.Errorf("API key: status = %d, want 200", rec.Code)
+	}
+	if rec := getStats("/stats/keyed", http.Header{"Authorization": {"Bearer wrong"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("wrong API key: status = %d, want 403", rec.Code)
+	}
+}
+
+func TestStatsPublicWithoutSecret(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &statsSecret, nil)
+	resp := shortenOK(t, `{"url": "https://golang.org/doc"}`)
+	if resp.StatsURL != "" {
+		t.Errorf("stats_url = %q without a secret", resp.StatsURL)
+	}
+	if rec := getStats("/stats/"+resp.Code, nil); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
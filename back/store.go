@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Errors returned by Store implementations
+var (
+	ErrNotFound   = errors.New("short code not found")
+	ErrCodeExists = errors.New("short code already exists")
+	ErrStoreFull  = errors.New("store is full") // A capped memory store has no room left, see MemoryStore.capacity
+)
+
+// Link is the value stored under a short code
+type Link struct {
+	URL       string
+	ExpiresAt time.Time // Zero means the link never expires
+	Clicks    int64     // Number of successful redirects
+	Permanent bool      // Redirect with 301 instead of the default status
+	CreatedAt time.Time // When the link was shortened (zero for links older than this field)
+	Disabled  bool      // Turned off by an admin: redirects answer 410 but the link is kept
+	// bcrypt hash of the password visitors must enter, empty for public links.
+	// The password itself is never stored.
+	PasswordHash string
+	OneTime      bool       // Burned by the first redirect, see claimLimitedVisit
+	MaxClicks    int64      // Expires once Clicks reaches it, 0 for no limit
+	Tags         []string   // Normalized by normalizeTags, for filtering the list
+	UTM          url.Values // utm_* defaults added to the destination, see withUTM
+	// Destinations by visitor country (ISO code like "DE"), used instead of
+	// URL for visitors from there, see linkDestination
+	Geo map[string]string
+	// Destinations by device platform, see devicePlatform. They take
+	// precedence over Geo, an app store link fits wherever the visitor is.
+	Devices map[string]string
+	// Who created the link, for auditing: requestCreator and the client
+	// IP. Only shown to admins. Empty for links from before it was recorded.
+	CreatedBy string
+	CreatorIP string
+	// Last destination check, see checkLinkHealth: the HTTP status, or 0
+	// if the destination couldn't be reached. CheckedAt is zero if never checked.
+	CheckStatus int
+	CheckedAt   time.Time
+	// How long the countdown page is shown before redirecting, see
+	// serveDelayPage. Whole seconds, 0 for REDIRECT_DELAY.
+	RedirectDelay time.Duration
+	Campaign      string // Normalized by normalizeCampaign, "" for none
+}
+
+// Expired reports whether the link has an expiry that is already past
+func (l Link) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
+// Entry pairs a short code with its link, for batch operations
+type Entry struct {
+	Code string
+	Link Link
+}
+
+// ListOptions selects a page of links for Store.List
+type ListOptions struct {
+	Offset int
+	Limit  int
+	Tag    string // Only links with this tag (already normalized), "" for all
+	After  string // Only codes sorting after this one, for cursors; "" from the start
+	// Only links in this campaign (already normalized), "" for all
+	Campaign string
+}
+
+// StoreSummary aggregates all stored links, see Store.Summary
+type StoreSummary struct {
+	Stored       int   // Every link held, including expired ones not swept yet
+	Active       int   // Links that haven't expired
+	Clicks       int64 // Clicks across all stored links
+	CreatedSince int   // Links created after the since time
+}
+
+// Store is the storage layer for our short code -> URL mappings.
+// Implementations must be safe for concurrent use by multiple handlers.
+// Errors other than ErrNotFound, ErrCodeExists and ErrStoreFull mean the backend itself
+// failed (e.g., Redis is unreachable); handlers answer those with 503.
+// Network backed stores give up once ctx is done, see timeoutStore.
+type Store interface {
+	// Save stores link under code. It returns ErrCodeExists if the code is
+	// already taken, so the uniqueness check and the write are atomic.
+	Save(ctx context.Context, code string, link Link) error
+	// SaveMany stores several links at once, under a single lock or
+	// transaction. It returns one error per entry: nil on success,
+	// ErrCodeExists if that code was taken, or the storage error.
+	SaveMany(ctx context.Context, entries []Entry) []error
+	// Lookup returns the link stored under code, or ErrNotFound.
+	// Expired links are still returned until they are swept.
+	Lookup(ctx context.Context, code string) (Link, error)
+	// Exists reports whether code is already in use.
+	Exists(ctx context.Context, code string) (bool, error)
+	// ExistsMany reports which of codes are in use, in one read (a single
+	// lock, query or round trip), so the answers are consistent.
+	ExistsMany(ctx context.Context, codes []string) ([]bool, error)
+	// LookupURL returns the most recently saved code pointing at url,
+	// or ErrNotFound.
+	LookupURL(ctx context.Context, url string) (string, error)
+	// CodesForURL returns every code pointing at url, sorted, and none
+	// (not ErrNotFound) if there are none.
+	CodesForURL(ctx context.Context, url string) ([]string, error)
+	// IncrementClicks atomically adds one to the click count of code
+	// and returns the new count, or ErrNotFound.
+	IncrementClicks(ctx context.Context, code string) (int64, error)
+	// AddClicks adds to the click counts of several links at once, in one
+	// lock, transaction or script. Codes that no longer exist are skipped.
+	// On error none of the clicks were added, so clickBuffer, which writes
+	// the clicks it collected with it, can retry them all without counting
+	// any twice.
+	AddClicks(ctx context.Context, clicks map[string]int64) error
+	// List returns links ordered by code (so pages are stable) starting at
+	// opts.Offset (counted from after opts.After if set), at most opts.Limit
+	// of them, and the total number of links with the tag, whatever After.
+	List(ctx context.Context, opts ListOptions) ([]Entry, int, error)
+	// Update loads the link stored under code, lets fn modify it and saves
+	// the result atomically, returning the updated link. It returns
+	// ErrNotFound if there's no such link. An error from fn aborts the
+	// update and is returned as is. The code, creation time and creator
+	// can't change.
+	Update(ctx context.Context, code string, fn func(*Link) error) (Link, error)
+	// DeleteMany removes the links stored under codes, under a single lock
+	// or transaction, along with their URL index entries. It returns one
+	// error per code: nil if it was deleted, ErrNotFound if there was no
+	// such link, or the storage error.
+	DeleteMany(ctx context.Context, codes []string) []error
+	// Summary counts the stored links, the ones still active at now, their
+	// clicks, and the links created after since.
+	Summary(ctx context.Context, now, since time.Time) (StoreSummary, error)
+	// DeleteExpired removes links that expired before now and
+	// returns how many were removed.
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+	// Flush removes every link, for resetting test and staging instances,
+	// and returns how many were removed.
+	Flush(ctx context.Context) (int, error)
+	// NextSequence increments a counter kept with the links and returns
+	// its new value, 1 the first time. Persistent stores keep it across
+	// restarts (and Flush), so values are never handed out twice.
+	NextSequence(ctx context.Context) (uint64, error)
+	// Close flushes and releases any resources held by the store.
+	Close() error
+}
+
+// newStore opens the configured storage backend
+// ("memory" by default, "sqlite", "redis" or "postgres").
+func newStore(cfg Config) (Store, error) {
+	switch cfg.StorageBackend {
+	case "", "memory":
+		// MaxEntries bounds memory use by evicting the least recently used
+		// links, MemoryCapacity by refusing new ones
+		s := NewMemoryStore(cfg.MaxEntries)
+		if cfg.SnapshotPath != "" {
+			var err error
+			if s, err = OpenMemorySnapshot(cfg.MaxEntries, cfg.SnapshotPath); err != nil {
+				return nil, err
+			}
+		}
+		s.capacity = cfg.MemoryCapacity
+		return s, nil
+	case "sqlite":
+		return NewSQLiteStore(cfg.SQLitePath)
+	case "redis":
+		if cfg.RedisURL == "" {
+			return nil, errors.New("REDIS_URL must be set when STORAGE_BACKEND is \"redis\"")
+		}
+		return NewRedisStore(cfg.RedisURL)
+	case "postgres":
+		if cfg.DatabaseURL == "" {
+			return nil, errors.New("DATABASE_URL must be set when STORAGE_BACKEND is \"postgres\"")
+		}
+		return NewPostgresStore(cfg.DatabaseURL)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q (expected \"memory\", \"sqlite\", \"redis\" or \"postgres\")", cfg.StorageBackend)
+	}
+}
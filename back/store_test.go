@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testStore checks the parts of the Store contract every backend must
+// honor. Codes get a per-run prefix and are deleted afterwards, so it can
+// run against a shared server.
+func testStore(t *testing.T, s Store) {
+	ctx := t.Context()
+	prefix := fmt.Sprintf("test%d-", time.Now().UnixNano())
+	code, other := prefix+"a", prefix+"b"
+	url := "https://golang.org/" + prefix
+	t.Cleanup(func() { s.DeleteMany(t.Context(), []string{code, other}) })
+
+	if _, err := s.Lookup(ctx, code); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Lookup before Save: %v, want ErrNotFound", err)
+	}
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := s.Save(ctx, code, Link{URL: url, ExpiresAt: expires, Permanent: true, Tags: []string{"docs"}}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := s.Save(ctx, code, Link{URL: "https://golang.org/other"}); !errors.Is(err, ErrCodeExists) {
+		t.Errorf("Save of a taken code: %v, want ErrCodeExists", err)
+	}
+	link, err := s.Lookup(ctx, code)
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if link.URL != url || !link.ExpiresAt.Equal(expires) || !link.Permanent || !slices.Equal(link.Tags, []string{"docs"}) {
+		t.Errorf("Lookup = %+v, want what was saved", link)
+	}
+
+	if exists, err := s.Exists(ctx, code); err != nil || !exists {
+		t.Errorf("Exists(saved) = %v, %v", exists, err)
+	}
+	if exists, err := s.ExistsMany(ctx, []string{code, other}); err != nil || !slices.Equal(exists, []bool{true, false}) {
+		t.Errorf("ExistsMany = %v, %v, want [true false]", exists, err)
+	}
+	if got, err := s.LookupURL(ctx, url); err != nil || got != code {
+		t.Errorf("LookupURL = %q, %v, want %q", got, err, code)
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		if clicks, err := s.IncrementClicks(ctx, code); err != nil || clicks != want {
+			t.Errorf("IncrementClicks = %d, %v, want %d", clicks, err, want)
+		}
+	}
+	if _, err := s.IncrementClicks(ctx, other); !errors.Is(err, ErrNotFound) {
+		t.Errorf("IncrementClicks of a missing code: %v, want ErrNotFound", err)
+	}
+	// Missing codes are skipped
+	if err := s.AddClicks(ctx, map[string]int64{code: 3, other: 1}); err != nil {
+		t.Errorf("AddClicks: %v", err)
+	}
+	if link, err := s.Lookup(ctx, code); err != nil || link.Clicks != 5 {
+		t.Errorf("clicks after AddClicks = %d, %v, want 5", link.Clicks, err)
+	}
+
+	// A newer link to the same URL is the one LookupURL finds, even after
+	// the older one is updated
+	if err := s.Save(ctx, other, Link{URL: url, CreatedAt: time.Now().Add(time.Second).Truncate(time.Second)}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err := s.Update(ctx, code, func(link *Link) error {
+		link.Disabled = true
+		return nil
+	}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, err := s.LookupURL(ctx, url); err != nil || got != other {
+		t.Errorf("LookupURL after updating the older link = %q, %v, want %q", got, err, other)
+	}
+
+	errs := s.DeleteMany(ctx, []string{code, other})
+	if len(errs) != 2 || errs[0] != nil || errs[1] != nil {
+		t.Errorf("DeleteMany = %v, want [nil nil]", errs)
+	}
+	if errs := s.DeleteMany(ctx, []string{other}); len(errs) != 1 || !errors.Is(errs[0], ErrNotFound) {
+		t.Errorf("DeleteMany of a deleted code = %v, want [ErrNotFound]", errs)
+	}
+	if _, err := s.Lookup(ctx, code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup after delete: %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(0))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Default limit for a single store operation, see STORE_TIMEOUT
+const defaultStoreTimeout = 5 * time.Second
+
+// timeoutStore bounds every operation of the wrapped store, so a slow or
+// hung backend fails the request with 503 instead of holding the handler
+// (and the client) indefinitely. The request context still applies: a
+// client that disconnects cancels its store calls too.
+type timeoutStore struct {
+	Store
+	timeout time.Duration
+}
+
+// withStoreTimeout wraps s so each operation takes at most timeout
+// (0 leaves s unbounded)
+func withStoreTimeout(s Store, timeout time.Duration) Store {
+	if timeout <= 0 {
+		return s
+	}
+	return timeoutStore{Store: s, timeout: timeout}
+}
+
+func (t timeoutStore) Save(ctx context.Context, code string, link Link) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.Save(ctx, code, link)
+}
+
+func (t timeoutStore) SaveMany(ctx context.Context, entries []Entry) []error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.SaveMany(ctx, entries)
+}
+
+func (t timeoutStore) Lookup(ctx context.Context, code string) (Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.Lookup(ctx, code)
+}
+
+func (t timeoutStore) Exists(ctx context.Context, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.Exists(ctx, code)
+}
+
+func (t timeoutStore) ExistsMany(ctx context.Context, codes []string) ([]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.ExistsMany(ctx, codes)
+}
+
+func (t timeoutStore) LookupURL(ctx context.Context, url string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.LookupURL(ctx, url)
+}
+
+func (t timeoutStore) CodesForURL(ctx context.Context, url string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.CodesForURL(ctx, url)
+}
+
+func (t timeoutStore) IncrementClicks(ctx context.Context, code string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.IncrementClicks(ctx, code)
+}
+
+func (t timeoutStore) AddClicks(ctx context.Context, clicks map[string]int64) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.AddClicks(ctx, clicks)
+}
+
+func (t timeoutStore) List(ctx context.Context, opts ListOptions) ([]Entry, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.List(ctx, opts)
+}
+
+func (t timeoutStore) DeleteMany(ctx context.Context, codes []string) []error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.DeleteMany(ctx, codes)
+}
+
+func (t timeoutStore) Update(ctx context.Context, code string, fn func(*Link) error) (Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.Update(ctx, code, fn)
+}
+
+func (t timeoutStore) Summary(ctx context.Context, now, since time.Time) (StoreSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.Summary(ctx, now, since)
+}
+
+func (t timeoutStore) NextSequence(ctx context.Context) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.NextSequence(ctx)
+}
+
+func (t timeoutStore) Flush(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.Flush(ctx)
+}
+
+func (t timeoutStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Store.DeleteExpired(ctx, now)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingStore hangs like an unreachable backend: its reads and writes
+// only return once their context is done
+type blockingStore struct {
+	Store
+}
+
+func (blockingStore) Lookup(ctx context.Context, _ string) (Link, error) {
+	<-ctx.Done()
+	return Link{}, ctx.Err()
+}
+
+func (blockingStore) Exists(ctx context.Context, _ string) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func (blockingStore) Save(ctx context.Context, _ string, _ Link) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStoreTimeout(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &store, withStoreTimeout(blockingStore{store}, 50*time.Millisecond))
+
+	start := time.Now()
+	if rec := getRedirect("/abc123"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("redirect: status = %d, want 503", rec.Code)
+	}
+	if rec := postShorten(t, `{"url": "https://golang.org/doc"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("shorten: status = %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("handlers took %s, the timeout didn't apply", elapsed)
+	}
+}
+
+func TestStoreRequestCancel(t *testing.T) {
+	useMemoryStore(t)
+	setForTest(t, &store, Store(blockingStore{store})) // No timeout, only the client going away
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handleRedirect(rec, httptest.NewRequest(http.MethodGet, "/abc123", nil).WithContext(ctx))
+		done <- rec.Code
+	}()
+	select {
+	case code := <-done:
+		t.Fatalf("redirect returned %d before the context was canceled", code)
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("redirect still blocked after the request was canceled")
+	}
+}
+
+func TestWithStoreTimeout(t *testing.T) {
+	s := NewMemoryStore(0)
+	if got := withStoreTimeout(s, 0); got != Store(s) {
+		t.Error("a zero timeout should leave the store unwrapped")
+	}
+	_, err := withStoreTimeout(blockingStore{s}, time.Millisecond).Lookup(context.Background(), "abc")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lookup error = %v, want a deadline exceeded", err)
+	}
+}
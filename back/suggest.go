@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// How many alternatives a taken custom alias comes back with, and how
+// many candidates are tried for them: numbered ones ("promo-2", "promo-3",
+// ...) first, then ones with a random suffix
+const (
+	maxAliasSuggestions = 3
+	numberedCandidates  = 4
+	randomCandidates    = 3
+	randomSuffixLength  = 4
+)
+
+// Lowercase only, so suggestions hold up with CASE_INSENSITIVE
+var suggestionRunes = []rune("abcdefghijkmnpqrstuvwxyz23456789")
+
+// A trailing "-<number>", so "promo-2" suggests "promo-3" rather than "promo-2-2"
+var aliasNumberPattern = regexp.MustCompile(`^(.*)-([0-9]{1,4})$`)
+
+// aliasCandidates are alternatives to a taken alias, all valid aliases
+// themselves (validCustomAlias, not reserved)
+func aliasCandidates(alias string) []string {
+	base, next := alias, 2
+	if m := aliasNumberPattern.FindStringSubmatch(alias); m != nil && utf8.RuneCountInString(m[1]) >= minAliasLength {
+		n, _ := strconv.Atoi(m[2])
+		base, next = m[1], n+1
+	}
+
+	var candidates []string
+	add := func(suffix string) {
+		// Shorten the base if needed, by characters so Unicode ones stay whole
+		runes := []rune(base)
+		candidate := string(runes[:min(len(runes), maxAliasLength-len(suffix))]) + suffix
+		if validCustomAlias(candidate) && !isReservedCode(candidate) {
+			candidates = append(candidates, canonicalCode(candidate))
+		}
+	}
+	for i := range numberedCandidates {
+		add("-" + strconv.Itoa(next+i))
+	}
+	for range randomCandidates {
+		add("-" + generateShortCode(randomSuffixLength, suggestionRunes))
+	}
+	return candidates
+}
+
+// writeShortenConflict is writeShortenError for the requests of
+// handleShorten: a 409 for a custom alias (reserved or in use) comes with
+// suggestions to retry with
+func writeShortenConflict(w http.ResponseWriter, r *http.Request, req ShortenRequest, err *shortenError) {
+	if err.status != http.StatusConflict || req.CustomAlias == "" {
+		writeShortenError(w, r, err)
+		return
+	}
+	suggestions := suggestAliases(r.Context(), req.Tenant, req.CustomAlias)
+	logRequest(r, err.status, "shorten", err.message, "alias", req.CustomAlias, "suggestions", len(suggestions))
+	writeErrorResponse(w, err.status, ErrorResponse{Error: err.message, Code: err.code, Suggestions: suggestions})
+}
+
+// suggestAliases returns up to maxAliasSuggestions alternatives to a taken
+// alias that are free right now. They're checked together with
+// store.ExistsMany but not reserved, so one can still be taken by the time
+// the client asks for it (it gets a 409 with fresh suggestions then).
+// Errors only cost the suggestions, the 409 is sent either way.
+func suggestAliases(ctx context.Context, tenant, alias string) []string {
+	candidates := aliasCandidates(alias)
+	keys := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		keys[i] = tenantKey(tenant, candidate)
+	}
+	taken, err := store.ExistsMany(ctx, keys)
+	if err != nil {
+		slog.WarnContext(ctx, "Error checking alias suggestions", "event", "shorten", "alias", alias, "error", err)
+		return nil
+	}
+	var free []string
+	for i, candidate := range candidates {
+		if !taken[i] && !strings.EqualFold(candidate, alias) && len(free) < maxAliasSuggestions {
+			free = append(free, candidate)
+		}
+	}
+	return free
+}
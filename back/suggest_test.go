@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// conflictSuggestions shortens with alias, expecting a 409, and returns
+// its suggestions
+func conflictSuggestions(t *testing.T, alias string) []string {
+	t.Helper()
+	rec := postShorten(t, `{"url": "https://golang.org/other", "custom_alias": "`+alias+`"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("alias %q: status = %d, want 409: %s", alias, rec.Code, rec.Body)
+	}
+	body := decodeJSON[ErrorResponse](t, rec)
+	if body.Code != errConflict {
+		t.Fatalf("alias %q: error = %+v", alias, body)
+	}
+	return body.Suggestions
+}
+
+// assertFree fails the test if any of aliases is already a link
+func assertFree(t *testing.T, aliases []string) {
+	t.Helper()
+	for _, alias := range aliases {
+		if _, err := store.Lookup(t.Context(), alias); !errors.Is(err, ErrNotFound) {
+			t.Errorf("suggestion %q is taken (%v)", alias, err)
+		}
+	}
+}
+
+func TestAliasSuggestions(t *testing.T) {
+	useMemoryStore(t)
+	for _, code := range []string{"promo", "promo-2", "promo-4"} {
+		saveTestLink(t, code, Link{URL: "https://golang.org/" + code})
+	}
+
+	suggestions := conflictSuggestions(t, "promo")
+	if len(suggestions) != maxAliasSuggestions {
+		t.Fatalf("suggestions = %q, want %d", suggestions, maxAliasSuggestions)
+	}
+	assertFree(t, suggestions)
+	// The free numbered ones come first
+	if suggestions[0] != "promo-3" || suggestions[1] != "promo-5" {
+		t.Errorf("suggestions = %q, want promo-3 and promo-5 first", suggestions)
+	}
+	for _, s := range suggestions {
+		if !validCustomAlias(s) || isReservedCode(s) {
+			t.Errorf("suggestion %q isn't a usable alias", s)
+		}
+	}
+
+	// And each of them can be used right away
+	for _, s := range suggestions {
+		if got := shortenOK(t, `{"url": "https://golang.org/doc", "custom_alias": "`+s+`"}`).Code; got != s {
+			t.Errorf("retry with %q: code %q", s, got)
+		}
+	}
+	// Which takes them, so the next conflict suggests others
+	again := conflictSuggestions(t, "promo")
+	assertFree(t, again)
+	for _, s := range again {
+		if slices.Contains(suggestions, s) {
+			t.Errorf("%q suggested again once taken", s)
+		}
+	}
+}
+
+func TestAliasSuggestionsNumbered(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "sale-7", Link{URL: "https://golang.org/doc"})
+	if got := conflictSuggestions(t, "sale-7"); len(got) == 0 || got[0] != "sale-8" {
+		t.Errorf("suggestions = %q, want sale-8 first", got)
+	}
+
+	// A base too long for a suffix is shortened, suggestions stay valid
+	long := strings.Repeat("a", maxAliasLength)
+	saveTestLink(t, long, Link{URL: "https://golang.org/doc"})
+	got := conflictSuggestions(t, long)
+	if len(got) == 0 {
+		t.Fatal("no suggestions for a long alias")
+	}
+	for _, s := range got {
+		if utf8.RuneCountInString(s) > maxAliasLength || !validCustomAlias(s) {
+			t.Errorf("suggestion %q for a long alias isn't valid", s)
+		}
+	}
+	assertFree(t, got)
+}
+
+func TestAliasSuggestionsAllTaken(t *testing.T) {
+	useMemoryStore(t)
+	// Every candidate is taken: the random ones are taken by a store
+	// claiming everything exists
+	saveTestLink(t, "promo", Link{URL: "https://golang.org/doc"})
+	setForTest(t, &store, Store(takenStore{store}))
+	if got := conflictSuggestions(t, "promo"); len(got) != 0 {
+		t.Errorf("suggestions = %q, want none when all are taken", got)
+	}
+}
+
+// takenStore reports every code as taken
+type takenStore struct{ Store }
+
+func (takenStore) ExistsMany(_ context.Context, codes []string) ([]bool, error) {
+	taken := make([]bool, len(codes))
+	for i := range taken {
+		taken[i] = true
+	}
+	return taken, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Recent links are the ones created within this window
+const summaryWindow = 24 * time.Hour
+
+// Summaries are reused for this long. Counting walks every link, so a
+// dashboard polling GET /stats shouldn't cost a full scan each time.
+const summaryCacheTTL = 10 * time.Second
+
+// Response structure for GET /stats
+type SummaryResponse struct {
+	TotalLinks   int       `json:"total_links"`    // Links that haven't expired
+	TotalClicks  int64     `json:"total_clicks"`   // Clicks across all links
+	LinksLast24h int       `json:"links_last_24h"` // Links created in the last 24 hours
+	StoreSize    int       `json:"store_size"`     // Entries held, including expired ones not swept yet
+	GeneratedAt  time.Time `json:"generated_at"`   // When the numbers were computed
+}
+
+// summaryCache holds the last computed summary
+type summaryCache struct {
+	mu   sync.Mutex
+	resp SummaryResponse
+}
+
+var serviceSummary summaryCache
+
+// get returns a summary at most summaryCacheTTL old. The lock is held while
+// computing, so concurrent requests wait for one scan instead of each running their own.
+func (c *summaryCache) get(ctx context.Context, now time.Time) (SummaryResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.resp.GeneratedAt) < summaryCacheTTL {
+		return c.resp, nil
+	}
+	summary, err := store.Summary(ctx, now, now.Add(-summaryWindow))
+	if err != nil {
+		return SummaryResponse{}, err
+	}
+	c.resp = SummaryResponse{
+		TotalLinks:   summary.Active,
+		TotalClicks:  summary.Clicks,
+		LinksLast24h: summary.CreatedSince,
+		StoreSize:    summary.Stored,
+		GeneratedAt:  now.UTC(),
+	}
+	return c.resp, nil
+}
+
+// handleStatsSummary returns service wide totals, a lightweight feed for
+// dashboards next to the Prometheus metrics
+func handleStatsSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	resp, err := serviceSummary.get(r.Context(), time.Now())
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Error summarizing links", errUnavailable)
+		logRequest(r, http.StatusServiceUnavailable, "stats_summary", "Error summarizing links", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response", errInternal)
+		logRequest(r, http.StatusInternalServerError, "stats_summary", "Error encoding response", "error", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// getSummary fetches GET /stats without the cached summary of earlier tests
+func getSummary(t *testing.T) SummaryResponse {
+	t.Helper()
+	setForTest(t, &serviceSummary.resp, SummaryResponse{})
+	rec := httptest.NewRecorder()
+	handleStatsSummary(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	return decodeJSON[SummaryResponse](t, rec)
+}
+
+func TestStatsSummary(t *testing.T) {
+	useMemoryStore(t)
+	now := time.Now()
+	saveTestLink(t, "new1", Link{URL: "https://golang.org/a", CreatedAt: now.Add(-time.Hour), Clicks: 3})
+	saveTestLink(t, "new2", Link{URL: "https://golang.org/b", CreatedAt: now.Add(-23 * time.Hour), Clicks: 4})
+	saveTestLink(t, "old", Link{URL: "https://golang.org/c", CreatedAt: now.Add(-48 * time.Hour), Clicks: 5})
+	saveTestLink(t, "gone", Link{URL: "https://golang.org/d", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute), Clicks: 100})
+
+	got := getSummary(t)
+	if got.TotalLinks != 3 {
+		t.Errorf("total_links = %d, want 3 (expired left out)", got.TotalLinks)
+	}
+	// Clicks and creations count every stored link, expired or not
+	if got.TotalClicks != 112 {
+		t.Errorf("total_clicks = %d, want 112", got.TotalClicks)
+	}
+	if got.LinksLast24h != 3 {
+		t.Errorf("links_last_24h = %d, want 3", got.LinksLast24h)
+	}
+	if got.StoreSize != 4 {
+		t.Errorf("store_size = %d, want 4 (expired not swept yet)", got.StoreSize)
+	}
+	if got.GeneratedAt.IsZero() {
+		t.Error("generated_at not set")
+	}
+}
+
+func TestStatsSummaryCached(t *testing.T) {
+	useMemoryStore(t)
+	saveTestLink(t, "one", Link{URL: "https://golang.org/a", CreatedAt: time.Now()})
+	if got := getSummary(t).TotalLinks; got != 1 {
+		t.Fatalf("total_links = %d, want 1", got)
+	}
+
+	// A second request within summaryCacheTTL reuses the first count
+	saveTestLink(t, "two", Link{URL: "https://golang.org/b", CreatedAt: time.Now()})
+	rec := httptest.NewRecorder()
+	handleStatsSummary(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if got := decodeJSON[SummaryResponse](t, rec).TotalLinks; got != 1 {
+		t.Errorf("cached total_links = %d, want 1", got)
+	}
+	if got := getSummary(t).TotalLinks; got != 2 {
+		t.Errorf("fresh total_links = %d, want 2", got)
+	}
+}
+
+func TestStatsSummaryMethod(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleStatsSummary(rec, httptest.NewRequest(http.MethodPost, "/stats", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", rec.Code)
+	}
+}
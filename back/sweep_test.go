@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSweepExpired(t *testing.T) {
+	useMemoryStore(t)
+	now := time.Now()
+	saveTestLink(t, "expired1", Link{URL: "https://golang.org/a", ExpiresAt: now.Add(-time.Hour)})
+	saveTestLink(t, "expired2", Link{URL: "https://golang.org/b", ExpiresAt: now.Add(-time.Second)})
+	saveTestLink(t, "later", Link{URL: "https://golang.org/c", ExpiresAt: now.Add(time.Hour)})
+	saveTestLink(t, "forever", Link{URL: "https://golang.org/d"})
+	before := testutil.ToFloat64(linksSweptTotal)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		sweepExpired(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for storeSize(t) != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sweeper didn't stop once its context was cancelled")
+	}
+
+	for code, want := range map[string]bool{"expired1": false, "expired2": false, "later": true, "forever": true} {
+		if exists, err := store.Exists(t.Context(), code); err != nil || exists != want {
+			t.Errorf("%s: exists = %v (%v), want %v", code, exists, err, want)
+		}
+	}
+	if got := testutil.ToFloat64(linksSweptTotal) - before; got != 2 {
+		t.Errorf("urlshortener_links_swept_total went up by %g, want 2", got)
+	}
+}
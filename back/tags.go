@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Maximum number of tags on one link
+const maxTags = 10
+
+// Tags are short lowercase labels. Commas can't appear in them, which lets
+// the SQL and Redis stores keep them in a single comma separated field.
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// normalizeTag lowercases and trims a tag, returning an error if it's
+// still not a valid tag
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if !tagPattern.MatchString(tag) {
+		return "", fmt.Errorf("%q is not a valid tag (use up to 32 letters, digits, dashes or underscores)", tag)
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes each tag, dropping blank and duplicate ones.
+// The result is sorted so equal sets of tags are stored the same way.
+func normalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			continue
+		}
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, tag)
+	}
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	return normalized, nil
+}
+
+// joinTags is how the SQL and Redis stores keep tags
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// splitTags reads tags stored with joinTags
+func splitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
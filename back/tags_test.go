@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := normalizeTags([]string{" Marketing ", "q3", "marketing", "", "SPRING_sale"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"marketing", "q3", "spring_sale"}; !slices.Equal(got, want) {
+		t.Errorf("normalizeTags = %q, want %q", got, want)
+	}
+	for _, bad := range [][]string{
+		{"has space"},
+		{"a,b"},
+		{"-leading"},
+		{strings.Repeat("x", 33)},
+		{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"},
+	} {
+		if got, err := normalizeTags(bad); err == nil {
+			t.Errorf("normalizeTags(%q) = %q, want an error", bad, got)
+		}
+	}
+	if got := splitTags(joinTags([]string{"a", "b"})); !slices.Equal(got, []string{"a", "b"}) || splitTags("") != nil {
+		t.Errorf("splitTags(joinTags) = %q", got)
+	}
+}
+
+func TestFilterLinksByTag(t *testing.T) {
+	useMemoryStore(t)
+	spring := shortenOK(t, `{"url": "https://golang.org/a", "tags": ["Marketing", "spring"]}`).Code
+	autumn := shortenOK(t, `{"url": "https://golang.org/b", "tags": ["marketing ", "autumn"]}`).Code
+	shortenOK(t, `{"url": "https://golang.org/c", "tags": ["docs"]}`)
+	shortenOK(t, `{"url": "https://golang.org/d"}`)
+
+	rec := listLinks("tag=MARKETING")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	resp := decodeJSON[LinkListResponse](t, rec)
+	want := []string{spring, autumn}
+	slices.Sort(want)
+	if got := linkCodes(resp); !slices.Equal(got, want) || resp.Total != 2 {
+		t.Errorf("tag=marketing: codes %q (total %d), want %q", got, resp.Total, want)
+	}
+	for _, link := range resp.Links {
+		if !slices.Contains(link.Tags, "marketing") {
+			t.Errorf("%s: tags %q", link.Code, link.Tags)
+		}
+	}
+
+	if resp := decodeJSON[LinkListResponse](t, listLinks("tag=nothing")); len(resp.Links) != 0 || resp.Total != 0 {
+		t.Errorf("unused tag: %+v", resp)
+	}
+	if rec := listLinks("tag=not+valid"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid tag filter: status = %d, want 400", rec.Code)
+	}
+	if rec := postShorten(t, `{"url": "https://golang.org/e", "tags": ["no spaces"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid tag on creation: status = %d, want 400", rec.Code)
+	}
+}
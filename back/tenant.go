@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Tenants from TENANTS, for hosting several clients on one instance. Each
+// tenant has its own code space under /{tenant}/{code}, so codes can repeat
+// across tenants. Links are stored under "tenant:code" (codes can't contain
+// a colon), and that key is what the API returns as the code and accepts
+// wherever a {code} is expected, e.g. /stats/acme:launch. Tenant names are
+// reserved as codes, so "/acme" can't be a root link.
+var tenants = map[string]bool{}
+
+// Tenant names are lowercase, like codes in their URLs
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// newTenants checks the configured tenant names and builds the tenant set
+func newTenants(names []string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !tenantPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid tenant %q (use up to 32 lowercase letters, digits or dashes)", name)
+		}
+		if slices.Contains(defaultReservedCodes, name) {
+			return nil, fmt.Errorf("tenant %q would hide the /%s route", name, name)
+		}
+		set[name] = true
+	}
+	return set, nil
+}
+
+// requestTenant picks the tenant of a shorten request: the tenant field if
+// set, or else the X-Tenant header. Empty means the root code space.
+func requestTenant(r *http.Request, field string) string {
+	if field == "" {
+		field = r.Header.Get("X-Tenant")
+	}
+	return strings.ToLower(strings.TrimSpace(field))
+}
+
+// tenantKey is the store key of code in tenant's code space
+func tenantKey(tenant, code string) string {
+	if tenant == "" {
+		return code
+	}
+	return tenant + ":" + code
+}
+
+// splitKey splits a store key into its tenant ("" for root codes) and code
+func splitKey(key string) (tenant, code string) {
+	tenant, code, found := strings.Cut(key, ":")
+	if !found {
+		return "", key
+	}
+	return tenant, code
+}
+
+// keyPath is the path of the short URL for a store key, "acme/launch" for "acme:launch"
+func keyPath(key string) string {
+	return strings.Replace(key, ":", "/", 1)
+}
+
+// shortLink builds the full short URL for a store key
+func shortLink(r *http.Request, key string) string {
+	// Escaped for any Unicode alias, ":" is left alone
+	return shortURLBase(r) + "/" + keyPath(url.PathEscape(key))
+}
+
+// splitTenantPath splits a redirect path like forwardPath's splitShortPath,
+// taking a leading tenant name off first. It returns the store key of the
+// code and the extra path to forward.
+func splitTenantPath(path string) (key, extra string) {
+	first, rest, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if found && rest != "" && tenants[strings.ToLower(first)] {
+		code, extra := splitShortPath(rest)
+		if code == "" || strings.Contains(code, ":") {
+			return "", ""
+		}
+		return tenantKey(strings.ToLower(first), canonicalCode(code)), extra
+	}
+
+	code, extra := splitShortPath(path)
+	if strings.Contains(code, ":") {
+		return "", "" // Tenant links are only reachable under their tenant
+	}
+	return canonicalCode(code), extra
+}
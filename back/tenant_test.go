@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useTenants turns on the given tenants, reserved as codes like at startup
+func useTenants(t *testing.T, names ...string) {
+	t.Helper()
+	set, err := newTenants(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &tenants, set)
+	setForTest(t, &reservedCodes, newReservedCodes(names))
+}
+
+func TestSameCodeUnderTwoTenants(t *testing.T) {
+	useMemoryStore(t)
+	useTenants(t, "acme", "globex")
+
+	acme := shortenOK(t, `{"url": "https://golang.org/acme", "custom_alias": "launch", "tenant": "acme"}`)
+	req := jsonRequest(http.MethodPost, "/shorten", `{"url": "https://golang.org/globex", "custom_alias": "launch"}`)
+	req.Header.Set("X-Tenant", "Globex")
+	rec := serve("/shorten", handleShorten, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("shorten with X-Tenant: status %d: %s", rec.Code, rec.Body)
+	}
+	globex := decodeJSON[ShortenResponse](t, rec)
+	root := shortenOK(t, `{"url": "https://golang.org/root", "custom_alias": "launch"}`)
+
+	if acme.Code != "acme:launch" || globex.Code != "globex:launch" || root.Code != "launch" {
+		t.Errorf("codes = %q, %q, %q", acme.Code, globex.Code, root.Code)
+	}
+	if !strings.HasSuffix(acme.ShortURL, "/acme/launch") {
+		t.Errorf("short URL = %q, want it under /acme/", acme.ShortURL)
+	}
+
+	for path, want := range map[string]string{
+		"/acme/launch":   "https://golang.org/acme",
+		"/GLOBEX/launch": "https://golang.org/globex",
+		"/launch":        "https://golang.org/root",
+	} {
+		if rec := getRedirect(path); rec.Code != http.StatusFound || rec.Header().Get("Location") != want {
+			t.Errorf("%s: status %d, Location %q; want %s", path, rec.Code, rec.Header().Get("Location"), want)
+		}
+	}
+	// Tenant links aren't reachable by their store key, nor under another tenant
+	for _, path := range []string{"/acme:launch", "/acme/globex:launch", "/initech/launch"} {
+		if rec := getRedirect(path); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+func TestTenantValidation(t *testing.T) {
+	useMemoryStore(t)
+	useTenants(t, "acme")
+	if rec := postShorten(t, `{"url": "https://golang.org/doc", "tenant": "initech"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown tenant: status = %d, want 400", rec.Code)
+	}
+	// "/acme" is the tenant's prefix, it can't be a root link
+	if rec := postShorten(t, `{"url": "https://golang.org/doc", "custom_alias": "acme"}`); rec.Code != http.StatusConflict {
+		t.Errorf("tenant name as alias: status = %d, want 409", rec.Code)
+	}
+
+	for _, names := range [][]string{{"Has Space"}, {"stats"}, {strings.Repeat("a", 33)}} {
+		if _, err := newTenants(names); err == nil {
+			t.Errorf("newTenants(%q) accepted it", names)
+		}
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	for key, want := range map[string][2]string{
+		"launch":      {"", "launch"},
+		"acme:launch": {"acme", "launch"},
+	} {
+		if tenant, code := splitKey(key); tenant != want[0] || code != want[1] {
+			t.Errorf("splitKey(%q) = %q, %q; want %q", key, tenant, code, want)
+		}
+		if tenantKey(want[0], want[1]) != key {
+			t.Errorf("tenantKey(%q, %q) = %q, want %q", want[0], want[1], tenantKey(want[0], want[1]), key)
+		}
+	}
+	if got := keyPath("acme:launch"); got != "acme/launch" {
+		t.Errorf("keyPath = %q", got)
+	}
+}
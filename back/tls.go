@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// listenAndServe runs server until it's shut down, over HTTPS when
+// configured:
+//   - TLS_CERT and TLS_KEY serve a certificate from PEM files
+//   - DOMAIN gets (and renews) one from Let's Encrypt. The tls-alpn-01
+//     challenge is answered on the same port, so it must be reachable on
+//     443 from the internet.
+//   - otherwise plain HTTP, e.g. behind a proxy that terminates TLS
+//
+// HTTP/2 is negotiated automatically over TLS.
+func listenAndServe(server *http.Server, cfg Config) error {
+	switch {
+	case cfg.TLSCert != "":
+		slog.Info("Starting URL shortener service", "addr", server.Addr, "tls", "certificate")
+		return server.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+	case cfg.Domain != "":
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(cfg.Domain, ",")...),
+			Cache:      autocert.DirCache(cfg.AutocertCache),
+		}
+		server.TLSConfig = manager.TLSConfig()
+		slog.Info("Starting URL shortener service", "addr", server.Addr, "tls", "autocert", "domain", cfg.Domain)
+		return server.ListenAndServeTLS("", "")
+	default:
+		slog.Info("Starting URL shortener service", "addr", server.Addr)
+		return server.ListenAndServe()
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans around store operations. Until setupTracing
+// installs a provider it's a no-op.
+var tracer = otel.Tracer("url-shortener")
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set. The exporter reads the
+// other standard OTEL_* variables itself. Without an endpoint, tracing stays
+// a no-op. The returned function flushes pending spans on shutdown.
+func setupTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return noop, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "url-shortener")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return noop, fmt.Errorf("creating OTel resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	// Continue traces started by callers (W3C traceparent headers)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// storeResult sums up a store error for the "result" span attribute
+func storeResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrCodeExists):
+		return "exists"
+	case errors.Is(err, ErrStoreFull):
+		return "full"
+	default:
+		return "error"
+	}
+}
+
+// startStoreSpan starts a span for a store operation on code
+func startStoreSpan(ctx context.Context, operation, code string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "store."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("shortener.code", code)))
+}
+
+// endStoreSpan records the result of a store operation and ends its span.
+// Only real failures mark the span as an error, not a missing code.
+func endStoreSpan(span trace.Span, err error) {
+	span.SetAttributes(attribute.String("shortener.result", storeResult(err)))
+	if storeResult(err) == "error" {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// lookupLink is store.Lookup inside a span
+func lookupLink(ctx context.Context, code string) (Link, error) {
+	ctx, span := startStoreSpan(ctx, "lookup", code)
+	link, err := store.Lookup(ctx, code)
+	endStoreSpan(span, err)
+	return link, err
+}
+
+// saveCode is store.Save inside a span
+func saveCode(ctx context.Context, code string, link Link) error {
+	ctx, span := startStoreSpan(ctx, "save", code)
+	err := store.Save(ctx, code, link)
+	endStoreSpan(span, err)
+	return err
+}
+
+// saveEntries is store.SaveMany inside a span
+func saveEntries(ctx context.Context, entries []Entry) []error {
+	ctx, span := tracer.Start(ctx, "store.save_many",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("shortener.entries", len(entries))))
+	defer span.End()
+	return store.SaveMany(ctx, entries)
+}
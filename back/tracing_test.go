@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// useSpanRecorder sends spans to an in-memory exporter until the test ends
+func useSpanRecorder(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+	setForTest(t, &tracer, provider.Tracer("url-shortener"))
+	return exporter
+}
+
+// spanAttr returns the value of a span attribute, or "" if it has none
+func spanAttr(span tracetest.SpanStub, key attribute.Key) string {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestRedirectSpans(t *testing.T) {
+	useMemoryStore(t)
+	exporter := useSpanRecorder(t)
+	saveTestLink(t, "abc123", Link{URL: "https://golang.org/doc"})
+	router := testRouter(t) // After the provider is set, otelhttp picks it up then
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	spans := exporter.GetSpans()
+	var lookups []tracetest.SpanStub
+	servers := 0
+	for _, span := range spans {
+		switch span.Name {
+		case "store.lookup":
+			lookups = append(lookups, span)
+		case "GET /": // Named after the route by otelhttp
+			servers++
+		}
+	}
+	if servers != 2 {
+		t.Errorf("%d server spans, want one per request: %v", servers, spanNames(spans))
+	}
+	if len(lookups) != 2 {
+		t.Fatalf("%d store.lookup spans, want 2: %v", len(lookups), spanNames(spans))
+	}
+	for i, want := range []struct{ code, result string }{{"abc123", "ok"}, {"missing", "not_found"}} {
+		if got := spanAttr(lookups[i], "shortener.code"); got != want.code {
+			t.Errorf("lookup %d: code %q, want %q", i, got, want.code)
+		}
+		if got := spanAttr(lookups[i], "shortener.result"); got != want.result {
+			t.Errorf("lookup %d: result %q, want %q", i, got, want.result)
+		}
+		// Children of the request's span
+		if !lookups[i].Parent.IsValid() {
+			t.Errorf("lookup %d has no parent span", i)
+		}
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+	}
+	return names
+}
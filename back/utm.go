@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Longest value accepted for one UTM parameter
+const maxUTMLength = 200
+
+// UTM parameters a link can carry defaults for, by ShortenRequest field
+var utmParams = []string{"utm_source", "utm_medium", "utm_campaign"}
+
+// newUTM collects the UTM defaults of a shorten request, nil if it sets none
+func newUTM(req ShortenRequest) (url.Values, error) {
+	var utm url.Values
+	for i, value := range []string{req.UTMSource, req.UTMMedium, req.UTMCampaign} {
+		if value == "" {
+			continue
+		}
+		if len(value) > maxUTMLength {
+			return nil, fmt.Errorf("%s is too long (maximum is %d bytes)", utmParams[i], maxUTMLength)
+		}
+		if utm == nil {
+			utm = url.Values{}
+		}
+		utm.Set(utmParams[i], value)
+	}
+	return utm, nil
+}
+
+// parseUTM reads UTM defaults back from the encoded form stores keep
+// (utm.Encode()), nil for links without any
+func parseUTM(encoded string) url.Values {
+	if encoded == "" {
+		return nil
+	}
+	utm, err := url.ParseQuery(encoded)
+	if err != nil {
+		return nil // We wrote it with Encode, this shouldn't happen
+	}
+	return utm
+}
+
+// withUTM adds the UTM defaults of a link to its destination. Parameters
+// the destination already has are kept as they are, so a URL shortened
+// with its own utm_source isn't retagged. With FORWARD_QUERY the visitor's
+// parameters are merged on top afterwards and win over both.
+func withUTM(dest string, utm url.Values) string {
+	if len(utm) == 0 {
+		return dest
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest // Stored URLs were validated, this shouldn't happen
+	}
+	query := u.Query()
+	added := false
+	for name, values := range utm {
+		if !query.Has(name) {
+			query[name] = values
+			added = true
+		}
+	}
+	if !added {
+		return dest // Keep the destination's own encoding untouched
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// linkDestination is where the visit r to link is sent, before
+// FORWARD_PATH and FORWARD_QUERY are applied: its device URL for the
+// visitor's platform, else its geo URL for their country, else its URL,
+// with its UTM defaults
+func linkDestination(r *http.Request, link Link) string {
+	dest := ""
+	if len(link.Devices) > 0 {
+		dest = deviceURL(link, devicePlatform(r.UserAgent()))
+	}
+	if dest == "" && len(link.Geo) > 0 {
+		dest = geoURL(link, requestCountry(r))
+	}
+	if dest == "" {
+		dest = link.URL
+	}
+	if upgradeHTTPS {
+		dest = upgradeToHTTPS(dest)
+	}
+	return withUTM(dest, link.UTM)
+}
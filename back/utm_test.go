@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWithUTM(t *testing.T) {
+	utm := url.Values{"utm_source": {"mail"}, "utm_medium": {"email"}}
+	tests := []struct {
+		name, dest, want string
+	}{
+		{"added", "https://golang.org/doc", "https://golang.org/doc?utm_medium=email&utm_source=mail"},
+		{"merged with the query", "https://golang.org/doc?page=2", "https://golang.org/doc?page=2&utm_medium=email&utm_source=mail"},
+		{"destination wins", "https://golang.org/doc?utm_source=site", "https://golang.org/doc?utm_medium=email&utm_source=site"},
+		{"nothing to add keeps encoding", "https://golang.org/doc?utm_source=a%20b&utm_medium=x", "https://golang.org/doc?utm_source=a%20b&utm_medium=x"},
+		{"keeps fragment", "https://golang.org/doc#intro", "https://golang.org/doc?utm_medium=email&utm_source=mail#intro"},
+	}
+	for _, tt := range tests {
+		if got := withUTM(tt.dest, utm); got != tt.want {
+			t.Errorf("%s: withUTM(%q) = %q, want %q", tt.name, tt.dest, got, tt.want)
+		}
+	}
+	if got := withUTM("https://golang.org/doc", nil); got != "https://golang.org/doc" {
+		t.Errorf("no UTM: %q", got)
+	}
+}
+
+func TestRedirectUTM(t *testing.T) {
+	useMemoryStore(t)
+	code := shortenOK(t, `{"url": "https://golang.org/doc?utm_medium=site", "utm_source": "newsletter", "utm_medium": "email", "utm_campaign": "launch"}`).Code
+	// The stored URL is left as it was
+	if link, _ := store.Lookup(t.Context(), code); link.URL != "https://golang.org/doc?utm_medium=site" {
+		t.Errorf("stored URL = %q", link.URL)
+	}
+
+	want := "https://golang.org/doc?utm_campaign=launch&utm_medium=site&utm_source=newsletter"
+	if got := getRedirect("/" + code).Header().Get("Location"); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+
+	// The visitor's own parameters win over both with FORWARD_QUERY
+	setForTest(t, &forwardQuery, true)
+	want = "https://golang.org/doc?utm_campaign=launch&utm_medium=site&utm_source=friend"
+	if got := getRedirect("/" + code + "?utm_source=friend").Header().Get("Location"); got != want {
+		t.Errorf("forwarded query: Location = %q, want %q", got, want)
+	}
+}
+
+func TestShortenUTMTooLong(t *testing.T) {
+	useMemoryStore(t)
+	rec := postShorten(t, `{"url": "https://golang.org/doc", "utm_campaign": "`+strings.Repeat("x", maxUTMLength+1)+`"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Events that can be sent to WEBHOOK_URL
+var webhookEventNames = map[string]bool{"shorten": true, "redirect": true}
+
+// Events waiting to be delivered. Once the queue is full (the receiver is
+// down or slow) new events are dropped rather than holding up requests.
+const webhookQueueSize = 1000
+
+// Delivery attempts per event, waiting webhookRetryDelay before the first
+// retry and twice as long before each next one
+const (
+	webhookAttempts   = 5
+	webhookRetryDelay = time.Second
+	webhookTimeout    = 10 * time.Second // Per attempt
+)
+
+// WebhookEvent is the JSON body POSTed to WEBHOOK_URL
+type WebhookEvent struct {
+	Event     string    `json:"event"` // "shorten" or "redirect"
+	Code      string    `json:"code"`
+	ShortURL  string    `json:"short_url"`
+	URL       string    `json:"url"` // The destination
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"` // Redirects only
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// webhookSender delivers events to one URL from a queue, so requests never
+// wait on the receiver
+type webhookSender struct {
+	url      string
+	events   map[string]bool // Which events are sent, from WEBHOOK_EVENTS
+	queue    chan WebhookEvent
+	client   *http.Client
+	ctx      context.Context // Cancelled when stop gives up on the queue
+	cancel   context.CancelFunc
+	stopping chan struct{} // Closed by stop
+	done     chan struct{} // Closed by run once it returns
+}
+
+// webhooks sends events to WEBHOOK_URL, nil if it isn't set
+var webhooks *webhookSender
+
+// newWebhookSender returns a sender for the given events to url. Call run
+// to start delivering.
+func newWebhookSender(url string, events []string) *webhookSender {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &webhookSender{
+		url:      url,
+		events:   make(map[string]bool, len(events)),
+		queue:    make(chan WebhookEvent, webhookQueueSize),
+		client:   &http.Client{Timeout: webhookTimeout},
+		ctx:      ctx,
+		cancel:   cancel,
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, event := range events {
+		s.events[event] = true
+	}
+	return s
+}
+
+// notifyWebhook queues event for delivery, if webhooks are on and the
+// event is one of WEBHOOK_EVENTS. It never blocks.
+func notifyWebhook(event WebhookEvent) {
+	if webhooks == nil || !webhooks.events[event.Event] {
+		return
+	}
+	select {
+	case webhooks.queue <- event:
+	default:
+		webhookDeliveriesTotal.WithLabelValues("dropped").Inc()
+		slog.Warn("Webhook queue full, dropping event", "event", "webhook", "webhook_event", event.Event, "code", event.Code)
+	}
+}
+
+// newVisitEvent describes a counted visit to code for the webhook
+func newVisitEvent(r *http.Request, code string, link Link) WebhookEvent {
+	return WebhookEvent{
+		Event:     "redirect",
+		Code:      code,
+		ShortURL:  shortLink(r, code),
+		URL:       link.URL,
+		Time:      time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// newShortenEvent describes a link created under code for the webhook
+func newShortenEvent(r *http.Request, code, longURL string) WebhookEvent {
+	return WebhookEvent{Event: "shorten", Code: code, ShortURL: shortLink(r, code), URL: longURL, Time: time.Now().UTC()}
+}
+
+// run delivers queued events one at a time until stop is called, then
+// delivers what's left in the queue and returns
+func (s *webhookSender) run() {
+	defer close(s.done)
+	for {
+		select {
+		case event := <-s.queue:
+			s.deliver(event)
+		case <-s.stopping:
+			for {
+				select {
+				case event := <-s.queue:
+					s.deliver(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// stop lets run deliver the remaining events until ctx is done. Events
+// queued after it's called may not be sent.
+func (s *webhookSender) stop(ctx context.Context) {
+	close(s.stopping)
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.cancel() // The remaining deliveries fail right away
+		<-s.done
+	}
+}
+
+// deliver POSTs event to the webhook URL, retrying with backoff. Client
+// errors other than 429 aren't retried, they'd fail the same way again.
+func (s *webhookSender) deliver(event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Error encoding webhook event", "event", "webhook", "error", err)
+		return
+	}
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := s.post(body)
+		if err == nil {
+			webhookDeliveriesTotal.WithLabelValues("delivered").Inc()
+			return
+		}
+		var statusErr webhookStatusError
+		retryable := !errors.As(err, &statusErr) || statusErr.retryable()
+		if !retryable || attempt == webhookAttempts || s.ctx.Err() != nil {
+			webhookDeliveriesTotal.WithLabelValues("failed").Inc()
+			slog.Error("Webhook delivery failed", "event", "webhook", "webhook_event", event.Event, "code", event.Code, "attempts", attempt, "error", err)
+			return
+		}
+		select {
+		case <-s.ctx.Done():
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// webhookStatusError is a response from the receiver outside 2xx
+type webhookStatusError int
+
+func (e webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook answered %d", int(e))
+}
+
+// retryable reports whether the receiver might accept the event later
+func (e webhookStatusError) retryable() bool {
+	return e == http.StatusTooManyRequests || e >= 500
+}
+
+// post makes one delivery attempt
+func (s *webhookSender) post(body []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "url-shortener-webhook")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return webhookStatusError(resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver collects the events POSTed to it. status answers each
+// attempt, by attempt number from 1.
+func webhookReceiver(t *testing.T, status func(attempt int64) int) (*httptest.Server, chan WebhookEvent, *atomic.Int64) {
+	t.Helper()
+	events := make(chan WebhookEvent, 10)
+	var attempts atomic.Int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := attempts.Add(1)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if code := status(n); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		var event WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+		events <- event
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver, events, &attempts
+}
+
+// useWebhooks starts a sender for events to url until the test ends
+func useWebhooks(t *testing.T, url string, events ...string) {
+	t.Helper()
+	sender := newWebhookSender(url, events)
+	setForTest(t, &webhooks, sender)
+	go sender.run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		sender.stop(ctx)
+	})
+}
+
+// nextEvent waits for the receiver's next event
+func nextEvent(t *testing.T, events chan WebhookEvent) WebhookEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event delivered")
+		return WebhookEvent{}
+	}
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	useMemoryStore(t)
+	receiver, events, _ := webhookReceiver(t, func(int64) int { return http.StatusOK })
+	useWebhooks(t, receiver.URL, "shorten", "redirect")
+
+	code := shortenOK(t, `{"url": "https://golang.org/doc"}`).Code
+	event := nextEvent(t, events)
+	if event.Event != "shorten" || event.Code != code || event.URL != "https://golang.org/doc" || event.ShortURL != "http://example.com/"+code || event.Time.IsZero() {
+		t.Errorf("shorten event = %+v", event)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+	req.Header.Set("Referer", "https://news.example/")
+	req.Header.Set("User-Agent", firefoxUA)
+	handleRedirect(httptest.NewRecorder(), req)
+	event = nextEvent(t, events)
+	if event.Event != "redirect" || event.Code != code || event.URL != "https://golang.org/doc" || event.Referrer != "https://news.example/" || event.UserAgent != firefoxUA {
+		t.Errorf("redirect event = %+v", event)
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	useMemoryStore(t)
+	receiver, events, _ := webhookReceiver(t, func(int64) int { return http.StatusOK })
+	sender := newWebhookSender(receiver.URL, []string{"redirect"})
+	setForTest(t, &webhooks, sender)
+	go sender.run()
+
+	code := shortenOK(t, `{"url": "https://golang.org/doc"}`).Code
+	getRedirect("/" + code)
+	if event := nextEvent(t, events); event.Event != "redirect" {
+		t.Errorf("got a %q event, only redirects are configured", event.Event)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	sender.stop(ctx)
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %q event", event.Event)
+	default:
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	useMemoryStore(t)
+	// Down for the first attempt, then fine
+	receiver, events, attempts := webhookReceiver(t, func(n int64) int {
+		if n == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	useWebhooks(t, receiver.URL, "shorten")
+
+	start := time.Now()
+	code := shortenOK(t, `{"url": "https://golang.org/doc"}`).Code
+	if time.Since(start) > webhookRetryDelay/2 {
+		t.Error("shorten waited for the webhook")
+	}
+	if event := nextEvent(t, events); event.Code != code {
+		t.Errorf("delivered event for %q, want %q", event.Code, code)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("%d attempts, want 2", got)
+	}
+}
+
+func TestWebhookClientErrorNotRetried(t *testing.T) {
+	receiver, _, attempts := webhookReceiver(t, func(int64) int { return http.StatusBadRequest })
+	sender := newWebhookSender(receiver.URL, []string{"shorten"})
+	sender.deliver(WebhookEvent{Event: "shorten", Code: "abc"})
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("%d attempts after a 400, want 1", got)
+	}
+
+	for status, want := range map[int]bool{429: true, 500: true, 503: true, 400: false, 404: false} {
+		if got := webhookStatusError(status).retryable(); got != want {
+			t.Errorf("retryable(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
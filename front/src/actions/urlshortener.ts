@@ -3,7 +3,8 @@
 
 interface ShortenApiResponse {
   short_url?: string;
-  error?: string; // Go service might return errors differently, adjust as needed
+  error?: string;
+  code?: string; // Machine readable error code, e.g. "invalid_url"
 }
 
 interface ActionResult {
@@ -28,11 +29,18 @@ export async function shortenUrlAction(longUrl: string): Promise<ActionResult> {
     if (!process.env.RAIL_PUBLIC_DOMAIN) {
       throw new Error("RAIL_PUBLIC_DOMAIN environment variable is not defined");
     }
+    const headers: Record<string, string> = {
+      "Content-Type": "application/json",
+    };
+    // Required when the Go service is configured with API_KEYS.
+    // This runs on the server, so the key is never sent to the browser.
+    if (process.env.SHORTENER_API_KEY) {
+      headers["Authorization"] = `Bearer ${process.env.SHORTENER_API_KEY}`;
+    }
+
     const response = await fetch(process.env.RAIL_PUBLIC_DOMAIN, {
       method: "POST",
-      headers: {
-        "Content-Type": "application/json",
-      },
+      headers,
       body: JSON.stringify({ url: longUrl }),
       cache: 'no-store', // Important for dynamic requests to API routes
     });
@@ -41,10 +49,9 @@ export async function shortenUrlAction(longUrl: string): Promise<ActionResult> {
       // Try to parse error from Go service if possible
       let errorMessage = `Error: ${response.status} ${response.statusText}`;
       try {
+        // Go service errors are JSON: { error: message, code: error code }
         const errorBody = await response.json();
-        if (errorBody && typeof errorBody === 'string') { // Go error is plain text
-            errorMessage = errorBody;
-        } else if (errorBody && errorBody.error) { // Or if it's JSON with an error field
+        if (errorBody && errorBody.error) {
             errorMessage = errorBody.error;
         }
       } catch (e) {